@@ -0,0 +1,126 @@
+package api
+
+import _ "embed" // for the embedded JSON Schema
+
+// Database formats reported in DatabaseMetadataV2.Format.
+const (
+	FormatArchived   = "archived"
+	FormatUnarchived = "unarchived"
+)
+
+// Hash kinds reported in DatabaseMetadataV2.HashKind, describing what the
+// content hash covers.
+const (
+	// HashKindSHA256 is the SHA-256 of the database archive.
+	HashKindSHA256 = "sha256"
+
+	// HashKindMD5 and HashKindCRC32C are the MD5 and CRC32C checksums a
+	// storage service records for an archive it holds without a SHA-256.
+	HashKindMD5    = "md5"
+	HashKindCRC32C = "crc32c"
+
+	// HashKindMerkle is a SHA-256 over the sorted paths and content hashes
+	// of every file of an unarchived database directory.
+	HashKindMerkle = "merkle"
+
+	// HashKindPath is the SHA-256 of the database's storage path, used only
+	// when its content cannot be hashed.
+	HashKindPath = "path"
+
+	// HashKindLanguage is declared in the X-Hash-Kind header of downloads of
+	// multi-language archives, whose per-language content hashes are derived
+	// from the archive hash and cannot verify the archive.
+	HashKindLanguage = "language"
+)
+
+// DatabaseMetadataV2 is the metadata record served under /api/v2/. It embeds
+// the v1 DatabaseMetadata unchanged, so its JSON is a superset of the v1
+// record, and adds facts read from the database itself. Facts that a backend
+// cannot determine (e.g. for databases proxied from a v1 upstream) are omitted.
+type DatabaseMetadataV2 struct {
+	DatabaseMetadata
+
+	// Format is "archived" for zip archives or "unarchived" for database directories.
+	Format string `json:"format,omitempty" db:"format"`
+
+	// Languages lists every language contained in the database artifact;
+	// PrimaryLanguage is the language this record describes.
+	Languages []string `json:"languages,omitempty" db:"languages"`
+
+	// SourceLocationPrefix is the source root recorded in codeql-database.yml.
+	SourceLocationPrefix string `json:"source_location_prefix,omitempty" db:"source_location_prefix"`
+
+	// Finalised is the finalised state recorded in codeql-database.yml.
+	Finalised *bool `json:"finalised,omitempty" db:"finalised"`
+
+	// DBScheme is the file name of the database schema of this language
+	// (e.g., "semmlecode.javascript.dbscheme").
+	DBScheme string `json:"dbscheme,omitempty" db:"dbscheme"`
+
+	// DBSchemeSHA256 is the SHA-256 hash of the database schema, which
+	// identifies the extractor's schema version.
+	DBSchemeSHA256 string `json:"dbscheme_sha256,omitempty" db:"dbscheme_sha256"`
+
+	// HasSourceArchive reports whether the database includes src.zip.
+	HasSourceArchive *bool `json:"has_source_archive,omitempty" db:"has_source_archive"`
+
+	// LinesOfCode is the baseline lines of code of this language from
+	// baseline-info.json.
+	LinesOfCode int64 `json:"lines_of_code,omitempty" db:"lines_of_code"`
+
+	// FileCount is the number of source files of this language from
+	// baseline-info.json.
+	FileCount int `json:"file_count,omitempty" db:"file_count"`
+
+	// Location is where the backend stores the database (e.g., a file
+	// path, "gs://bucket/object" or an upstream URL).
+	Location string `json:"location,omitempty" db:"location"`
+
+	// HashKind is one of the HashKind* constants and describes what the
+	// content hash covers. For records of multi-language databases it
+	// describes the artifact hash the per-language hash is derived from.
+	HashKind string `json:"hash_kind,omitempty" db:"hash_kind"`
+
+	// IdentitySource records how GitOwner and GitRepo were determined:
+	// "sidecar", "git-remote", "rule" or "path-heuristic".
+	IdentitySource string `json:"identity_source,omitempty" db:"identity_source"`
+
+	// Visibility is the repository visibility (e.g., "public", "private",
+	// "internal") as declared in a sidecar file.
+	Visibility string `json:"visibility,omitempty" db:"visibility"`
+
+	// Team is the team owning the repository as declared in a sidecar file.
+	Team string `json:"team,omitempty" db:"team"`
+
+	// Tags are free-form labels from a sidecar file (e.g., "critical", "archived").
+	Tags []string `json:"tags,omitempty" db:"tags"`
+}
+
+// MetadataV2Schema is the JSON Schema document describing DatabaseMetadataV2.
+//
+//go:embed metadata-v2.schema.json
+var MetadataV2Schema []byte
+
+// ToV1 returns the v1 records of records.
+func ToV1(records []DatabaseMetadataV2) []DatabaseMetadata {
+	if records == nil {
+		return nil
+	}
+	result := make([]DatabaseMetadata, len(records))
+	for i := range records {
+		result[i] = records[i].DatabaseMetadata
+	}
+	return result
+}
+
+// ToV2 returns v2 records carrying only the v1 fields of records.
+func ToV2(records []DatabaseMetadata) []DatabaseMetadataV2 {
+	if records == nil {
+		return nil
+	}
+	result := make([]DatabaseMetadataV2, len(records))
+	for i := range records {
+		result[i] = DatabaseMetadataV2{DatabaseMetadata: records[i]}
+	}
+	return result
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// jsonFields returns the JSON field names of a struct type, descending into
+// embedded structs, and the names of fields without omitempty.
+func jsonFields(t reflect.Type) (all, required []string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			a, r := jsonFields(field.Type)
+			all = append(all, a...)
+			required = append(required, r...)
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		all = append(all, name)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return all, required
+}
+
+func TestMetadataV2Schema(t *testing.T) {
+	var schema struct {
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(MetadataV2Schema, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	all, required := jsonFields(reflect.TypeOf(DatabaseMetadataV2{}))
+	for _, name := range all {
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("schema has no property %q", name)
+		}
+	}
+	if len(schema.Properties) != len(all) {
+		t.Errorf("schema has %d properties, want %d", len(schema.Properties), len(all))
+	}
+
+	slices.Sort(required)
+	slices.Sort(schema.Required)
+	if !slices.Equal(schema.Required, required) {
+		t.Errorf("schema required = %v, want %v", schema.Required, required)
+	}
+}
+
+func TestDatabaseMetadataV2_V1Compatible(t *testing.T) {
+	v1 := DatabaseMetadata{
+		ContentHash:     "abc123",
+		GitOwner:        "owner",
+		GitRepo:         "repo",
+		PrimaryLanguage: "go",
+	}
+	finalised := true
+	v2 := DatabaseMetadataV2{
+		DatabaseMetadata: v1,
+		Format:           FormatArchived,
+		Languages:        []string{"go"},
+		Finalised:        &finalised,
+		LinesOfCode:      42,
+		Tags:             []string{"critical"},
+	}
+
+	v1Data, err := json.Marshal(v1)
+	if err != nil {
+		t.Fatalf("json.Marshal(v1) error = %v", err)
+	}
+	v2Data, err := json.Marshal(v2)
+	if err != nil {
+		t.Fatalf("json.Marshal(v2) error = %v", err)
+	}
+
+	// Every v1 field must appear in the v2 record with the same value
+	var v1Fields, v2Fields map[string]json.RawMessage
+	if err := json.Unmarshal(v1Data, &v1Fields); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(v2Data, &v2Fields); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range v1Fields {
+		if string(v2Fields[name]) != string(value) {
+			t.Errorf("v2 field %q = %s, want %s", name, v2Fields[name], value)
+		}
+	}
+	if string(v2Fields["format"]) != `"archived"` || string(v2Fields["finalised"]) != "true" || string(v2Fields["tags"]) != `["critical"]` {
+		t.Errorf("v2 record = %s, want format, finalised and tags", v2Data)
+	}
+	if _, ok := v2Fields["has_source_archive"]; ok {
+		t.Error("unknown has_source_archive should be omitted")
+	}
+
+	// Converting back yields the identical v1 encoding
+	back, err := json.Marshal(ToV1([]DatabaseMetadataV2{v2})[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(back) != string(v1Data) {
+		t.Errorf("ToV1() = %s, want %s", back, v1Data)
+	}
+
+	if got := ToV2([]DatabaseMetadata{v1}); len(got) != 1 || got[0].ContentHash != "abc123" || got[0].Format != "" {
+		t.Errorf("ToV2() = %+v, want v1 fields only", got)
+	}
+}
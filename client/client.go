@@ -0,0 +1,549 @@
+// Package client provides a Go client for HEPC servers. It lists and looks
+// up database metadata and downloads databases with resume and checksum
+// verification.
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+)
+
+// hashKindHeader is the response header in which a HEPC server declares the
+// kind of the content hash of a database archive it serves.
+const hashKindHeader = "X-Hash-Kind"
+
+// Config holds the configuration of a Client.
+type Config struct {
+	// BaseURL is the base URL of the HEPC server (e.g., "https://hepc.example.com").
+	BaseURL string
+
+	// Token is an optional bearer token. It is sent only to the host of
+	// BaseURL, never to other hosts named in result URLs.
+	Token string
+
+	// HTTPClient is an optional HTTP client (default: a new http.Client).
+	HTTPClient *http.Client
+
+	// MaxRetries is the number of retries for transient failures (default: 3).
+	// A negative value disables retries.
+	MaxRetries int
+
+	// RetryBackoff is the initial delay between retries, doubled after each
+	// attempt (default: 500 milliseconds).
+	RetryBackoff time.Duration
+
+	// SkipVerify disables SHA-256 verification of downloads.
+	SkipVerify bool
+}
+
+// Client is a client for a HEPC server. It is safe for concurrent use.
+type Client struct {
+	baseURL      *url.URL
+	token        string
+	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+	skipVerify   bool
+}
+
+// New creates a new client.
+func New(cfg Config) (*Client, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("client: base URL is required")
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("client: invalid base URL %q", cfg.BaseURL)
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	maxRetries := cfg.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 3
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	retryBackoff := cfg.RetryBackoff
+	if retryBackoff == 0 {
+		retryBackoff = 500 * time.Millisecond
+	}
+
+	return &Client{
+		baseURL:      baseURL,
+		token:        cfg.Token,
+		httpClient:   httpClient,
+		maxRetries:   maxRetries,
+		retryBackoff: retryBackoff,
+		skipVerify:   cfg.SkipVerify,
+	}, nil
+}
+
+// StatusError is returned when the server answers with an unexpected status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status: %s", e.URL, e.Status)
+}
+
+// Temporary reports whether the request may succeed when retried.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// ChecksumError is returned when a downloaded database does not match the
+// content hash of its metadata.
+type ChecksumError struct {
+	URL  string
+	Want string
+	Got  string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: checksum mismatch: want sha256 %s, got %s", e.URL, e.Want, e.Got)
+}
+
+// Filter selects metadata records. Tags, Team and Visibility are applied by
+// the server; Owner, Repo and Language are applied by the client. Empty
+// fields match everything.
+type Filter struct {
+	// Tags lists tags a record must all carry.
+	Tags []string
+
+	// Team and Visibility must match exactly.
+	Team       string
+	Visibility string
+
+	// Owner and Repo match case-insensitively.
+	Owner string
+	Repo  string
+
+	// Language matches the primary language.
+	Language string
+}
+
+// query returns the server-side part of the filter.
+func (f Filter) query() url.Values {
+	q := url.Values{}
+	for _, tag := range f.Tags {
+		q.Add("tag", tag)
+	}
+	if f.Team != "" {
+		q.Set("team", f.Team)
+	}
+	if f.Visibility != "" {
+		q.Set("visibility", f.Visibility)
+	}
+	return q
+}
+
+// matchesServerSide applies the server-side part of the filter, for servers
+// that cannot.
+func (f Filter) matchesServerSide(m *api.DatabaseMetadataV2) bool {
+	if f.Team != "" && m.Team != f.Team {
+		return false
+	}
+	if f.Visibility != "" && m.Visibility != f.Visibility {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !slices.Contains(m.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches applies the client-side part of the filter.
+func (f Filter) matches(m *api.DatabaseMetadataV2) bool {
+	if f.Owner != "" && !strings.EqualFold(m.GitOwner, f.Owner) {
+		return false
+	}
+	if f.Repo != "" && !strings.EqualFold(m.GitRepo, f.Repo) {
+		return false
+	}
+	return f.Language == "" || m.PrimaryLanguage == f.Language
+}
+
+// EachMetadata streams the server's v2 metadata index, calling fn for every
+// record matching the filter. Records are decoded one at a time, so large
+// indexes are never held in memory. An error returned by fn stops the
+// iteration and is returned.
+//
+// Servers without the v2 index, such as Python HEPC servers, answer it with
+// 404; their v1 /index is streamed instead, with every record carrying only
+// the v1 fields. As those have no tags, team or visibility, the whole filter
+// is then applied by the client.
+func (c *Client) EachMetadata(ctx context.Context, filter Filter, fn func(api.DatabaseMetadataV2) error) error {
+	target := c.endpoint("/api/v2/index")
+	if q := filter.query(); len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+
+	v1 := false
+	resp, err := c.doWithRetry(ctx, target, nil)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		v1 = true
+		resp, err = c.doWithRetry(ctx, c.endpoint("/index"), nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var m api.DatabaseMetadataV2
+		if v1 {
+			err = dec.Decode(&m.DatabaseMetadata)
+		} else {
+			err = dec.Decode(&m)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to decode index record: %w", err)
+		}
+		if !filter.matches(&m) || (v1 && !filter.matchesServerSide(&m)) {
+			continue
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+}
+
+// ListMetadata returns all metadata records matching the filter.
+func (c *Client) ListMetadata(ctx context.Context, filter Filter) ([]api.DatabaseMetadataV2, error) {
+	var records []api.DatabaseMetadataV2
+	err := c.EachMetadata(ctx, filter, func(m api.DatabaseMetadataV2) error {
+		records = append(records, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Database returns the metadata record with the given content hash.
+func (c *Client) Database(ctx context.Context, contentHash string) (api.DatabaseMetadata, error) {
+	resp, err := c.doWithRetry(ctx, c.endpoint("/api/v1/databases/"+url.PathEscape(contentHash)), nil)
+	if err != nil {
+		return api.DatabaseMetadata{}, err
+	}
+	defer func() {
+		_ = resp.Body.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	var m api.DatabaseMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return api.DatabaseMetadata{}, fmt.Errorf("failed to decode database record: %w", err)
+	}
+	return m, nil
+}
+
+// Download downloads the database described by m to dest.
+//
+// Data is written to dest + ".part" and renamed to dest once complete. The
+// ETag or Last-Modified validator the server sent for it is kept in
+// dest + ".part.validator". An existing partial file is resumed with a Range
+// request carrying the validator in If-Range, and transient failures,
+// including interrupted transfers, resume from where they stopped. If the
+// file changed on the server, or no validator is known, the partial data is
+// discarded and the whole file downloaded. Unless verification is disabled,
+// the file is checked against m.ContentHash if that is a SHA-256 and the
+// server's X-Hash-Kind response header declares no other kind, such as
+// "md5" or the "language" kind of multi-language archives; a mismatching
+// partial file is removed and a *ChecksumError returned.
+func (c *Client) Download(ctx context.Context, m api.DatabaseMetadata, dest string) error {
+	if m.ResultURL == "" {
+		return fmt.Errorf("database %s has no result URL", m.ContentHash)
+	}
+
+	part := dest + ".part"
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644) //nolint:gosec // Destination chosen by the caller
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", part, err)
+	}
+	defer func() {
+		_ = f.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	// Hash what was downloaded before; this also positions f at its end
+	h := sha256.New()
+	offset, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", part, err)
+	}
+	dl := &download{target: m.ResultURL, f: f, h: h, offset: offset, validatorPath: part + ".validator"}
+	if validator, err := os.ReadFile(dl.validatorPath); err == nil {
+		dl.validator = strings.TrimSpace(string(validator))
+	}
+
+	backoff := c.retryBackoff
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if attempt > c.maxRetries {
+				return fmt.Errorf("download of %s failed after %d attempts: %w", m.ResultURL, attempt, lastErr)
+			}
+			if err := sleep(ctx, backoff); err != nil {
+				return errors.Join(err, lastErr)
+			}
+			backoff *= 2
+		}
+
+		lastErr = c.downloadFrom(ctx, dl)
+		if lastErr == nil {
+			break
+		}
+		if ctx.Err() != nil || !temporary(lastErr) {
+			return lastErr
+		}
+	}
+
+	if !c.skipVerify && verifiable(m.ContentHash, dl.hashKind) {
+		if got := hex.EncodeToString(h.Sum(nil)); got != m.ContentHash {
+			_ = f.Close()                   //nolint:errcheck // The corrupt file is removed
+			_ = os.Remove(part)             //nolint:errcheck // Best effort removal
+			_ = os.Remove(dl.validatorPath) //nolint:errcheck // Best effort removal
+			return &ChecksumError{URL: m.ResultURL, Want: m.ContentHash, Got: got}
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", part, err)
+	}
+	if err := os.Rename(part, dest); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", part, err)
+	}
+	_ = os.Remove(dl.validatorPath) //nolint:errcheck // Best effort removal
+	return nil
+}
+
+// verifiable reports whether contentHash can verify a download for which the
+// server declared hashKind in X-Hash-Kind. Servers that declare no kind,
+// such as v1 and Python HEPC servers, publish the SHA-256 of the archive, so
+// any SHA-256 is checked unless the server declares another kind.
+func verifiable(contentHash, hashKind string) bool {
+	if hashKind != "" {
+		return hashKind == api.HashKindSHA256
+	}
+	if len(contentHash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(contentHash)
+	return err == nil
+}
+
+// download is the state of a download in progress.
+type download struct {
+	target string
+
+	// f and h receive the data; offset is the number of bytes received
+	f      *os.File
+	h      hash.Hash
+	offset int64
+
+	// validator is the ETag or Last-Modified value the data was received
+	// with, kept in the file at validatorPath
+	validator     string
+	validatorPath string
+
+	// hashKind is the kind of content hash the server declares for the file
+	hashKind string
+}
+
+// setValidator records the validator of a response that starts the file
+// from the beginning. Weak entity tags cannot validate ranges and are
+// ignored in favour of Last-Modified.
+func (dl *download) setValidator(h http.Header) error {
+	dl.validator = h.Get("ETag")
+	if !strings.HasPrefix(dl.validator, `"`) {
+		dl.validator = h.Get("Last-Modified")
+	}
+	if dl.validator == "" {
+		if err := os.Remove(dl.validatorPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(dl.validatorPath, []byte(dl.validator+"\n"), 0o644) //nolint:gosec // Next to the caller's destination
+}
+
+// downloadFrom makes one download attempt, requesting the bytes from
+// dl.offset onwards and appending them to dl.f and dl.h. Data received
+// before is only resumed if its validator is known; otherwise, or if the
+// server no longer has the same file, the whole file is downloaded again.
+func (c *Client) downloadFrom(ctx context.Context, dl *download) error {
+	header := http.Header{}
+	if dl.offset > 0 && dl.validator != "" {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", dl.offset))
+		header.Set("If-Range", dl.validator)
+	}
+
+	resp, err := c.send(ctx, dl.target, header)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close() //nolint:errcheck // Best effort close in defer
+	}()
+	dl.hashKind = resp.Header.Get(hashKindHeader)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The whole file was sent; discard any partial data
+		if dl.offset > 0 {
+			if err := dl.f.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := dl.f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			dl.h.Reset()
+			dl.offset = 0
+		}
+		if err := dl.setValidator(resp.Header); err != nil {
+			return fmt.Errorf("failed to record validator of %s: %w", dl.target, err)
+		}
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", dl.offset)) {
+			return fmt.Errorf("%s: unexpected Content-Range %q", dl.target, resp.Header.Get("Content-Range"))
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is already complete
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", dl.offset) {
+			return nil
+		}
+		return &StatusError{URL: dl.target, StatusCode: resp.StatusCode, Status: resp.Status}
+	default:
+		return &StatusError{URL: dl.target, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	n, err := io.Copy(io.MultiWriter(dl.f, dl.h), resp.Body)
+	dl.offset += n
+	if err != nil {
+		return &transferError{err: err}
+	}
+	return nil
+}
+
+// transferError marks a failure while streaming a response body, which is
+// retried by resuming the download.
+type transferError struct {
+	err error
+}
+
+func (e *transferError) Error() string { return "transfer interrupted: " + e.err.Error() }
+func (e *transferError) Unwrap() error { return e.err }
+
+// temporary reports whether an error from send or downloadFrom may succeed
+// when retried.
+func temporary(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var transferErr *transferError
+	var urlErr *url.Error
+	return errors.As(err, &transferErr) || errors.As(err, &urlErr)
+}
+
+// doWithRetry issues a GET request, retrying network errors, 429 and 5xx
+// responses with exponential backoff. Any other non-200 response is returned
+// as a *StatusError.
+func (c *Client) doWithRetry(ctx context.Context, target string, header http.Header) (*http.Response, error) {
+	backoff := c.retryBackoff
+	var lastErr error
+
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, backoff); err != nil {
+				return nil, errors.Join(err, lastErr)
+			}
+			backoff *= 2
+		}
+
+		resp, err := c.send(ctx, target, header)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		_ = resp.Body.Close() //nolint:errcheck // Response is discarded
+		statusErr := &StatusError{URL: target, StatusCode: resp.StatusCode, Status: resp.Status}
+		if !statusErr.Temporary() {
+			return nil, statusErr
+		}
+		lastErr = statusErr
+	}
+
+	return nil, fmt.Errorf("request to %s failed after %d attempts: %w", target, c.maxRetries+1, lastErr)
+}
+
+// send issues a single GET request, adding the bearer token for the server's
+// own host.
+func (c *Client) send(ctx context.Context, target string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.token != "" && req.URL.Scheme == c.baseURL.Scheme && req.URL.Host == c.baseURL.Host {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.httpClient.Do(req)
+}
+
+// endpoint returns the URL of a server path.
+func (c *Client) endpoint(p string) string {
+	return c.baseURL.String() + p
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
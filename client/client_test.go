@@ -0,0 +1,570 @@
+package client
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/server"
+	"github.com/data-douser/mrva-go-hepc/internal/storage/local"
+)
+
+// testServer is a HEPC server over a directory of test databases. Requests
+// pass through intercept, if set, before reaching the server.
+type testServer struct {
+	*httptest.Server
+
+	// dir holds the databases served
+	dir string
+
+	mu        sync.Mutex
+	intercept func(w http.ResponseWriter, r *http.Request) bool
+	requests  []*http.Request
+}
+
+func newTestServer(t *testing.T, databases map[string]string) *testServer {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, owner := range databases {
+		createDatabaseZip(t, filepath.Join(dir, name), owner)
+	}
+
+	ts := &testServer{dir: dir}
+	var handler http.Handler
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		ts.requests = append(ts.requests, r)
+		intercept := ts.intercept
+		ts.mu.Unlock()
+		if intercept != nil && intercept(w, r) {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	backend, err := local.New(local.Config{BasePath: dir, EndpointURL: ts.URL})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	t.Cleanup(func() { _ = backend.Close() })
+	handler = server.New(server.Config{}, backend, slog.New(slog.NewTextHandler(io.Discard, nil))).Handler()
+
+	return ts
+}
+
+// setIntercept installs a request interceptor.
+func (ts *testServer) setIntercept(fn func(w http.ResponseWriter, r *http.Request) bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.intercept = fn
+}
+
+// requestsTo returns the requests made to paths with the given prefix.
+func (ts *testServer) requestsTo(prefix string) []*http.Request {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	var result []*http.Request
+	for _, r := range ts.requests {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// createDatabaseZip writes an archived database of owner/<repo> to path,
+// padded so downloads span several reads.
+func createDatabaseZip(t *testing.T, path, ownerRepo string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create zip: %v", err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"codeql-database.yml": "sourceLocationPrefix: /src/" + ownerRepo + "\nprimaryLanguage: go\n",
+		"db-go/default/x":     strings.Repeat(ownerRepo, 4096),
+	} {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+}
+
+func newTestClient(t *testing.T, cfg Config) *Client {
+	t.Helper()
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = 1
+	}
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return c
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		wantErr bool
+	}{
+		{"valid", "http://localhost:8070/", false},
+		{"missing", "", true},
+		{"relative", "localhost:8070", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(Config{BaseURL: tt.baseURL})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClient_ListMetadata(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"widgets.zip": "octo/widgets",
+		"gadgets.zip": "octo/gadgets",
+		"other.zip":   "acme/tools",
+	})
+	c := newTestClient(t, Config{BaseURL: ts.URL})
+	ctx := context.Background()
+
+	all, err := c.ListMetadata(ctx, Filter{})
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("ListMetadata() returned %d records, want 3", len(all))
+	}
+
+	octo, err := c.ListMetadata(ctx, Filter{Owner: "OCTO", Language: "go"})
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(octo) != 2 {
+		t.Errorf("ListMetadata(owner=OCTO) returned %d records, want 2", len(octo))
+	}
+
+	// Server-side filters are sent as query parameters
+	if _, err := c.ListMetadata(ctx, Filter{Tags: []string{"a", "b"}, Team: "platform"}); err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	reqs := ts.requestsTo("/api/v2/index")
+	if got := reqs[len(reqs)-1].URL.RawQuery; got != "tag=a&tag=b&team=platform" {
+		t.Errorf("query = %q, want %q", got, "tag=a&tag=b&team=platform")
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = c.EachMetadata(ctx, Filter{}, func(api.DatabaseMetadataV2) error {
+		count++
+		return stop
+	})
+	if !errors.Is(err, stop) || count != 1 {
+		t.Errorf("EachMetadata() = %v after %d records, want stop after 1", err, count)
+	}
+}
+
+func TestClient_ListMetadata_V1Server(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"widgets.zip": "octo/widgets",
+		"other.zip":   "acme/tools",
+	})
+	// A Python HEPC server has only the v1 index
+	ts.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasPrefix(r.URL.Path, "/api/v2/") {
+			http.NotFound(w, r)
+			return true
+		}
+		return false
+	})
+	c := newTestClient(t, Config{BaseURL: ts.URL})
+	ctx := context.Background()
+
+	octo, err := c.ListMetadata(ctx, Filter{Owner: "octo"})
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(octo) != 1 || octo[0].GitRepo != "widgets" || octo[0].ResultURL == "" {
+		t.Errorf("ListMetadata(owner=octo) = %+v, want the widgets record", octo)
+	}
+	if len(ts.requestsTo("/index")) == 0 {
+		t.Error("v1 index was not requested")
+	}
+
+	// Server-side filters cannot match records without tags
+	tagged, err := c.ListMetadata(ctx, Filter{Tags: []string{"critical"}})
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(tagged) != 0 {
+		t.Errorf("ListMetadata(tag=critical) returned %d records, want 0", len(tagged))
+	}
+}
+
+func TestClient_Database(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"widgets.zip": "octo/widgets"})
+	c := newTestClient(t, Config{BaseURL: ts.URL})
+	ctx := context.Background()
+
+	records, err := c.ListMetadata(ctx, Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListMetadata() = %v, %v", records, err)
+	}
+
+	m, err := c.Database(ctx, records[0].ContentHash)
+	if err != nil {
+		t.Fatalf("Database() error = %v", err)
+	}
+	if m.Projname != "octo/widgets" {
+		t.Errorf("Projname = %q, want %q", m.Projname, "octo/widgets")
+	}
+
+	_, err = c.Database(ctx, "missing")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Database(missing) error = %v, want 404 StatusError", err)
+	}
+}
+
+func TestClient_Download(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"widgets.zip": "octo/widgets"})
+	c := newTestClient(t, Config{BaseURL: ts.URL})
+	ctx := context.Background()
+
+	records, err := c.ListMetadata(ctx, Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListMetadata() = %v, %v", records, err)
+	}
+	m := records[0].DatabaseMetadata
+
+	dest := filepath.Join(t.TempDir(), "widgets.zip")
+	if err := c.Download(ctx, m, dest); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != m.ContentHash {
+		t.Error("downloaded file does not match ContentHash")
+	}
+	if _, err := os.Stat(dest + ".part"); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
+
+func TestClient_Download_Resume(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"widgets.zip": "octo/widgets"})
+	ctx := context.Background()
+
+	records, err := newTestClient(t, Config{BaseURL: ts.URL}).ListMetadata(ctx, Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListMetadata() = %v, %v", records, err)
+	}
+	m := records[0].DatabaseMetadata
+	data, err := os.ReadFile(filepath.Join(ts.dir, "widgets.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave the first half as an interrupted download
+	dest := filepath.Join(t.TempDir(), "widgets.zip")
+	var interrupted bool
+	ts.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasPrefix(r.URL.Path, "/db/") || interrupted {
+			return false
+		}
+		interrupted = true
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"`+m.ContentHash+`"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data[:len(data)/2])
+		return true
+	})
+	if err := newTestClient(t, Config{BaseURL: ts.URL, MaxRetries: -1}).Download(ctx, m, dest); err == nil {
+		t.Fatal("interrupted Download() succeeded")
+	}
+	validator, err := os.ReadFile(dest + ".part.validator")
+	if err != nil {
+		t.Fatalf("validator of partial file not kept: %v", err)
+	}
+
+	if err := newTestClient(t, Config{BaseURL: ts.URL}).Download(ctx, m, dest); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	reqs := ts.requestsTo("/db/")
+	last := reqs[len(reqs)-1]
+	if got := last.Header.Get("Range"); got != "bytes="+strconv.Itoa(len(data)/2)+"-" {
+		t.Errorf("Range = %q, want bytes=%d-", got, len(data)/2)
+	}
+	if got := last.Header.Get("If-Range"); got == "" || got != strings.TrimSpace(string(validator)) {
+		t.Errorf("If-Range = %q, want %q", got, validator)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil || string(got) != string(data) {
+		t.Errorf("resumed download differs from full download (err %v)", err)
+	}
+	if _, err := os.Stat(dest + ".part.validator"); !os.IsNotExist(err) {
+		t.Errorf("validator file left behind: %v", err)
+	}
+}
+
+func TestClient_Download_ReplacedFile(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"widgets.zip": "octo/widgets"})
+	c := newTestClient(t, Config{BaseURL: ts.URL})
+	ctx := context.Background()
+
+	records, err := c.ListMetadata(ctx, Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListMetadata() = %v, %v", records, err)
+	}
+	data, err := os.ReadFile(filepath.Join(ts.dir, "widgets.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A partial file of an earlier version of the database, and one whose
+	// version is unknown, are both downloaded again in full
+	for name, validator := range map[string]string{"stale": `"earlier-version"`, "unknown": ""} {
+		t.Run(name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "widgets.zip")
+			if err := os.WriteFile(dest+".part", []byte(strings.Repeat("x", len(data)/2)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if validator != "" {
+				if err := os.WriteFile(dest+".part.validator", []byte(validator), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := c.Download(ctx, records[0].DatabaseMetadata, dest); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			got, err := os.ReadFile(dest)
+			if err != nil || string(got) != string(data) {
+				t.Errorf("download spliced onto stale partial data (err %v)", err)
+			}
+		})
+	}
+}
+
+func TestClient_Download_RetriesInterruptedTransfer(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"widgets.zip": "octo/widgets"})
+	c := newTestClient(t, Config{BaseURL: ts.URL})
+	ctx := context.Background()
+
+	records, err := c.ListMetadata(ctx, Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListMetadata() = %v, %v", records, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(ts.dir, "widgets.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first download request fails with 503, the second is cut short
+	var attempts int
+	ts.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasPrefix(r.URL.Path, "/db/") {
+			return false
+		}
+		attempts++
+		switch attempts {
+		case 1:
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return true
+		case 2:
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Header().Set("ETag", `"`+records[0].ContentHash+`"`)
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(data[:len(data)/3])
+			return true
+		}
+		return false
+	})
+
+	dest := filepath.Join(t.TempDir(), "widgets.zip")
+	if err := c.Download(ctx, records[0].DatabaseMetadata, dest); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("download attempts = %d, want 3", attempts)
+	}
+	reqs := ts.requestsTo("/db/")
+	if got := reqs[len(reqs)-1].Header.Get("Range"); got == "" {
+		t.Error("interrupted transfer was not resumed with a Range request")
+	}
+}
+
+func TestClient_Download_ChecksumMismatch(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"widgets.zip": "octo/widgets"})
+	ctx := context.Background()
+
+	records, err := newTestClient(t, Config{BaseURL: ts.URL}).ListMetadata(ctx, Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListMetadata() = %v, %v", records, err)
+	}
+	m := records[0].DatabaseMetadata
+	m.ContentHash = strings.Repeat("0", 64)
+
+	dest := filepath.Join(t.TempDir(), "widgets.zip")
+	err = newTestClient(t, Config{BaseURL: ts.URL}).Download(ctx, m, dest)
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("Download() error = %v, want ChecksumError", err)
+	}
+	if _, err := os.Stat(dest + ".part"); !os.IsNotExist(err) {
+		t.Error("corrupt partial file was not removed")
+	}
+
+	if err := newTestClient(t, Config{BaseURL: ts.URL, SkipVerify: true}).Download(ctx, m, dest); err != nil {
+		t.Errorf("Download() with SkipVerify error = %v", err)
+	}
+}
+
+func TestClient_Download_UnverifiableHash(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"widgets.zip": "octo/widgets"})
+	ctx := context.Background()
+
+	records, err := newTestClient(t, Config{BaseURL: ts.URL}).ListMetadata(ctx, Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListMetadata() = %v, %v", records, err)
+	}
+	m := records[0].DatabaseMetadata
+	m.ContentHash = strings.Repeat("0", 64)
+	data, err := os.ReadFile(filepath.Join(ts.dir, "widgets.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hashes of other kinds are not SHA-256 of the archive even when they
+	// look like one
+	for _, kind := range []string{api.HashKindMD5, api.HashKindPath, api.HashKindMerkle, api.HashKindLanguage} {
+		t.Run(kind, func(t *testing.T) {
+			ts.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+				if !strings.HasPrefix(r.URL.Path, "/db/") {
+					return false
+				}
+				w.Header().Set(hashKindHeader, kind)
+				_, _ = w.Write(data)
+				return true
+			})
+			dest := filepath.Join(t.TempDir(), "widgets.zip")
+			if err := newTestClient(t, Config{BaseURL: ts.URL}).Download(ctx, m, dest); err != nil {
+				t.Errorf("Download() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_Download_UndeclaredHashKind(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"widgets.zip": "octo/widgets"})
+	ctx := context.Background()
+
+	records, err := newTestClient(t, Config{BaseURL: ts.URL}).ListMetadata(ctx, Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListMetadata() = %v, %v", records, err)
+	}
+	data, err := os.ReadFile(filepath.Join(ts.dir, "widgets.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A v1 or Python HEPC server sends no X-Hash-Kind
+	ts.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasPrefix(r.URL.Path, "/db/") {
+			return false
+		}
+		_, _ = w.Write(data)
+		return true
+	})
+
+	tests := []struct {
+		name        string
+		contentHash string
+		wantErr     bool
+	}{
+		{"matching SHA-256", records[0].ContentHash, false},
+		{"mismatching SHA-256", strings.Repeat("0", 64), true},
+		{"not a SHA-256", "abc123", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := records[0].DatabaseMetadata
+			m.ContentHash = tt.contentHash
+			err := newTestClient(t, Config{BaseURL: ts.URL}).Download(ctx, m, filepath.Join(t.TempDir(), "widgets.zip"))
+			var checksumErr *ChecksumError
+			if tt.wantErr != errors.As(err, &checksumErr) {
+				t.Errorf("Download() error = %v, want checksum error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClient_Token(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"widgets.zip": "octo/widgets"})
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Error("token sent to a foreign host")
+		}
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer other.Close()
+
+	ts.setIntercept(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return true
+		}
+		return false
+	})
+
+	ctx := context.Background()
+	c := newTestClient(t, Config{BaseURL: ts.URL, Token: "secret"})
+	if _, err := c.ListMetadata(ctx, Filter{}); err != nil {
+		t.Fatalf("ListMetadata() with token error = %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "foreign.zip")
+	if err := c.Download(ctx, api.DatabaseMetadata{ResultURL: other.URL + "/db/x.zip"}, dest); err != nil {
+		t.Fatalf("Download() from foreign host error = %v", err)
+	}
+
+	_, err := newTestClient(t, Config{BaseURL: ts.URL}).ListMetadata(ctx, Filter{})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("ListMetadata() without token error = %v, want 401 StatusError", err)
+	}
+}
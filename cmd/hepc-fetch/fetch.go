@@ -0,0 +1,622 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/client"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// manifestFileName is the name of the manifest written to the destination
+// directory. It is not a database, so serving the directory ignores it.
+const manifestFileName = "hepc-manifest.json"
+
+// Limits on what unzip extracts, so that an archive expanding far beyond
+// its size cannot fill the disk.
+const (
+	// maxEntrySize bounds the bytes extracted from one entry.
+	maxEntrySize = 16 << 30
+
+	// maxExpansion bounds the bytes extracted from an archive as a
+	// multiple of its size. Databases compress far less.
+	maxExpansion = 100
+)
+
+// manifest records the databases fetched into a directory.
+type manifest struct {
+	Server    string          `json:"server"`
+	UpdatedAt string          `json:"updated_at"`
+	Databases []manifestEntry `json:"databases"`
+}
+
+// manifestEntry describes one fetched database. Path is relative to the
+// destination directory and names the archive, or the extracted directory
+// when archives are unzipped.
+type manifestEntry struct {
+	Path     string               `json:"path"`
+	Metadata api.DatabaseMetadata `json:"metadata"`
+}
+
+// fetchConfig holds the configuration of a fetcher.
+type fetchConfig struct {
+	// Dest is the destination directory.
+	Dest string
+
+	// Parallel is the number of concurrent downloads (default: 1).
+	Parallel int
+
+	// Unzip extracts archives into directories and removes the archives.
+	Unzip bool
+
+	// DryRun lists the selected databases without downloading them.
+	DryRun bool
+}
+
+// fetcher downloads databases from a HEPC server into a local directory.
+type fetcher struct {
+	client *client.Client
+	server string
+	cfg    fetchConfig
+	logger *slog.Logger
+
+	// out receives the listing of a dry run
+	out io.Writer
+
+	// previous maps the manifestKey of each database recorded by an earlier
+	// run to its content hash; it is set by run
+	previous map[string]string
+}
+
+// artifact is one file on the server together with the metadata records it
+// holds; multi-language archives have one record per language.
+type artifact struct {
+	path    string
+	records []api.DatabaseMetadataV2
+}
+
+// fetchResult is the outcome of fetching one artifact.
+type fetchResult struct {
+	artifact artifact
+	local    string
+	err      error
+}
+
+// selection decides which metadata records to fetch.
+type selection struct {
+	filter client.Filter
+
+	// repos restricts the selection to these "owner/repo" keys, in lower
+	// case, when non-empty.
+	repos map[string]bool
+}
+
+// matches reports whether m is selected. The client filter has already been
+// applied by EachMetadata.
+func (s selection) matches(m *api.DatabaseMetadataV2) bool {
+	if len(s.repos) == 0 {
+		return true
+	}
+	return s.repos[strings.ToLower(m.GitOwner+"/"+m.GitRepo)]
+}
+
+// parseRepoList reads a repository list with one "owner/repo" per line.
+// Blank lines and lines starting with '#' are ignored.
+func parseRepoList(r io.Reader) (map[string]bool, error) {
+	repos := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		owner, repo, ok := strings.Cut(text, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return nil, fmt.Errorf("line %d: invalid repository %q (expected owner/repo)", line, text)
+		}
+		repos[strings.ToLower(text)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+// parseRate parses a bandwidth limit in bytes per second with an optional
+// K, M or G suffix (powers of 1024). Zero means unlimited.
+func parseRate(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	digits, multiplier := s, int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		digits = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q (expected bytes per second, e.g. 500K or 10M)", s)
+	}
+	return n * multiplier, nil
+}
+
+// rateLimitedTransport limits the rate at which response bodies are read,
+// shared across all requests made through it.
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+// newRateLimitedTransport returns a transport reading at most bytesPerSecond
+// across all responses.
+func newRateLimitedTransport(base http.RoundTripper, bytesPerSecond int64) *rateLimitedTransport {
+	burst := min(bytesPerSecond, 64<<10)
+	return &rateLimitedTransport{
+		base:    base,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst)),
+	}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &rateLimitedBody{ReadCloser: resp.Body, ctx: req.Context(), limiter: t.limiter}
+	return resp, nil
+}
+
+// rateLimitedBody is a response body throttled by a shared limiter.
+type rateLimitedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (b *rateLimitedBody) Read(p []byte) (int, error) {
+	if burst := b.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.WaitN(b.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// selectArtifacts lists the server's metadata and groups the selected
+// records by artifact, in index order.
+func (f *fetcher) selectArtifacts(ctx context.Context, sel selection) ([]artifact, error) {
+	var artifacts []artifact
+	byPath := make(map[string]int)
+
+	err := f.client.EachMetadata(ctx, sel.filter, func(m api.DatabaseMetadataV2) error {
+		if !sel.matches(&m) {
+			return nil
+		}
+		p, ok := storage.ArtifactPath(m.ResultURL)
+		if !ok {
+			f.logger.Warn("skipping database without a download path", "content_hash", m.ContentHash, "result_url", m.ResultURL)
+			return nil
+		}
+		if i, seen := byPath[p]; seen {
+			artifacts[i].records = append(artifacts[i].records, m)
+			return nil
+		}
+		byPath[p] = len(artifacts)
+		artifacts = append(artifacts, artifact{path: p, records: []api.DatabaseMetadataV2{m}})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %w", err)
+	}
+	return artifacts, nil
+}
+
+// run fetches the selected databases and updates the manifest. Databases
+// that fail to download are reported in the returned error; the others are
+// kept and recorded.
+func (f *fetcher) run(ctx context.Context, sel selection) error {
+	artifacts, err := f.selectArtifacts(ctx, sel)
+	if err != nil {
+		return err
+	}
+	f.logger.Info("selected databases", "artifacts", len(artifacts))
+
+	if f.cfg.DryRun {
+		for _, a := range artifacts {
+			for _, m := range a.records {
+				fmt.Fprintf(f.out, "%s\t%s/%s\t%s\t%d\n", a.path, m.GitOwner, m.GitRepo, m.PrimaryLanguage, m.DBFileSize)
+			}
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(f.cfg.Dest, 0o755); err != nil { //nolint:gosec // Destination is readable by the server serving it
+		return fmt.Errorf("failed to create %s: %w", f.cfg.Dest, err)
+	}
+	mf, err := f.loadManifest()
+	if err != nil {
+		return err
+	}
+	f.previous = make(map[string]string, len(mf.Databases))
+	for _, e := range mf.Databases {
+		f.previous[manifestKey(e.Path, e.Metadata.PrimaryLanguage)] = e.Metadata.ContentHash
+	}
+
+	results := f.fetchAll(ctx, artifacts)
+
+	var entries []manifestEntry
+	var errs []error
+	for _, res := range results {
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", res.artifact.path, res.err))
+			continue
+		}
+		for _, m := range res.artifact.records {
+			entries = append(entries, manifestEntry{Path: res.local, Metadata: m.DatabaseMetadata})
+		}
+	}
+
+	if err := f.updateManifest(entries); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d databases failed: %w", len(errs), len(results), errors.Join(errs...))
+	}
+	return nil
+}
+
+// fetchAll fetches artifacts with up to cfg.Parallel concurrent downloads.
+// Results are returned in the order of artifacts.
+func (f *fetcher) fetchAll(ctx context.Context, artifacts []artifact) []fetchResult {
+	results := make([]fetchResult, len(artifacts))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range max(f.cfg.Parallel, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				local, err := f.fetch(ctx, artifacts[i])
+				results[i] = fetchResult{artifact: artifacts[i], local: local, err: err}
+			}
+		}()
+	}
+	for i := range artifacts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// fetch downloads one artifact, writes its sidecar files and, if
+// configured, unzips it. It returns the local path relative to the
+// destination directory. Artifacts already present in the version the
+// server describes are skipped; older versions are replaced.
+func (f *fetcher) fetch(ctx context.Context, a artifact) (string, error) {
+	rel, err := localPath(a.path)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(f.cfg.Dest, filepath.FromSlash(rel))
+	m := a.records[0]
+
+	if f.cfg.Unzip {
+		rel = strings.TrimSuffix(rel, path.Ext(rel))
+		dir := filepath.Join(f.cfg.Dest, filepath.FromSlash(rel))
+		if f.upToDate(a, rel, dir) {
+			f.logger.Info("already present", "path", rel)
+			return rel, nil
+		}
+		// An archive left by an earlier failed extraction is reused if it
+		// is verifiably the current version
+		if !archiveMatches(target, a) {
+			if err := f.download(ctx, m.DatabaseMetadata, target); err != nil {
+				return "", err
+			}
+		}
+		if err := unzip(target, dir); err != nil {
+			return "", err
+		}
+		if err := os.Remove(target); err != nil {
+			return "", fmt.Errorf("failed to remove %s: %w", target, err)
+		}
+		if err := writeDirectorySidecars(dir, m); err != nil {
+			return "", err
+		}
+		f.logger.Info("fetched", "path", rel)
+		return rel, nil
+	}
+
+	if f.upToDate(a, rel, target) || archiveMatches(target, a) {
+		f.logger.Info("already present", "path", rel)
+		return rel, nil
+	}
+	if err := f.download(ctx, m.DatabaseMetadata, target); err != nil {
+		return "", err
+	}
+	sidecar := strings.TrimSuffix(target, filepath.Ext(target)) + ".hepc.yml"
+	if err := writeSidecar(sidecar, m); err != nil {
+		return "", err
+	}
+	f.logger.Info("fetched", "path", rel, "size", m.DBFileSize)
+	return rel, nil
+}
+
+// upToDate reports whether the database of a exists at local, with the path
+// rel relative to the destination directory, and the manifest of an earlier
+// run recorded it with the content hashes the server now reports.
+func (f *fetcher) upToDate(a artifact, rel, local string) bool {
+	if _, err := os.Stat(local); err != nil {
+		return false
+	}
+	for _, m := range a.records {
+		if hash, ok := f.previous[manifestKey(rel, m.PrimaryLanguage)]; !ok || hash != m.ContentHash {
+			return false
+		}
+	}
+	return true
+}
+
+// archiveMatches reports whether the file at local is the archive of a, as
+// verified by its SHA-256. Only single-language archives whose content hash
+// is the SHA-256 of the archive can be verified.
+func archiveMatches(local string, a artifact) bool {
+	if len(a.records) != 1 || a.records[0].HashKind != api.HashKindSHA256 {
+		return false
+	}
+	file, err := os.Open(local) //nolint:gosec // Path is confined to the destination directory
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = file.Close() //nolint:errcheck // Best effort close in defer
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == a.records[0].ContentHash
+}
+
+// download downloads m to target, creating parent directories.
+func (f *fetcher) download(ctx context.Context, m api.DatabaseMetadata, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil { //nolint:gosec // Destination is readable by the server serving it
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f.logger.Debug("downloading", "url", m.ResultURL, "target", target)
+	return f.client.Download(ctx, m, target)
+}
+
+// localPath maps an artifact path from the server to a slash-separated path
+// relative to the destination directory, rejecting paths that would escape it.
+func localPath(artifactPath string) (string, error) {
+	rel := strings.TrimPrefix(path.Clean("/"+artifactPath), "/")
+	if rel == "" || rel != artifactPath {
+		return "", fmt.Errorf("unsafe artifact path %q", artifactPath)
+	}
+	return rel, nil
+}
+
+// sidecarFor returns the sidecar describing m, so that serving the fetched
+// database reports the same repository, team and tags as the source server.
+func sidecarFor(m api.DatabaseMetadataV2) codeql.Sidecar {
+	return codeql.Sidecar{
+		Owner:      m.GitOwner,
+		Repo:       m.GitRepo,
+		Branch:     m.GitBranch,
+		Commit:     m.GitCommitID,
+		Visibility: m.Visibility,
+		Team:       m.Team,
+		Tags:       m.Tags,
+	}
+}
+
+// writeSidecar writes the sidecar of m to name.
+func writeSidecar(name string, m api.DatabaseMetadataV2) error {
+	data, err := yaml.Marshal(sidecarFor(m))
+	if err != nil {
+		return fmt.Errorf("failed to encode sidecar: %w", err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil { //nolint:gosec // Sidecars are served alongside databases
+		return fmt.Errorf("failed to write sidecar: %w", err)
+	}
+	return nil
+}
+
+// writeDirectorySidecars writes a hepc.yml sidecar into every database
+// directory under dir, as archives may hold the database below a top-level
+// directory.
+func writeDirectorySidecars(dir string, m api.DatabaseMetadataV2) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != "codeql-database.yml" {
+			return nil
+		}
+		return writeSidecar(filepath.Join(filepath.Dir(p), codeql.SidecarFileName), m)
+	})
+}
+
+// unzip extracts the archive src into the directory dest, replacing any
+// earlier version of it. Entries are extracted into a temporary directory
+// beside dest first, so an interrupted extraction never leaves a partial
+// database behind. Entries that would escape dest and symbolic links are
+// skipped. Extraction fails once an entry exceeds maxEntrySize or the
+// archive expands more than maxExpansion times.
+func unzip(src, dest string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer func() {
+		_ = r.Close() //nolint:errcheck // Best effort close in defer
+	}()
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	tmp := dest + ".part"
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("failed to clean %s: %w", tmp, err)
+	}
+	remaining := info.Size() * maxExpansion
+	for _, zf := range r.File {
+		n, err := extractFile(zf, tmp, min(maxEntrySize, remaining))
+		if err != nil {
+			_ = os.RemoveAll(tmp) //nolint:errcheck // Best effort cleanup
+			return fmt.Errorf("failed to extract %s: %w", src, err)
+		}
+		remaining -= n
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		return fmt.Errorf("failed to remove earlier %s: %w", dest, err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", tmp, err)
+	}
+	return nil
+}
+
+// extractFile extracts one archive entry below dir, failing if it holds
+// more than limit bytes. It returns the number of bytes extracted.
+func extractFile(zf *zip.File, dir string, limit int64) (int64, error) {
+	name := path.Clean("/" + zf.Name)
+	if name == "/" || zf.Mode()&fs.ModeSymlink != 0 {
+		return 0, nil
+	}
+	target := filepath.Join(dir, filepath.FromSlash(name))
+
+	if zf.FileInfo().IsDir() {
+		return 0, os.MkdirAll(target, 0o755) //nolint:gosec // Extracted databases are served
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil { //nolint:gosec // Extracted databases are served
+		return 0, err
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = rc.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644) //nolint:gosec // Path is confined to dir
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.CopyN(out, rc, limit+1)
+	if err == nil {
+		err = fmt.Errorf("%s expands beyond the limit of %d bytes", zf.Name, limit)
+	} else if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err != nil {
+		_ = out.Close() //nolint:errcheck // The copy error takes precedence
+		return n, err
+	}
+	return n, out.Close()
+}
+
+// manifestKey identifies a manifest entry by its path and language.
+func manifestKey(p, language string) string {
+	return p + "\x00" + language
+}
+
+// loadManifest reads the manifest of the destination directory. A missing
+// manifest is empty, and an unreadable one is ignored with a warning.
+func (f *fetcher) loadManifest() (manifest, error) {
+	name := filepath.Join(f.cfg.Dest, manifestFileName)
+
+	var mf manifest
+	data, err := os.ReadFile(name) //nolint:gosec // Manifest in the destination directory
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &mf); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring unreadable manifest %s: %v\n", name, err)
+			mf = manifest{}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return manifest{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return mf, nil
+}
+
+// updateManifest merges entries into the manifest of the destination
+// directory, replacing earlier entries for the same path and language.
+func (f *fetcher) updateManifest(entries []manifestEntry) error {
+	name := filepath.Join(f.cfg.Dest, manifestFileName)
+	mf, err := f.loadManifest()
+	if err != nil {
+		return err
+	}
+
+	key := func(e manifestEntry) string { return manifestKey(e.Path, e.Metadata.PrimaryLanguage) }
+	merged := make(map[string]manifestEntry, len(mf.Databases)+len(entries))
+	for _, e := range mf.Databases {
+		merged[key(e)] = e
+	}
+	for _, e := range entries {
+		merged[key(e)] = e
+	}
+
+	mf.Server = f.server
+	mf.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	mf.Databases = make([]manifestEntry, 0, len(merged))
+	for _, e := range merged {
+		mf.Databases = append(mf.Databases, e)
+	}
+	sort.Slice(mf.Databases, func(i, j int) bool { return key(mf.Databases[i]) < key(mf.Databases[j]) })
+
+	data, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil { //nolint:gosec // Manifest is served alongside databases
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
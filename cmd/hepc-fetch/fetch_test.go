@@ -0,0 +1,513 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/client"
+	"github.com/data-douser/mrva-go-hepc/internal/server"
+	"github.com/data-douser/mrva-go-hepc/internal/storage/local"
+)
+
+// newTestServer serves the databases in a new directory, given as archive
+// path to "owner/repo", and counts downloads.
+func newTestServer(t *testing.T, databases map[string]string, sidecars map[string]string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, ownerRepo := range databases {
+		createDatabaseZip(t, filepath.Join(dir, name), ownerRepo)
+	}
+	for name, content := range sidecars {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write sidecar: %v", err)
+		}
+	}
+
+	var downloads atomic.Int32
+	var handler http.Handler
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/db/") {
+			downloads.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	backend, err := local.New(local.Config{BasePath: dir, EndpointURL: ts.URL})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	t.Cleanup(func() { _ = backend.Close() })
+	handler = server.New(server.Config{}, backend, slog.New(slog.NewTextHandler(io.Discard, nil))).Handler()
+
+	return ts, &downloads
+}
+
+// createDatabaseZip writes an archived Go database of ownerRepo to path.
+func createDatabaseZip(t *testing.T, path, ownerRepo string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create zip: %v", err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"codeql_db/codeql-database.yml": "sourceLocationPrefix: /src/" + ownerRepo + "\nprimaryLanguage: go\n",
+		"codeql_db/db-go/default/x":     strings.Repeat(ownerRepo, 1024),
+	} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+}
+
+func newTestFetcher(t *testing.T, serverURL string, cfg fetchConfig) *fetcher {
+	t.Helper()
+
+	c, err := client.New(client.Config{BaseURL: serverURL, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return &fetcher{
+		client: c,
+		server: serverURL,
+		cfg:    cfg,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		out:    io.Discard,
+	}
+}
+
+func readManifest(t *testing.T, dir string) manifest {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	var mf manifest
+	if err := json.Unmarshal(data, &mf); err != nil {
+		t.Fatalf("failed to decode manifest: %v", err)
+	}
+	return mf
+}
+
+// serveLocally lists the metadata of dir as hepc-server --storage local would.
+func serveLocally(t *testing.T, dir string) map[string][]string {
+	t.Helper()
+
+	backend, err := local.New(local.Config{BasePath: dir, EndpointURL: "http://localhost"})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	idx, err := backend.Index(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list metadata: %v", err)
+	}
+	result := make(map[string][]string)
+	for _, m := range idx.Records() {
+		result[m.GitOwner+"/"+m.GitRepo] = append([]string{m.Team}, m.Tags...)
+	}
+	return result
+}
+
+func TestFetcher_Run(t *testing.T) {
+	ts, downloads := newTestServer(t, map[string]string{
+		"octo/hello.zip":  "octo/hello",
+		"octo/world.zip":  "octo/world",
+		"other/thing.zip": "other/thing",
+	}, map[string]string{
+		// The sidecar names a repository the path heuristic cannot find
+		"octo/hello.hepc.yml": "repository: octo-org/hello-world\nteam: red\ntags: [critical]\n",
+	})
+
+	dest := t.TempDir()
+	f := newTestFetcher(t, ts.URL, fetchConfig{Dest: dest, Parallel: 2})
+	if err := f.run(context.Background(), selection{filter: client.Filter{Owner: "OCTO-ORG"}}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "octo", "hello.zip")); err != nil {
+		t.Errorf("archive not fetched: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "octo", "world.zip")); err == nil {
+		t.Errorf("unselected archive was fetched")
+	}
+
+	mf := readManifest(t, dest)
+	if mf.Server != ts.URL || len(mf.Databases) != 1 || mf.Databases[0].Path != "octo/hello.zip" {
+		t.Fatalf("manifest = %+v, want one entry for octo/hello.zip", mf)
+	}
+
+	served := serveLocally(t, dest)
+	if want := map[string][]string{"octo-org/hello-world": {"red", "critical"}}; !reflect.DeepEqual(served, want) {
+		t.Errorf("served = %v, want %v", served, want)
+	}
+
+	// A second run with a wider selection only fetches what is missing
+	downloads.Store(0)
+	if err := f.run(context.Background(), selection{filter: client.Filter{Owner: "octo"}}); err != nil {
+		t.Fatalf("second run() error = %v", err)
+	}
+	if got := downloads.Load(); got != 1 {
+		t.Errorf("second run made %d downloads, want 1", got)
+	}
+	if mf := readManifest(t, dest); len(mf.Databases) != 2 {
+		t.Errorf("manifest has %d entries after second run, want 2", len(mf.Databases))
+	}
+}
+
+func TestFetcher_RunUnzip(t *testing.T) {
+	ts, _ := newTestServer(t, map[string]string{
+		"octo/hello.zip":  "octo/hello",
+		"other/thing.zip": "other/thing",
+	}, map[string]string{
+		"octo/hello.hepc.yml": "repository: octo-org/hello-world\ntags: [critical]\n",
+	})
+
+	dest := t.TempDir()
+	f := newTestFetcher(t, ts.URL, fetchConfig{Dest: dest, Parallel: 1, Unzip: true})
+	sel := selection{repos: map[string]bool{"octo-org/hello-world": true}}
+	if err := f.run(context.Background(), sel); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "octo", "hello.zip")); err == nil {
+		t.Errorf("archive was kept after extraction")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "octo", "hello", "codeql_db", "hepc.yml")); err != nil {
+		t.Errorf("sidecar not written into the database directory: %v", err)
+	}
+
+	mf := readManifest(t, dest)
+	if len(mf.Databases) != 1 || mf.Databases[0].Path != "octo/hello" {
+		t.Fatalf("manifest = %+v, want one entry for octo/hello", mf)
+	}
+
+	served := serveLocally(t, dest)
+	if want := map[string][]string{"octo-org/hello-world": {"", "critical"}}; !reflect.DeepEqual(served, want) {
+		t.Errorf("served = %v, want %v", served, want)
+	}
+}
+
+// writeStaleCopy leaves an older version of a database at rel in dest,
+// recorded in the manifest with another content hash.
+func writeStaleCopy(t *testing.T, dest, rel, language string, dir bool) {
+	t.Helper()
+
+	p := filepath.Join(dest, filepath.FromSlash(rel))
+	if dir {
+		p = filepath.Join(p, "stale")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte("older version"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := &fetcher{cfg: fetchConfig{Dest: dest}}
+	old := manifestEntry{Path: rel}
+	old.Metadata.ContentHash = strings.Repeat("0", 64)
+	old.Metadata.PrimaryLanguage = language
+	if err := f.updateManifest([]manifestEntry{old}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetcher_RunReplacesUpdatedDatabases(t *testing.T) {
+	ts, downloads := newTestServer(t, map[string]string{"octo/hello.zip": "octo/hello"}, nil)
+	ctx := context.Background()
+
+	t.Run("archive", func(t *testing.T) {
+		dest := t.TempDir()
+		writeStaleCopy(t, dest, "octo/hello.zip", "go", false)
+		downloads.Store(0)
+		if err := newTestFetcher(t, ts.URL, fetchConfig{Dest: dest}).run(ctx, selection{}); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		if got := downloads.Load(); got != 1 {
+			t.Errorf("run made %d downloads, want 1", got)
+		}
+
+		mf := readManifest(t, dest)
+		data, err := os.ReadFile(filepath.Join(dest, "octo", "hello.zip"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if len(mf.Databases) != 1 || hex.EncodeToString(sum[:]) != mf.Databases[0].Metadata.ContentHash {
+			t.Errorf("local archive does not match its manifest entry %+v", mf.Databases)
+		}
+
+		// Without a manifest the archive is verified against its SHA-256
+		if err := os.Remove(filepath.Join(dest, manifestFileName)); err != nil {
+			t.Fatal(err)
+		}
+		downloads.Store(0)
+		if err := newTestFetcher(t, ts.URL, fetchConfig{Dest: dest}).run(ctx, selection{}); err != nil {
+			t.Fatalf("second run() error = %v", err)
+		}
+		if got := downloads.Load(); got != 0 {
+			t.Errorf("second run made %d downloads, want 0", got)
+		}
+	})
+
+	t.Run("unzipped", func(t *testing.T) {
+		dest := t.TempDir()
+		writeStaleCopy(t, dest, "octo/hello", "go", true)
+		downloads.Store(0)
+		if err := newTestFetcher(t, ts.URL, fetchConfig{Dest: dest, Unzip: true}).run(ctx, selection{}); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		if got := downloads.Load(); got != 1 {
+			t.Errorf("run made %d downloads, want 1", got)
+		}
+		if _, err := os.Stat(filepath.Join(dest, "octo", "hello", "stale")); err == nil {
+			t.Error("older version was not replaced")
+		}
+		if _, err := os.Stat(filepath.Join(dest, "octo", "hello", "codeql_db", "codeql-database.yml")); err != nil {
+			t.Errorf("database not extracted: %v", err)
+		}
+	})
+}
+
+func TestFetcher_RunDryRun(t *testing.T) {
+	ts, downloads := newTestServer(t, map[string]string{"octo/hello.zip": "octo/hello"}, nil)
+
+	dest := filepath.Join(t.TempDir(), "dest")
+	f := newTestFetcher(t, ts.URL, fetchConfig{Dest: dest, Parallel: 1, DryRun: true})
+	var out bytes.Buffer
+	f.out = &out
+	if err := f.run(context.Background(), selection{}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if !strings.HasPrefix(out.String(), "octo/hello.zip\tocto/hello\tgo\t") {
+		t.Errorf("output = %q, want listing of octo/hello.zip", out.String())
+	}
+	if downloads.Load() != 0 {
+		t.Errorf("dry run downloaded databases")
+	}
+	if _, err := os.Stat(dest); err == nil {
+		t.Errorf("dry run created the destination directory")
+	}
+}
+
+func TestParseRepoList(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]bool
+		wantErr bool
+	}{
+		{
+			name:  "repositories with comments",
+			input: "# team repos\nOcto/Hello\n\n  other/thing  \n",
+			want:  map[string]bool{"octo/hello": true, "other/thing": true},
+		},
+		{
+			name:    "missing repo",
+			input:   "octo\n",
+			wantErr: true,
+		},
+		{
+			name:    "too many components",
+			input:   "github.com/octo/hello\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRepoList(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRepoList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRepoList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "", want: 0},
+		{input: "1000", want: 1000},
+		{input: "500K", want: 500 << 10},
+		{input: "10m", want: 10 << 20},
+		{input: "1G", want: 1 << 30},
+		{input: "fast", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "M", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseRate(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRate(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRate(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "octo/hello.zip", want: "octo/hello.zip"},
+		{input: "hello.zip", want: "hello.zip"},
+		{input: "../hello.zip", wantErr: true},
+		{input: "octo/../../hello.zip", wantErr: true},
+		{input: "/hello.zip", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := localPath(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("localPath(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("localPath(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnzip_SkipsEscapingEntries(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "evil.zip")
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"../escaped", "db/../../escaped2", "db/ok"} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		_, _ = fw.Write([]byte("x"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+	if err := os.WriteFile(src, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+
+	dest := filepath.Join(dir, "out", "evil")
+	if err := unzip(src, dest); err != nil {
+		t.Fatalf("unzip() error = %v", err)
+	}
+
+	for _, name := range []string{"escaped", "escaped2", filepath.Join("out", "escaped"), filepath.Join("out", "escaped2")} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("entry escaped to %s", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "db", "ok")); err != nil {
+		t.Errorf("regular entry not extracted: %v", err)
+	}
+}
+
+func TestUnzip_Bomb(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bomb.zip")
+
+	// Zeros compress about a thousandfold, beyond maxExpansion
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, err := w.Create("db/bomb")
+	if err != nil {
+		t.Fatalf("failed to create zip entry: %v", err)
+	}
+	_, _ = fw.Write(make([]byte, 8<<20))
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+	if err := os.WriteFile(src, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+
+	dest := filepath.Join(dir, "out", "bomb")
+	err = unzip(src, dest)
+	if err == nil || !strings.Contains(err.Error(), "expands beyond the limit") {
+		t.Fatalf("unzip() error = %v, want the expansion limit", err)
+	}
+	for _, p := range []string{dest, dest + ".part"} {
+		if _, err := os.Stat(p); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s left behind: %v", p, err)
+		}
+	}
+}
+
+func TestRateLimitedTransport(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 24<<10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer ts.Close()
+
+	// 16 KiB/s with a 16 KiB burst: the remaining 8 KiB take half a second
+	httpClient := &http.Client{Transport: newRateLimitedTransport(http.DefaultTransport, 16<<10)}
+
+	start := time.Now()
+	resp, err := httpClient.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if !bytes.Equal(data, body) {
+		t.Errorf("body was altered")
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("read took %v, want at least 400ms", elapsed)
+	}
+}
@@ -0,0 +1,221 @@
+// hepc-fetch downloads a selection of CodeQL databases from a HEPC server.
+//
+// Databases are selected from the server's index by filters or a repository
+// list, downloaded in parallel with resume and checksum verification, and
+// stored with sidecar files and a manifest, so the destination directory can
+// itself be served with "hepc-server --storage local".
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/data-douser/mrva-go-hepc/client"
+)
+
+const defaultParallel = 4
+
+// stringSliceFlag collects the values of a repeatable string flag.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func main() {
+	// Server flags
+	serverURL := flag.String("server", "", "Base URL of the HEPC server (required)")
+	token := flag.String("token", os.Getenv("HEPC_TOKEN"), "Bearer token for the server (default: $HEPC_TOKEN)")
+
+	// Selection flags
+	owner := flag.String("owner", "", "Select databases of repositories owned by this owner")
+	repo := flag.String("repo", "", "Select databases of repositories with this name")
+	language := flag.String("language", "", "Select databases with this primary language")
+	team := flag.String("team", "", "Select databases owned by this team")
+	visibility := flag.String("visibility", "", "Select databases with this repository visibility")
+	var tags stringSliceFlag
+	flag.Var(&tags, "tag", "Select databases carrying this tag (repeatable, all must match)")
+	repoList := flag.String("repo-list", "", "File listing repositories to select, one owner/repo per line")
+
+	// Download flags
+	dest := flag.String("dest", "", "Destination directory (required)")
+	parallel := flag.Int("parallel", defaultParallel, "Number of concurrent downloads")
+	limitRate := flag.String("limit-rate", "", "Total bandwidth limit in bytes per second, e.g. 500K or 10M")
+	retries := flag.Int("retries", 3, "Retries for failed requests and interrupted downloads")
+	unzip := flag.Bool("unzip", false, "Extract archives into database directories")
+	skipVerify := flag.Bool("skip-verify", false, "Do not verify downloads against their content hash")
+	dryRun := flag.Bool("dry-run", false, "List the selected databases without downloading them")
+
+	verbose := flag.Bool("verbose", false, "Log every download")
+	help := flag.Bool("help", false, "Show help message")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `hepc-fetch - Download CodeQL databases from a HEPC server
+
+USAGE:
+    hepc-fetch --server <url> --dest <directory> [SELECTION OPTIONS] [DOWNLOAD OPTIONS]
+
+DESCRIPTION:
+    Downloads the databases selected from a HEPC server's index into a
+    local directory. Partial downloads are resumed on the next run.
+    Databases already present are skipped unless the server reports a new
+    content hash for them, in which case they are downloaded again.
+
+    Each database is stored under the same path as on the server, with a
+    sidecar file recording its repository, team, visibility and tags, and
+    listed in hepc-manifest.json. The directory can be served with:
+
+        hepc-server --storage local --db-dir <directory>
+
+OPTIONS:
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+EXAMPLES:
+    # All Go databases of one owner
+    hepc-fetch --server https://hepc.example.com --dest ./dbs \
+        --owner octo-org --language go
+
+    # Repositories from a list, four at a time, limited to 10 MiB/s
+    hepc-fetch --server https://hepc.example.com --dest ./dbs \
+        --repo-list repos.txt --parallel 4 --limit-rate 10M
+
+    # Preview a selection by tag
+    hepc-fetch --server https://hepc.example.com --dest ./dbs \
+        --tag critical --dry-run
+
+`)
+	}
+
+	flag.Parse()
+
+	if *help {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	opts := options{
+		server:     *serverURL,
+		token:      *token,
+		repoList:   *repoList,
+		limitRate:  *limitRate,
+		retries:    *retries,
+		skipVerify: *skipVerify,
+		fetch: fetchConfig{
+			Dest:     *dest,
+			Parallel: *parallel,
+			Unzip:    *unzip,
+			DryRun:   *dryRun,
+		},
+		filter: client.Filter{
+			Tags:       tags,
+			Team:       *team,
+			Visibility: *visibility,
+			Owner:      *owner,
+			Repo:       *repo,
+			Language:   *language,
+		},
+	}
+
+	f, sel, err := newFetcher(opts, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := f.run(ctx, sel); err != nil {
+		stop()
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// options holds the parsed command-line options.
+type options struct {
+	server     string
+	token      string
+	repoList   string
+	limitRate  string
+	retries    int
+	skipVerify bool
+	fetch      fetchConfig
+	filter     client.Filter
+}
+
+// newFetcher validates the options and creates the fetcher and selection.
+func newFetcher(opts options, logger *slog.Logger) (*fetcher, selection, error) {
+	if opts.server == "" {
+		return nil, selection{}, fmt.Errorf("--server is required")
+	}
+	if opts.fetch.Dest == "" && !opts.fetch.DryRun {
+		return nil, selection{}, fmt.Errorf("--dest is required")
+	}
+	if opts.fetch.Parallel < 1 {
+		return nil, selection{}, fmt.Errorf("--parallel must be at least 1")
+	}
+
+	sel := selection{filter: opts.filter}
+	if opts.repoList != "" {
+		f, err := os.Open(opts.repoList)
+		if err != nil {
+			return nil, selection{}, fmt.Errorf("failed to open repository list: %w", err)
+		}
+		sel.repos, err = parseRepoList(f)
+		_ = f.Close() //nolint:errcheck // Read-only file
+		if err != nil {
+			return nil, selection{}, fmt.Errorf("invalid repository list %s: %w", opts.repoList, err)
+		}
+		if len(sel.repos) == 0 {
+			return nil, selection{}, fmt.Errorf("repository list %s is empty", opts.repoList)
+		}
+	}
+
+	bytesPerSecond, err := parseRate(opts.limitRate)
+	if err != nil {
+		return nil, selection{}, err
+	}
+	httpClient := &http.Client{}
+	if bytesPerSecond > 0 {
+		httpClient.Transport = newRateLimitedTransport(http.DefaultTransport, bytesPerSecond)
+	}
+
+	// The client treats zero as its default; zero retries means none
+	maxRetries := opts.retries
+	if maxRetries == 0 {
+		maxRetries = -1
+	}
+	c, err := client.New(client.Config{
+		BaseURL:    opts.server,
+		Token:      opts.token,
+		HTTPClient: httpClient,
+		MaxRetries: maxRetries,
+		SkipVerify: opts.skipVerify,
+	})
+	if err != nil {
+		return nil, selection{}, err
+	}
+
+	return &fetcher{client: c, server: opts.server, cfg: opts.fetch, logger: logger, out: os.Stdout}, sel, nil
+}
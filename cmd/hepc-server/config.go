@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variables that override the
+// configuration file, e.g. HEPC_GCS_BUCKET for --gcs-bucket.
+const envPrefix = "HEPC_"
+
+// redacted replaces secrets in printed configurations.
+const redacted = "<redacted>"
+
+// config is the effective configuration of hepc-server. Each setting is
+// taken from the first of: a command-line flag, a HEPC_* environment
+// variable, the --config file, or the default.
+type config struct {
+	Server  serverSection  `yaml:"server"`
+	Storage storageSection `yaml:"storage"`
+	Logging loggingSection `yaml:"logging"`
+	Auth    authSection    `yaml:"auth"`
+}
+
+type serverSection struct {
+	Host        string `yaml:"host"`
+	Port        int    `yaml:"port"`
+	EndpointURL string `yaml:"endpoint_url"`
+}
+
+type storageSection struct {
+	Type          string        `yaml:"type"`
+	CacheTTL      time.Duration `yaml:"cache_ttl"`
+	IdentityRules []string      `yaml:"identity_rules"`
+	Validation    string        `yaml:"validation"`
+	Local         localSection  `yaml:"local"`
+	GCS           gcsSection    `yaml:"gcs"`
+	HEPC          hepcSection   `yaml:"hepc"`
+}
+
+type localSection struct {
+	DBDir          string   `yaml:"db_dir"`
+	SymlinkTargets []string `yaml:"symlink_targets"`
+	HashCache      string   `yaml:"hash_cache"`
+	StateDir       string   `yaml:"state_dir"`
+}
+
+type gcsSection struct {
+	Bucket          string `yaml:"bucket"`
+	Prefix          string `yaml:"prefix"`
+	CredentialsFile string `yaml:"credentials_file"`
+	CacheDir        string `yaml:"cache_dir"`
+	Subscription    string `yaml:"subscription"`
+}
+
+type hepcSection struct {
+	Upstreams []string      `yaml:"upstreams"`
+	Headers   []string      `yaml:"headers"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+type loggingSection struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type authSection struct {
+	Tokens      []string `yaml:"tokens"`
+	AdminTokens []string `yaml:"admin_tokens"`
+}
+
+// defaultConfig returns the configuration used when nothing is set.
+func defaultConfig() config {
+	return config{
+		Server: serverSection{
+			Host: defaultHost,
+			Port: defaultPort,
+		},
+		Storage: storageSection{
+			Type:       defaultStorageType,
+			CacheTTL:   5 * time.Minute,
+			Validation: "basic",
+			HEPC:       hepcSection{Timeout: 30 * time.Second},
+		},
+		Logging: loggingSection{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// setting describes one configuration value and the flag and environment
+// variable that set it. The flags, environment variables and the options
+// section of the usage text are all generated from settings, so they cannot
+// drift apart.
+type setting struct {
+	flag  string
+	group string
+	usage string
+
+	// field returns a pointer to the value in c: *string, *int,
+	// *time.Duration or *[]string. Lists are set by repeating the flag or by
+	// newline-separated environment values.
+	field func(c *config) any
+}
+
+// env returns the name of the environment variable for s.
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.flag, "-", "_"))
+}
+
+// settings lists every configuration value, in usage order.
+var settings = []setting{
+	{flag: "host", group: "SERVER", usage: "Host address for the HTTP server",
+		field: func(c *config) any { return &c.Server.Host }},
+	{flag: "port", group: "SERVER", usage: "Port for the HTTP server",
+		field: func(c *config) any { return &c.Server.Port }},
+	{flag: "endpoint-url", group: "SERVER", usage: "Base URL for result URLs (default: http://<host>:<port>)",
+		field: func(c *config) any { return &c.Server.EndpointURL }},
+
+	{flag: "storage", group: "STORAGE", usage: "Storage backend type: local, gcs or hepc",
+		field: func(c *config) any { return &c.Storage.Type }},
+	{flag: "cache-ttl", group: "STORAGE", usage: "How long discovered metadata is cached before rediscovery",
+		field: func(c *config) any { return &c.Storage.CacheTTL }},
+	{flag: "identity-rule", group: "STORAGE", usage: "Map database paths or file names to owner/repo as path:<regexp> or filename:<regexp> (repeatable)",
+		field: func(c *config) any { return &c.Storage.IdentityRules }},
+	{flag: "validation", group: "STORAGE", usage: "Database validation: basic, or strict to also verify every archive checksum and the dataset structure",
+		field: func(c *config) any { return &c.Storage.Validation }},
+
+	{flag: "db-dir", group: "LOCAL STORAGE", usage: "Directory containing CodeQL databases (required for local storage)",
+		field: func(c *config) any { return &c.Storage.Local.DBDir }},
+	{flag: "symlink-target", group: "LOCAL STORAGE", usage: "Directory outside --db-dir that symlinks under it may point to (repeatable; other such symlinks are refused)",
+		field: func(c *config) any { return &c.Storage.Local.SymlinkTargets }},
+	{flag: "hash-cache", group: "LOCAL STORAGE", usage: "File persisting the content hashes of unarchived databases across restarts (default: hash-cache.json in --state-dir)",
+		field: func(c *config) any { return &c.Storage.Local.HashCache }},
+	{flag: "state-dir", group: "LOCAL STORAGE", usage: "Directory persisting the discovered metadata, served on startup while rediscovering",
+		field: func(c *config) any { return &c.Storage.Local.StateDir }},
+
+	{flag: "gcs-bucket", group: "GCS STORAGE", usage: "GCS bucket name (required for gcs storage)",
+		field: func(c *config) any { return &c.Storage.GCS.Bucket }},
+	{flag: "gcs-prefix", group: "GCS STORAGE", usage: "Object path prefix within the bucket",
+		field: func(c *config) any { return &c.Storage.GCS.Prefix }},
+	{flag: "gcs-credentials", group: "GCS STORAGE", usage: "Service account JSON key file (uses ADC if not specified)",
+		field: func(c *config) any { return &c.Storage.GCS.CredentialsFile }},
+	{flag: "gcs-cache-dir", group: "GCS STORAGE", usage: "Local directory for caching GCS metadata, served on startup while rediscovering (uses a temp dir if not specified)",
+		field: func(c *config) any { return &c.Storage.GCS.CacheDir }},
+	{flag: "gcs-subscription", group: "GCS STORAGE", usage: "Pub/Sub subscription (projects/<project>/subscriptions/<id>) receiving the bucket's object change notifications",
+		field: func(c *config) any { return &c.Storage.GCS.Subscription }},
+
+	{flag: "hepc-upstream", group: "HEPC FEDERATION", usage: "Upstream HEPC server as [name=]url (repeatable, required for hepc storage)",
+		field: func(c *config) any { return &c.Storage.HEPC.Upstreams }},
+	{flag: "hepc-header", group: "HEPC FEDERATION", usage: "Header sent to upstreams as [name=]Header: value (repeatable, values are secret)",
+		field: func(c *config) any { return &c.Storage.HEPC.Headers }},
+	{flag: "hepc-timeout", group: "HEPC FEDERATION", usage: "Timeout for upstream requests",
+		field: func(c *config) any { return &c.Storage.HEPC.Timeout }},
+
+	{flag: "log-level", group: "LOGGING", usage: "Log level: debug, info, warn or error",
+		field: func(c *config) any { return &c.Logging.Level }},
+	{flag: "log-format", group: "LOGGING", usage: "Log format: text or json",
+		field: func(c *config) any { return &c.Logging.Format }},
+
+	{flag: "auth-token", group: "AUTHENTICATION", usage: "Bearer token clients must present (repeatable, secret; health probes stay open)",
+		field: func(c *config) any { return &c.Auth.Tokens }},
+	{flag: "admin-token", group: "AUTHENTICATION", usage: "Bearer token for the /admin/ endpoints, which are disabled without one (repeatable, secret)",
+		field: func(c *config) any { return &c.Auth.AdminTokens }},
+}
+
+// settingValue adapts a configuration field to flag.Value. Repeated list
+// flags append to the list.
+type settingValue struct {
+	field any
+}
+
+func (v settingValue) String() string {
+	if v.field == nil {
+		return ""
+	}
+	switch p := v.field.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *time.Duration:
+		return p.String()
+	case *[]string:
+		return strings.Join(*p, ",")
+	}
+	return ""
+}
+
+func (v settingValue) Set(value string) error {
+	if p, ok := v.field.(*[]string); ok {
+		*p = append(*p, value)
+		return nil
+	}
+	return setField(v.field, value)
+}
+
+// setField parses value into the field. Lists are replaced by the
+// newline-separated values.
+func setField(field any, value string) error {
+	switch p := field.(type) {
+	case *string:
+		*p = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*p = n
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*p = d
+	case *[]string:
+		var values []string
+		for line := range strings.SplitSeq(value, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				values = append(values, line)
+			}
+		}
+		*p = values
+	default:
+		return fmt.Errorf("unsupported setting type %T", field)
+	}
+	return nil
+}
+
+// copyField copies the value of one field to another of the same type.
+func copyField(dst, src any) {
+	switch p := dst.(type) {
+	case *string:
+		*p = *src.(*string)
+	case *int:
+		*p = *src.(*int)
+	case *time.Duration:
+		*p = *src.(*time.Duration)
+	case *[]string:
+		*p = append([]string(nil), *src.(*[]string)...)
+	}
+}
+
+// options holds the command-line options that are not configuration values.
+type options struct {
+	configFile  string
+	printConfig bool
+	help        bool
+}
+
+// parseFlags registers the settings and options on fs and parses args.
+// It returns the options and the configuration given by the flags alone,
+// along with the names of the flags that were set.
+func parseFlags(fs *flag.FlagSet, args []string) (options, config, map[string]bool, error) {
+	var opts options
+	fs.StringVar(&opts.configFile, "config", "", "YAML configuration file, .yml or .yaml (env: HEPC_CONFIG)")
+	fs.BoolVar(&opts.printConfig, "print-config", false, "Print the effective configuration with secrets redacted and exit")
+	fs.BoolVar(&opts.help, "help", false, "Show help message")
+
+	flagCfg := defaultConfig()
+	for _, s := range settings {
+		fs.Var(settingValue{field: s.field(&flagCfg)}, s.flag, s.usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return options{}, config{}, nil, err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return opts, flagCfg, set, nil
+}
+
+// loadConfig builds the effective configuration from the defaults, the
+// configuration file, the environment and the flags that were set, in
+// increasing order of precedence. getenv looks up environment variables.
+func loadConfig(opts options, flagCfg config, flagsSet map[string]bool, getenv func(string) string) (config, error) {
+	cfg := defaultConfig()
+
+	configFile := opts.configFile
+	if !flagsSet["config"] {
+		configFile = getenv(envPrefix + "CONFIG")
+	}
+	if configFile != "" {
+		// Only YAML is parsed; a TOML or JSON file would otherwise fail
+		// with a confusing YAML syntax error
+		switch ext := strings.ToLower(filepath.Ext(configFile)); ext {
+		case "", ".yml", ".yaml":
+		default:
+			return config{}, fmt.Errorf("unsupported config file %s: only YAML (.yml or .yaml) is supported, not %s", configFile, ext)
+		}
+		data, err := os.ReadFile(configFile) //nolint:gosec // File named by the operator
+		if err != nil {
+			return config{}, fmt.Errorf("failed to read config file: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+			return config{}, fmt.Errorf("invalid config file %s: %w", configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env()); value != "" {
+			if err := setField(s.field(&cfg), value); err != nil {
+				return config{}, fmt.Errorf("invalid %s: %w", s.env(), err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if flagsSet[s.flag] {
+			copyField(s.field(&cfg), s.field(&flagCfg))
+		}
+	}
+
+	if _, err := parseLogLevel(cfg.Logging.Level); err != nil {
+		return config{}, err
+	}
+	if cfg.Logging.Format != "text" && cfg.Logging.Format != "json" {
+		return config{}, fmt.Errorf("invalid log format %q (expected text or json)", cfg.Logging.Format)
+	}
+	return cfg, nil
+}
+
+// redact returns a copy of cfg with secrets replaced: auth tokens and the
+// values of upstream headers, which typically carry credentials.
+func (c config) redact() config {
+	r := c
+	r.Auth.Tokens = redactAll(c.Auth.Tokens)
+	r.Auth.AdminTokens = redactAll(c.Auth.AdminTokens)
+	r.Storage.HEPC.Headers = make([]string, len(c.Storage.HEPC.Headers))
+	for i, h := range c.Storage.HEPC.Headers {
+		key, _, _ := strings.Cut(h, ":")
+		r.Storage.HEPC.Headers[i] = key + ": " + redacted
+	}
+	return r
+}
+
+// redactAll returns as many redacted placeholders as there are secrets.
+func redactAll(secrets []string) []string {
+	r := make([]string, len(secrets))
+	for i := range secrets {
+		r[i] = redacted
+	}
+	return r
+}
+
+// writeConfig writes cfg as YAML with secrets redacted.
+func writeConfig(w io.Writer, cfg config) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg.redact()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// parseLogLevel parses a log level name.
+func parseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", level)
+	}
+	return l, nil
+}
+
+// newLogger creates the logger described by the logging configuration.
+func newLogger(cfg loggingSection, w io.Writer) *slog.Logger {
+	level, _ := parseLogLevel(cfg.Level) //nolint:errcheck // Validated by loadConfig
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// writeOptions writes the options section of the usage text, grouped by
+// setting group, with each option's environment variable and default.
+func writeOptions(w io.Writer, fs *flag.FlagSet) {
+	group := ""
+	for _, s := range settings {
+		if s.group != group {
+			group = s.group
+			fmt.Fprintf(w, "\n%s OPTIONS:\n", group)
+		}
+		f := fs.Lookup(s.flag)
+		fmt.Fprintf(w, "    --%s\n        %s\n        env: %s", s.flag, s.usage, s.env())
+		if f.DefValue != "" {
+			fmt.Fprintf(w, ", default: %s", f.DefValue)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "\nGENERAL OPTIONS:\n")
+	for _, name := range []string{"config", "print-config", "help"} {
+		f := fs.Lookup(name)
+		fmt.Fprintf(w, "    --%s\n        %s\n", f.Name, f.Usage)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestConfig parses args and loads the configuration with env as the
+// environment.
+func loadTestConfig(t *testing.T, args []string, env map[string]string) (config, error) {
+	t.Helper()
+
+	fs := flag.NewFlagSet("hepc-server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts, flagCfg, flagsSet, err := parseFlags(fs, args)
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+	return loadConfig(opts, flagCfg, flagsSet, func(key string) string { return env[key] })
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hepc.yml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	file := writeConfigFile(t, `
+server:
+  host: 0.0.0.0
+  port: 9000
+storage:
+  type: gcs
+  cache_ttl: 1m
+  gcs:
+    bucket: file-bucket
+    prefix: file-prefix
+  hepc:
+    upstreams: [a=https://a.example.com, b=https://b.example.com]
+logging:
+  format: json
+`)
+
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		check func(t *testing.T, cfg config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg config) {
+				if !reflect.DeepEqual(cfg, defaultConfig()) {
+					t.Errorf("config = %+v, want defaults", cfg)
+				}
+			},
+		},
+		{
+			name: "file overrides defaults",
+			args: []string{"--config", file},
+			check: func(t *testing.T, cfg config) {
+				if cfg.Server.Host != "0.0.0.0" || cfg.Server.Port != 9000 {
+					t.Errorf("server = %+v, want 0.0.0.0:9000", cfg.Server)
+				}
+				if cfg.Storage.CacheTTL != time.Minute {
+					t.Errorf("CacheTTL = %v, want 1m", cfg.Storage.CacheTTL)
+				}
+				if cfg.Storage.HEPC.Timeout != 30*time.Second {
+					t.Errorf("HEPC.Timeout = %v, want default 30s", cfg.Storage.HEPC.Timeout)
+				}
+				if cfg.Logging.Level != "info" || cfg.Logging.Format != "json" {
+					t.Errorf("logging = %+v, want info/json", cfg.Logging)
+				}
+			},
+		},
+		{
+			name: "environment overrides file",
+			args: []string{"--config", file},
+			env: map[string]string{
+				"HEPC_PORT":          "9100",
+				"HEPC_GCS_BUCKET":    "env-bucket",
+				"HEPC_HEPC_UPSTREAM": "c=https://c.example.com\n\n d=https://d.example.com \n",
+			},
+			check: func(t *testing.T, cfg config) {
+				if cfg.Server.Port != 9100 || cfg.Server.Host != "0.0.0.0" {
+					t.Errorf("server = %+v, want 0.0.0.0:9100", cfg.Server)
+				}
+				if cfg.Storage.GCS.Bucket != "env-bucket" || cfg.Storage.GCS.Prefix != "file-prefix" {
+					t.Errorf("gcs = %+v, want env-bucket/file-prefix", cfg.Storage.GCS)
+				}
+				want := []string{"c=https://c.example.com", "d=https://d.example.com"}
+				if !reflect.DeepEqual(cfg.Storage.HEPC.Upstreams, want) {
+					t.Errorf("upstreams = %v, want %v", cfg.Storage.HEPC.Upstreams, want)
+				}
+			},
+		},
+		{
+			name: "flags override environment",
+			args: []string{"--config", file, "--port", "9200", "--hepc-upstream", "e=https://e.example.com", "--cache-ttl", "10s"},
+			env: map[string]string{
+				"HEPC_PORT":          "9100",
+				"HEPC_CACHE_TTL":     "2m",
+				"HEPC_HEPC_UPSTREAM": "c=https://c.example.com",
+			},
+			check: func(t *testing.T, cfg config) {
+				if cfg.Server.Port != 9200 {
+					t.Errorf("Port = %d, want 9200", cfg.Server.Port)
+				}
+				if cfg.Storage.CacheTTL != 10*time.Second {
+					t.Errorf("CacheTTL = %v, want 10s", cfg.Storage.CacheTTL)
+				}
+				want := []string{"e=https://e.example.com"}
+				if !reflect.DeepEqual(cfg.Storage.HEPC.Upstreams, want) {
+					t.Errorf("upstreams = %v, want %v", cfg.Storage.HEPC.Upstreams, want)
+				}
+			},
+		},
+		{
+			name: "config file from environment",
+			env:  map[string]string{"HEPC_CONFIG": file},
+			check: func(t *testing.T, cfg config) {
+				if cfg.Storage.Type != "gcs" {
+					t.Errorf("Type = %q, want %q", cfg.Storage.Type, "gcs")
+				}
+			},
+		},
+		{
+			name: "repeated flags",
+			args: []string{"--auth-token", "one", "--auth-token", "two"},
+			check: func(t *testing.T, cfg config) {
+				if want := []string{"one", "two"}; !reflect.DeepEqual(cfg.Auth.Tokens, want) {
+					t.Errorf("tokens = %v, want %v", cfg.Auth.Tokens, want)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, tt.args, tt.env)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "unknown file key",
+			file:    "server:\n  hots: 0.0.0.0\n",
+			wantErr: "field hots not found",
+		},
+		{
+			name:    "invalid duration in file",
+			file:    "storage:\n  cache_ttl: 30\n",
+			wantErr: "invalid config file",
+		},
+		{
+			name:    "invalid port in environment",
+			env:     map[string]string{"HEPC_PORT": "http"},
+			wantErr: "HEPC_PORT",
+		},
+		{
+			name:    "invalid duration in environment",
+			env:     map[string]string{"HEPC_HEPC_TIMEOUT": "soon"},
+			wantErr: "HEPC_HEPC_TIMEOUT",
+		},
+		{
+			name:    "invalid log level",
+			env:     map[string]string{"HEPC_LOG_LEVEL": "verbose"},
+			wantErr: "invalid log level",
+		},
+		{
+			name:    "invalid log format",
+			file:    "logging:\n  format: xml\n",
+			wantErr: "invalid log format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.file != "" {
+				args = []string{"--config", writeConfigFile(t, tt.file)}
+			}
+			_, err := loadTestConfig(t, args, tt.env)
+			if err == nil {
+				t.Fatalf("loadConfig() expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_FileFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr string
+	}{
+		{name: "hepc.yml"},
+		{name: "hepc.YAML"},
+		{name: "hepc"},
+		{name: "hepc.toml", wantErr: "only YAML (.yml or .yaml) is supported, not .toml"},
+		{name: "hepc.json", wantErr: "only YAML (.yml or .yaml) is supported, not .json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(file, []byte("server:\n  port: 9000\n"), 0o644); err != nil {
+				t.Fatalf("failed to write config file: %v", err)
+			}
+			cfg, err := loadTestConfig(t, []string{"--config", file}, nil)
+			if tt.wantErr == "" {
+				if err != nil || cfg.Server.Port != 9000 {
+					t.Errorf("loadConfig() = port %d, %v; want 9000, nil", cfg.Server.Port, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_MissingFile(t *testing.T) {
+	if _, err := loadTestConfig(t, []string{"--config", filepath.Join(t.TempDir(), "missing.yml")}, nil); err == nil {
+		t.Error("loadConfig() expected error for missing file, got nil")
+	}
+}
+
+func TestWriteConfig_RedactsSecrets(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Auth.Tokens = []string{"token-one", "token-two"}
+	cfg.Auth.AdminTokens = []string{"admin-token"}
+	cfg.Storage.HEPC.Headers = []string{"team-b=Authorization: Bearer upstream-secret"}
+
+	var buf bytes.Buffer
+	if err := writeConfig(&buf, cfg); err != nil {
+		t.Fatalf("writeConfig() error = %v", err)
+	}
+	out := buf.String()
+
+	for _, secret := range []string{"token-one", "token-two", "admin-token", "upstream-secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("printed config contains secret %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "team-b=Authorization: <redacted>") {
+		t.Errorf("printed config does not name the redacted header:\n%s", out)
+	}
+	if cfg.Auth.Tokens[0] != "token-one" {
+		t.Error("writeConfig() modified the configuration")
+	}
+
+	// The printed configuration loads back to the same settings
+	path := writeConfigFile(t, out)
+	loaded, err := loadTestConfig(t, []string{"--config", path}, nil)
+	if err != nil {
+		t.Fatalf("loadConfig() of printed config error = %v", err)
+	}
+	if loaded.Storage.CacheTTL != cfg.Storage.CacheTTL || loaded.Server != cfg.Server {
+		t.Errorf("loaded config = %+v, want %+v", loaded, cfg.redact())
+	}
+}
@@ -6,6 +6,7 @@
 // Supported storage backends:
 //   - local: Local filesystem storage
 //   - gcs: Google Cloud Storage
+//   - hepc: Federation of upstream HEPC servers
 package main
 
 import (
@@ -15,11 +16,15 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
 	"github.com/data-douser/mrva-go-hepc/internal/server"
 	"github.com/data-douser/mrva-go-hepc/internal/storage"
 	"github.com/data-douser/mrva-go-hepc/internal/storage/gcs"
+	"github.com/data-douser/mrva-go-hepc/internal/storage/hepc"
 	"github.com/data-douser/mrva-go-hepc/internal/storage/local"
 )
 
@@ -29,130 +34,351 @@ const (
 	defaultStorageType = "local"
 )
 
-// storageConfig holds parsed storage configuration.
-type storageConfig struct {
-	storageType    string
-	dbDir          string
-	endpointURL    string
-	gcsBucket      string
-	gcsPrefix      string
-	gcsCredentials string
-	gcsCacheDir    string
-	host           string
-	port           int
+// parseUpstreams builds upstream definitions from --hepc-upstream values of
+// the form "[name=]url" and --hepc-header values of the form
+// "[name=]Header: value". A header without a name applies to every upstream.
+func parseUpstreams(specs, headers []string, timeout time.Duration) ([]hepc.Upstream, error) {
+	upstreams := make([]hepc.Upstream, 0, len(specs))
+	byName := make(map[string]int)
+
+	for i, spec := range specs {
+		name, rawURL := "", spec
+		if before, after, ok := strings.Cut(spec, "="); ok && !strings.Contains(before, "://") {
+			name, rawURL = before, after
+		}
+		if name == "" {
+			name = fmt.Sprintf("upstream%d", i)
+		}
+		byName[name] = len(upstreams)
+		upstreams = append(upstreams, hepc.Upstream{
+			Name:    name,
+			URL:     rawURL,
+			Headers: make(map[string]string),
+			Timeout: timeout,
+		})
+	}
+
+	for _, h := range headers {
+		key, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid --hepc-header %q (expected [name=]Header: value)", h)
+		}
+		value = strings.TrimSpace(value)
+
+		if name, header, scoped := strings.Cut(key, "="); scoped {
+			idx, found := byName[name]
+			if !found {
+				return nil, fmt.Errorf("--hepc-header refers to unknown upstream %q", name)
+			}
+			upstreams[idx].Headers[strings.TrimSpace(header)] = value
+			continue
+		}
+		for i := range upstreams {
+			upstreams[i].Headers[strings.TrimSpace(key)] = value
+		}
+	}
+
+	return upstreams, nil
+}
+
+// parseIdentityRules parses --identity-rule values of the form "<field>:<regexp>".
+func parseIdentityRules(specs []string) ([]codeql.IdentityRule, error) {
+	rules := make([]codeql.IdentityRule, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := codeql.ParseIdentityRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
 }
 
 // initStorage creates and initializes the appropriate storage backend.
-func initStorage(ctx context.Context, cfg storageConfig, logger *slog.Logger) (storage.Backend, error) {
-	epURL := cfg.endpointURL
+func initStorage(ctx context.Context, cfg config, logger *slog.Logger) (storage.Backend, error) {
+	epURL := cfg.Server.EndpointURL
 	if epURL == "" {
-		epURL = fmt.Sprintf("http://%s:%d", cfg.host, cfg.port)
+		epURL = fmt.Sprintf("http://%s:%d", cfg.Server.Host, cfg.Server.Port)
+	}
+
+	identityRules, err := parseIdentityRules(cfg.Storage.IdentityRules)
+	if err != nil {
+		return nil, err
+	}
+	validation, err := codeql.ParseValidation(cfg.Storage.Validation)
+	if err != nil {
+		return nil, err
 	}
 
-	switch cfg.storageType {
+	switch cfg.Storage.Type {
 	case "local":
-		if cfg.dbDir == "" {
+		if cfg.Storage.Local.DBDir == "" {
 			return nil, fmt.Errorf("--db-dir is required for local storage")
 		}
 		store, err := local.New(local.Config{
-			BasePath:    cfg.dbDir,
-			EndpointURL: epURL,
+			BasePath:       cfg.Storage.Local.DBDir,
+			EndpointURL:    epURL,
+			CacheTTL:       cfg.Storage.CacheTTL,
+			IdentityRules:  identityRules,
+			Validation:     validation,
+			SymlinkTargets: cfg.Storage.Local.SymlinkTargets,
+			HashCacheFile:  cfg.Storage.Local.HashCache,
+			StateDir:       cfg.Storage.Local.StateDir,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to initialize local storage: %w", err)
 		}
-		logger.Info("initialized local storage", "path", cfg.dbDir, "endpoint", epURL)
+		logger.Info("initialized local storage", "path", cfg.Storage.Local.DBDir, "endpoint", epURL)
 		return store, nil
 
 	case "gcs":
-		if cfg.gcsBucket == "" {
+		gcsCfg := cfg.Storage.GCS
+		if gcsCfg.Bucket == "" {
 			return nil, fmt.Errorf("--gcs-bucket is required for gcs storage")
 		}
 		store, err := gcs.New(ctx, gcs.Config{
-			Bucket:          cfg.gcsBucket,
-			Prefix:          cfg.gcsPrefix,
-			CredentialsFile: cfg.gcsCredentials,
-			LocalCacheDir:   cfg.gcsCacheDir,
+			Bucket:          gcsCfg.Bucket,
+			Prefix:          gcsCfg.Prefix,
+			CredentialsFile: gcsCfg.CredentialsFile,
+			LocalCacheDir:   gcsCfg.CacheDir,
+			Subscription:    gcsCfg.Subscription,
 			EndpointURL:     epURL,
+			CacheTTL:        cfg.Storage.CacheTTL,
+			IdentityRules:   identityRules,
+			Validation:      validation,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to initialize GCS storage: %w", err)
 		}
 		logger.Info("initialized GCS storage",
-			"bucket", cfg.gcsBucket,
-			"prefix", cfg.gcsPrefix,
+			"bucket", gcsCfg.Bucket,
+			"prefix", gcsCfg.Prefix,
+			"subscription", gcsCfg.Subscription,
+			"endpoint", epURL,
+		)
+		return store, nil
+
+	case "hepc":
+		hepcCfg := cfg.Storage.HEPC
+		if len(hepcCfg.Upstreams) == 0 {
+			return nil, fmt.Errorf("--hepc-upstream is required for hepc storage")
+		}
+		upstreams, err := parseUpstreams(hepcCfg.Upstreams, hepcCfg.Headers, hepcCfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		store, err := hepc.New(hepc.Config{
+			Upstreams:   upstreams,
+			EndpointURL: epURL,
+			CacheTTL:    cfg.Storage.CacheTTL,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize hepc storage: %w", err)
+		}
+		names := make([]string, len(upstreams))
+		for i, up := range upstreams {
+			names[i] = up.Name
+		}
+		logger.Info("initialized hepc federation storage",
+			"upstreams", strings.Join(names, ","),
 			"endpoint", epURL,
 		)
 		return store, nil
 
 	default:
-		return nil, fmt.Errorf("unknown storage type: %s (supported: local, gcs)", cfg.storageType)
+		return nil, fmt.Errorf("unknown storage type: %s (supported: local, gcs, hepc)", cfg.Storage.Type)
 	}
 }
 
 func main() {
-	// Define command-line flags
-	// Server flags
-	host := flag.String("host", defaultHost, "Host address for the HTTP server")
-	port := flag.Int("port", defaultPort, "Port for the HTTP server")
+	fs := flag.CommandLine
+	fs.Usage = func() { usage(fs) }
+
+	opts, flagCfg, flagsSet, err := parseFlags(fs, os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	if opts.help {
+		fs.Usage()
+		os.Exit(0)
+	}
+
+	cfg, err := loadConfig(opts, flagCfg, flagsSet, os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if opts.printConfig {
+		if err := writeConfig(os.Stdout, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	// Storage selection
-	storageType := flag.String("storage", defaultStorageType, "Storage backend type: 'local' or 'gcs'")
+	// Set up structured logging
+	logger := newLogger(cfg.Logging, os.Stdout)
+
+	// Create storage backend
+	ctx := context.Background()
+	store, err := initStorage(ctx, cfg, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	// A reload re-reads the config file and environment, with the flags
+	// keeping precedence, and builds a new storage backend. The backend
+	// outlives the reload request, so it is created with the server context.
+	reload := func(context.Context) (server.Config, storage.Backend, error) {
+		next, err := loadConfig(opts, flagCfg, flagsSet, os.Getenv)
+		if err != nil {
+			return server.Config{}, nil, err
+		}
+		if next.Logging != cfg.Logging {
+			logger.Warn("logging changes take effect after a restart")
+		}
+		store, err := initStorage(ctx, next, logger)
+		if err != nil {
+			return server.Config{}, nil, err
+		}
+		return serverConfig(next, nil), store, nil
+	}
 
-	// Local storage flags
-	dbDir := flag.String("db-dir", "", "Directory containing CodeQL database files (required for local storage)")
-	endpointURL := flag.String("endpoint-url", "", "Base URL for result URLs (e.g., http://localhost:8070)")
+	// Create and start the server
+	srv := server.New(serverConfig(cfg, reload), store, logger)
+	defer func() {
+		if closeErr := srv.Close(); closeErr != nil {
+			logger.Error("failed to close storage", "error", closeErr)
+		}
+	}()
 
-	// GCS storage flags
-	gcsBucket := flag.String("gcs-bucket", "", "GCS bucket name (required for gcs storage)")
-	gcsPrefix := flag.String("gcs-prefix", "", "Object path prefix within the GCS bucket")
-	gcsCredentials := flag.String("gcs-credentials", "", "Path to GCS service account JSON key file (uses ADC if not specified)")
-	gcsCacheDir := flag.String("gcs-cache-dir", "", "Local directory for caching GCS metadata (uses temp dir if not specified)")
+	if len(cfg.Auth.Tokens) > 0 {
+		logger.Info("bearer token authentication enabled", "tokens", len(cfg.Auth.Tokens))
+	}
 
-	help := flag.Bool("help", false, "Show help message")
+	// Set up signal handling for graceful shutdown
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, `hepc-server - MRVA HTTP Endpoint for CodeQL databases
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigChan
+		logger.Info("received signal, initiating shutdown", "signal", sig)
+		cancel()
+	}()
+
+	// Reload configuration and storage on SIGHUP
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	go func() {
+		for range hupChan {
+			logger.Info("received SIGHUP, reloading configuration")
+			_ = srv.Reload(ctx) //nolint:errcheck // Failures are logged and the current storage is kept
+		}
+	}()
+
+	// Run the server
+	if err := srv.ListenAndServe(ctx); err != nil {
+		// http.ErrServerClosed is expected during graceful shutdown
+		if err.Error() != "http: Server closed" {
+			logger.Error("server error", "error", err)
+			cancel()
+			logger.Info("server stopped")
+			return
+		}
+	}
+
+	logger.Info("server stopped")
+}
+
+// serverConfig returns the server configuration for cfg.
+func serverConfig(cfg config, reload server.ReloadFunc) server.Config {
+	return server.Config{
+		Host:        cfg.Server.Host,
+		Port:        cfg.Server.Port,
+		Tokens:      cfg.Auth.Tokens,
+		AdminTokens: cfg.Auth.AdminTokens,
+		Reload:      reload,
+	}
+}
+
+// usage writes the help text, with the options generated from settings.
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, `hepc-server - MRVA HTTP Endpoint for CodeQL databases
 
 USAGE:
     hepc-server --storage <type> [STORAGE OPTIONS] [SERVER OPTIONS]
+    hepc-server --config <file> [OPTIONS]
 
 DESCRIPTION:
     Serves CodeQL database .zip files and metadata from a storage backend.
     
     The HTTP endpoints are:
       GET /db/{filename}                    - Download a database file
+      HEAD /db/{filename}                   - Database size and content hash
       GET /index                            - List all databases (JSONL)
       GET /api/v1/latest_results/codeql-all - List all databases (JSONL)
+      GET /api/v1/databases/{content_hash}  - Metadata of one database (JSON)
+      GET /api/v1/repos/{owner}/{repo}      - Databases of a repository (JSON)
+      GET /api/v2/index, /api/v2/databases/{content_hash},
+          /api/v2/repos/{owner}/{repo}      - As above with the v2 metadata schema
+      GET /api/v2/schema.json               - JSON Schema of the v2 record
       GET /health                           - Health check endpoint
+      GET /livez                            - Liveness probe
+      GET /readyz                           - Readiness probe (503 if not ready)
+      POST /admin/reload                    - Reload configuration and storage
+      POST /admin/reindex[?prefix=<dir>]    - Rediscover databases
+      GET, DELETE /admin/cache              - Cache statistics, or empty it
+      GET /admin/quarantine                 - Databases that failed validation
+                                              (admin endpoints require an
+                                              --admin-token)
+      GET /repos/{owner}/{repo}/code-scanning/codeql/databases[/{language}]
+                                            - GitHub-compatible database API
+                                              (also under /api/v3)
+
+    The listing endpoints accept ?tag=, ?team= and ?visibility= filters
+    matching the values declared in database sidecar files.
 
 STORAGE BACKENDS:
     local   Local filesystem storage (default)
     gcs     Google Cloud Storage
-
-SERVER OPTIONS:
+    hepc    Federation of one or more upstream HEPC servers
+
+CONFIGURATION:
+    Every option can also be set by a HEPC_* environment variable or in
+    the YAML file given by --config. Each value is taken from the first
+    of: a command-line flag, the environment, the config file, the default.
+    Repeatable options replace, not extend, lower-precedence lists; in the
+    environment their values are separated by newlines.
+
+    SIGHUP or POST /admin/reload re-reads the config file and rebuilds the
+    storage backend. New requests use the new configuration while running
+    downloads finish on the old backend, which is closed afterwards. The
+    host, port and logging settings take effect after a restart.
 `)
-		flag.VisitAll(func(f *flag.Flag) {
-			if f.Name == "host" || f.Name == "port" || f.Name == "help" {
-				fmt.Fprintf(os.Stderr, "    --%s\n        %s (default: %v)\n", f.Name, f.Usage, f.DefValue)
-			}
-		})
-
-		fmt.Fprintf(os.Stderr, `
-LOCAL STORAGE OPTIONS:
-    --db-dir <directory>
-        Directory containing CodeQL database files (required)
-
-GCS STORAGE OPTIONS:
-    --gcs-bucket <bucket>
-        GCS bucket name (required)
-    --gcs-prefix <prefix>
-        Object path prefix within the bucket (optional)
-    --gcs-credentials <path>
-        Path to service account JSON key file
-        If not specified, uses Application Default Credentials (ADC)
-    --gcs-cache-dir <directory>
-        Local directory for caching metadata (optional)
+	writeOptions(w, fs)
+	fmt.Fprintf(w, `
+    Upstreams are served under /db/<name>/..., and a --hepc-header without
+    a name applies to all upstreams.
+
+    An --identity-rule is a regular expression with (?P<owner>...) and
+    (?P<repo>...) groups, and optionally (?P<branch>...), matched against
+    the database's sourceLocationPrefix or file name (first match wins).
+    Owner and repo are taken from the first available source:
+      1. A sidecar file: <name>.hepc.yml beside an archive, or hepc.yml
+         inside a database directory
+      2. The git remote recorded in the database's src.zip
+      3. The first matching --identity-rule
+      4. The last two components of sourceLocationPrefix
+    The source used is reported in each record's identity_source field.
 
 EXAMPLES:
     # Local filesystem storage
@@ -167,6 +393,15 @@ EXAMPLES:
         --gcs-prefix databases/production/ \
         --gcs-credentials /path/to/service-account.json
 
+    # Federate two team servers, one requiring a token
+    hepc-server --storage hepc \
+        --hepc-upstream team-a=https://hepc.team-a.example.com \
+        --hepc-upstream team-b=https://hepc.team-b.example.com \
+        --hepc-header "team-b=Authorization: Bearer $TOKEN"
+
+    # Settings from a file, with the port overridden, checked before use
+    HEPC_PORT=9000 hepc-server --config hepc.yml --print-config
+
 AUTHENTICATION (GCS):
     The GCS backend supports multiple authentication methods:
     
@@ -180,76 +415,4 @@ AUTHENTICATION (GCS):
        - Compute Engine/GKE service account (when running on GCP)
 
 `)
-	}
-
-	flag.Parse()
-
-	if *help {
-		flag.Usage()
-		os.Exit(0)
-	}
-
-	// Set up structured logging
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-
-	// Create storage backend
-	ctx := context.Background()
-	store, err := initStorage(ctx, storageConfig{
-		storageType:    *storageType,
-		dbDir:          *dbDir,
-		endpointURL:    *endpointURL,
-		gcsBucket:      *gcsBucket,
-		gcsPrefix:      *gcsPrefix,
-		gcsCredentials: *gcsCredentials,
-		gcsCacheDir:    *gcsCacheDir,
-		host:           *host,
-		port:           *port,
-	}, logger)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		flag.Usage()
-		os.Exit(1)
-	}
-	defer func() {
-		if closeErr := store.Close(); closeErr != nil {
-			logger.Error("failed to close storage", "error", closeErr)
-		}
-	}()
-
-	// Create server configuration
-	cfg := server.Config{
-		Host: *host,
-		Port: *port,
-	}
-
-	// Create and start the server
-	srv := server.New(cfg, store, logger)
-
-	// Set up signal handling for graceful shutdown
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		sig := <-sigChan
-		logger.Info("received signal, initiating shutdown", "signal", sig)
-		cancel()
-	}()
-
-	// Run the server
-	if err := srv.ListenAndServe(ctx); err != nil {
-		// http.ErrServerClosed is expected during graceful shutdown
-		if err.Error() != "http: Server closed" {
-			logger.Error("server error", "error", err)
-			cancel()
-			logger.Info("server stopped")
-			return
-		}
-	}
-
-	logger.Info("server stopped")
 }
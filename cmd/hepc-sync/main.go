@@ -0,0 +1,189 @@
+// hepc-sync copies CodeQL databases between storage backends.
+//
+// It copies every archived database of the source, with its sidecar file,
+// and every file of each database directory to the same path in the
+// destination, skipping files the destination already holds with the same
+// SHA-256 and verifying each copy by reading it back.
+//
+// Supported storage locations:
+//   - A local directory path
+//   - gs://bucket/prefix for Google Cloud Storage
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+	"github.com/data-douser/mrva-go-hepc/internal/storage/gcs"
+	"github.com/data-douser/mrva-go-hepc/internal/storage/local"
+)
+
+const defaultParallel = 4
+
+// locationConfig holds the options for opening a storage location.
+type locationConfig struct {
+	gcsCredentials string
+
+	// createLocal creates a missing local directory, for destinations
+	createLocal bool
+}
+
+// openLocation opens the storage backend for a location given as a local
+// directory or as gs://bucket[/prefix].
+func openLocation(ctx context.Context, location string, cfg locationConfig) (storage.Backend, error) {
+	if location == "" {
+		return nil, fmt.Errorf("location is empty")
+	}
+
+	if rest, ok := strings.CutPrefix(location, "gs://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		store, err := gcs.New(ctx, gcs.Config{
+			Bucket:          bucket,
+			Prefix:          prefix,
+			CredentialsFile: cfg.gcsCredentials,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	if strings.Contains(location, "://") {
+		return nil, fmt.Errorf("unsupported location %q (expected a directory or gs://bucket/prefix)", location)
+	}
+
+	if cfg.createLocal {
+		if err := os.MkdirAll(location, 0o755); err != nil { //nolint:gosec // Databases are served to clients
+			return nil, fmt.Errorf("failed to create %s: %w", location, err)
+		}
+	}
+	store, err := local.New(local.Config{BasePath: location})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func main() {
+	from := flag.String("from", "", "Source location: a directory or gs://bucket/prefix (required)")
+	to := flag.String("to", "", "Destination location: a directory or gs://bucket/prefix (required)")
+	gcsCredentials := flag.String("gcs-credentials", "", "Path to GCS service account JSON key file (uses ADC if not specified)")
+	parallel := flag.Int("parallel", defaultParallel, "Number of concurrent copies")
+	dryRun := flag.Bool("dry-run", false, "Report what would be copied and deleted without changing the destination")
+	deleteExtraneous := flag.Bool("delete", false, "Delete databases from the destination that are not in the source")
+	verbose := flag.Bool("verbose", false, "Also log unchanged files")
+	help := flag.Bool("help", false, "Show help message")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `hepc-sync - Copy CodeQL databases between storage backends
+
+USAGE:
+    hepc-sync --from <location> --to <location> [OPTIONS]
+
+DESCRIPTION:
+    Copies every archived database of the source, together with its
+    <name>.hepc.yml sidecar file, to the same path in the destination.
+    Databases stored as directories are copied file by file, including
+    their hepc.yml sidecar file. Files the destination already holds with
+    the same SHA-256 are skipped, and every copy is verified by reading it
+    back. Database directories of a source that cannot list their files
+    are reported as unsupported. Databases that fail validation in the
+    source are not copied; they are logged with their problems and
+    counted as quarantined.
+
+    A location is a local directory or gs://bucket/prefix. Objects
+    uploaded to GCS record their SHA-256 in the object metadata, so later
+    runs compare them without downloading.
+
+OPTIONS:
+`)
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+EXAMPLES:
+    # Move a local collection to GCS
+    hepc-sync --from ./db-collection --to gs://my-codeql-dbs/databases
+
+    # Preview mirroring one prefix to another, removing extra databases
+    hepc-sync --from gs://my-codeql-dbs/prod --to gs://my-codeql-dbs/staging \
+        --delete --dry-run
+
+`)
+	}
+
+	flag.Parse()
+
+	if *help {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	if *from == "" || *to == "" {
+		fmt.Fprintf(os.Stderr, "error: --from and --to are required\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *parallel < 1 {
+		fmt.Fprintf(os.Stderr, "error: --parallel must be at least 1\n")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, *from, *to, locationConfig{gcsCredentials: *gcsCredentials}, syncConfig{
+		Parallel: *parallel,
+		DryRun:   *dryRun,
+		Delete:   *deleteExtraneous,
+	}, logger, os.Stdout)
+	if err != nil {
+		stop()
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run opens both locations, syncs them and prints the summary to out.
+func run(ctx context.Context, from, to string, loc locationConfig, cfg syncConfig, logger *slog.Logger, out io.Writer) error {
+	src, err := openLocation(ctx, from, loc)
+	if err != nil {
+		return fmt.Errorf("failed to open source: %w", err)
+	}
+	defer func() {
+		_ = src.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	loc.createLocal = !cfg.DryRun
+	dst, err := openLocation(ctx, to, loc)
+	if err != nil {
+		return fmt.Errorf("failed to open destination: %w", err)
+	}
+	defer func() {
+		_ = dst.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	s, err := newSyncer(src, dst, cfg, logger)
+	if err != nil {
+		return err
+	}
+	sum, err := s.run(ctx)
+
+	prefix := ""
+	if cfg.DryRun {
+		prefix = "dry run: "
+	}
+	fmt.Fprintf(out, "%s%s\n", prefix, sum)
+	return err
+}
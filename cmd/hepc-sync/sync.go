@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// syncConfig holds the configuration of a syncer.
+type syncConfig struct {
+	// Parallel is the number of concurrent copies (default: 1).
+	Parallel int
+
+	// DryRun reports what would be done without changing the destination.
+	DryRun bool
+
+	// Delete removes databases from the destination that are not in the
+	// source.
+	Delete bool
+}
+
+// syncer copies databases from one storage backend to another.
+type syncer struct {
+	src    storage.Backend
+	dst    storage.Backend
+	writer storage.Writer
+	cfg    syncConfig
+	logger *slog.Logger
+}
+
+// summary counts the outcome of a sync.
+type summary struct {
+	Copied      int
+	CopiedBytes int64
+	Skipped     int
+	Deleted     int
+	Unsupported int
+	Quarantined int
+	Failed      int
+}
+
+func (s summary) String() string {
+	return fmt.Sprintf("copied %d files (%d bytes), skipped %d unchanged, deleted %d, %d unsupported, %d quarantined, %d failed",
+		s.Copied, s.CopiedBytes, s.Skipped, s.Deleted, s.Unsupported, s.Quarantined, s.Failed)
+}
+
+// newSyncer returns a syncer from src to dst, which must implement
+// storage.Writer.
+func newSyncer(src, dst storage.Backend, cfg syncConfig, logger *slog.Logger) (*syncer, error) {
+	writer, ok := dst.(storage.Writer)
+	if !ok {
+		return nil, fmt.Errorf("%s storage cannot be written to", dst.Type())
+	}
+	return &syncer{src: src, dst: dst, writer: writer, cfg: cfg, logger: logger}, nil
+}
+
+// databaseFiles returns the files making up the databases of b, sorted:
+// every archive in its index and the sidecar files beside them, and every
+// file of the databases stored as directories, listed through the backend's
+// storage.MemberReader implementation, together with their sidecar files.
+// It also returns the paths of the directory databases that b cannot list.
+func databaseFiles(ctx context.Context, b storage.Backend) ([]string, []string, error) {
+	idx, err := b.Index(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list %s databases: %w", b.Type(), err)
+	}
+
+	seen := make(map[string]bool)
+	var files, unsupported []string
+	for _, m := range idx.Records() {
+		p, ok := storage.ArtifactPath(m.ResultURL)
+		if !ok || seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		exists, err := b.FileExists(ctx, p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check %s: %w", p, err)
+		}
+		if !exists {
+			// A database directory, whose files are copied one by one
+			lister, ok := b.(storage.MemberReader)
+			if !ok {
+				unsupported = append(unsupported, p)
+				continue
+			}
+			members, err := lister.ListMembers(ctx, p)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to list %s: %w", p, err)
+			}
+			for _, member := range members {
+				files = append(files, path.Join(p, member.Path))
+			}
+		} else {
+			files = append(files, p)
+		}
+
+		// Member listings leave out the sidecar inside a database directory
+		sidecar := strings.TrimSuffix(p, path.Ext(p)) + ".hepc.yml"
+		if !exists {
+			sidecar = path.Join(p, codeql.SidecarFileName)
+		}
+		if found, err := b.FileExists(ctx, sidecar); err != nil {
+			return nil, nil, fmt.Errorf("failed to check %s: %w", sidecar, err)
+		} else if found {
+			files = append(files, sidecar)
+		}
+	}
+	sort.Strings(files)
+	sort.Strings(unsupported)
+	return files, unsupported, nil
+}
+
+// run syncs the source to the destination and returns a summary. Files
+// that fail, and source databases that cannot be copied, are counted and
+// reported in the returned error; the others are still synced. Source
+// databases that failed validation are not copied, and are counted and
+// logged with their problems as quarantined.
+func (s *syncer) run(ctx context.Context) (summary, error) {
+	var sum summary
+
+	files, unsupported, err := databaseFiles(ctx, s.src)
+	if err != nil {
+		return sum, err
+	}
+	var errs []error
+	for _, p := range unsupported {
+		s.logger.Error("cannot copy database directory", "type", s.src.Type(), "path", p)
+		errs = append(errs, fmt.Errorf("%s: %s storage cannot list the files of database directories", p, s.src.Type()))
+	}
+	sum.Unsupported = len(unsupported)
+
+	// The destination keeps its copies of the databases not copied
+	uncopied := slices.Clone(unsupported)
+	if reporter, ok := s.src.(storage.QuarantineReporter); ok {
+		for _, q := range reporter.Quarantine() {
+			s.logger.Warn("skipping quarantined database", "path", q.RelPath, "problems", strings.Join(q.Problems, "; "))
+			uncopied = append(uncopied, q.RelPath)
+			if path.Ext(q.RelPath) == ".zip" {
+				uncopied = append(uncopied, strings.TrimSuffix(q.RelPath, ".zip")+".hepc.yml")
+			}
+			sum.Quarantined++
+		}
+	}
+	s.logger.Info("source listed", "type", s.src.Type(), "files", len(files))
+
+	var mu sync.Mutex
+	s.forEach(files, func(name string) {
+		copied, size, err := s.syncFile(ctx, name)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			sum.Failed++
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			s.logger.Error("sync failed", "path", name, "error", err)
+		case copied:
+			sum.Copied++
+			sum.CopiedBytes += size
+		default:
+			sum.Skipped++
+		}
+	})
+
+	if s.cfg.Delete {
+		deleted, deleteErrs := s.deleteExtraneous(ctx, files, uncopied)
+		sum.Deleted = deleted
+		sum.Failed += len(deleteErrs)
+		errs = append(errs, deleteErrs...)
+	}
+
+	return sum, errors.Join(errs...)
+}
+
+// forEach calls fn for every name with up to cfg.Parallel calls at a time.
+func (s *syncer) forEach(names []string, fn func(string)) {
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range max(s.cfg.Parallel, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				fn(name)
+			}
+		}()
+	}
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// syncFile copies one file unless the destination already holds the same
+// content, and reports whether it was (or, in a dry run, would be) copied
+// along with the number of bytes.
+func (s *syncer) syncFile(ctx context.Context, name string) (bool, int64, error) {
+	dstHash, err := storage.FileHash(ctx, s.dst, name)
+	var notFound *storage.ErrNotFound
+	switch {
+	case errors.As(err, &notFound):
+		dstHash = ""
+	case err != nil:
+		return false, 0, fmt.Errorf("failed to hash destination: %w", err)
+	}
+
+	if dstHash != "" {
+		srcHash, err := storage.FileHash(ctx, s.src, name)
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to hash source: %w", err)
+		}
+		if srcHash == dstHash {
+			s.logger.Debug("unchanged", "path", name)
+			return false, 0, nil
+		}
+	}
+
+	if s.cfg.DryRun {
+		s.logger.Info("would copy", "path", name)
+		return true, 0, nil
+	}
+
+	reader, size, _, err := s.src.GetFile(ctx, name)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to read source: %w", err)
+	}
+	defer func() {
+		_ = reader.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	h := sha256.New()
+	if err := s.writer.PutFile(ctx, name, io.TeeReader(reader, h)); err != nil {
+		return false, 0, err
+	}
+	want := hex.EncodeToString(h.Sum(nil))
+
+	// Verify by reading back what was stored
+	got, err := storage.ReadHash(ctx, s.dst, name)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to verify copy: %w", err)
+	}
+	if got != want {
+		return false, 0, fmt.Errorf("verification failed: copied sha256 %s, stored %s", want, got)
+	}
+
+	s.logger.Info("copied", "path", name, "size", size)
+	return true, size, nil
+}
+
+// deleteExtraneous removes the database files of the destination that are
+// not among the source files. Files of the source databases that were not
+// copied, named by uncopied, are kept. It returns how many were (or, in a dry run, would be)
+// removed and the errors of those that could not be.
+func (s *syncer) deleteExtraneous(ctx context.Context, srcFiles, uncopied []string) (int, []error) {
+	dstFiles, _, err := databaseFiles(ctx, s.dst)
+	if err != nil {
+		return 0, []error{err}
+	}
+
+	keep := make(map[string]bool, len(srcFiles))
+	for _, name := range srcFiles {
+		keep[name] = true
+	}
+
+	deleted := 0
+	var errs []error
+	for _, name := range dstFiles {
+		if keep[name] || slices.ContainsFunc(uncopied, func(p string) bool { return name == p || storage.InPrefix(name, p) }) {
+			continue
+		}
+		if s.cfg.DryRun {
+			s.logger.Info("would delete", "path", name)
+			deleted++
+			continue
+		}
+		if err := s.writer.DeleteFile(ctx, name); err != nil {
+			s.logger.Error("delete failed", "path", name, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		s.logger.Info("deleted", "path", name)
+		deleted++
+	}
+	return deleted, errs
+}
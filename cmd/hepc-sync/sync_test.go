@@ -0,0 +1,348 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+	"github.com/data-douser/mrva-go-hepc/internal/storage/gcs"
+	"github.com/data-douser/mrva-go-hepc/internal/storage/local"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// createDatabaseZip writes an archived Go database of ownerRepo to path.
+func createDatabaseZip(t *testing.T, path, ownerRepo string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create zip: %v", err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"codeql-database.yml": "sourceLocationPrefix: /src/" + ownerRepo + "\nprimaryLanguage: go\n",
+		"db-go/default/x":     strings.Repeat(ownerRepo, 1024),
+	} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+}
+
+// newSourceDir creates a local collection with two archives, a sidecar and
+// a database directory.
+func newSourceDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	createDatabaseZip(t, filepath.Join(dir, "octo", "hello.zip"), "octo/hello")
+	createDatabaseZip(t, filepath.Join(dir, "other", "thing.zip"), "other/thing")
+	if err := os.WriteFile(filepath.Join(dir, "octo", "hello.hepc.yml"), []byte("repository: octo-org/hello-world\n"), 0o644); err != nil {
+		t.Fatalf("failed to write sidecar: %v", err)
+	}
+	unarchived := filepath.Join(dir, "unarchived", "db-go")
+	if err := os.MkdirAll(unarchived, 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "unarchived", "codeql-database.yml"), []byte("sourceLocationPrefix: /src/octo/unarchived\nprimaryLanguage: go\n"), 0o644); err != nil {
+		t.Fatalf("failed to write database: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(unarchived, "db-go.stats"), []byte("stats"), 0o644); err != nil {
+		t.Fatalf("failed to write database: %v", err)
+	}
+	return dir
+}
+
+func newLocalBackend(t *testing.T, dir string) *local.Backend {
+	t.Helper()
+
+	backend, err := local.New(local.Config{BasePath: dir})
+	if err != nil {
+		t.Fatalf("failed to create local backend: %v", err)
+	}
+	t.Cleanup(func() { _ = backend.Close() })
+	return backend
+}
+
+func newGCSBackend(t *testing.T, server *fakestorage.Server, prefix string) *gcs.Backend {
+	t.Helper()
+
+	backend, err := gcs.New(context.Background(), gcs.Config{
+		Bucket:        "test-bucket",
+		Prefix:        prefix,
+		Client:        server.Client(),
+		LocalCacheDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create gcs backend: %v", err)
+	}
+	t.Cleanup(func() { _ = backend.Close() })
+	return backend
+}
+
+func newFakeGCS(t *testing.T) *fakestorage.Server {
+	t.Helper()
+
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{NoListener: true})
+	if err != nil {
+		t.Fatalf("failed to create fake GCS server: %v", err)
+	}
+	server.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: "test-bucket"})
+	t.Cleanup(server.Stop)
+	return server
+}
+
+func syncBackends(t *testing.T, src, dst storage.Backend, cfg syncConfig) summary {
+	t.Helper()
+
+	s, err := newSyncer(src, dst, cfg, testLogger)
+	if err != nil {
+		t.Fatalf("newSyncer() error = %v", err)
+	}
+	sum, err := s.run(context.Background())
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	return sum
+}
+
+// localFiles returns the regular files under dir, relative and slash-separated.
+func localFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk %s: %v", dir, err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestSyncer_LocalToGCSAndBack(t *testing.T) {
+	srcDir := newSourceDir(t)
+	server := newFakeGCS(t)
+
+	sum := syncBackends(t, newLocalBackend(t, srcDir), newGCSBackend(t, server, "dbs"), syncConfig{Parallel: 2})
+	if sum.Copied != 5 || sum.Skipped != 0 || sum.Unsupported != 0 || sum.Failed != 0 {
+		t.Errorf("first sync = %+v, want 5 copied", sum)
+	}
+
+	for _, name := range []string{"dbs/octo/hello.zip", "dbs/octo/hello.hepc.yml", "dbs/other/thing.zip", "dbs/unarchived/codeql-database.yml", "dbs/unarchived/db-go/db-go.stats"} {
+		obj, err := server.GetObject("test-bucket", name)
+		if err != nil {
+			t.Fatalf("object %s not copied: %v", name, err)
+		}
+		if obj.Metadata["sha256"] == "" {
+			t.Errorf("object %s has no recorded hash", name)
+		}
+	}
+
+	// Unchanged files are skipped using the recorded hashes
+	sum = syncBackends(t, newLocalBackend(t, srcDir), newGCSBackend(t, server, "dbs"), syncConfig{Parallel: 2})
+	if sum.Copied != 0 || sum.Skipped != 5 {
+		t.Errorf("second sync = %+v, want 5 skipped", sum)
+	}
+
+	// Copying back preserves the layout and the identity from the sidecar
+	backDir := t.TempDir()
+	back := newLocalBackend(t, backDir)
+	sum = syncBackends(t, newGCSBackend(t, server, "dbs"), back, syncConfig{Parallel: 1})
+	if sum.Copied != 5 {
+		t.Errorf("sync back = %+v, want 5 copied", sum)
+	}
+	want := []string{"octo/hello.hepc.yml", "octo/hello.zip", "other/thing.zip", "unarchived/codeql-database.yml", "unarchived/db-go/db-go.stats"}
+	if got := localFiles(t, backDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+
+	records, err := back.ListMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	var projects []string
+	for _, m := range records {
+		projects = append(projects, m.Projname)
+	}
+	sort.Strings(projects)
+	if want := []string{"octo-org/hello-world", "octo/unarchived", "other/thing"}; !reflect.DeepEqual(projects, want) {
+		t.Errorf("projects = %v, want %v", projects, want)
+	}
+}
+
+func TestSyncer_DirectorySidecar(t *testing.T) {
+	srcDir := newSourceDir(t)
+	if err := os.WriteFile(filepath.Join(srcDir, "unarchived", "hepc.yml"), []byte("team: red\n"), 0o644); err != nil {
+		t.Fatalf("failed to write sidecar: %v", err)
+	}
+	dstDir := t.TempDir()
+
+	sum := syncBackends(t, newLocalBackend(t, srcDir), newLocalBackend(t, dstDir), syncConfig{Parallel: 1})
+	if sum.Copied != 6 {
+		t.Errorf("sync = %+v, want 6 copied", sum)
+	}
+	got, err := os.ReadFile(filepath.Join(dstDir, "unarchived", "hepc.yml"))
+	if err != nil || string(got) != "team: red\n" {
+		t.Errorf("sidecar of the database directory not copied: %q, %v", got, err)
+	}
+}
+
+func TestSyncer_ReplacesChangedFiles(t *testing.T) {
+	srcDir := newSourceDir(t)
+	dstDir := t.TempDir()
+	createDatabaseZip(t, filepath.Join(dstDir, "octo", "hello.zip"), "stale/content")
+
+	sum := syncBackends(t, newLocalBackend(t, srcDir), newLocalBackend(t, dstDir), syncConfig{Parallel: 1})
+	if sum.Copied != 5 || sum.Skipped != 0 {
+		t.Errorf("sync = %+v, want 5 copied", sum)
+	}
+
+	src, _ := os.ReadFile(filepath.Join(srcDir, "octo", "hello.zip"))
+	dst, _ := os.ReadFile(filepath.Join(dstDir, "octo", "hello.zip"))
+	if string(src) != string(dst) {
+		t.Error("changed file was not replaced")
+	}
+}
+
+func TestSyncer_Delete(t *testing.T) {
+	srcDir := newSourceDir(t)
+	dstDir := t.TempDir()
+	createDatabaseZip(t, filepath.Join(dstDir, "gone", "old.zip"), "gone/old")
+	if err := os.WriteFile(filepath.Join(dstDir, "gone", "old.hepc.yml"), []byte("team: red\n"), 0o644); err != nil {
+		t.Fatalf("failed to write sidecar: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dstDir, "notes.txt"), []byte("not a database"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	// A dry run reports but changes nothing
+	before := localFiles(t, dstDir)
+	sum := syncBackends(t, newLocalBackend(t, srcDir), newLocalBackend(t, dstDir), syncConfig{Parallel: 1, DryRun: true, Delete: true})
+	if sum.Copied != 5 || sum.Deleted != 2 {
+		t.Errorf("dry run = %+v, want 5 copied and 2 deleted", sum)
+	}
+	if got := localFiles(t, dstDir); !reflect.DeepEqual(got, before) {
+		t.Errorf("dry run changed files to %v", got)
+	}
+
+	sum = syncBackends(t, newLocalBackend(t, srcDir), newLocalBackend(t, dstDir), syncConfig{Parallel: 1, Delete: true})
+	if sum.Copied != 5 || sum.Deleted != 2 {
+		t.Errorf("sync = %+v, want 5 copied and 2 deleted", sum)
+	}
+	want := []string{"notes.txt", "octo/hello.hepc.yml", "octo/hello.zip", "other/thing.zip", "unarchived/codeql-database.yml", "unarchived/db-go/db-go.stats"}
+	if got := localFiles(t, dstDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestSyncer_UnlistableDirectory(t *testing.T) {
+	srcDir := newSourceDir(t)
+	dstDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dstDir, "unarchived"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dstDir, "unarchived", "codeql-database.yml"), []byte("sourceLocationPrefix: /src/octo/unarchived\nprimaryLanguage: go\n"), 0o644); err != nil {
+		t.Fatalf("failed to write database: %v", err)
+	}
+
+	// A source that cannot list database directories fails the sync, and
+	// does not delete their copies in the destination
+	src := struct{ storage.Backend }{newLocalBackend(t, srcDir)}
+	s, err := newSyncer(src, newLocalBackend(t, dstDir), syncConfig{Parallel: 1, Delete: true}, testLogger)
+	if err != nil {
+		t.Fatalf("newSyncer() error = %v", err)
+	}
+	sum, err := s.run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unarchived") {
+		t.Errorf("run() error = %v, want an error naming the directory", err)
+	}
+	if sum.Copied != 3 || sum.Unsupported != 1 || sum.Deleted != 0 {
+		t.Errorf("sync = %+v, want 3 copied and 1 unsupported", sum)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "unarchived", "codeql-database.yml")); err != nil {
+		t.Errorf("copy of the unlisted database was deleted: %v", err)
+	}
+}
+
+func TestSyncer_QuarantinedDatabase(t *testing.T) {
+	srcDir := newSourceDir(t)
+	if err := os.WriteFile(filepath.Join(srcDir, "octo", "broken.zip"), []byte("not a zip"), 0o644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	dstDir := t.TempDir()
+	createDatabaseZip(t, filepath.Join(dstDir, "octo", "broken.zip"), "octo/broken")
+	if err := os.WriteFile(filepath.Join(dstDir, "octo", "broken.hepc.yml"), []byte("team: red\n"), 0o644); err != nil {
+		t.Fatalf("failed to write sidecar: %v", err)
+	}
+
+	// The quarantined database is neither copied nor deleted from the
+	// destination, and does not fail the sync
+	sum := syncBackends(t, newLocalBackend(t, srcDir), newLocalBackend(t, dstDir), syncConfig{Parallel: 1, Delete: true})
+	if sum.Copied != 5 || sum.Quarantined != 1 || sum.Deleted != 0 {
+		t.Errorf("sync = %+v, want 5 copied and 1 quarantined", sum)
+	}
+	for _, name := range []string{"broken.zip", "broken.hepc.yml"} {
+		got, err := os.ReadFile(filepath.Join(dstDir, "octo", name))
+		if err != nil || string(got) == "not a zip" {
+			t.Errorf("copy of octo/%s in the destination changed: %v", name, err)
+		}
+	}
+}
+
+func TestNewSyncer_ReadOnlyDestination(t *testing.T) {
+	readOnly := struct{ storage.Backend }{newLocalBackend(t, t.TempDir())}
+	if _, err := newSyncer(newLocalBackend(t, t.TempDir()), readOnly, syncConfig{}, testLogger); err == nil {
+		t.Error("newSyncer() expected error for read-only destination, got nil")
+	}
+}
+
+func TestOpenLocation(t *testing.T) {
+	ctx := context.Background()
+
+	dir := filepath.Join(t.TempDir(), "new")
+	if _, err := openLocation(ctx, dir, locationConfig{}); err == nil {
+		t.Error("openLocation() of missing directory expected error, got nil")
+	}
+	backend, err := openLocation(ctx, dir, locationConfig{createLocal: true})
+	if err != nil {
+		t.Fatalf("openLocation() error = %v", err)
+	}
+	defer backend.Close()
+	if backend.Type() != "local" {
+		t.Errorf("Type() = %q, want %q", backend.Type(), "local")
+	}
+
+	if _, err := openLocation(ctx, "https://hepc.example.com", locationConfig{}); err == nil {
+		t.Error("openLocation() of unsupported scheme expected error, got nil")
+	}
+}
@@ -7,13 +7,21 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/data-douser/mrva-go-hepc/api"
 )
 
 // DatabaseYAML represents the structure of codeql-database.yml file.
@@ -24,6 +32,8 @@ type DatabaseYAML struct {
 	UnicodeNewlines      bool                      `yaml:"unicodeNewlines"`
 	ColumnKind           string                    `yaml:"columnKind"`
 	CreationMetadata     *DatabaseCreationMetadata `yaml:"creationMetadata,omitempty"`
+	BaselineLinesOfCode  int64                     `yaml:"baselineLinesOfCode,omitempty"`
+	Finalised            *bool                     `yaml:"finalised,omitempty"`
 }
 
 // DatabaseCreationMetadata holds creation details from codeql-database.yml.
@@ -44,15 +54,25 @@ type DiscoveredDatabase struct {
 	// Path is the full path to the database (directory or archive file).
 	Path string
 
+	// RelPath is the slash-separated path of the database relative to the
+	// root of the discovered storage, as used in result URLs.
+	RelPath string
+
 	// Name is the base name of the database.
 	Name string
 
 	// IsArchived indicates if the database is a zip archive.
 	IsArchived bool
 
-	// Language is the primary programming language.
+	// Language is the programming language of this entry.
 	Language string
 
+	// Languages lists every language contained in the database artifact.
+	// Databases built with --db-cluster or holding several db-<lang>
+	// directories yield one DiscoveredDatabase per language, all sharing
+	// the same Path and Languages.
+	Languages []string
+
 	// SourceLocationPrefix is the original source path.
 	SourceLocationPrefix string
 
@@ -62,278 +82,709 @@ type DiscoveredDatabase struct {
 	// FileSize is the size of the database archive or directory.
 	FileSize int64
 
-	// ContentHash is the SHA-256 hash of the database (for archives only).
+	// ContentHash is the content hash of the database: of the archive, or
+	// a Merkle-style hash over the files of a directory.
 	ContentHash string
 
-	// Owner and Repo extracted from source location prefix.
+	// HashKind is the api.HashKind* constant describing ContentHash.
+	HashKind string
+
+	// Owner and Repo identify the repository the database was built from.
 	Owner string
 	Repo  string
+
+	// Branch and Commit are set when the identity evidence names them.
+	Branch string
+	Commit string
+
+	// IdentitySource records which evidence provided Owner and Repo
+	// (one of the IdentitySource* constants).
+	IdentitySource string
+
+	// Visibility, Team and Tags come from the database's sidecar file.
+	Visibility string
+	Team       string
+	Tags       []string
+
+	// Finalised is the finalised state from codeql-database.yml, or nil
+	// if the database does not record it.
+	Finalised *bool
+
+	// HasSourceArchive indicates if the database includes src.zip.
+	HasSourceArchive bool
+
+	// DBScheme and DBSchemeHash name and hash the database schema of
+	// this entry's language.
+	DBScheme     string
+	DBSchemeHash string
+
+	// LinesOfCode and FileCount are the baseline counts of this entry's
+	// language from baseline-info.json.
+	LinesOfCode int64
+	FileCount   int
+}
+
+// applyIdentity records a resolved identity on the database.
+func (db *DiscoveredDatabase) applyIdentity(id Identity) {
+	db.Owner = id.Owner
+	db.Repo = id.Repo
+	db.Branch = id.Branch
+	db.Commit = id.Commit
+	db.IdentitySource = id.Source
+}
+
+// applySidecar records the descriptive fields of a sidecar file on the database.
+func (db *DiscoveredDatabase) applySidecar(sc *Sidecar) {
+	if sc == nil {
+		return
+	}
+	db.Visibility = sc.Visibility
+	db.Team = sc.Team
+	db.Tags = sc.Tags
 }
 
 // DiscoverDatabases recursively scans a directory for CodeQL databases.
-// It finds both archived (.zip) and unarchived databases.
+// It finds both archived (.zip) and unarchived databases, and sets the Path
+// of each to its native location.
 func DiscoverDatabases(basePath string) ([]*DiscoveredDatabase, error) {
-	var databases []*DiscoveredDatabase
+	result, err := Discover(os.DirFS(basePath), Options{})
+	for _, db := range result.Databases {
+		db.Path = filepath.Join(basePath, filepath.FromSlash(db.RelPath))
+	}
+	return result.Databases, err
+}
 
-	err := filepath.WalkDir(basePath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
+// HashFS is implemented by filesystems that supply the content hash of a
+// file themselves, e.g. from object metadata, instead of having discovery
+// read the whole file.
+type HashFS interface {
+	fs.FS
+
+	// Hash returns the content hash of the named file and the api.HashKind*
+	// constant describing it.
+	Hash(name string) (hash, kind string, err error)
+}
+
+// TreeFS is implemented by filesystems that list every file below a
+// directory at once, as object stores do with a listing without delimiter.
+// Discovery then sizes and hashes an unarchived database from that one
+// listing instead of reading each of its directories.
+type TreeFS interface {
+	fs.FS
+
+	// Tree returns the files below the directory dir.
+	Tree(dir string) ([]TreeFile, error)
+}
+
+// FindFS is implemented by filesystems that find every candidate database
+// below a directory with one query, such as object stores filtering a
+// listing by glob. Discovery then visits the candidates instead of listing
+// each directory.
+type FindFS interface {
+	fs.FS
+
+	// FindDatabases returns the paths of the zip archives and of the
+	// codeql-database.yml files below the directory dir.
+	FindDatabases(dir string) ([]string, error)
+}
+
+// TreeFile is a file below a database directory.
+type TreeFile struct {
+	// Path is slash-separated and relative to the database directory.
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Result is the outcome of a discovery.
+type Result struct {
+	// Databases are the databases that passed validation.
+	Databases []*DiscoveredDatabase
+
+	// Quarantined are the database artifacts that failed validation,
+	// in discovery order. They are not part of Databases.
+	Quarantined []QuarantinedDatabase
+
+	// visited holds the paths of the archives and directories examined
+	visited map[string]bool
+}
+
+// Discover recursively scans fsys for CodeQL databases, and reports those
+// quarantined because they failed validation. It is the backend-agnostic
+// discovery pipeline used by every storage backend: fsys must implement
+// fs.ReadDirFS, and files opened from it must implement io.ReaderAt so that
+// archives are read with ranged reads rather than in full. The returned
+// result is never nil.
+//
+// Path and RelPath of the returned databases are slash-separated paths
+// relative to the root of fsys, even when opts.Dir restricts discovery to a
+// subdirectory; callers may rewrite Path to a native location.
+func Discover(fsys fs.FS, opts Options) (*Result, error) {
+	result := &Result{}
+	dir := "."
+	if opts.Dir != "" {
+		dir = path.Clean(opts.Dir)
+	}
+
+	if dir != "." {
+		if _, err := fs.Stat(fsys, dir); errors.Is(err, fs.ErrNotExist) {
+			opts.DiscoveryCache.prune(dir, nil)
+			return result, nil
 		}
+	}
 
-		// Skip the base path itself
-		if path == basePath {
-			return nil
+	var err error
+	if ffs, ok := fsys.(FindFS); ok {
+		err = findDatabases(ffs, dir, opts, result)
+	} else {
+		err = walkDatabases(fsys, dir, opts, result)
+	}
+	if err == nil {
+		opts.DiscoveryCache.prune(dir, result.visited)
+	}
+	return result, err
+}
+
+// DiscoverArtifact is like Discover but examines only the artifact name,
+// a database archive or database directory relative to the root of fsys.
+// A missing artifact, or one that is not a database, holds no databases.
+func DiscoverArtifact(fsys fs.FS, name string, opts Options) (*Result, error) {
+	result := &Result{}
+	name = path.Clean(name)
+	if name == "." || !fs.ValidPath(name) {
+		return result, fmt.Errorf("invalid database artifact %q", name)
+	}
+
+	info, err := fs.Stat(fsys, name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return result, err
+	case !info.IsDir():
+		if strings.HasSuffix(strings.ToLower(name), ".zip") {
+			dbs, err := discoverArchivedDatabase(fsys, name, opts)
+			result.add(name, dbs, err)
+		}
+	default:
+		_, err := fs.Stat(fsys, path.Join(name, "codeql-database.yml"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return result, err
 		}
+		if err == nil {
+			dbs, err := discoverUnarchivedDatabase(fsys, name, nil, opts)
+			result.add(name, dbs, err)
+		}
+	}
+	if !result.visited[name] {
+		opts.DiscoveryCache.forget(name)
+	}
+	return result, nil
+}
+
+// add records the outcome of discovering the artifact name: its databases,
+// its quarantine if err is an InvalidDatabaseError, or else a warning
+// about err.
+func (r *Result) add(name string, dbs []*DiscoveredDatabase, err error) {
+	if r.visited == nil {
+		r.visited = make(map[string]bool)
+	}
+	r.visited[name] = true
+
+	var invalidErr *InvalidDatabaseError
+	switch {
+	case errors.As(err, &invalidErr):
+		fmt.Fprintf(os.Stderr, "Warning: quarantined %s: %v\n", name, err)
+		r.Quarantined = append(r.Quarantined, QuarantinedDatabase{RelPath: name, Problems: invalidErr.Problems})
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Warning: failed to process %s: %v\n", name, err)
+	default:
+		r.Databases = append(r.Databases, dbs...)
+	}
+}
+
+// walkDatabases scans dir, descending into subdirectories that are not
+// databases themselves. The root directory is never treated as a database.
+func walkDatabases(fsys fs.FS, dir string, opts Options, result *Result) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
 
 		// Check for zip archives
-		if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".zip") {
-			db, err := discoverArchivedDatabase(path)
-			if err != nil {
-				// Log but continue - not all zips are CodeQL databases
-				fmt.Fprintf(os.Stderr, "Warning: failed to process %s: %v\n", path, err)
-				return nil
-			}
-			if db != nil {
-				databases = append(databases, db)
+		if !entry.IsDir() {
+			if strings.HasSuffix(strings.ToLower(entry.Name()), ".zip") {
+				// Log failures but continue - not all zips are CodeQL databases
+				dbs, err := discoverArchivedDatabase(fsys, name, opts)
+				result.add(name, dbs, err)
 			}
-			return nil
+			continue
 		}
 
 		// Check for unarchived databases (directories with codeql-database.yml)
-		if d.IsDir() {
-			ymlPath := filepath.Join(path, "codeql-database.yml")
-			if _, err := os.Stat(ymlPath); err == nil {
-				db, err := discoverUnarchivedDatabase(path)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: failed to process %s: %v\n", path, err)
-					return nil
-				}
-				if db != nil {
-					databases = append(databases, db)
-					// Skip descending into this directory
-					return filepath.SkipDir
-				}
+		children, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			return err
+		}
+		if hasEntry(children, "codeql-database.yml") {
+			dbs, err := discoverUnarchivedDatabase(fsys, name, children, opts)
+			result.add(name, dbs, err)
+			if err != nil || len(dbs) > 0 {
+				// Skip descending into this directory
+				continue
 			}
 		}
 
-		return nil
-	})
+		if err := walkDatabases(fsys, name, opts, result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findDatabases discovers the candidates a FindFS reports below dir, in the
+// order walkDatabases would visit them. Like walkDatabases, it skips
+// candidates inside database directories and never treats dir itself as a
+// database.
+func findDatabases(ffs FindFS, dir string, opts Options, result *Result) error {
+	found, err := ffs.FindDatabases(dir)
+	if err != nil {
+		return err
+	}
+
+	// A database directory is visited in place of its codeql-database.yml
+	type candidate struct {
+		name string
+		dir  bool
+	}
+	candidates := make([]candidate, len(found))
+	for i, name := range found {
+		if path.Base(name) == "codeql-database.yml" {
+			candidates[i] = candidate{name: path.Dir(name), dir: true}
+		} else {
+			candidates[i] = candidate{name: name}
+		}
+	}
+	// Ordering by path components visits a directory right before its
+	// contents, in the same place among its siblings as walkDatabases does
+	walkKey := func(name string) string { return strings.ReplaceAll(name, "/", "\x00") }
+	sort.Slice(candidates, func(i, j int) bool { return walkKey(candidates[i].name) < walkKey(candidates[j].name) })
+
+	databaseDir := ""
+	for _, c := range candidates {
+		if c.name == dir || (databaseDir != "" && strings.HasPrefix(c.name, databaseDir+"/")) {
+			continue
+		}
+		if !c.dir {
+			dbs, err := discoverArchivedDatabase(ffs, c.name, opts)
+			result.add(c.name, dbs, err)
+			continue
+		}
+		dbs, err := discoverUnarchivedDatabase(ffs, c.name, nil, opts)
+		result.add(c.name, dbs, err)
+		if err != nil || len(dbs) > 0 {
+			databaseDir = c.name
+		}
+	}
+	return nil
+}
 
-	return databases, err
+// hasEntry reports whether entries contains a regular file with the given name.
+func hasEntry(entries []fs.DirEntry, name string) bool {
+	for _, e := range entries {
+		if e.Name() == name && !e.IsDir() {
+			return true
+		}
+	}
+	return false
 }
 
 // discoverArchivedDatabase extracts metadata from a zip-archived CodeQL database.
-func discoverArchivedDatabase(zipPath string) (*DiscoveredDatabase, error) {
-	reader, err := zip.OpenReader(zipPath)
+// An archive may hold several databases (e.g. one per language when created
+// with --db-cluster); one entry is returned per language found.
+func discoverArchivedDatabase(fsys fs.FS, name string, opts Options) ([]*DiscoveredDatabase, error) {
+	return reuse(fsys, name, opts, func() ([]*DiscoveredDatabase, error) {
+		return readArchivedDatabase(fsys, name, opts)
+	})
+}
+
+// readArchivedDatabase reads the database archive name.
+func readArchivedDatabase(fsys fs.FS, name string, opts Options) ([]*DiscoveredDatabase, error) {
+	file, err := fsys.Open(name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open zip: %w", err)
 	}
 	defer func() {
-		_ = reader.Close() //nolint:errcheck // Best effort close in defer
+		_ = file.Close() //nolint:errcheck // Best effort close in defer
 	}()
 
-	// Try to find codeql-database.yml first
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat zip file: %w", err)
+	}
+	ra, ok := file.(io.ReaderAt)
+	if !ok {
+		return nil, fmt.Errorf("failed to open zip: %s does not support random access", name)
+	}
+	reader, err := openZip(ra, info.Size())
+	if err != nil {
+		var invalidErr *InvalidDatabaseError
+		if errors.As(err, &invalidErr) {
+			return nil, err
+		}
+		// A truncated or corrupt upload
+		return nil, invalid("cannot read zip directory: %v", err)
+	}
+
+	a := &archive{
+		fsys:    fsys,
+		path:    name,
+		size:    info.Size(),
+		files:   reader.File,
+		reader:  ra,
+		sidecar: readSidecar(fsys, strings.TrimSuffix(name, path.Ext(name))+".hepc.yml"),
+		opts:    opts,
+	}
+
+	// Every codeql-database.yml marks the root of a database in the archive
+	var databases []*DiscoveredDatabase
 	for _, f := range reader.File {
-		if strings.HasSuffix(f.Name, "codeql-database.yml") {
-			db, err := extractMetadataFromYAMLFile(f, zipPath, true)
+		if path.Base(f.Name) == "codeql-database.yml" {
+			dbs, err := extractMetadataFromYAMLFile(f, a, true)
 			if err != nil {
 				return nil, err
 			}
-			return db, nil
+			databases = append(databases, dbs...)
 		}
 	}
+	if len(databases) > 0 {
+		if err := validateArchive(reader.File, info.Size(), opts.Validation); err != nil {
+			return nil, err
+		}
+		return withArtifactLanguages(databases), nil
+	}
 
 	// Fall back to .dbinfo (older format)
 	for _, f := range reader.File {
 		if strings.HasSuffix(f.Name, ".dbinfo") {
-			db, err := extractMetadataFromDBInfo(f, zipPath)
+			dbs, err := extractMetadataFromDBInfo(f, a)
 			if err != nil {
 				return nil, err
 			}
-			return db, nil
+			if err := validateArchive(reader.File, info.Size(), opts.Validation); err != nil {
+				return nil, err
+			}
+			return withArtifactLanguages(dbs), nil
 		}
 	}
 
 	return nil, nil // Not a CodeQL database
 }
 
-// discoverUnarchivedDatabase extracts metadata from an unarchived CodeQL database.
-func discoverUnarchivedDatabase(dbPath string) (*DiscoveredDatabase, error) {
-	ymlPath := filepath.Join(dbPath, "codeql-database.yml")
+// archive holds an open database archive during discovery.
+type archive struct {
+	fsys    fs.FS
+	path    string
+	size    int64
+	files   []*zip.File
+	reader  io.ReaderAt
+	sidecar *Sidecar
+	opts    Options
+
+	// contentHash and hashKind are computed on first use and shared by
+	// all databases in the archive
+	contentHash string
+	hashKind    string
+}
+
+// hash returns the content hash of the archive and its kind.
+func (a *archive) hash() (string, string, error) {
+	if a.contentHash != "" {
+		return a.contentHash, a.hashKind, nil
+	}
+
+	var err error
+	a.contentHash, a.hashKind, err = fileHash(a.fsys, a.path)
+	return a.contentHash, a.hashKind, err
+}
 
-	data, err := os.ReadFile(ymlPath)
+// newDatabase returns the common fields of a database found in the archive.
+func (a *archive) newDatabase(sourceLocationPrefix string) (*DiscoveredDatabase, error) {
+	contentHash, hashKind, err := a.hash()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read codeql-database.yml: %w", err)
+		return nil, fmt.Errorf("failed to hash file: %w", err)
 	}
+	return &DiscoveredDatabase{
+		Path:                 a.path,
+		RelPath:              a.path,
+		Name:                 path.Base(a.path),
+		IsArchived:           true,
+		SourceLocationPrefix: sourceLocationPrefix,
+		FileSize:             a.size,
+		ContentHash:          contentHash,
+		HashKind:             hashKind,
+	}, nil
+}
 
-	var dbYAML DatabaseYAML
-	if unmarshalErr := yaml.Unmarshal(data, &dbYAML); unmarshalErr != nil {
-		return nil, fmt.Errorf("failed to parse codeql-database.yml: %w", unmarshalErr)
+// identity resolves the identity of the database rooted at root in the archive.
+func (a *archive) identity(root, sourceLocationPrefix string) Identity {
+	ev := IdentityEvidence{
+		Sidecar:              a.sidecar,
+		SourceLocationPrefix: sourceLocationPrefix,
+		ArtifactName:         path.Base(a.path),
+	}
+	if id, ok := GitIdentityFromDatabaseArchive(a.files, a.reader, root, sourceLocationPrefix); ok {
+		ev.Git = id
 	}
+	return ResolveIdentity(ev, a.opts.IdentityRules)
+}
 
-	// Calculate directory size
-	var totalSize int64
-	err = filepath.WalkDir(dbPath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			info, err := d.Info()
-			if err == nil {
-				totalSize += info.Size()
-			}
-		}
+// readSidecar reads a sidecar file, returning nil if it does not exist or
+// cannot be parsed.
+func readSidecar(fsys fs.FS, name string) *Sidecar {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
 		return nil
-	})
+	}
+	sc, err := ParseSidecar(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to calculate directory size: %w", err)
+		fmt.Fprintf(os.Stderr, "Warning: ignoring %s: %v\n", name, err)
+		return nil
 	}
+	return sc
+}
 
-	// Determine language - use primaryLanguage from YAML, or detect from db-<lang> directories
-	language := dbYAML.PrimaryLanguage
-	if language == "" {
-		language = detectLanguageFromDirectory(dbPath)
+// withArtifactLanguages records the union of all languages found in one
+// artifact on each of its entries.
+func withArtifactLanguages(databases []*DiscoveredDatabase) []*DiscoveredDatabase {
+	var all []string
+	seen := make(map[string]bool)
+	for _, db := range databases {
+		if !seen[db.Language] {
+			seen[db.Language] = true
+			all = append(all, db.Language)
+		}
+	}
+	for _, db := range databases {
+		db.Languages = all
 	}
+	return databases
+}
 
-	// Extract owner/repo from source location prefix
-	owner, repo := extractOwnerRepo(dbYAML.SourceLocationPrefix)
+// expandLanguages returns one copy of db per language.
+func expandLanguages(db *DiscoveredDatabase, languages []string) []*DiscoveredDatabase {
+	dbs := make([]*DiscoveredDatabase, 0, len(languages))
+	for _, lang := range languages {
+		entry := *db
+		entry.Language = lang
+		entry.Languages = languages
+		dbs = append(dbs, &entry)
+	}
+	return dbs
+}
 
-	db := &DiscoveredDatabase{
-		Path:                 dbPath,
-		Name:                 filepath.Base(dbPath),
-		IsArchived:           false,
-		Language:             language,
-		SourceLocationPrefix: dbYAML.SourceLocationPrefix,
-		CreationMetadata:     dbYAML.CreationMetadata,
-		FileSize:             totalSize,
-		Owner:                owner,
-		Repo:                 repo,
+// OrderLanguages returns the database languages with the declared primary
+// language first, followed by the remaining detected languages in sorted order.
+// It returns ["unknown"] if no language is known.
+func OrderLanguages(primary string, detected []string) []string {
+	var languages []string
+	seen := make(map[string]bool)
+	if primary != "" {
+		languages = append(languages, primary)
+		seen[primary] = true
+	}
+
+	rest := make([]string, 0, len(detected))
+	for _, lang := range detected {
+		if !seen[lang] {
+			seen[lang] = true
+			rest = append(rest, lang)
+		}
 	}
+	sort.Strings(rest)
+	languages = append(languages, rest...)
 
-	return db, nil
+	if len(languages) == 0 {
+		return []string{"unknown"}
+	}
+	return languages
 }
 
-// extractMetadataFromYAMLFile extracts metadata from a codeql-database.yml inside a zip.
-func extractMetadataFromYAMLFile(f *zip.File, zipPath string, isArchived bool) (*DiscoveredDatabase, error) {
-	rc, err := f.Open()
+// discoverUnarchivedDatabase extracts metadata from an unarchived CodeQL database,
+// returning one entry per language it contains. entries lists the database
+// directory, or is nil to have it listed.
+func discoverUnarchivedDatabase(fsys fs.FS, dbPath string, entries []fs.DirEntry, opts Options) ([]*DiscoveredDatabase, error) {
+	// List the files first: a TreeFS answers the reads below from this
+	// listing, and a StampFS stamps the directory from it
+	files, err := listDirectory(fsys, dbPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open yaml file in zip: %w", err)
+		return nil, fmt.Errorf("failed to list database directory: %w", err)
 	}
-	defer func() {
-		_ = rc.Close() //nolint:errcheck // Best effort close in defer
-	}()
+	return reuse(fsys, dbPath, opts, func() ([]*DiscoveredDatabase, error) {
+		return readUnarchivedDatabase(fsys, dbPath, entries, files, opts)
+	})
+}
 
-	data, err := io.ReadAll(rc)
+// readUnarchivedDatabase reads the database directory dbPath holding files.
+func readUnarchivedDatabase(fsys fs.FS, dbPath string, entries []fs.DirEntry, files []TreeFile, opts Options) ([]*DiscoveredDatabase, error) {
+	if entries == nil {
+		var err error
+		if entries, err = fs.ReadDir(fsys, dbPath); err != nil {
+			return nil, fmt.Errorf("failed to list database directory: %w", err)
+		}
+	}
+
+	data, err := fs.ReadFile(fsys, path.Join(dbPath, "codeql-database.yml"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read yaml file: %w", err)
+		return nil, fmt.Errorf("failed to read codeql-database.yml: %w", err)
 	}
 
 	var dbYAML DatabaseYAML
 	if unmarshalErr := yaml.Unmarshal(data, &dbYAML); unmarshalErr != nil {
-		return nil, fmt.Errorf("failed to parse codeql-database.yml: %w", unmarshalErr)
+		return nil, invalid("failed to parse codeql-database.yml: %v", unmarshalErr)
 	}
 
-	// Get zip file info
-	info, err := os.Stat(zipPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to stat zip file: %w", err)
+	// Determine languages - primaryLanguage from YAML plus any db-<lang> directories
+	languages := OrderLanguages(dbYAML.PrimaryLanguage, detectLanguagesFromEntries(entries))
+	layout := directoryLayout(fsys, dbPath, entries, opts.Validation)
+	if err := validateDatabase(&dbYAML, languages, layout, opts.Validation); err != nil {
+		return nil, err
 	}
 
-	// Calculate content hash
-	contentHash, err := hashFile(zipPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to hash file: %w", err)
+	// Calculate directory size and content hash
+	var totalSize int64
+	for _, f := range files {
+		totalSize += f.Size
 	}
-
-	// Extract owner/repo from source location prefix
-	owner, repo := extractOwnerRepo(dbYAML.SourceLocationPrefix)
-
-	// Determine language - use primaryLanguage from YAML, or detect from db-<lang> directories in zip
-	language := dbYAML.PrimaryLanguage
-	if language == "" {
-		language = detectLanguageFromZip(zipPath)
+	contentHash, err := directoryHash(fsys, dbPath, files, opts.HashCache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash directory: %w", err)
 	}
 
 	db := &DiscoveredDatabase{
-		Path:                 zipPath,
-		Name:                 filepath.Base(zipPath),
-		IsArchived:           isArchived,
-		Language:             language,
+		Path:                 dbPath,
+		RelPath:              dbPath,
+		Name:                 path.Base(dbPath),
+		IsArchived:           false,
 		SourceLocationPrefix: dbYAML.SourceLocationPrefix,
 		CreationMetadata:     dbYAML.CreationMetadata,
-		FileSize:             info.Size(),
+		FileSize:             totalSize,
 		ContentHash:          contentHash,
-		Owner:                owner,
-		Repo:                 repo,
-	}
+		HashKind:             api.HashKindMerkle,
+		Finalised:            dbYAML.Finalised,
+	}
+	sidecar := readSidecar(fsys, path.Join(dbPath, SidecarFileName))
+	db.applySidecar(sidecar)
+	db.applyIdentity(ResolveIdentity(IdentityEvidence{
+		Sidecar:              sidecar,
+		Git:                  gitIdentityFromDirectory(fsys, dbPath, dbYAML.SourceLocationPrefix),
+		SourceLocationPrefix: dbYAML.SourceLocationPrefix,
+		ArtifactName:         db.Name,
+	}, opts.IdentityRules))
 
-	return db, nil
+	dbs := expandLanguages(db, languages)
+	facts := directoryFacts(fsys, dbPath, entries)
+	facts.apply(dbs, dbYAML.BaselineLinesOfCode)
+	return dbs, nil
 }
 
-// extractMetadataFromDBInfo extracts metadata from a .dbinfo XML file inside a zip.
-func extractMetadataFromDBInfo(f *zip.File, zipPath string) (*DiscoveredDatabase, error) {
-	rc, err := f.Open()
+// gitIdentityFromDirectory reads the git identity from the src.zip of an
+// unarchived database, if it has one.
+func gitIdentityFromDirectory(fsys fs.FS, dbPath, sourceLocationPrefix string) *Identity {
+	file, err := fsys.Open(path.Join(dbPath, "src.zip"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open dbinfo file in zip: %w", err)
+		return nil
 	}
 	defer func() {
-		_ = rc.Close() //nolint:errcheck // Best effort close in defer
+		_ = file.Close() //nolint:errcheck // Best effort close in defer
 	}()
 
-	data, err := io.ReadAll(rc)
+	info, err := file.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read dbinfo file: %w", err)
+		return nil
 	}
-
-	var dbInfo DBInfo
-	if unmarshalErr := xml.Unmarshal(data, &dbInfo); unmarshalErr != nil {
-		return nil, fmt.Errorf("failed to parse .dbinfo: %w", unmarshalErr)
+	ra, ok := file.(io.ReaderAt)
+	if !ok {
+		return nil
 	}
-
-	// Get zip file info
-	info, err := os.Stat(zipPath)
+	reader, err := openZip(ra, info.Size())
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat zip file: %w", err)
+		return nil
 	}
 
-	// Calculate content hash
-	contentHash, err := hashFile(zipPath)
+	id, _ := GitIdentityFromSourceArchive(reader, sourceLocationPrefix)
+	return id
+}
+
+// extractMetadataFromYAMLFile extracts metadata from a codeql-database.yml inside a zip,
+// returning one entry per language of the database rooted at the file's directory.
+func extractMetadataFromYAMLFile(f *zip.File, a *archive, isArchived bool) ([]*DiscoveredDatabase, error) {
+	data, err := readZipEntry(f, maxMetadataSize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash file: %w", err)
+		// Includes CRC-32 mismatches and entries beyond the limits
+		return nil, invalid("failed to read %s: %v", f.Name, err)
 	}
 
-	// Try to detect language from the zip contents
-	language := detectLanguageFromZip(zipPath)
+	var dbYAML DatabaseYAML
+	if unmarshalErr := yaml.Unmarshal(data, &dbYAML); unmarshalErr != nil {
+		return nil, invalid("failed to parse %s: %v", f.Name, unmarshalErr)
+	}
 
-	// Extract owner/repo from source location prefix
-	owner, repo := extractOwnerRepo(dbInfo.SourceLocationPrefix)
+	// Determine languages - primaryLanguage from YAML plus any db-<lang> directories
+	// directly under this database's root in the zip
+	root := strings.TrimSuffix(f.Name, "codeql-database.yml")
+	languages := OrderLanguages(dbYAML.PrimaryLanguage, languagesUnderRoot(a.files, root))
+	if err := validateDatabase(&dbYAML, languages, archiveLayout(a.files, root), a.opts.Validation); err != nil {
+		return nil, err
+	}
 
-	// For old-format databases, try to extract owner/repo from the filename
-	// if the source location prefix doesn't give useful info
-	if owner == "opt" || owner == "src" || owner == "unknown" {
-		filenameOwner, filenameRepo := extractOwnerRepoFromFilename(filepath.Base(zipPath))
-		if filenameOwner != "unknown" {
-			owner = filenameOwner
-		}
-		if filenameRepo != "unknown" {
-			repo = filenameRepo
-		}
+	db, err := a.newDatabase(dbYAML.SourceLocationPrefix)
+	if err != nil {
+		return nil, err
+	}
+	db.IsArchived = isArchived
+	db.CreationMetadata = dbYAML.CreationMetadata
+	db.Finalised = dbYAML.Finalised
+	db.applySidecar(a.sidecar)
+	db.applyIdentity(a.identity(root, dbYAML.SourceLocationPrefix))
+
+	dbs := expandLanguages(db, languages)
+	facts := archiveFacts(a.files, root)
+	facts.apply(dbs, dbYAML.BaselineLinesOfCode)
+	return dbs, nil
+}
+
+// extractMetadataFromDBInfo extracts metadata from a .dbinfo XML file inside a zip.
+func extractMetadataFromDBInfo(f *zip.File, a *archive) ([]*DiscoveredDatabase, error) {
+	data, err := readZipEntry(f, maxMetadataSize)
+	if err != nil {
+		return nil, invalid("failed to read %s: %v", f.Name, err)
 	}
 
-	db := &DiscoveredDatabase{
-		Path:                 zipPath,
-		Name:                 filepath.Base(zipPath),
-		IsArchived:           true,
-		Language:             language,
-		SourceLocationPrefix: dbInfo.SourceLocationPrefix,
-		CreationMetadata:     nil, // Old format doesn't have this
-		FileSize:             info.Size(),
-		ContentHash:          contentHash,
-		Owner:                owner,
-		Repo:                 repo,
+	var dbInfo DBInfo
+	if unmarshalErr := xml.Unmarshal(data, &dbInfo); unmarshalErr != nil {
+		return nil, fmt.Errorf("failed to parse .dbinfo: %w", unmarshalErr)
+	}
+
+	// Try to detect languages from the zip contents
+	languages := OrderLanguages("", detectLanguagesFromZipFiles(a.files))
+
+	// Old format doesn't have creation metadata
+	db, err := a.newDatabase(dbInfo.SourceLocationPrefix)
+	if err != nil {
+		return nil, err
 	}
+	db.applySidecar(a.sidecar)
+	root := strings.TrimSuffix(f.Name, path.Base(f.Name))
+	db.applyIdentity(a.identity(root, dbInfo.SourceLocationPrefix))
 
-	return db, nil
+	dbs := expandLanguages(db, languages)
+	facts := archiveFacts(a.files, root)
+	facts.apply(dbs, 0)
+	return dbs, nil
 }
 
 // extractOwnerRepo extracts owner and repo from a source location prefix path.
@@ -399,9 +850,19 @@ func extractOwnerRepoFromFilename(filename string) (owner, repo string) {
 	return "unknown", "unknown"
 }
 
+// fileHash returns the content hash of a file and its kind, from fsys if it
+// is a HashFS and otherwise by reading the file.
+func fileHash(fsys fs.FS, name string) (string, string, error) {
+	if hfs, ok := fsys.(HashFS); ok {
+		return hfs.Hash(name)
+	}
+	sum, err := hashFile(fsys, name)
+	return sum, api.HashKindSHA256, err
+}
+
 // hashFile computes the SHA-256 hash of a file.
-func hashFile(path string) (string, error) {
-	f, err := os.Open(path)
+func hashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
 	if err != nil {
 		return "", err
 	}
@@ -417,50 +878,81 @@ func hashFile(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// detectLanguageFromZip tries to detect the primary language from a zip file
-// by looking for db-<language> directories or paths containing db-<language>/.
-func detectLanguageFromZip(zipPath string) string {
-	reader, err := zip.OpenReader(zipPath)
-	if err != nil {
-		return "unknown"
+// LanguageContentHash returns the content hash advertised for one language of
+// a database artifact. Single-language artifacts keep the artifact hash so it
+// still matches the downloaded file; entries of multi-language artifacts get a
+// hash derived from the artifact hash and language so each stays unique.
+func LanguageContentHash(db *DiscoveredDatabase, artifactHash string) string {
+	if len(db.Languages) <= 1 {
+		return artifactHash
 	}
-	defer func() {
-		_ = reader.Close() //nolint:errcheck // Best effort close in defer
-	}()
+	h := sha256.Sum256([]byte(artifactHash + ":" + db.Language))
+	return hex.EncodeToString(h[:])
+}
 
-	// Look for paths containing db-<language>/
-	for _, f := range reader.File {
-		// Check if path contains db-<language>/ pattern
-		parts := strings.Split(f.Name, "/")
-		for _, part := range parts {
-			if strings.HasPrefix(part, "db-") {
-				lang := strings.TrimPrefix(part, "db-")
-				if lang != "" {
-					return lang
-				}
+// LanguageResultURL returns the result URL for one language of a database
+// artifact. For multi-language artifacts a "language" query parameter is added
+// so that each entry identifies both the artifact and the language within it.
+func LanguageResultURL(db *DiscoveredDatabase, artifactURL string) string {
+	if len(db.Languages) <= 1 {
+		return artifactURL
+	}
+	return artifactURL + "?language=" + url.QueryEscape(db.Language)
+}
+
+// detectLanguagesFromZipFiles returns the sorted languages of all db-<language>
+// path components found anywhere in the archive.
+func detectLanguagesFromZipFiles(files []*zip.File) []string {
+	seen := make(map[string]bool)
+	var languages []string
+	for _, f := range files {
+		for _, part := range strings.Split(f.Name, "/") {
+			if lang, ok := strings.CutPrefix(part, "db-"); ok && lang != "" && !seen[lang] {
+				seen[lang] = true
+				languages = append(languages, lang)
 			}
 		}
 	}
-
-	return "unknown"
+	sort.Strings(languages)
+	return languages
 }
 
-// detectLanguageFromDirectory tries to detect the primary language from an unarchived
-// database directory by looking for db-<language> subdirectories.
-func detectLanguageFromDirectory(dbPath string) string {
-	entries, err := os.ReadDir(dbPath)
-	if err != nil {
-		return "unknown"
+// languagesUnderRoot returns the sorted languages of db-<language> directories
+// located directly under root (a database directory inside the archive,
+// either "" or ending in "/").
+func languagesUnderRoot(files []*zip.File, root string) []string {
+	seen := make(map[string]bool)
+	var languages []string
+	for _, f := range files {
+		rest, ok := strings.CutPrefix(f.Name, root)
+		if !ok {
+			continue
+		}
+		dir, _, isDir := strings.Cut(rest, "/")
+		if !isDir {
+			continue
+		}
+		if lang, ok := strings.CutPrefix(dir, "db-"); ok && lang != "" && !seen[lang] {
+			seen[lang] = true
+			languages = append(languages, lang)
+		}
 	}
+	sort.Strings(languages)
+	return languages
+}
 
+// detectLanguagesFromEntries detects languages from the entries of an
+// unarchived database directory by looking for db-<language> subdirectories.
+func detectLanguagesFromEntries(entries []fs.DirEntry) []string {
+	var languages []string
 	for _, entry := range entries {
 		if entry.IsDir() && strings.HasPrefix(entry.Name(), "db-") {
 			lang := strings.TrimPrefix(entry.Name(), "db-")
 			if lang != "" {
-				return lang
+				languages = append(languages, lang)
 			}
 		}
 	}
 
-	return "unknown"
+	return languages
 }
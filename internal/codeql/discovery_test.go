@@ -4,12 +4,20 @@ package codeql
 
 import (
 	"archive/zip"
+	"bytes"
 	"encoding/xml"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/data-douser/mrva-go-hepc/api"
 )
 
 func TestExtractOwnerRepo(t *testing.T) {
@@ -49,6 +57,12 @@ func TestExtractOwnerRepo(t *testing.T) {
 			expectedOwner:        "unknown",
 			expectedRepo:         "repo",
 		},
+		{
+			name:                 "relative path",
+			sourceLocationPrefix: "owner/repo",
+			expectedOwner:        "owner",
+			expectedRepo:         "repo",
+		},
 		{
 			name:                 "deep path",
 			sourceLocationPrefix: "/a/b/c/d/owner/repo",
@@ -152,7 +166,7 @@ func TestExtractOwnerRepoFromFilename(t *testing.T) {
 	}
 }
 
-func TestDetectLanguageFromDirectory(t *testing.T) {
+func TestDetectLanguagesFromEntries(t *testing.T) {
 	// Create a temporary directory structure for testing
 	tempDir, err := os.MkdirTemp("", "codeql-test-*")
 	if err != nil {
@@ -161,9 +175,9 @@ func TestDetectLanguageFromDirectory(t *testing.T) {
 	defer os.RemoveAll(tempDir)
 
 	tests := []struct {
-		name         string
-		setupFunc    func(baseDir string) string
-		expectedLang string
+		name          string
+		setupFunc     func(baseDir string) string
+		expectedLangs []string
 	}{
 		{
 			name: "db-go directory",
@@ -172,7 +186,7 @@ func TestDetectLanguageFromDirectory(t *testing.T) {
 				os.MkdirAll(filepath.Join(dbDir, "db-go"), 0o755)
 				return dbDir
 			},
-			expectedLang: "go",
+			expectedLangs: []string{"go"},
 		},
 		{
 			name: "db-java directory",
@@ -181,7 +195,7 @@ func TestDetectLanguageFromDirectory(t *testing.T) {
 				os.MkdirAll(filepath.Join(dbDir, "db-java"), 0o755)
 				return dbDir
 			},
-			expectedLang: "java",
+			expectedLangs: []string{"java"},
 		},
 		{
 			name: "db-javascript directory",
@@ -190,7 +204,7 @@ func TestDetectLanguageFromDirectory(t *testing.T) {
 				os.MkdirAll(filepath.Join(dbDir, "db-javascript"), 0o755)
 				return dbDir
 			},
-			expectedLang: "javascript",
+			expectedLangs: []string{"javascript"},
 		},
 		{
 			name: "no db- directory",
@@ -200,7 +214,7 @@ func TestDetectLanguageFromDirectory(t *testing.T) {
 				os.MkdirAll(filepath.Join(dbDir, "log"), 0o755)
 				return dbDir
 			},
-			expectedLang: "unknown",
+			expectedLangs: nil,
 		},
 		{
 			name: "empty directory",
@@ -209,22 +223,36 @@ func TestDetectLanguageFromDirectory(t *testing.T) {
 				os.MkdirAll(dbDir, 0o755)
 				return dbDir
 			},
-			expectedLang: "unknown",
+			expectedLangs: nil,
+		},
+		{
+			name: "multiple db- directories",
+			setupFunc: func(baseDir string) string {
+				dbDir := filepath.Join(baseDir, "test-db-multi")
+				os.MkdirAll(filepath.Join(dbDir, "db-python"), 0o755)
+				os.MkdirAll(filepath.Join(dbDir, "db-javascript"), 0o755)
+				return dbDir
+			},
+			expectedLangs: []string{"javascript", "python"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			dbPath := tt.setupFunc(tempDir)
-			lang := detectLanguageFromDirectory(dbPath)
-			if lang != tt.expectedLang {
-				t.Errorf("detectLanguageFromDirectory(%q) = %q, want %q", dbPath, lang, tt.expectedLang)
+			entries, err := os.ReadDir(dbPath)
+			if err != nil {
+				t.Fatalf("ReadDir(%q) error = %v", dbPath, err)
+			}
+			langs := detectLanguagesFromEntries(entries)
+			if !reflect.DeepEqual(langs, tt.expectedLangs) {
+				t.Errorf("detectLanguagesFromEntries(%q) = %v, want %v", dbPath, langs, tt.expectedLangs)
 			}
 		})
 	}
 }
 
-func TestDetectLanguageFromZip(t *testing.T) {
+func TestDetectLanguagesFromZipFiles(t *testing.T) {
 	// Create a temporary directory for test zip files
 	tempDir, err := os.MkdirTemp("", "codeql-zip-test-*")
 	if err != nil {
@@ -233,34 +261,39 @@ func TestDetectLanguageFromZip(t *testing.T) {
 	defer os.RemoveAll(tempDir)
 
 	tests := []struct {
-		name         string
-		zipContents  []string // paths to include in the zip
-		expectedLang string
+		name          string
+		zipContents   []string // paths to include in the zip
+		expectedLangs []string
 	}{
 		{
-			name:         "db-go path in zip",
-			zipContents:  []string{"db/db-go/default/", "db/db-go/default/data.db"},
-			expectedLang: "go",
+			name:          "db-go path in zip",
+			zipContents:   []string{"db/db-go/default/", "db/db-go/default/data.db"},
+			expectedLangs: []string{"go"},
 		},
 		{
-			name:         "db-python path in zip",
-			zipContents:  []string{"mydb/db-python/something.txt"},
-			expectedLang: "python",
+			name:          "db-python path in zip",
+			zipContents:   []string{"mydb/db-python/something.txt"},
+			expectedLangs: []string{"python"},
 		},
 		{
-			name:         "db-cpp path in zip",
-			zipContents:  []string{"root/db-cpp/nested/file.txt"},
-			expectedLang: "cpp",
+			name:          "db-cpp path in zip",
+			zipContents:   []string{"root/db-cpp/nested/file.txt"},
+			expectedLangs: []string{"cpp"},
 		},
 		{
-			name:         "no db- path in zip",
-			zipContents:  []string{"src/main.go", "log/build.log"},
-			expectedLang: "unknown",
+			name:          "no db- path in zip",
+			zipContents:   []string{"src/main.go", "log/build.log"},
+			expectedLangs: nil,
 		},
 		{
-			name:         "empty zip",
-			zipContents:  []string{},
-			expectedLang: "unknown",
+			name:          "empty zip",
+			zipContents:   []string{},
+			expectedLangs: nil,
+		},
+		{
+			name:          "multiple db- paths in zip",
+			zipContents:   []string{"db/db-python/a", "db/db-go/b", "db/db-python/c"},
+			expectedLangs: []string{"go", "python"},
 		},
 	}
 
@@ -269,14 +302,63 @@ func TestDetectLanguageFromZip(t *testing.T) {
 			zipPath := filepath.Join(tempDir, tt.name+".zip")
 			createTestZip(t, zipPath, tt.zipContents)
 
-			lang := detectLanguageFromZip(zipPath)
-			if lang != tt.expectedLang {
-				t.Errorf("detectLanguageFromZip() = %q, want %q", lang, tt.expectedLang)
+			reader, err := zip.OpenReader(zipPath)
+			if err != nil {
+				t.Fatalf("Failed to open zip: %v", err)
+			}
+			defer reader.Close()
+
+			langs := detectLanguagesFromZipFiles(reader.File)
+			if !reflect.DeepEqual(langs, tt.expectedLangs) {
+				t.Errorf("detectLanguagesFromZipFiles() = %v, want %v", langs, tt.expectedLangs)
 			}
 		})
 	}
 }
 
+func TestOrderLanguages(t *testing.T) {
+	tests := []struct {
+		name     string
+		primary  string
+		detected []string
+		want     []string
+	}{
+		{"primary only", "go", nil, []string{"go"}},
+		{"primary first", "python", []string{"go", "python", "cpp"}, []string{"python", "cpp", "go"}},
+		{"detected only", "", []string{"ruby", "java"}, []string{"java", "ruby"}},
+		{"nothing known", "", nil, []string{"unknown"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OrderLanguages(tt.primary, tt.detected); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OrderLanguages(%q, %v) = %v, want %v", tt.primary, tt.detected, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLanguageContentHashAndResultURL(t *testing.T) {
+	single := &DiscoveredDatabase{Language: "go", Languages: []string{"go"}}
+	if got := LanguageContentHash(single, "abc"); got != "abc" {
+		t.Errorf("LanguageContentHash(single) = %q, want artifact hash", got)
+	}
+	if got := LanguageResultURL(single, "http://h/db/x.zip"); got != "http://h/db/x.zip" {
+		t.Errorf("LanguageResultURL(single) = %q, want artifact URL", got)
+	}
+
+	goDB := &DiscoveredDatabase{Language: "go", Languages: []string{"go", "python"}}
+	pyDB := &DiscoveredDatabase{Language: "python", Languages: []string{"go", "python"}}
+	goHash := LanguageContentHash(goDB, "abc")
+	pyHash := LanguageContentHash(pyDB, "abc")
+	if goHash == "abc" || pyHash == "abc" || goHash == pyHash {
+		t.Errorf("multi-language hashes must be distinct and derived: go=%q python=%q", goHash, pyHash)
+	}
+	if got := LanguageResultURL(pyDB, "http://h/db/x.zip"); got != "http://h/db/x.zip?language=python" {
+		t.Errorf("LanguageResultURL(multi) = %q, want language query", got)
+	}
+}
+
 func TestHashFile(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "hash-test-*")
 	if err != nil {
@@ -294,7 +376,7 @@ func TestHashFile(t *testing.T) {
 	// Known SHA-256 hash for "hello world"
 	expectedHash := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
 
-	hash, err := hashFile(testFile)
+	hash, err := hashFile(os.DirFS(tempDir), "test.txt")
 	if err != nil {
 		t.Fatalf("hashFile() error = %v", err)
 	}
@@ -305,7 +387,7 @@ func TestHashFile(t *testing.T) {
 }
 
 func TestHashFile_NonExistent(t *testing.T) {
-	_, err := hashFile("/nonexistent/file.txt")
+	_, err := hashFile(os.DirFS("/nonexistent"), "file.txt")
 	if err == nil {
 		t.Error("hashFile() expected error for non-existent file, got nil")
 	}
@@ -491,6 +573,368 @@ primaryLanguage: python
 	}
 }
 
+func TestDiscoverDatabases_MultiLanguageDirectory(t *testing.T) {
+	tempDir := t.TempDir()
+
+	dbDir := filepath.Join(tempDir, "multi-db")
+	for _, lang := range []string{"db-javascript", "db-python"} {
+		if err := os.MkdirAll(filepath.Join(dbDir, lang), 0o755); err != nil {
+			t.Fatalf("Failed to create db directory: %v", err)
+		}
+	}
+	yamlContent := `sourceLocationPrefix: /src/owner/repo
+primaryLanguage: python
+`
+	if err := os.WriteFile(filepath.Join(dbDir, "codeql-database.yml"), []byte(yamlContent), 0o644); err != nil {
+		t.Fatalf("Failed to write codeql-database.yml: %v", err)
+	}
+
+	databases, err := DiscoverDatabases(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverDatabases() error = %v", err)
+	}
+	if len(databases) != 2 {
+		t.Fatalf("DiscoverDatabases() returned %d databases, want 2", len(databases))
+	}
+
+	if databases[0].Language != "python" || databases[1].Language != "javascript" {
+		t.Errorf("languages = [%q %q], want [python javascript]", databases[0].Language, databases[1].Language)
+	}
+	for _, db := range databases {
+		if !reflect.DeepEqual(db.Languages, []string{"python", "javascript"}) {
+			t.Errorf("db.Languages = %v, want [python javascript]", db.Languages)
+		}
+		if db.Path != dbDir {
+			t.Errorf("db.Path = %q, want %q", db.Path, dbDir)
+		}
+	}
+}
+
+func TestDiscoverDatabases_ClusterArchive(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// A --db-cluster archive holds one database per language
+	zipPath := filepath.Join(tempDir, "cluster.zip")
+	createTestZipWithEntries(t, zipPath, map[string]string{
+		"cluster/go/codeql-database.yml":      "sourceLocationPrefix: /src/owner/repo\nprimaryLanguage: go\n",
+		"cluster/go/db-go/default/x":          "",
+		"cluster/python/codeql-database.yml":  "sourceLocationPrefix: /src/owner/repo\nprimaryLanguage: python\n",
+		"cluster/python/db-python/default/x":  "",
+		"cluster/python/log/db-unrelated.log": "",
+	})
+
+	databases, err := DiscoverDatabases(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverDatabases() error = %v", err)
+	}
+	if len(databases) != 2 {
+		t.Fatalf("DiscoverDatabases() returned %d databases, want 2", len(databases))
+	}
+
+	languages := map[string]bool{}
+	for _, db := range databases {
+		languages[db.Language] = true
+		if len(db.Languages) != 2 {
+			t.Errorf("db.Languages = %v, want both cluster languages", db.Languages)
+		}
+		if !db.IsArchived || db.Path != zipPath {
+			t.Errorf("db.Path = %q (archived=%v), want %q", db.Path, db.IsArchived, zipPath)
+		}
+	}
+	if !languages["go"] || !languages["python"] {
+		t.Errorf("languages = %v, want go and python", languages)
+	}
+}
+
+func TestDiscoverDatabases_MultiLanguageArchive(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// One database root with several db-<lang> directories and no primaryLanguage
+	zipPath := filepath.Join(tempDir, "multi.zip")
+	createTestZipWithEntries(t, zipPath, map[string]string{
+		"db/codeql-database.yml":    "sourceLocationPrefix: /src/owner/repo\n",
+		"db/db-cpp/default/x":       "",
+		"db/db-csharp/default/x":    "",
+		"db/src/vendor/db-fake/a.c": "",
+	})
+
+	databases, err := DiscoverDatabases(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverDatabases() error = %v", err)
+	}
+	if len(databases) != 2 {
+		t.Fatalf("DiscoverDatabases() returned %d databases, want 2", len(databases))
+	}
+	if databases[0].Language != "cpp" || databases[1].Language != "csharp" {
+		t.Errorf("languages = [%q %q], want [cpp csharp]", databases[0].Language, databases[1].Language)
+	}
+}
+
+func TestDiscoverDatabases_ClusterDirectory(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// An unarchived cluster is a directory of per-language databases
+	for _, lang := range []string{"go", "java"} {
+		dbDir := filepath.Join(tempDir, "cluster", lang)
+		if err := os.MkdirAll(filepath.Join(dbDir, "db-"+lang), 0o755); err != nil {
+			t.Fatalf("Failed to create db directory: %v", err)
+		}
+		yamlContent := "sourceLocationPrefix: /src/owner/repo\nprimaryLanguage: " + lang + "\n"
+		if err := os.WriteFile(filepath.Join(dbDir, "codeql-database.yml"), []byte(yamlContent), 0o644); err != nil {
+			t.Fatalf("Failed to write codeql-database.yml: %v", err)
+		}
+	}
+
+	databases, err := DiscoverDatabases(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverDatabases() error = %v", err)
+	}
+	if len(databases) != 2 {
+		t.Fatalf("DiscoverDatabases() returned %d databases, want 2", len(databases))
+	}
+	for _, db := range databases {
+		if filepath.Base(db.Path) != db.Language {
+			t.Errorf("db.Path = %q for language %q, want per-language directory", db.Path, db.Language)
+		}
+		if len(db.Languages) != 1 {
+			t.Errorf("db.Languages = %v, want single language", db.Languages)
+		}
+	}
+}
+
+// hashMapFS is a MapFS that supplies file hashes itself.
+type hashMapFS struct {
+	fstest.MapFS
+}
+
+func (h hashMapFS) Hash(name string) (string, string, error) {
+	return "hash-of-" + name, api.HashKindMD5, nil
+}
+
+func TestDiscover(t *testing.T) {
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"codeql-database.yml": "sourceLocationPrefix: /src/octo/widgets\nprimaryLanguage: go\n",
+		"db-go/default/x":     "data",
+	} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+
+	fsys := hashMapFS{fstest.MapFS{
+		"team/widgets.zip":                 {Data: archive.Bytes()},
+		"team/gadgets/codeql-database.yml": {Data: []byte("sourceLocationPrefix: /src/octo/gadgets\nprimaryLanguage: python\n")},
+		"team/gadgets/db-python/default/x": {Data: []byte("12345")},
+		"team/gadgets/nested/ignored.zip":  {Data: []byte("not descended into")},
+		"notes/readme.txt":                 {Data: []byte("not a database")},
+	}}
+
+	result, err := Discover(fsys, Options{})
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	databases := result.Databases
+	if len(databases) != 2 {
+		t.Fatalf("Discover() returned %d databases, want 2", len(databases))
+	}
+
+	// Directory entries are visited in lexical order
+	gadgets, widgets := databases[0], databases[1]
+	if gadgets.RelPath != "team/gadgets" || gadgets.IsArchived {
+		t.Errorf("gadgets RelPath, IsArchived = %q, %v, want %q, false", gadgets.RelPath, gadgets.IsArchived, "team/gadgets")
+	}
+	if want := int64(len("sourceLocationPrefix: /src/octo/gadgets\nprimaryLanguage: python\n") + 5 + len("not descended into")); gadgets.FileSize != want {
+		t.Errorf("gadgets FileSize = %d, want %d", gadgets.FileSize, want)
+	}
+	if widgets.RelPath != "team/widgets.zip" || !widgets.IsArchived {
+		t.Errorf("widgets RelPath, IsArchived = %q, %v, want %q, true", widgets.RelPath, widgets.IsArchived, "team/widgets.zip")
+	}
+	if widgets.ContentHash != "hash-of-team/widgets.zip" || widgets.HashKind != api.HashKindMD5 {
+		t.Errorf("widgets ContentHash, HashKind = %q, %q, want hash from HashFS", widgets.ContentHash, widgets.HashKind)
+	}
+	if gadgets.HashKind != api.HashKindMerkle {
+		t.Errorf("gadgets HashKind = %q, want %q", gadgets.HashKind, api.HashKindMerkle)
+	}
+	if widgets.FileSize != int64(archive.Len()) {
+		t.Errorf("widgets FileSize = %d, want %d", widgets.FileSize, archive.Len())
+	}
+}
+
+// findMapFS is a MapFS that finds database candidates with one query.
+type findMapFS struct {
+	fstest.MapFS
+	queries int
+}
+
+func (f *findMapFS) FindDatabases(dir string) ([]string, error) {
+	f.queries++
+	var found []string
+	for name := range f.MapFS {
+		if dir != "." && !strings.HasPrefix(name, dir+"/") {
+			continue
+		}
+		if strings.HasSuffix(strings.ToLower(name), ".zip") || path.Base(name) == "codeql-database.yml" {
+			found = append(found, name)
+		}
+	}
+	return found, nil
+}
+
+func TestDiscover_FindFS(t *testing.T) {
+	archive := zipBytes(t, map[string]string{"codeql-database.yml": "primaryLanguage: go\n", "db-go/default/x": "x"}, "")
+	yml := []byte("primaryLanguage: go\n")
+	fsys := fstest.MapFS{
+		"codeql-database.yml":                {Data: yml}, // the root is never a database
+		"a/db/codeql-database.yml":           {Data: yml},
+		"a/db/db-go/default/x":               {Data: []byte("x")},
+		"a/db/nested/inner.zip":              {Data: archive}, // inside a database
+		"a/db.zip":                           {Data: archive},
+		"a/dir.zip/codeql-database.yml":      {Data: yml}, // a directory, not an archive
+		"a/dir.zip/db-go/default/x":          {Data: []byte("x")},
+		"a/empty/codeql-database.yml":        {Data: []byte("primaryLanguage: [\n")},
+		"a/empty/inner.zip":                  {Data: archive}, // inside a quarantined database
+		"b/TEAM.ZIP":                         {Data: archive},
+		"b/notes/readme.txt":                 {Data: []byte("not a database")},
+		"b/notes/deeper/codeql-database.yml": {Data: yml},
+	}
+
+	relPaths := func(result *Result) []string {
+		var names []string
+		for _, db := range result.Databases {
+			names = append(names, db.RelPath)
+		}
+		for _, q := range result.Quarantined {
+			names = append(names, "quarantined:"+q.RelPath)
+		}
+		return names
+	}
+
+	tests := []struct {
+		dir  string
+		want []string
+	}{
+		{dir: "", want: []string{"a/db", "a/db.zip", "a/dir.zip", "b/TEAM.ZIP", "quarantined:a/empty", "quarantined:b/notes/deeper"}},
+		{dir: "a", want: []string{"a/db", "a/db.zip", "a/dir.zip", "quarantined:a/empty"}},
+		{dir: "b/notes", want: []string{"quarantined:b/notes/deeper"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			walked, err := Discover(fsys, Options{Dir: tt.dir})
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+			if got := relPaths(walked); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("walk discovered %v, want %v", got, tt.want)
+			}
+			finder := &findMapFS{MapFS: fsys}
+			found, err := Discover(finder, Options{Dir: tt.dir})
+			if err != nil {
+				t.Fatalf("Discover() with FindFS error = %v", err)
+			}
+			if finder.queries != 1 {
+				t.Errorf("FindDatabases called %d times, want 1", finder.queries)
+			}
+			if got, want := relPaths(found), relPaths(walked); !reflect.DeepEqual(got, want) {
+				t.Errorf("FindFS discovered %v, want %v as walked", got, want)
+			}
+		})
+	}
+}
+
+func TestDiscoverArtifact(t *testing.T) {
+	archive := zipBytes(t, map[string]string{"codeql-database.yml": "primaryLanguage: go\n", "db-go/default/x": "x"}, "")
+	yml := []byte("primaryLanguage: go\n")
+	fsys := fstest.MapFS{
+		"a/db/codeql-database.yml":     {Data: yml},
+		"a/db/db-go/default/x":         {Data: []byte("x")},
+		"a/db/nested/inner.zip":        {Data: archive},
+		"a/db.zip":                     {Data: archive},
+		"a/broken/codeql-database.yml": {Data: []byte("primaryLanguage: [\n")},
+		"a/notes/readme.txt":           {Data: []byte("not a database")},
+	}
+
+	tests := []struct {
+		name           string
+		wantDatabases  []string
+		wantQuarantine []string
+		wantErr        bool
+	}{
+		{name: "a/db", wantDatabases: []string{"a/db"}},
+		{name: "a/db.zip", wantDatabases: []string{"a/db.zip"}},
+		{name: "a/broken", wantQuarantine: []string{"a/broken"}},
+		{name: "a/notes"},
+		{name: "a/notes/readme.txt"},
+		{name: "a/missing.zip"},
+		{name: ".", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := DiscoverArtifact(fsys, tt.name, Options{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DiscoverArtifact() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var databases, quarantine []string
+			for _, db := range result.Databases {
+				databases = append(databases, db.RelPath)
+			}
+			for _, q := range result.Quarantined {
+				quarantine = append(quarantine, q.RelPath)
+			}
+			if !reflect.DeepEqual(databases, tt.wantDatabases) || !reflect.DeepEqual(quarantine, tt.wantQuarantine) {
+				t.Errorf("DiscoverArtifact() = %v, quarantined %v, want %v, %v", databases, quarantine, tt.wantDatabases, tt.wantQuarantine)
+			}
+		})
+	}
+}
+
+func TestDiscover_Dir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"team-a/one/codeql-database.yml":    {Data: []byte("primaryLanguage: go\n")},
+		"team-a/one/db-go/x":                {Data: []byte("x")},
+		"team-b/two/codeql-database.yml":    {Data: []byte("primaryLanguage: go\n")},
+		"team-b/two/db-go/x":                {Data: []byte("x")},
+		"team-ab/three/codeql-database.yml": {Data: []byte("primaryLanguage: go\n")},
+		"team-ab/three/db-go/x":             {Data: []byte("x")},
+	}
+
+	tests := []struct {
+		dir  string
+		want []string
+	}{
+		{dir: "", want: []string{"team-a/one", "team-ab/three", "team-b/two"}},
+		{dir: "team-a", want: []string{"team-a/one"}},
+		{dir: "team-a/", want: []string{"team-a/one"}},
+		{dir: "missing", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			result, err := Discover(fsys, Options{Dir: tt.dir})
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+			var got []string
+			for _, db := range result.Databases {
+				got = append(got, db.RelPath)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RelPaths = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestDiscoverDatabases_NonExistentDirectory(t *testing.T) {
 	_, err := DiscoverDatabases("/nonexistent/directory")
 	if err == nil {
@@ -617,6 +1061,36 @@ func createTestZip(t *testing.T, zipPath string, contents []string) {
 	}
 }
 
+func createTestZipWithEntries(t *testing.T, zipPath string, entries map[string]string) {
+	t.Helper()
+
+	file, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatalf("Failed to create zip file: %v", err)
+	}
+	defer file.Close()
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := zip.NewWriter(file)
+	for _, name := range names {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write([]byte(entries[name])); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+}
+
 func createTestZipWithYAML(t *testing.T, zipPath, yamlContent, langDir string) {
 	t.Helper()
 
@@ -0,0 +1,134 @@
+package codeql
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// StampFS is implemented by filesystems that cheaply report a stamp for a
+// database archive or directory which changes whenever its content does,
+// such as GCS object generations. Discovery keeps the databases found under
+// each stamp in Options.DiscoveryCache and reuses them while the stamp is
+// unchanged, without reading the archive or directory again.
+type StampFS interface {
+	fs.FS
+
+	// Stamp returns the stamp of the named archive or database directory,
+	// or false if it has none. A directory is stamped only after its files
+	// were listed with TreeFS.Tree.
+	Stamp(name string) (string, bool)
+}
+
+// DiscoveryCache remembers the databases discovered in each archive and
+// directory of a StampFS between discoveries. Databases that failed
+// validation are remembered too, so they are not read again either; other
+// failures are retried.
+//
+// A nil *DiscoveryCache caches nothing.
+type DiscoveryCache struct {
+	mu      sync.Mutex
+	entries map[string]discoveryEntry
+}
+
+// discoveryEntry is the outcome of discovering one archive or directory.
+type discoveryEntry struct {
+	stamp     string
+	databases []*DiscoveredDatabase
+	err       error
+}
+
+// NewDiscoveryCache returns an empty cache.
+func NewDiscoveryCache() *DiscoveryCache {
+	return &DiscoveryCache{entries: make(map[string]discoveryEntry)}
+}
+
+// Len returns the number of archives and directories in the cache.
+func (c *DiscoveryCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Clear empties the cache, so the next discovery reads every database.
+func (c *DiscoveryCache) Clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// reuse returns the databases discover finds at name, or those found by a
+// previous discovery if fsys stamps name as unchanged.
+func reuse(fsys fs.FS, name string, opts Options, discover func() ([]*DiscoveredDatabase, error)) ([]*DiscoveredDatabase, error) {
+	c := opts.DiscoveryCache
+	sfs, ok := fsys.(StampFS)
+	if c == nil || !ok {
+		return discover()
+	}
+	stamp, ok := sfs.Stamp(name)
+	if !ok {
+		return discover()
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	c.mu.Unlock()
+	if ok && e.stamp == stamp {
+		return cloneDatabases(e.databases), e.err
+	}
+
+	dbs, err := discover()
+	var invalidErr *InvalidDatabaseError
+	if err == nil || errors.As(err, &invalidErr) {
+		c.mu.Lock()
+		c.entries[name] = discoveryEntry{stamp: stamp, databases: cloneDatabases(dbs), err: err}
+		c.mu.Unlock()
+	}
+	return dbs, err
+}
+
+// prune forgets the archives and directories below dir, or everywhere if
+// dir is ".", that a discovery of dir did not visit.
+func (c *DiscoveryCache) prune(dir string, visited map[string]bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name := range c.entries {
+		if (dir == "." || strings.HasPrefix(name, dir+"/")) && !visited[name] {
+			delete(c.entries, name)
+		}
+	}
+}
+
+// forget removes the archive or directory name from the cache.
+func (c *DiscoveryCache) forget(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// cloneDatabases copies dbs, so callers may modify the databases they are
+// given without affecting the cache.
+func cloneDatabases(dbs []*DiscoveredDatabase) []*DiscoveredDatabase {
+	if dbs == nil {
+		return nil
+	}
+	clones := make([]*DiscoveredDatabase, len(dbs))
+	for i, db := range dbs {
+		clone := *db
+		clones[i] = &clone
+	}
+	return clones
+}
@@ -0,0 +1,96 @@
+package codeql
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// stampMapFS is a MapFS with stamps set by the test, counting how often it
+// opens the archive and metadata file of its databases.
+type stampMapFS struct {
+	fstest.MapFS
+	stamps map[string]string
+	opened int
+}
+
+func (s *stampMapFS) Open(name string) (fs.File, error) {
+	if name == "team/widgets.zip" || name == "team/gadgets/codeql-database.yml" {
+		s.opened++
+	}
+	return s.MapFS.Open(name)
+}
+
+func (s *stampMapFS) Stamp(name string) (string, bool) {
+	stamp, ok := s.stamps[name]
+	return stamp, ok
+}
+
+func TestDiscover_DiscoveryCache(t *testing.T) {
+	archive := zipBytes(t, map[string]string{"codeql-database.yml": "primaryLanguage: go\n", "db-go/default/x": "x"}, "")
+	fsys := &stampMapFS{
+		MapFS: fstest.MapFS{
+			"team/widgets.zip":                 {Data: archive},
+			"team/gadgets/codeql-database.yml": {Data: []byte("primaryLanguage: go\n")},
+			"team/gadgets/db-go/default/x":     {Data: []byte("x")},
+			"team/broken.zip":                  {Data: []byte("not a zip")},
+		},
+		stamps: map[string]string{"team/widgets.zip": "1", "team/gadgets": "1", "team/broken.zip": "1"},
+	}
+	cache := NewDiscoveryCache()
+	opts := Options{DiscoveryCache: cache}
+
+	discover := func() *Result {
+		t.Helper()
+		fsys.opened = 0
+		result, err := Discover(fsys, opts)
+		if err != nil {
+			t.Fatalf("Discover() error = %v", err)
+		}
+		if len(result.Databases) != 2 || len(result.Quarantined) != 1 {
+			t.Fatalf("Discover() found %d databases and quarantined %d, want 2 and 1", len(result.Databases), len(result.Quarantined))
+		}
+		return result
+	}
+
+	discover()
+	if fsys.opened == 0 || cache.Len() != 3 {
+		t.Fatalf("first discovery opened %d metadata files and cached %d entries, want some and 3", fsys.opened, cache.Len())
+	}
+
+	// Unchanged databases are not read again, and may be modified by the
+	// caller without affecting the cache
+	result := discover()
+	if fsys.opened != 0 {
+		t.Errorf("second discovery opened %d metadata files, want 0", fsys.opened)
+	}
+	result.Databases[0].Path = "modified"
+	if again := discover(); again.Databases[0].Path == "modified" {
+		t.Error("modifying a discovered database changed the cache")
+	}
+
+	// A new stamp rereads the database
+	fsys.stamps["team/gadgets"] = "2"
+	discover()
+	if fsys.opened != 1 {
+		t.Errorf("discovery after a change opened %d metadata files, want 1", fsys.opened)
+	}
+
+	// Removed databases are forgotten
+	delete(fsys.MapFS, "team/widgets.zip")
+	if _, err := Discover(fsys, opts); err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("cache holds %d entries after a removal, want 2", cache.Len())
+	}
+
+	// So are those removed since a discovery of the single artifact
+	delete(fsys.MapFS, "team/broken.zip")
+	if _, err := DiscoverArtifact(fsys, "team/broken.zip", opts); err != nil {
+		t.Fatalf("DiscoverArtifact() error = %v", err)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("cache holds %d entries after an artifact removal, want 1", cache.Len())
+	}
+}
@@ -0,0 +1,177 @@
+package codeql
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const (
+	// maxBaselineInfoSize bounds the baseline-info.json read per database;
+	// it lists every source file, so it can be large for big repositories.
+	maxBaselineInfoSize = 32 << 20
+
+	// maxDBSchemeSize bounds the .dbscheme read per language.
+	maxDBSchemeSize = 8 << 20
+)
+
+// baselineInfo is the structure of the baseline-info.json file written by
+// the CodeQL CLI into the database root.
+type baselineInfo struct {
+	Languages map[string]struct {
+		Files       []string `json:"files"`
+		LinesOfCode int64    `json:"linesOfCode"`
+	} `json:"languages"`
+}
+
+// languageFacts holds the per-language facts of a database.
+type languageFacts struct {
+	dbscheme     string
+	dbschemeHash string
+	linesOfCode  int64
+	fileCount    int
+}
+
+// databaseFacts holds facts read from the files of one database.
+type databaseFacts struct {
+	hasSourceArchive bool
+	languages        map[string]*languageFacts
+}
+
+// language returns the facts of lang, creating them on first use.
+func (f *databaseFacts) language(lang string) *languageFacts {
+	if f.languages == nil {
+		f.languages = make(map[string]*languageFacts)
+	}
+	lf, ok := f.languages[lang]
+	if !ok {
+		lf = &languageFacts{}
+		f.languages[lang] = lf
+	}
+	return lf
+}
+
+// addBaselineInfo records the line and file counts of a baseline-info.json.
+// Unparsable files are ignored.
+func (f *databaseFacts) addBaselineInfo(data []byte) {
+	var info baselineInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return
+	}
+	for lang, counts := range info.Languages {
+		lf := f.language(lang)
+		lf.linesOfCode = counts.LinesOfCode
+		lf.fileCount = len(counts.Files)
+	}
+}
+
+// addDBScheme records the database schema of lang.
+func (f *databaseFacts) addDBScheme(lang, name string, data []byte) {
+	h := sha256.Sum256(data)
+	lf := f.language(lang)
+	lf.dbscheme = name
+	lf.dbschemeHash = hex.EncodeToString(h[:])
+}
+
+// apply records the facts on each per-language entry of a database. The
+// baseline line count from codeql-database.yml is used for single-language
+// databases without a baseline-info.json.
+func (f *databaseFacts) apply(dbs []*DiscoveredDatabase, baselineLinesOfCode int64) {
+	for _, db := range dbs {
+		db.HasSourceArchive = f.hasSourceArchive
+		if lf, ok := f.languages[db.Language]; ok {
+			db.DBScheme = lf.dbscheme
+			db.DBSchemeHash = lf.dbschemeHash
+			db.LinesOfCode = lf.linesOfCode
+			db.FileCount = lf.fileCount
+		}
+		if db.LinesOfCode == 0 && len(dbs) == 1 {
+			db.LinesOfCode = baselineLinesOfCode
+		}
+	}
+}
+
+// archiveFacts reads the facts of the database rooted at root (either "" or
+// ending in "/") in an archive.
+func archiveFacts(files []*zip.File, root string) databaseFacts {
+	var facts databaseFacts
+	for _, f := range files {
+		rest, ok := strings.CutPrefix(f.Name, root)
+		if !ok {
+			continue
+		}
+		switch rest {
+		case "src.zip":
+			facts.hasSourceArchive = true
+		case "baseline-info.json":
+			if data, err := readZipEntry(f, maxBaselineInfoSize); err == nil {
+				facts.addBaselineInfo(data)
+			}
+		default:
+			if lang, name, ok := dbSchemePath(rest); ok {
+				if data, err := readZipEntry(f, maxDBSchemeSize); err == nil {
+					facts.addDBScheme(lang, name, data)
+				}
+			}
+		}
+	}
+	return facts
+}
+
+// directoryFacts reads the facts of an unarchived database. entries lists
+// the database directory.
+func directoryFacts(fsys fs.FS, dbPath string, entries []fs.DirEntry) databaseFacts {
+	facts := databaseFacts{hasSourceArchive: hasEntry(entries, "src.zip")}
+
+	if data, err := readFileLimit(fsys, path.Join(dbPath, "baseline-info.json"), maxBaselineInfoSize); err == nil {
+		facts.addBaselineInfo(data)
+	}
+
+	for _, entry := range entries {
+		lang, ok := strings.CutPrefix(entry.Name(), "db-")
+		if !entry.IsDir() || !ok || lang == "" {
+			continue
+		}
+		langEntries, err := fs.ReadDir(fsys, path.Join(dbPath, entry.Name()))
+		if err != nil {
+			continue
+		}
+		for _, e := range langEntries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".dbscheme") {
+				continue
+			}
+			if data, err := readFileLimit(fsys, path.Join(dbPath, entry.Name(), e.Name()), maxDBSchemeSize); err == nil {
+				facts.addDBScheme(lang, e.Name(), data)
+			}
+			break
+		}
+	}
+	return facts
+}
+
+// dbSchemePath reports whether rest, a path relative to a database root,
+// names the schema file "db-<lang>/<name>.dbscheme".
+func dbSchemePath(rest string) (lang, name string, ok bool) {
+	dir, name, found := strings.Cut(rest, "/")
+	if !found || strings.Contains(name, "/") || !strings.HasSuffix(name, ".dbscheme") {
+		return "", "", false
+	}
+	lang, ok = strings.CutPrefix(dir, "db-")
+	return lang, name, ok && lang != ""
+}
+
+// readFileLimit reads a file from fsys if it is at most limit bytes.
+func readFileLimit(fsys fs.FS, name string, limit int64) ([]byte, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > limit {
+		return nil, fs.ErrInvalid
+	}
+	return fs.ReadFile(fsys, name)
+}
@@ -0,0 +1,123 @@
+package codeql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+const factsBaselineInfo = `{"languages": {
+	"go": {"displayName": "Go", "files": ["a.go", "b.go"], "linesOfCode": 120, "name": "go"},
+	"python": {"displayName": "Python", "files": ["c.py"], "linesOfCode": 30, "name": "python"}
+}}`
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestDiscoverDatabases_ArchiveFacts(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestZipWithEntries(t, filepath.Join(tempDir, "octo-widgets.zip"), map[string]string{
+		"widgets/codeql-database.yml":                   "sourceLocationPrefix: /src/octo/widgets\nprimaryLanguage: go\nfinalised: true\n",
+		"widgets/baseline-info.json":                    factsBaselineInfo,
+		"widgets/src.zip":                               "",
+		"widgets/db-go/go.dbscheme":                     "go schema",
+		"widgets/db-go/default/x":                       "data",
+		"widgets/db-python/semmlecode.python.dbscheme":  "python schema",
+		"widgets/db-python/default/nested/not.dbscheme": "ignored",
+		"widgets/db-python/default/y":                   "data",
+	})
+
+	databases, err := DiscoverDatabases(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverDatabases() error = %v", err)
+	}
+	if len(databases) != 2 {
+		t.Fatalf("DiscoverDatabases() returned %d databases, want 2", len(databases))
+	}
+
+	goDB, pyDB := databases[0], databases[1]
+	if goDB.Finalised == nil || !*goDB.Finalised {
+		t.Errorf("Finalised = %v, want true", goDB.Finalised)
+	}
+	if !goDB.HasSourceArchive || !pyDB.HasSourceArchive {
+		t.Error("HasSourceArchive = false, want true")
+	}
+	if goDB.DBScheme != "go.dbscheme" || goDB.DBSchemeHash != sha256Hex("go schema") {
+		t.Errorf("go DBScheme, DBSchemeHash = %q, %q", goDB.DBScheme, goDB.DBSchemeHash)
+	}
+	if pyDB.DBScheme != "semmlecode.python.dbscheme" || pyDB.DBSchemeHash != sha256Hex("python schema") {
+		t.Errorf("python DBScheme, DBSchemeHash = %q, %q", pyDB.DBScheme, pyDB.DBSchemeHash)
+	}
+	if goDB.LinesOfCode != 120 || goDB.FileCount != 2 {
+		t.Errorf("go LinesOfCode, FileCount = %d, %d, want 120, 2", goDB.LinesOfCode, goDB.FileCount)
+	}
+	if pyDB.LinesOfCode != 30 || pyDB.FileCount != 1 {
+		t.Errorf("python LinesOfCode, FileCount = %d, %d, want 30, 1", pyDB.LinesOfCode, pyDB.FileCount)
+	}
+}
+
+func TestDiscoverDatabases_DirectoryFacts(t *testing.T) {
+	tempDir := t.TempDir()
+	dbDir := filepath.Join(tempDir, "octo-gadgets")
+	files := map[string]string{
+		"codeql-database.yml":         "sourceLocationPrefix: /src/octo/gadgets\nprimaryLanguage: java\nbaselineLinesOfCode: 77\nfinalised: true\n",
+		"db-java/semmlecode.dbscheme": "java schema",
+		"db-java/default/x":           "data",
+	}
+	for name, content := range files {
+		p := filepath.Join(dbDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	databases, err := DiscoverDatabases(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverDatabases() error = %v", err)
+	}
+	if len(databases) != 1 {
+		t.Fatalf("DiscoverDatabases() returned %d databases, want 1", len(databases))
+	}
+
+	db := databases[0]
+	if db.Finalised == nil || !*db.Finalised {
+		t.Errorf("Finalised = %v, want true", db.Finalised)
+	}
+	if db.HasSourceArchive {
+		t.Error("HasSourceArchive = true, want false")
+	}
+	if db.DBScheme != "semmlecode.dbscheme" || db.DBSchemeHash != sha256Hex("java schema") {
+		t.Errorf("DBScheme, DBSchemeHash = %q, %q", db.DBScheme, db.DBSchemeHash)
+	}
+	// Without baseline-info.json the yml line count is used
+	if db.LinesOfCode != 77 || db.FileCount != 0 {
+		t.Errorf("LinesOfCode, FileCount = %d, %d, want 77, 0", db.LinesOfCode, db.FileCount)
+	}
+}
+
+func TestDirectoryFacts_Unreadable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"db/baseline-info.json": {Data: []byte("not json")},
+		"db/db-go/go.dbscheme":  {Data: make([]byte, maxDBSchemeSize+1)},
+	}
+	entries, err := fsys.ReadDir("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	facts := directoryFacts(fsys, "db", entries)
+	if lf, ok := facts.languages["go"]; ok && lf.dbscheme != "" {
+		t.Errorf("oversized dbscheme was read: %+v", lf)
+	}
+	if len(facts.languages) != 0 {
+		t.Errorf("languages = %v, want none", facts.languages)
+	}
+}
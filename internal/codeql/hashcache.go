@@ -0,0 +1,207 @@
+package codeql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// HashCache remembers the content hashes of unarchived databases, keyed by
+// database path together with a stamp of the paths, sizes and modification
+// times of their files. A directory is read in full only when it is first
+// seen or its files change. A cache with a file survives restarts.
+//
+// A nil *HashCache caches nothing.
+type HashCache struct {
+	mu      sync.Mutex
+	file    string
+	entries map[string]hashCacheEntry
+	dirty   bool
+}
+
+// hashCacheEntry is the cached hash of one database directory.
+type hashCacheEntry struct {
+	Stamp string `json:"stamp"`
+	Hash  string `json:"hash"`
+}
+
+// NewHashCache returns a cache persisted to file, loading the entries it
+// already holds. A missing file starts an empty cache; an empty file name
+// keeps the cache in memory only.
+func NewHashCache(file string) (*HashCache, error) {
+	c := &HashCache{file: file, entries: make(map[string]hashCacheEntry)}
+	if file == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(file) //nolint:gosec // Cache file chosen by the operator
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("failed to parse hash cache %s: %w", file, err)
+	}
+	return c, nil
+}
+
+// Save writes the cache to its file if it changed since it was loaded or
+// last saved. The file is replaced atomically.
+func (c *HashCache) Save() error {
+	if c == nil || c.file == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode hash cache: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), "."+filepath.Base(c.file)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name()) //nolint:errcheck // Already renamed on success
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close() //nolint:errcheck // The write error takes precedence
+		return fmt.Errorf("failed to write hash cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write hash cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.file); err != nil {
+		return fmt.Errorf("failed to move hash cache into place: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// lookup returns the cached hash of the database at dbPath if its stamp is
+// unchanged.
+func (c *HashCache) lookup(dbPath, stamp string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[dbPath]
+	if !ok || e.Stamp != stamp {
+		return "", false
+	}
+	return e.Hash, true
+}
+
+// store records the hash of the database at dbPath.
+func (c *HashCache) store(dbPath, stamp, hash string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[dbPath] = hashCacheEntry{Stamp: stamp, Hash: hash}
+	c.dirty = true
+}
+
+// Prune drops the entries of the databases for whose paths keep returns
+// false, so that databases removed from storage do not stay in the cache.
+func (c *HashCache) Prune(keep func(dbPath string) bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for dbPath := range c.entries {
+		if !keep(dbPath) {
+			delete(c.entries, dbPath)
+			c.dirty = true
+		}
+	}
+}
+
+// listDirectory lists the files below the database directory dbPath, sorted
+// by path.
+func listDirectory(fsys fs.FS, dbPath string) ([]TreeFile, error) {
+	var files []TreeFile
+	var err error
+	if tfs, ok := fsys.(TreeFS); ok {
+		files, err = tfs.Tree(dbPath)
+	} else {
+		files, err = walkDirectory(fsys, dbPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// walkDirectory lists the files below dbPath by reading each directory.
+func walkDirectory(fsys fs.FS, dbPath string) ([]TreeFile, error) {
+	var files []TreeFile
+	err := fs.WalkDir(fsys, dbPath, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel := name
+		if dbPath != "." {
+			rel = name[len(dbPath)+1:]
+		}
+		files = append(files, TreeFile{Path: rel, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// directoryHash returns the Merkle-style content hash of the database
+// directory dbPath: the SHA-256 over the path and content hash of each of
+// its files, in lexical order. The sidecar file is left out, as it
+// describes the database rather than being part of it. The hash is taken
+// from cache while the files are unchanged.
+func directoryHash(fsys fs.FS, dbPath string, files []TreeFile, cache *HashCache) (string, error) {
+	stamp := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(stamp, "%s\x00%d\x00%d\n", f.Path, f.Size, f.ModTime.UnixNano())
+	}
+	stampSum := hex.EncodeToString(stamp.Sum(nil))
+	if sum, ok := cache.lookup(dbPath, stampSum); ok {
+		return sum, nil
+	}
+
+	h := sha256.New()
+	for _, f := range files {
+		if f.Path == SidecarFileName {
+			continue
+		}
+		sum, kind, err := fileHash(fsys, path.Join(dbPath, f.Path))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%s:%s\n", f.Path, kind, sum)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	cache.store(dbPath, stampSum, sum)
+	return sum, nil
+}
@@ -0,0 +1,107 @@
+package codeql
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+)
+
+// unarchived returns the files of an unarchived go database under dir.
+func unarchived(dir, data string) fstest.MapFS {
+	return fstest.MapFS{
+		dir + "/codeql-database.yml":   {Data: []byte("sourceLocationPrefix: /src/octo/widgets\nprimaryLanguage: go\n")},
+		dir + "/db-go/default/strings": {Data: []byte(data)},
+	}
+}
+
+// discoverOne discovers the single database in fsys.
+func discoverOne(t *testing.T, fsys fstest.MapFS, cache *HashCache) *DiscoveredDatabase {
+	t.Helper()
+	result, err := Discover(fsys, Options{HashCache: cache})
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if len(result.Databases) != 1 {
+		t.Fatalf("Discover() returned %d databases, want 1", len(result.Databases))
+	}
+	return result.Databases[0]
+}
+
+func TestDiscover_DirectoryHash(t *testing.T) {
+	base := discoverOne(t, unarchived("team/widgets", "abc"), nil)
+	if base.HashKind != api.HashKindMerkle {
+		t.Errorf("HashKind = %q, want %q", base.HashKind, api.HashKindMerkle)
+	}
+	if len(base.ContentHash) != 64 {
+		t.Errorf("ContentHash = %q, want a SHA-256", base.ContentHash)
+	}
+
+	withSidecar := unarchived("team/widgets", "abc")
+	withSidecar["team/widgets/"+SidecarFileName] = &fstest.MapFile{Data: []byte("team: platform\n")}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		same bool
+	}{
+		{name: "moved", fsys: unarchived("archive/widgets", "abc"), same: true},
+		{name: "sidecar added", fsys: withSidecar, same: true},
+		{name: "content modified", fsys: unarchived("team/widgets", "abd"), same: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := discoverOne(t, tt.fsys, nil)
+			if same := db.ContentHash == base.ContentHash; same != tt.same {
+				t.Errorf("ContentHash = %q, same as original = %v, want %v", db.ContentHash, same, tt.same)
+			}
+		})
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hashes.json")
+	cache, err := NewHashCache(file)
+	if err != nil {
+		t.Fatalf("NewHashCache() error = %v", err)
+	}
+
+	fsys := unarchived("team/widgets", "abc")
+	want := discoverOne(t, fsys, cache).ContentHash
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// A reloaded cache answers for unchanged files without reading them,
+	// so a wrong cached hash shows that the cache was used
+	reloaded, err := NewHashCache(file)
+	if err != nil {
+		t.Fatalf("NewHashCache() error = %v", err)
+	}
+	for dbPath, e := range reloaded.entries {
+		reloaded.entries[dbPath] = hashCacheEntry{Stamp: e.Stamp, Hash: "cached"}
+	}
+	if got := discoverOne(t, fsys, reloaded).ContentHash; got != "cached" {
+		t.Errorf("ContentHash = %q, want the cached hash", got)
+	}
+
+	// Changed files are rehashed
+	fsys["team/widgets/db-go/default/strings"].ModTime = time.Unix(1, 0)
+	if got := discoverOne(t, fsys, reloaded).ContentHash; got != want {
+		t.Errorf("ContentHash after change = %q, want %q", got, want)
+	}
+}
+
+func TestNewHashCache_Invalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hashes.json")
+	if err := os.WriteFile(file, []byte("not json"), 0o600); err != nil {
+		t.Fatalf("failed to write cache: %v", err)
+	}
+	if _, err := NewHashCache(file); err == nil {
+		t.Error("NewHashCache() error = nil, want parse error")
+	}
+}
@@ -0,0 +1,389 @@
+package codeql
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Identity sources, in order of precedence.
+const (
+	// IdentitySourceSidecar means the identity came from a hepc.yml sidecar file.
+	IdentitySourceSidecar = "sidecar"
+	// IdentitySourceGitRemote means the identity came from a git remote recorded
+	// in the database's source archive.
+	IdentitySourceGitRemote = "git-remote"
+	// IdentitySourceRule means the identity came from a configured IdentityRule.
+	IdentitySourceRule = "rule"
+	// IdentitySourceHeuristic means the identity was guessed from the last two
+	// segments of sourceLocationPrefix or from the artifact file name.
+	IdentitySourceHeuristic = "path-heuristic"
+)
+
+// SidecarFileName is the name of the sidecar file inside a database directory.
+// Archived databases use "<name>.hepc.yml" beside the archive instead.
+const SidecarFileName = "hepc.yml"
+
+// maxSourceArchiveSize bounds how much of a compressed src.zip is read into
+// memory when it cannot be accessed in place.
+const maxSourceArchiveSize = 64 << 20
+
+// Identity describes the repository a database was built from.
+type Identity struct {
+	Owner  string
+	Repo   string
+	Branch string
+	Commit string
+
+	// Source records which evidence provided Owner and Repo.
+	Source string
+}
+
+// Sidecar is the content of an optional hepc.yml file describing a database.
+// It supplies information the database itself does not contain and takes
+// precedence over anything derived from the database.
+type Sidecar struct {
+	// Repository is "owner/repo" or a repository URL such as
+	// "https://github.com/owner/repo.git".
+	Repository string `yaml:"repository,omitempty"`
+	Owner      string `yaml:"owner,omitempty"`
+	Repo       string `yaml:"repo,omitempty"`
+	Branch     string `yaml:"branch,omitempty"`
+	Commit     string `yaml:"commit,omitempty"`
+
+	// Visibility is the repository visibility, e.g. "public" or "private".
+	Visibility string `yaml:"visibility,omitempty"`
+
+	// Team is the team owning the repository.
+	Team string `yaml:"team,omitempty"`
+
+	// Tags are free-form labels such as "critical" or "archived".
+	Tags []string `yaml:"tags,omitempty"`
+}
+
+// ParseSidecar parses the content of a hepc.yml sidecar file.
+func ParseSidecar(data []byte) (*Sidecar, error) {
+	var sc Sidecar
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("failed to parse sidecar: %w", err)
+	}
+	return &sc, nil
+}
+
+// ownerRepo returns the repository named by the sidecar, if any.
+func (sc *Sidecar) ownerRepo() (owner, repo string, ok bool) {
+	if sc.Owner != "" && sc.Repo != "" {
+		return sc.Owner, sc.Repo, true
+	}
+	if sc.Repository != "" {
+		return parseRepositoryURL(sc.Repository)
+	}
+	return "", "", false
+}
+
+// IdentityRule maps a database's source path or file name to a repository
+// identity using the named capture groups "owner", "repo" and optionally
+// "branch".
+type IdentityRule struct {
+	// Field selects the input matched by Pattern: "path" for the
+	// sourceLocationPrefix, or "filename" for the database artifact name.
+	Field string
+
+	// Pattern is the regular expression to match.
+	Pattern *regexp.Regexp
+}
+
+// ParseIdentityRule parses a rule of the form "<field>:<regexp>", for example
+// `filename:^(?P<owner>[^_]+)_(?P<repo>[^_]+)_`.
+func ParseIdentityRule(spec string) (IdentityRule, error) {
+	field, expr, ok := strings.Cut(spec, ":")
+	if !ok || (field != "path" && field != "filename") {
+		return IdentityRule{}, fmt.Errorf("invalid identity rule %q (expected path:<regexp> or filename:<regexp>)", spec)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return IdentityRule{}, fmt.Errorf("invalid identity rule %q: %w", spec, err)
+	}
+	if re.SubexpIndex("owner") < 0 || re.SubexpIndex("repo") < 0 {
+		return IdentityRule{}, fmt.Errorf("identity rule %q must define (?P<owner>...) and (?P<repo>...) groups", spec)
+	}
+	return IdentityRule{Field: field, Pattern: re}, nil
+}
+
+// apply matches the rule against a database and returns the identity it yields.
+func (r IdentityRule) apply(sourceLocationPrefix, artifactName string) (Identity, bool) {
+	input := sourceLocationPrefix
+	if r.Field == "filename" {
+		input = artifactName
+	}
+	m := r.Pattern.FindStringSubmatch(input)
+	if m == nil {
+		return Identity{}, false
+	}
+
+	group := func(name string) string {
+		if i := r.Pattern.SubexpIndex(name); i >= 0 {
+			return m[i]
+		}
+		return ""
+	}
+	id := Identity{Owner: group("owner"), Repo: group("repo"), Branch: group("branch"), Source: IdentitySourceRule}
+	return id, id.Owner != "" && id.Repo != ""
+}
+
+// Options configures database discovery.
+type Options struct {
+	// IdentityRules are tried in order when neither a sidecar file nor a git
+	// remote identifies the repository.
+	IdentityRules []IdentityRule
+
+	// Dir restricts discovery to the databases under this slash-separated
+	// directory of the root. A missing directory holds no databases.
+	Dir string
+
+	// Validation selects the checks a database must pass to be discovered.
+	Validation Validation
+
+	// HashCache remembers the content hashes of unarchived databases
+	// between discoveries. If nil, every discovery reads them in full.
+	HashCache *HashCache
+
+	// DiscoveryCache remembers the databases found on a StampFS between
+	// discoveries. If nil, every discovery reads every database.
+	DiscoveryCache *DiscoveryCache
+}
+
+// IdentityEvidence collects everything known about a database's origin.
+type IdentityEvidence struct {
+	// Sidecar is the parsed hepc.yml for the database, if present.
+	Sidecar *Sidecar
+
+	// Git is the identity recovered from the source archive, if any.
+	Git *Identity
+
+	// SourceLocationPrefix is the sourceLocationPrefix from the database metadata.
+	SourceLocationPrefix string
+
+	// ArtifactName is the base name of the database archive or directory.
+	ArtifactName string
+}
+
+// ResolveIdentity determines owner, repo, branch and commit from the strongest
+// available evidence: a sidecar file, then a git remote recorded in the
+// database, then the first matching rule, and finally the path heuristic.
+// Branch and commit are taken from the first source that supplies them.
+func ResolveIdentity(ev IdentityEvidence, rules []IdentityRule) Identity {
+	var candidates []Identity
+
+	if ev.Sidecar != nil {
+		id := Identity{Branch: ev.Sidecar.Branch, Commit: ev.Sidecar.Commit, Source: IdentitySourceSidecar}
+		id.Owner, id.Repo, _ = ev.Sidecar.ownerRepo()
+		candidates = append(candidates, id)
+	}
+	if ev.Git != nil {
+		candidates = append(candidates, *ev.Git)
+	}
+	for _, rule := range rules {
+		if id, ok := rule.apply(ev.SourceLocationPrefix, ev.ArtifactName); ok {
+			candidates = append(candidates, id)
+			break
+		}
+	}
+
+	var result Identity
+	for _, c := range candidates {
+		if result.Source == "" && c.Owner != "" && c.Repo != "" {
+			result.Owner, result.Repo, result.Source = c.Owner, c.Repo, c.Source
+		}
+		if result.Branch == "" {
+			result.Branch = c.Branch
+		}
+		if result.Commit == "" {
+			result.Commit = c.Commit
+		}
+	}
+
+	if result.Source == "" {
+		result.Owner, result.Repo = heuristicOwnerRepo(ev.SourceLocationPrefix, ev.ArtifactName)
+		result.Source = IdentitySourceHeuristic
+	}
+	return result
+}
+
+// heuristicOwnerRepo guesses owner and repo from the last two segments of the
+// source path, falling back to the artifact name for uninformative paths.
+func heuristicOwnerRepo(sourceLocationPrefix, artifactName string) (owner, repo string) {
+	owner, repo = extractOwnerRepo(sourceLocationPrefix)
+
+	// Paths such as /opt/src carry no repository information
+	if owner == "opt" || owner == "src" || owner == "unknown" {
+		filenameOwner, filenameRepo := extractOwnerRepoFromFilename(artifactName)
+		if filenameOwner != "unknown" {
+			owner = filenameOwner
+		}
+		if filenameRepo != "unknown" {
+			repo = filenameRepo
+		}
+	}
+	return owner, repo
+}
+
+// GitIdentityFromSourceArchive looks for a .git directory recorded in a
+// database source archive (src.zip) and returns the repository named by its
+// "origin" remote (or first remote) together with the checked-out branch.
+// The repository at sourceLocationPrefix is preferred over nested ones.
+func GitIdentityFromSourceArchive(r *zip.Reader, sourceLocationPrefix string) (*Identity, bool) {
+	root := strings.Trim(sourceLocationPrefix, "/")
+
+	var config, head *zip.File
+	best := -1
+	for _, f := range r.File {
+		dir, ok := strings.CutSuffix(f.Name, ".git/config")
+		if !ok {
+			continue
+		}
+		dir = strings.Trim(dir, "/")
+		// Rank: exact match on the source root, then the shallowest path
+		rank := strings.Count(dir, "/") + 1
+		if dir == root {
+			rank = 0
+		}
+		if best < 0 || rank < best {
+			best = rank
+			config = f
+		}
+	}
+	if config == nil {
+		return nil, false
+	}
+
+	headName := strings.TrimSuffix(config.Name, "config") + "HEAD"
+	for _, f := range r.File {
+		if f.Name == headName {
+			head = f
+			break
+		}
+	}
+
+	data, err := readZipEntry(config, 1<<20)
+	if err != nil {
+		return nil, false
+	}
+	remote := parseGitRemote(data)
+	if remote == "" {
+		return nil, false
+	}
+	owner, repo, ok := parseRepositoryURL(remote)
+	if !ok {
+		return nil, false
+	}
+
+	id := &Identity{Owner: owner, Repo: repo, Source: IdentitySourceGitRemote}
+	if head != nil {
+		if data, err := readZipEntry(head, 4096); err == nil {
+			if ref, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "ref: refs/heads/"); ok {
+				id.Branch = ref
+			}
+		}
+	}
+	return id, true
+}
+
+// GitIdentityFromDatabaseArchive opens the src.zip of the database rooted at
+// root inside an archived database and extracts its git identity.
+func GitIdentityFromDatabaseArchive(files []*zip.File, archive io.ReaderAt, root, sourceLocationPrefix string) (*Identity, bool) {
+	for _, f := range files {
+		if f.Name != root+"src.zip" {
+			continue
+		}
+
+		var ra io.ReaderAt
+		size := int64(f.UncompressedSize64) //nolint:gosec // Sizes beyond int64 are rejected by zip.NewReader
+		if f.Method == zip.Store && archive != nil {
+			// Stored entries can be read in place without loading them
+			offset, err := f.DataOffset()
+			if err != nil {
+				return nil, false
+			}
+			ra = io.NewSectionReader(archive, offset, size)
+		} else {
+			data, err := readZipEntry(f, maxSourceArchiveSize)
+			if err != nil {
+				return nil, false
+			}
+			ra = bytes.NewReader(data)
+			size = int64(len(data))
+		}
+
+		src, err := openZip(ra, size)
+		if err != nil {
+			return nil, false
+		}
+		return GitIdentityFromSourceArchive(src, sourceLocationPrefix)
+	}
+	return nil, false
+}
+
+// parseGitRemote returns the URL of the "origin" remote in a git config file,
+// or of the first remote if there is no origin.
+func parseGitRemote(config []byte) string {
+	var section, first, origin string
+
+	scanner := bufio.NewScanner(bytes.NewReader(config))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "url" || !strings.HasPrefix(section, "remote ") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if first == "" {
+			first = value
+		}
+		if section == `remote "origin"` && origin == "" {
+			origin = value
+		}
+	}
+
+	if origin != "" {
+		return origin
+	}
+	return first
+}
+
+// scpLikeURL matches git's scp-like syntax, e.g. "git@github.com:owner/repo.git".
+var scpLikeURL = regexp.MustCompile(`^[\w.-]+@[\w.-]+:(.+)$`)
+
+// parseRepositoryURL extracts owner and repo from "owner/repo", an HTTP(S) or
+// SSH repository URL, or an scp-like git address.
+func parseRepositoryURL(raw string) (owner, repo string, ok bool) {
+	p := raw
+	if m := scpLikeURL.FindStringSubmatch(raw); m != nil {
+		p = m[1]
+	} else if u, err := url.Parse(raw); err == nil && u.Scheme != "" {
+		p = u.Path
+	}
+
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	if p == "" || p == "." {
+		return "", "", false
+	}
+	repo = path.Base(p)
+	owner = path.Base(path.Dir(p))
+	if owner == "." || owner == "/" || owner == "" {
+		return "", "", false
+	}
+	return owner, repo, true
+}
@@ -0,0 +1,388 @@
+package codeql
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRepositoryURL(t *testing.T) {
+	tests := []struct {
+		input     string
+		wantOwner string
+		wantRepo  string
+		wantOK    bool
+	}{
+		{"https://github.com/octo-org/hello-world.git", "octo-org", "hello-world", true},
+		{"https://github.com/octo-org/hello-world", "octo-org", "hello-world", true},
+		{"git@github.com:octo-org/hello-world.git", "octo-org", "hello-world", true},
+		{"ssh://git@github.example.com:2222/octo-org/hello-world.git", "octo-org", "hello-world", true},
+		{"https://gitlab.example.com/group/subgroup/project.git", "subgroup", "project", true},
+		{"octo-org/hello-world", "octo-org", "hello-world", true},
+		{"hello-world", "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			owner, repo, ok := parseRepositoryURL(tt.input)
+			if ok != tt.wantOK || owner != tt.wantOwner || repo != tt.wantRepo {
+				t.Errorf("parseRepositoryURL(%q) = (%q, %q, %v), want (%q, %q, %v)",
+					tt.input, owner, repo, ok, tt.wantOwner, tt.wantRepo, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseGitRemote(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name: "origin preferred",
+			config: `[core]
+	bare = false
+[remote "upstream"]
+	url = https://github.com/upstream/repo.git
+[remote "origin"]
+	url = git@github.com:fork/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+`,
+			want: "git@github.com:fork/repo.git",
+		},
+		{
+			name: "first remote without origin",
+			config: `[remote "upstream"]
+	url = https://github.com/upstream/repo.git
+`,
+			want: "https://github.com/upstream/repo.git",
+		},
+		{
+			name:   "no remote",
+			config: "[core]\n\tbare = false\n",
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseGitRemote([]byte(tt.config)); got != tt.want {
+				t.Errorf("parseGitRemote() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIdentityRule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{`filename:^(?P<owner>[^_]+)_(?P<repo>[^_]+)_`, false},
+		{`path:/work/(?P<repo>[^/]+)/(?P<owner>[^/]+)$`, false},
+		{`path:(?P<repo>.*)`, true},
+		{`name:(?P<owner>.*)/(?P<repo>.*)`, true},
+		{`path:(?P<owner>[`, true},
+		{`no-field`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := ParseIdentityRule(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseIdentityRule(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveIdentity(t *testing.T) {
+	pathRule, err := ParseIdentityRule(`path:^/srv/(?P<owner>[^/]+)/(?P<repo>[^/]+)$`)
+	if err != nil {
+		t.Fatalf("ParseIdentityRule() error = %v", err)
+	}
+	filenameRule, err := ParseIdentityRule(`filename:^(?P<owner>[a-z]+)--(?P<repo>[a-z]+)(--(?P<branch>[a-z]+))?\.zip$`)
+	if err != nil {
+		t.Fatalf("ParseIdentityRule() error = %v", err)
+	}
+	rules := []IdentityRule{pathRule, filenameRule}
+
+	tests := []struct {
+		name string
+		ev   IdentityEvidence
+		want Identity
+	}{
+		{
+			name: "sidecar wins",
+			ev: IdentityEvidence{
+				Sidecar:              &Sidecar{Repository: "https://github.com/side/car.git", Branch: "main"},
+				Git:                  &Identity{Owner: "git", Repo: "remote", Branch: "dev", Commit: "abc", Source: IdentitySourceGitRemote},
+				SourceLocationPrefix: "/home/runner/work/repo/repo",
+			},
+			want: Identity{Owner: "side", Repo: "car", Branch: "main", Commit: "abc", Source: IdentitySourceSidecar},
+		},
+		{
+			name: "sidecar without repository falls through",
+			ev: IdentityEvidence{
+				Sidecar:              &Sidecar{Branch: "release"},
+				Git:                  &Identity{Owner: "git", Repo: "remote", Branch: "dev", Source: IdentitySourceGitRemote},
+				SourceLocationPrefix: "/home/runner/work/repo/repo",
+			},
+			want: Identity{Owner: "git", Repo: "remote", Branch: "release", Source: IdentitySourceGitRemote},
+		},
+		{
+			name: "rule before heuristic",
+			ev: IdentityEvidence{
+				SourceLocationPrefix: "/opt/src",
+				ArtifactName:         "octo--widgets--stable.zip",
+			},
+			want: Identity{Owner: "octo", Repo: "widgets", Branch: "stable", Source: IdentitySourceRule},
+		},
+		{
+			name: "heuristic last",
+			ev: IdentityEvidence{
+				SourceLocationPrefix: "/Users/test/src/owner/repo",
+				ArtifactName:         "repo.zip",
+			},
+			want: Identity{Owner: "owner", Repo: "repo", Source: IdentitySourceHeuristic},
+		},
+		{
+			name: "heuristic uses filename for uninformative paths",
+			ev: IdentityEvidence{
+				SourceLocationPrefix: "/opt/src",
+				ArtifactName:         "owner_repo_go-abc.zip",
+			},
+			want: Identity{Owner: "owner", Repo: "repo", Source: IdentitySourceHeuristic},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolveIdentity(tt.ev, rules); got != tt.want {
+				t.Errorf("ResolveIdentity() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitIdentityFromSourceArchive(t *testing.T) {
+	data := createSourceArchive(t, map[string]string{
+		"home/runner/work/widgets/widgets/main.go":                  "package main",
+		"home/runner/work/widgets/widgets/.git/config":              "[remote \"origin\"]\n\turl = https://github.com/octo/widgets.git\n",
+		"home/runner/work/widgets/widgets/.git/HEAD":                "ref: refs/heads/feature/x\n",
+		"home/runner/work/widgets/widgets/vendor/dep/.git/config":   "[remote \"origin\"]\n\turl = https://github.com/other/dep.git\n",
+		"home/runner/work/widgets/widgets/third_party/x/.git/HEAD":  "0123456789abcdef\n",
+		"home/runner/work/widgets/widgets/third_party/x/.git/index": "",
+	})
+
+	src, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+
+	id, ok := GitIdentityFromSourceArchive(src, "/home/runner/work/widgets/widgets")
+	if !ok {
+		t.Fatal("GitIdentityFromSourceArchive() found no identity")
+	}
+	want := Identity{Owner: "octo", Repo: "widgets", Branch: "feature/x", Source: IdentitySourceGitRemote}
+	if *id != want {
+		t.Errorf("GitIdentityFromSourceArchive() = %+v, want %+v", *id, want)
+	}
+}
+
+func TestDiscoverDatabases_SidecarIdentity(t *testing.T) {
+	tempDir := t.TempDir()
+
+	yamlContent := "sourceLocationPrefix: /home/runner/work/repo/repo\nprimaryLanguage: go\n"
+	createTestZipWithYAML(t, filepath.Join(tempDir, "repo-go.zip"), yamlContent, "db-go")
+	sidecar := "repository: https://github.com/octo/widgets\nbranch: main\ncommit: 0123abc\n"
+	if err := os.WriteFile(filepath.Join(tempDir, "repo-go.hepc.yml"), []byte(sidecar), 0o644); err != nil {
+		t.Fatalf("Failed to write sidecar: %v", err)
+	}
+
+	dbDir := filepath.Join(tempDir, "dir-db")
+	if err := os.MkdirAll(filepath.Join(dbDir, "db-python"), 0o755); err != nil {
+		t.Fatalf("Failed to create db directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dbDir, "codeql-database.yml"), []byte("sourceLocationPrefix: /opt/src\nprimaryLanguage: python\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write codeql-database.yml: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dbDir, SidecarFileName), []byte("owner: octo\nrepo: gadgets\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write sidecar: %v", err)
+	}
+
+	databases, err := DiscoverDatabases(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverDatabases() error = %v", err)
+	}
+	if len(databases) != 2 {
+		t.Fatalf("DiscoverDatabases() returned %d databases, want 2", len(databases))
+	}
+
+	got := make(map[string]Identity)
+	for _, db := range databases {
+		got[db.Name] = Identity{Owner: db.Owner, Repo: db.Repo, Branch: db.Branch, Commit: db.Commit, Source: db.IdentitySource}
+	}
+	if want := (Identity{Owner: "octo", Repo: "widgets", Branch: "main", Commit: "0123abc", Source: IdentitySourceSidecar}); got["repo-go.zip"] != want {
+		t.Errorf("archive identity = %+v, want %+v", got["repo-go.zip"], want)
+	}
+	if want := (Identity{Owner: "octo", Repo: "gadgets", Source: IdentitySourceSidecar}); got["dir-db"] != want {
+		t.Errorf("directory identity = %+v, want %+v", got["dir-db"], want)
+	}
+}
+
+func TestDiscoverDatabases_SidecarFields(t *testing.T) {
+	tempDir := t.TempDir()
+
+	createTestZipWithYAML(t, filepath.Join(tempDir, "widgets.zip"), "sourceLocationPrefix: /src/octo/widgets\nprimaryLanguage: go\n", "db-go")
+	sidecar := `visibility: private
+team: platform
+tags:
+  - critical
+  - archived
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "widgets.hepc.yml"), []byte(sidecar), 0o644); err != nil {
+		t.Fatalf("Failed to write sidecar: %v", err)
+	}
+
+	databases, err := DiscoverDatabases(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverDatabases() error = %v", err)
+	}
+	if len(databases) != 1 {
+		t.Fatalf("DiscoverDatabases() returned %d databases, want 1", len(databases))
+	}
+
+	db := databases[0]
+	if db.Visibility != "private" || db.Team != "platform" {
+		t.Errorf("Visibility, Team = %q, %q, want %q, %q", db.Visibility, db.Team, "private", "platform")
+	}
+	if len(db.Tags) != 2 || db.Tags[0] != "critical" || db.Tags[1] != "archived" {
+		t.Errorf("Tags = %v, want [critical archived]", db.Tags)
+	}
+	// Without a repository in the sidecar the identity falls through
+	if db.Owner != "octo" || db.IdentitySource != IdentitySourceHeuristic {
+		t.Errorf("identity = %s (%s), want octo (%s)", db.Owner, db.IdentitySource, IdentitySourceHeuristic)
+	}
+}
+
+func TestDiscoverDatabases_GitRemoteIdentity(t *testing.T) {
+	tempDir := t.TempDir()
+
+	src := createSourceArchive(t, map[string]string{
+		"home/runner/work/repo/repo/.git/config": "[remote \"origin\"]\n\turl = git@github.com:octo/widgets.git\n",
+		"home/runner/work/repo/repo/.git/HEAD":   "ref: refs/heads/main\n",
+	})
+	yamlContent := "sourceLocationPrefix: /home/runner/work/repo/repo\nprimaryLanguage: go\n"
+
+	// Archived database with src.zip stored uncompressed
+	zipPath := filepath.Join(tempDir, "stored.zip")
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range map[string][]byte{
+		"go-db/codeql-database.yml": []byte(yamlContent),
+		"go-db/db-go/default/x":     nil,
+		"go-db/src.zip":             src,
+	} {
+		method := zip.Deflate
+		if name == "go-db/src.zip" {
+			method = zip.Store
+		}
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write(content); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+	if err := os.WriteFile(zipPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+
+	// Archived database with src.zip compressed
+	createTestZipWithEntries(t, filepath.Join(tempDir, "deflated.zip"), map[string]string{
+		"codeql-database.yml": yamlContent,
+		"db-go/default/x":     "",
+		"src.zip":             string(src),
+	})
+
+	// Unarchived database
+	dbDir := filepath.Join(tempDir, "dir-db")
+	if err := os.MkdirAll(filepath.Join(dbDir, "db-go"), 0o755); err != nil {
+		t.Fatalf("Failed to create db directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dbDir, "codeql-database.yml"), []byte(yamlContent), 0o644); err != nil {
+		t.Fatalf("Failed to write codeql-database.yml: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dbDir, "src.zip"), src, 0o644); err != nil {
+		t.Fatalf("Failed to write src.zip: %v", err)
+	}
+
+	databases, err := DiscoverDatabases(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverDatabases() error = %v", err)
+	}
+	if len(databases) != 3 {
+		t.Fatalf("DiscoverDatabases() returned %d databases, want 3", len(databases))
+	}
+
+	want := Identity{Owner: "octo", Repo: "widgets", Branch: "main", Source: IdentitySourceGitRemote}
+	for _, db := range databases {
+		got := Identity{Owner: db.Owner, Repo: db.Repo, Branch: db.Branch, Commit: db.Commit, Source: db.IdentitySource}
+		if got != want {
+			t.Errorf("%s identity = %+v, want %+v", db.Name, got, want)
+		}
+	}
+}
+
+func TestDiscover_IdentityRules(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestZipWithYAML(t, filepath.Join(tempDir, "octo__widgets.zip"), "sourceLocationPrefix: /opt/src\nprimaryLanguage: go\n", "db-go")
+
+	rule, err := ParseIdentityRule(`filename:^(?P<owner>[^_]+)__(?P<repo>[^.]+)\.zip$`)
+	if err != nil {
+		t.Fatalf("ParseIdentityRule() error = %v", err)
+	}
+
+	result, err := Discover(os.DirFS(tempDir), Options{IdentityRules: []IdentityRule{rule}})
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if len(result.Databases) != 1 {
+		t.Fatalf("Discover() returned %d databases, want 1", len(result.Databases))
+	}
+
+	db := result.Databases[0]
+	if db.Owner != "octo" || db.Repo != "widgets" || db.IdentitySource != IdentitySourceRule {
+		t.Errorf("identity = %s/%s (%s), want octo/widgets (%s)", db.Owner, db.Repo, db.IdentitySource, IdentitySourceRule)
+	}
+}
+
+// createSourceArchive builds an in-memory src.zip with the given entries.
+func createSourceArchive(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range entries {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
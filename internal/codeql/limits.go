@@ -0,0 +1,204 @@
+package codeql
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Limits on the archives discovery reads from shared storage, so that a
+// hostile upload cannot exhaust memory or CPU. Archives exceeding them are
+// quarantined.
+const (
+	// maxArchiveEntries bounds the entries of an archive, and of the
+	// src.zip inside it. It is checked before the zip directory is read.
+	maxArchiveEntries = 500_000
+
+	// maxEntryDepth bounds the number of components of an entry name.
+	maxEntryDepth = 64
+
+	// maxMetadataSize bounds the uncompressed size of codeql-database.yml
+	// and .dbinfo entries.
+	maxMetadataSize = 1 << 20
+
+	// maxCompressionRatio bounds the ratio of uncompressed to compressed
+	// size of the entries discovery decompresses, once they are larger
+	// than compressionRatioMinSize. Legitimate metadata compresses far
+	// less; a higher ratio marks a zip bomb.
+	maxCompressionRatio = 100
+
+	// compressionRatioMinSize is the uncompressed size below which an
+	// entry's compression ratio is not checked.
+	compressionRatioMinSize = 64 << 10
+
+	// maxEntrySize bounds the uncompressed size of the entries strict
+	// validation decompresses.
+	maxEntrySize = 16 << 30
+)
+
+// End of central directory records, see the zip APPNOTE 4.3.14 to 4.3.16.
+const (
+	eocdSignature        = 0x06054b50
+	eocdLen              = 22
+	zip64LocatorSig      = 0x07064b50
+	zip64LocatorLen      = 20
+	zip64EOCDSignature   = 0x06064b50
+	zip64EOCDLen         = 56
+	maxArchiveCommentLen = 0xffff
+)
+
+// checkEntryCount returns an error if the zip archive in r of size bytes
+// declares more than maxArchiveEntries entries. It reads only the end of
+// the archive, so the limit holds before zip.NewReader allocates the
+// directory. Archives whose end record cannot be found are left for
+// zip.NewReader to reject.
+func checkEntryCount(r io.ReaderAt, size int64) error {
+	tailLen := min(size, eocdLen+maxArchiveCommentLen)
+	tail := make([]byte, tailLen)
+	if _, err := r.ReadAt(tail, size-tailLen); err != nil && err != io.EOF {
+		return err
+	}
+
+	i := bytes.LastIndex(tail, binary.LittleEndian.AppendUint32(nil, eocdSignature))
+	if i < 0 || len(tail)-i < eocdLen {
+		return nil
+	}
+	entries := uint64(binary.LittleEndian.Uint16(tail[i+10:]))
+
+	// Zip64 archives record the count in a separate record found through
+	// the locator preceding the end record
+	if entries == 0xffff && i >= zip64LocatorLen {
+		locator := tail[i-zip64LocatorLen : i]
+		if binary.LittleEndian.Uint32(locator) == zip64LocatorSig {
+			offset := binary.LittleEndian.Uint64(locator[8:])
+			record := make([]byte, zip64EOCDLen)
+			if offset > uint64(size) { //nolint:gosec // size is a file size
+				return nil
+			}
+			if _, err := r.ReadAt(record, int64(offset)); err != nil { //nolint:gosec // offset is within size
+				return nil //nolint:nilerr // Left for zip.NewReader to reject
+			}
+			if binary.LittleEndian.Uint32(record) == zip64EOCDSignature {
+				entries = binary.LittleEndian.Uint64(record[32:])
+			}
+		}
+	}
+
+	if entries > maxArchiveEntries {
+		return invalid("archive declares %d entries, more than the limit of %d", entries, maxArchiveEntries)
+	}
+	return nil
+}
+
+// checkEntryNames returns an error for the first entry whose name could
+// escape an extraction directory, or that is nested more than
+// maxEntryDepth components deep.
+func checkEntryNames(files []*zip.File) error {
+	for _, f := range files {
+		if err := checkEntryName(f.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEntryName checks one entry name for checkEntryNames.
+func checkEntryName(name string) error {
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsAny(name, "\\\x00") ||
+		(len(name) >= 2 && name[1] == ':') {
+		return invalid("unsafe entry name %q", name)
+	}
+	parts := strings.Split(strings.TrimSuffix(name, "/"), "/")
+	for _, part := range parts {
+		if part == ".." {
+			return invalid("unsafe entry name %q", name)
+		}
+	}
+	if len(parts) > maxEntryDepth {
+		return invalid("entry %q is nested %d levels deep, more than the limit of %d", name, len(parts), maxEntryDepth)
+	}
+	return nil
+}
+
+// checkCompressionRatio returns an error if an entry larger than
+// compressionRatioMinSize expands more than maxCompressionRatio times.
+func checkCompressionRatio(f *zip.File) error {
+	if f.UncompressedSize64 <= compressionRatioMinSize {
+		return nil
+	}
+	if f.CompressedSize64 == 0 || f.UncompressedSize64/f.CompressedSize64 > maxCompressionRatio {
+		return fmt.Errorf("%s expands %d bytes to %d, more than %d times", f.Name, f.CompressedSize64, f.UncompressedSize64, maxCompressionRatio)
+	}
+	return nil
+}
+
+// entryReader reads a zip entry, failing once it has produced more than
+// limit bytes or more than maxCompressionRatio times the entry's
+// compressed size beyond compressionRatioMinSize, whatever uncompressed
+// size the entry declares.
+type entryReader struct {
+	r          io.Reader
+	compressed uint64
+	n          int64
+	limit      int64
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.n += int64(n)
+	if e.n > e.limit {
+		return n, fmt.Errorf("exceeds %d bytes", e.limit)
+	}
+	if e.n > compressionRatioMinSize && uint64(e.n)/max(e.compressed, 1) > maxCompressionRatio { //nolint:gosec // n is positive
+		return n, fmt.Errorf("expands %d bytes to more than %d, more than %d times", e.compressed, e.n, maxCompressionRatio)
+	}
+	return n, err
+}
+
+// readZipEntry reads a zip entry, failing if it is larger than limit or
+// compresses suspiciously well.
+func readZipEntry(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) { //nolint:gosec // limit is a positive constant
+		return nil, fmt.Errorf("%s exceeds %d bytes", f.Name, limit)
+	}
+	if err := checkCompressionRatio(f); err != nil {
+		return nil, err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	// The zip reader fails on data beyond the declared size; the limit
+	// guards against it all the same
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s exceeds %d bytes", f.Name, limit)
+	}
+	return data, nil
+}
+
+// openZip checks the entry count of the archive in r of size bytes, opens
+// it and checks its entry names.
+func openZip(r io.ReaderAt, size int64) (*zip.Reader, error) {
+	if err := checkEntryCount(r, size); err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEntryNames(reader.File); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
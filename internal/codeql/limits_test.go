@@ -0,0 +1,229 @@
+package codeql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// withEntryCount rewrites the end of central directory record of a zip
+// archive without a comment to declare entries entries, through a zip64
+// record as a real archive of that many entries would.
+func withEntryCount(t *testing.T, archive []byte, entries uint64) []byte {
+	t.Helper()
+
+	eocd := archive[len(archive)-eocdLen:]
+	if binary.LittleEndian.Uint32(eocd) != eocdSignature {
+		t.Fatalf("archive does not end with an end of central directory record")
+	}
+	body := archive[:len(archive)-eocdLen]
+
+	record := make([]byte, zip64EOCDLen)
+	binary.LittleEndian.PutUint32(record, zip64EOCDSignature)
+	binary.LittleEndian.PutUint64(record[4:], zip64EOCDLen-12)
+	binary.LittleEndian.PutUint64(record[24:], entries)
+	binary.LittleEndian.PutUint64(record[32:], entries)
+	binary.LittleEndian.PutUint64(record[40:], uint64(binary.LittleEndian.Uint32(eocd[12:])))
+	binary.LittleEndian.PutUint64(record[48:], uint64(binary.LittleEndian.Uint32(eocd[16:])))
+
+	locator := make([]byte, zip64LocatorLen)
+	binary.LittleEndian.PutUint32(locator, zip64LocatorSig)
+	binary.LittleEndian.PutUint64(locator[8:], uint64(len(body)))
+	binary.LittleEndian.PutUint32(locator[16:], 1)
+
+	end := append([]byte(nil), eocd...)
+	binary.LittleEndian.PutUint16(end[8:], 0xffff)
+	binary.LittleEndian.PutUint16(end[10:], 0xffff)
+
+	crafted := append(append([]byte(nil), body...), record...)
+	crafted = append(crafted, locator...)
+	return append(crafted, end...)
+}
+
+func TestDiscover_Limits(t *testing.T) {
+	validArchive := map[string]string{
+		"db/codeql-database.yml":   validYAML,
+		"db/db-go/go.dbscheme":     "schema",
+		"db/db-go/default/strings": "data",
+	}
+	with := func(name, content string) map[string]string {
+		entries := make(map[string]string, len(validArchive)+1)
+		for k, v := range validArchive {
+			entries[k] = v
+		}
+		entries[name] = content
+		return entries
+	}
+
+	// Random text compresses poorly, so only the size limit applies
+	rng := rand.New(rand.NewSource(1)) //nolint:gosec // Deterministic test data
+	var noise strings.Builder
+	for noise.Len() <= maxMetadataSize {
+		fmt.Fprintf(&noise, "%x", rng.Uint64())
+	}
+
+	tests := []struct {
+		name       string
+		archive    []byte
+		validation Validation
+		wantErr    string
+	}{
+		{
+			name:    "many entries within the limit",
+			archive: withEntryCount(t, zipBytes(t, validArchive, ""), 3),
+		},
+		{
+			name:    "too many entries",
+			archive: withEntryCount(t, zipBytes(t, validArchive, ""), maxArchiveEntries+1),
+			wantErr: "archive declares 500001 entries, more than the limit of 500000",
+		},
+		{
+			name:    "oversized metadata",
+			archive: zipBytes(t, with("db/codeql-database.yml", validYAML+"# "+noise.String()+"\n"), ""),
+			wantErr: "db/codeql-database.yml exceeds 1048576 bytes",
+		},
+		{
+			name:    "metadata bomb",
+			archive: zipBytes(t, with("db/codeql-database.yml", validYAML+"# "+strings.Repeat("a", 512<<10)+"\n"), ""),
+			wantErr: "more than 100 times",
+		},
+		{
+			name:    "dataset bomb unread by basic validation",
+			archive: zipBytes(t, with("db/db-go/default/bomb", strings.Repeat("a", 4<<20)), ""),
+		},
+		{
+			name:       "dataset bomb",
+			archive:    zipBytes(t, with("db/db-go/default/bomb", strings.Repeat("a", 4<<20)), ""),
+			validation: ValidateStrict,
+			wantErr:    "db/db-go/default/bomb: expands",
+		},
+		{
+			name:    "deeply nested entry",
+			archive: zipBytes(t, with("db/src/"+strings.Repeat("a/", maxEntryDepth)+"x.go", "package a"), ""),
+			wantErr: "levels deep, more than the limit of 64",
+		},
+		{
+			name:    "parent directory entry",
+			archive: zipBytes(t, with("db/../../etc/passwd", "root"), ""),
+			wantErr: `unsafe entry name "db/../../etc/passwd"`,
+		},
+		{
+			name:    "leading parent directory entry",
+			archive: zipBytes(t, with("../evil", "x"), ""),
+			wantErr: `unsafe entry name "../evil"`,
+		},
+		{
+			name:    "absolute entry",
+			archive: zipBytes(t, with("/etc/cron.d/evil", "x"), ""),
+			wantErr: `unsafe entry name "/etc/cron.d/evil"`,
+		},
+		{
+			name:    "backslash entry",
+			archive: zipBytes(t, with(`db\..\..\evil`, "x"), ""),
+			wantErr: "unsafe entry name",
+		},
+		{
+			name:    "drive letter entry",
+			archive: zipBytes(t, with("C:/evil", "x"), ""),
+			wantErr: `unsafe entry name "C:/evil"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := hashMapFS{fstest.MapFS{"team/widgets.zip": {Data: tt.archive}}}
+			result, err := Discover(fsys, Options{Validation: tt.validation})
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+
+			if tt.wantErr == "" {
+				if len(result.Databases) != 1 || len(result.Quarantined) != 0 {
+					t.Fatalf("Discover() = %d databases, quarantined %+v; want the database", len(result.Databases), result.Quarantined)
+				}
+				return
+			}
+			if len(result.Databases) != 0 || len(result.Quarantined) != 1 {
+				t.Fatalf("Discover() = %d databases, quarantined %+v; want it quarantined", len(result.Databases), result.Quarantined)
+			}
+			if got := strings.Join(result.Quarantined[0].Problems, "; "); !strings.Contains(got, tt.wantErr) {
+				t.Errorf("Problems = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEntryReader(t *testing.T) {
+	tests := []struct {
+		name       string
+		compressed uint64
+		size       int
+		limit      int64
+		wantErr    string
+	}{
+		{name: "small entry", compressed: 1, size: compressionRatioMinSize, limit: maxEntrySize},
+		{name: "within the ratio", compressed: 1 << 20, size: 64 << 20, limit: maxEntrySize},
+		{name: "beyond the ratio", compressed: 1 << 10, size: 1 << 20, limit: maxEntrySize, wantErr: "more than 100 times"},
+		{name: "beyond the limit", compressed: 1 << 20, size: 2 << 20, limit: 1 << 20, wantErr: "exceeds 1048576 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The limits hold for the bytes actually read, whatever size an
+			// entry declares
+			r := &entryReader{r: bytes.NewReader(make([]byte, tt.size)), compressed: tt.compressed, limit: tt.limit}
+			_, err := io.Copy(io.Discard, r)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("read error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("read error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGitIdentityFromDatabaseArchive_Limits(t *testing.T) {
+	config := "[remote \"origin\"]\n\turl = https://github.com/octo/widgets.git\n"
+	tests := []struct {
+		name   string
+		src    map[string]string
+		wantOK bool
+	}{
+		{
+			name:   "safe source archive",
+			src:    map[string]string{"src/octo/widgets/.git/config": config},
+			wantOK: true,
+		},
+		{
+			name: "source archive with unsafe entry",
+			src: map[string]string{
+				"src/octo/widgets/.git/config": config,
+				"../../evil":                   "x",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := zipBytes(t, map[string]string{"db/src.zip": string(zipBytes(t, tt.src, ""))}, "")
+			reader, err := openZip(bytes.NewReader(archive), int64(len(archive)))
+			if err != nil {
+				t.Fatalf("openZip() error = %v", err)
+			}
+
+			_, ok := GitIdentityFromDatabaseArchive(reader.File, nil, "db/", "/src/octo/widgets")
+			if ok != tt.wantOK {
+				t.Errorf("GitIdentityFromDatabaseArchive() ok = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
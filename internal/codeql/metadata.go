@@ -0,0 +1,121 @@
+package codeql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+)
+
+// BuildMetadata converts a discovered database to the v1 API metadata format.
+// Result URLs are built from endpointURL and the database's RelPath.
+func BuildMetadata(db *DiscoveredDatabase, endpointURL string) api.DatabaseMetadata {
+	return BuildMetadataV2(db, endpointURL).DatabaseMetadata
+}
+
+// BuildMetadataV2 converts a discovered database to the v2 API metadata
+// format. Location is set to the database's Path; backends that store
+// databases elsewhere than the local filesystem replace it.
+func BuildMetadataV2(db *DiscoveredDatabase, endpointURL string) api.DatabaseMetadataV2 {
+	contentHash, hashKind := db.ContentHash, db.HashKind
+	if contentHash == "" {
+		// Without a content hash, identify the database by its path
+		h := sha256.Sum256([]byte(db.Path))
+		contentHash, hashKind = hex.EncodeToString(h[:]), api.HashKindPath
+	}
+	contentHash = LanguageContentHash(db, contentHash)
+
+	// Build CID from available metadata
+	buildCID := ""
+	creationTime := ""
+	cliVersion := ""
+	sourceSHA := ""
+
+	if db.CreationMetadata != nil {
+		cliVersion = db.CreationMetadata.CLIVersion
+		creationTime = db.CreationMetadata.CreationTime
+		sourceSHA = db.CreationMetadata.SHA
+		buildCID = generateBuildCID(cliVersion, creationTime, db.Language, sourceSHA)
+	} else {
+		// Derive a build CID from the content hash, which may be as short
+		// as the 8 hex digits of a CRC32C
+		h := sha256.Sum256([]byte(contentHash))
+		buildCID = hex.EncodeToString(h[:])[:10]
+	}
+
+	// Construct the result URL
+	relPath := db.RelPath
+	if relPath == "" {
+		relPath = db.Name
+	}
+	resultURL := fmt.Sprintf("%s/db/%s", strings.TrimSuffix(endpointURL, "/"), relPath)
+	resultURL = LanguageResultURL(db, resultURL)
+
+	// Prefer the branch and commit named by the identity evidence
+	branch := "HEAD"
+	if db.Branch != "" {
+		branch = db.Branch
+	}
+	if db.Commit != "" {
+		sourceSHA = db.Commit
+	}
+
+	// Determine tool name and tool ID
+	toolName := "codeql"
+	toolID := "codeql"
+	if db.Language != "" && db.Language != "unknown" {
+		toolName = fmt.Sprintf("codeql-%s", db.Language)
+		toolID = toolName // tool_id matches tool_name format
+	}
+
+	format := api.FormatUnarchived
+	if db.IsArchived {
+		format = api.FormatArchived
+	}
+	hasSourceArchive := db.HasSourceArchive
+
+	v1 := api.DatabaseMetadata{
+		ContentHash:          contentHash,
+		BuildCID:             buildCID,
+		GitBranch:            branch,
+		GitCommitID:          sourceSHA,
+		GitOwner:             db.Owner,
+		GitRepo:              db.Repo,
+		IngestionDatetimeUTC: creationTime,
+		PrimaryLanguage:      db.Language,
+		ResultURL:            resultURL,
+		ToolID:               toolID,
+		ToolName:             toolName,
+		ToolVersion:          cliVersion,
+		Projname:             fmt.Sprintf("%s/%s", db.Owner, db.Repo),
+		DBFileSize:           db.FileSize,
+	}
+
+	return api.DatabaseMetadataV2{
+		DatabaseMetadata:     v1,
+		Format:               format,
+		Languages:            db.Languages,
+		SourceLocationPrefix: db.SourceLocationPrefix,
+		Finalised:            db.Finalised,
+		DBScheme:             db.DBScheme,
+		DBSchemeSHA256:       db.DBSchemeHash,
+		HasSourceArchive:     &hasSourceArchive,
+		LinesOfCode:          db.LinesOfCode,
+		FileCount:            db.FileCount,
+		Location:             db.Path,
+		HashKind:             hashKind,
+		IdentitySource:       db.IdentitySource,
+		Visibility:           db.Visibility,
+		Team:                 db.Team,
+		Tags:                 db.Tags,
+	}
+}
+
+// generateBuildCID creates a build context identifier.
+func generateBuildCID(cliVersion, creationTime, language, sourceSHA string) string {
+	s := fmt.Sprintf("%s %s %s %s", cliVersion, creationTime, language, sourceSHA)
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])[:10]
+}
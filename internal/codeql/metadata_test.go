@@ -0,0 +1,261 @@
+package codeql
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+)
+
+func TestBuildMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+
+	tests := []struct {
+		name string
+		db   *DiscoveredDatabase
+		want api.DatabaseMetadata
+	}{
+		{
+			name: "with creation metadata",
+			db: &DiscoveredDatabase{
+				Path:                 filepath.Join(tempDir, "test-db"),
+				Name:                 "test-db",
+				IsArchived:           false,
+				Language:             "go",
+				SourceLocationPrefix: "/src/owner/repo",
+				CreationMetadata: &DatabaseCreationMetadata{
+					SHA:          "abc123",
+					CLIVersion:   "2.15.0",
+					CreationTime: "2024-01-15T10:30:00Z",
+				},
+				FileSize:    1000,
+				ContentHash: "deadbeef",
+				Owner:       "owner",
+				Repo:        "repo",
+			},
+			want: api.DatabaseMetadata{
+				ContentHash:          "deadbeef",
+				GitBranch:            "HEAD",
+				GitCommitID:          "abc123",
+				GitOwner:             "owner",
+				GitRepo:              "repo",
+				IngestionDatetimeUTC: "2024-01-15T10:30:00Z",
+				PrimaryLanguage:      "go",
+				ToolName:             "codeql-go",
+				ToolVersion:          "2.15.0",
+				Projname:             "owner/repo",
+				DBFileSize:           1000,
+			},
+		},
+		{
+			name: "without creation metadata",
+			db: &DiscoveredDatabase{
+				Path:       filepath.Join(tempDir, "simple-db"),
+				Name:       "simple-db",
+				IsArchived: false,
+				Language:   "python",
+				FileSize:   500,
+				Owner:      "testowner",
+				Repo:       "testrepo",
+			},
+			want: api.DatabaseMetadata{
+				GitBranch:       "HEAD",
+				GitOwner:        "testowner",
+				GitRepo:         "testrepo",
+				PrimaryLanguage: "python",
+				ToolName:        "codeql-python",
+				Projname:        "testowner/testrepo",
+				DBFileSize:      500,
+			},
+		},
+		{
+			name: "unknown language",
+			db: &DiscoveredDatabase{
+				Path:     filepath.Join(tempDir, "unknown-db"),
+				Name:     "unknown-db",
+				Language: "unknown",
+				Owner:    "owner",
+				Repo:     "repo",
+			},
+			want: api.DatabaseMetadata{
+				GitBranch:       "HEAD",
+				GitOwner:        "owner",
+				GitRepo:         "repo",
+				PrimaryLanguage: "unknown",
+				ToolName:        "codeql",
+				Projname:        "owner/repo",
+			},
+		},
+		{
+			name: "identity from sidecar",
+			db: &DiscoveredDatabase{
+				Path:     filepath.Join(tempDir, "sidecar-db"),
+				Name:     "sidecar-db",
+				Language: "go",
+				CreationMetadata: &DatabaseCreationMetadata{
+					SHA:        "abc123",
+					CLIVersion: "2.15.0",
+				},
+				Owner:          "octo",
+				Repo:           "widgets",
+				Branch:         "main",
+				Commit:         "fedcba",
+				IdentitySource: IdentitySourceSidecar,
+			},
+			want: api.DatabaseMetadata{
+				GitBranch:       "main",
+				GitCommitID:     "fedcba",
+				GitOwner:        "octo",
+				GitRepo:         "widgets",
+				PrimaryLanguage: "go",
+				ToolName:        "codeql-go",
+				Projname:        "octo/widgets",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildMetadata(tt.db, "http://example.com")
+
+			// Check specific fields (not all, as some are generated)
+			if got.PrimaryLanguage != tt.want.PrimaryLanguage {
+				t.Errorf("PrimaryLanguage = %q, want %q", got.PrimaryLanguage, tt.want.PrimaryLanguage)
+			}
+			if got.GitOwner != tt.want.GitOwner {
+				t.Errorf("GitOwner = %q, want %q", got.GitOwner, tt.want.GitOwner)
+			}
+			if got.GitRepo != tt.want.GitRepo {
+				t.Errorf("GitRepo = %q, want %q", got.GitRepo, tt.want.GitRepo)
+			}
+			if got.Projname != tt.want.Projname {
+				t.Errorf("Projname = %q, want %q", got.Projname, tt.want.Projname)
+			}
+			if got.ToolName != tt.want.ToolName {
+				t.Errorf("ToolName = %q, want %q", got.ToolName, tt.want.ToolName)
+			}
+			if got.GitBranch != tt.want.GitBranch {
+				t.Errorf("GitBranch = %q, want %q", got.GitBranch, tt.want.GitBranch)
+			}
+			if got.DBFileSize != tt.want.DBFileSize {
+				t.Errorf("DBFileSize = %d, want %d", got.DBFileSize, tt.want.DBFileSize)
+			}
+			if got.GitCommitID != tt.want.GitCommitID {
+				t.Errorf("GitCommitID = %q, want %q", got.GitCommitID, tt.want.GitCommitID)
+			}
+			if v2 := BuildMetadataV2(tt.db, "http://example.com"); v2.IdentitySource != tt.db.IdentitySource {
+				t.Errorf("IdentitySource = %q, want %q", v2.IdentitySource, tt.db.IdentitySource)
+			}
+
+			// Check that generated fields are present
+			if got.ContentHash == "" {
+				t.Error("ContentHash is empty")
+			}
+			if got.BuildCID == "" {
+				t.Error("BuildCID is empty")
+			}
+			if !strings.HasPrefix(got.ResultURL, "http://example.com/db/") {
+				t.Errorf("ResultURL = %q, want prefix %q", got.ResultURL, "http://example.com/db/")
+			}
+		})
+	}
+}
+
+func TestBuildMetadata_ResultURL(t *testing.T) {
+	db := &DiscoveredDatabase{
+		Path:      "/data/dbs/team/widgets.zip",
+		RelPath:   "team/widgets.zip",
+		Name:      "widgets.zip",
+		Language:  "go",
+		Languages: []string{"go", "python"},
+	}
+
+	got := BuildMetadata(db, "http://example.com/")
+	if got.ResultURL != "http://example.com/db/team/widgets.zip?language=go" {
+		t.Errorf("ResultURL = %q, want %q", got.ResultURL, "http://example.com/db/team/widgets.zip?language=go")
+	}
+}
+
+func TestBuildMetadataV2(t *testing.T) {
+	finalised := true
+	db := &DiscoveredDatabase{
+		Path:                 "/data/dbs/widgets.zip",
+		RelPath:              "widgets.zip",
+		Name:                 "widgets.zip",
+		IsArchived:           true,
+		Language:             "go",
+		Languages:            []string{"go"},
+		SourceLocationPrefix: "/src/octo/widgets",
+		ContentHash:          "abcdef0123456789",
+		Owner:                "octo",
+		Repo:                 "widgets",
+		Finalised:            &finalised,
+		HasSourceArchive:     true,
+		DBScheme:             "go.dbscheme",
+		DBSchemeHash:         "schemehash",
+		LinesOfCode:          120,
+		FileCount:            2,
+	}
+
+	got := BuildMetadataV2(db, "http://example.com")
+	if !reflect.DeepEqual(got.DatabaseMetadata, BuildMetadata(db, "http://example.com")) {
+		t.Errorf("v1 part = %+v, want BuildMetadata result", got.DatabaseMetadata)
+	}
+	if got.Format != api.FormatArchived || got.Location != db.Path || got.SourceLocationPrefix != db.SourceLocationPrefix {
+		t.Errorf("Format, Location, SourceLocationPrefix = %q, %q, %q", got.Format, got.Location, got.SourceLocationPrefix)
+	}
+	if got.Finalised == nil || !*got.Finalised || got.HasSourceArchive == nil || !*got.HasSourceArchive {
+		t.Errorf("Finalised, HasSourceArchive = %v, %v, want true, true", got.Finalised, got.HasSourceArchive)
+	}
+	if got.DBScheme != "go.dbscheme" || got.DBSchemeSHA256 != "schemehash" || got.LinesOfCode != 120 || got.FileCount != 2 {
+		t.Errorf("schema and counts = %+v", got)
+	}
+
+	db.IsArchived = false
+	if got := BuildMetadataV2(db, "http://example.com"); got.Format != api.FormatUnarchived {
+		t.Errorf("Format = %q, want %q", got.Format, api.FormatUnarchived)
+	}
+}
+
+func TestBuildMetadata_ShortContentHash(t *testing.T) {
+	// A composite GCS object has only a CRC32C, 8 hex digits long
+	db := &DiscoveredDatabase{
+		Path:        "team/widgets.zip",
+		RelPath:     "team/widgets.zip",
+		Name:        "widgets.zip",
+		IsArchived:  true,
+		Language:    "go",
+		ContentHash: "deadbeef",
+		HashKind:    api.HashKindCRC32C,
+	}
+
+	got := BuildMetadata(db, "http://example.com")
+	if got.ContentHash != "deadbeef" {
+		t.Errorf("ContentHash = %q, want %q", got.ContentHash, "deadbeef")
+	}
+	if len(got.BuildCID) != 10 {
+		t.Errorf("BuildCID = %q, want 10 characters", got.BuildCID)
+	}
+}
+
+func TestGenerateBuildCID(t *testing.T) {
+	// Test that generateBuildCID produces consistent results
+	cid1 := generateBuildCID("2.15.0", "2024-01-15T10:30:00Z", "go", "abc123")
+	cid2 := generateBuildCID("2.15.0", "2024-01-15T10:30:00Z", "go", "abc123")
+
+	if cid1 != cid2 {
+		t.Errorf("generateBuildCID() produced inconsistent results: %q vs %q", cid1, cid2)
+	}
+
+	if len(cid1) != 10 {
+		t.Errorf("generateBuildCID() length = %d, want 10", len(cid1))
+	}
+
+	// Different inputs should produce different CIDs
+	cid3 := generateBuildCID("2.14.0", "2024-01-15T10:30:00Z", "go", "abc123")
+	if cid1 == cid3 {
+		t.Error("generateBuildCID() should produce different CIDs for different inputs")
+	}
+}
@@ -0,0 +1,251 @@
+package codeql
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Validation selects how thoroughly discovery checks a database before
+// advertising it. Databases that fail are quarantined instead.
+type Validation int
+
+const (
+	// ValidateBasic checks what discovery reads anyway: the zip directory
+	// of an archive, the CRC-32 of its metadata entries, the fields of
+	// codeql-database.yml, the finalised state, and that every language
+	// has a db-<language> dataset directory.
+	ValidateBasic Validation = iota
+
+	// ValidateStrict additionally verifies the CRC-32 of every archive
+	// entry, which reads the whole archive, and checks the structure
+	// "codeql resolve database" relies on: a .dbscheme and a non-empty
+	// default/ dataset for every language.
+	ValidateStrict
+)
+
+// ParseValidation parses a validation level name: "basic" (the default
+// when empty) or "strict".
+func ParseValidation(s string) (Validation, error) {
+	switch s {
+	case "", "basic":
+		return ValidateBasic, nil
+	case "strict":
+		return ValidateStrict, nil
+	}
+	return ValidateBasic, fmt.Errorf("invalid validation level %q (want basic or strict)", s)
+}
+
+// String returns the name of the validation level.
+func (v Validation) String() string {
+	if v == ValidateStrict {
+		return "strict"
+	}
+	return "basic"
+}
+
+// InvalidDatabaseError reports a database that failed validation.
+type InvalidDatabaseError struct {
+	// Problems describe each failed check.
+	Problems []string
+}
+
+func (e *InvalidDatabaseError) Error() string {
+	return "invalid database: " + strings.Join(e.Problems, "; ")
+}
+
+// invalid returns an InvalidDatabaseError with a single problem.
+func invalid(format string, args ...any) error {
+	return &InvalidDatabaseError{Problems: []string{fmt.Sprintf(format, args...)}}
+}
+
+// QuarantinedDatabase is a database artifact excluded from discovery
+// because it failed validation.
+type QuarantinedDatabase struct {
+	// RelPath is the slash-separated path of the artifact relative to the
+	// root of the discovered storage.
+	RelPath string
+
+	// Problems describe each failed check.
+	Problems []string
+}
+
+// validLanguage matches CodeQL language names such as "go" or "csharp".
+var validLanguage = regexp.MustCompile(`^[a-z][a-z0-9_+-]*$`)
+
+// datasetLayout records which parts of the db-<language> directories of
+// one database exist. schemes and datasets are only filled for strict
+// validation.
+type datasetLayout struct {
+	dirs     map[string]bool
+	schemes  map[string]bool
+	datasets map[string]bool
+}
+
+func newDatasetLayout() datasetLayout {
+	return datasetLayout{
+		dirs:     make(map[string]bool),
+		schemes:  make(map[string]bool),
+		datasets: make(map[string]bool),
+	}
+}
+
+// archiveLayout returns the layout of the database rooted at root (either
+// "" or ending in "/") in an archive.
+func archiveLayout(files []*zip.File, root string) datasetLayout {
+	layout := newDatasetLayout()
+	for _, f := range files {
+		rest, ok := strings.CutPrefix(f.Name, root)
+		if !ok {
+			continue
+		}
+		dir, inside, isDir := strings.Cut(rest, "/")
+		lang, ok := strings.CutPrefix(dir, "db-")
+		if !isDir || !ok || lang == "" {
+			continue
+		}
+		layout.dirs[lang] = true
+		if _, _, ok := dbSchemePath(rest); ok {
+			layout.schemes[lang] = true
+		}
+		if file, ok := strings.CutPrefix(inside, "default/"); ok && file != "" && !strings.HasSuffix(file, "/") {
+			layout.datasets[lang] = true
+		}
+	}
+	return layout
+}
+
+// directoryLayout returns the layout of an unarchived database. entries
+// lists the database directory; the db-<language> directories are only
+// read for strict validation.
+func directoryLayout(fsys fs.FS, dbPath string, entries []fs.DirEntry, v Validation) datasetLayout {
+	layout := newDatasetLayout()
+	for _, entry := range entries {
+		lang, ok := strings.CutPrefix(entry.Name(), "db-")
+		if !entry.IsDir() || !ok || lang == "" {
+			continue
+		}
+		layout.dirs[lang] = true
+		if v < ValidateStrict {
+			continue
+		}
+
+		langDir := path.Join(dbPath, entry.Name())
+		langEntries, err := fs.ReadDir(fsys, langDir)
+		if err != nil {
+			continue
+		}
+		for _, e := range langEntries {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), ".dbscheme") {
+				layout.schemes[lang] = true
+			}
+		}
+		if hasFile(fsys, path.Join(langDir, "default")) {
+			layout.datasets[lang] = true
+		}
+	}
+	return layout
+}
+
+// errFound stops a walk once a file has been found.
+var errFound = errors.New("found")
+
+// hasFile reports whether the directory dir holds at least one file,
+// directly or in a subdirectory.
+func hasFile(fsys fs.FS, dir string) bool {
+	err := fs.WalkDir(fsys, dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return errFound
+		}
+		return nil
+	})
+	return errors.Is(err, errFound)
+}
+
+// validateDatabase checks the codeql-database.yml of a database and the
+// dataset of each of its languages, returning an InvalidDatabaseError
+// listing every problem found.
+func validateDatabase(dbYAML *DatabaseYAML, languages []string, layout datasetLayout, v Validation) error {
+	var problems []string
+	if dbYAML.PrimaryLanguage != "" && !validLanguage.MatchString(dbYAML.PrimaryLanguage) {
+		problems = append(problems, fmt.Sprintf("invalid primaryLanguage %q", dbYAML.PrimaryLanguage))
+	}
+	if dbYAML.BaselineLinesOfCode < 0 {
+		problems = append(problems, fmt.Sprintf("negative baselineLinesOfCode %d", dbYAML.BaselineLinesOfCode))
+	}
+	if cm := dbYAML.CreationMetadata; cm != nil && cm.CreationTime != "" {
+		if _, err := time.Parse(time.RFC3339Nano, cm.CreationTime); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid creationMetadata.creationTime %q", cm.CreationTime))
+		}
+	}
+	if dbYAML.Finalised != nil && !*dbYAML.Finalised {
+		problems = append(problems, "database is not finalised")
+	}
+
+	for _, lang := range languages {
+		switch {
+		case lang == "unknown" && !layout.dirs[lang]:
+			problems = append(problems, "no language: neither primaryLanguage nor a db-<language> directory")
+		case !layout.dirs[lang]:
+			problems = append(problems, fmt.Sprintf("missing dataset directory db-%s", lang))
+		case v >= ValidateStrict && !layout.schemes[lang]:
+			problems = append(problems, fmt.Sprintf("db-%s has no .dbscheme", lang))
+		case v >= ValidateStrict && !layout.datasets[lang]:
+			problems = append(problems, fmt.Sprintf("db-%s/default holds no dataset", lang))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &InvalidDatabaseError{Problems: problems}
+	}
+	return nil
+}
+
+// validateArchive checks the zip directory of an archive of size bytes
+// and, for strict validation, the CRC-32 of every entry.
+func validateArchive(files []*zip.File, size int64, v Validation) error {
+	var compressed uint64
+	for _, f := range files {
+		compressed += f.CompressedSize64
+	}
+	if compressed > uint64(size) {
+		return invalid("zip entries hold %d bytes, more than the %d byte archive", compressed, size)
+	}
+	if v < ValidateStrict {
+		return nil
+	}
+
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if err := verifyEntry(f); err != nil {
+			return invalid("%s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// verifyEntry reads an archive entry in full, which fails on a CRC-32
+// mismatch or truncated data, and on entries larger than maxEntrySize or
+// compressing suspiciously well.
+func verifyEntry(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close() //nolint:errcheck // Best effort close in defer
+	}()
+	_, err = io.Copy(io.Discard, &entryReader{r: rc, compressed: f.CompressedSize64, limit: maxEntrySize})
+	return err
+}
@@ -0,0 +1,286 @@
+package codeql
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// validYAML is a codeql-database.yml that passes validation.
+const validYAML = "sourceLocationPrefix: /src/octo/widgets\nprimaryLanguage: go\nfinalised: true\n" +
+	"creationMetadata:\n  creationTime: 2024-05-01T12:00:00.123Z\n"
+
+// zipBytes returns an archive of entries. The entry named badCRC, if any,
+// is stored with a wrong CRC-32.
+func zipBytes(t *testing.T, entries map[string]string, badCRC string) []byte {
+	t.Helper()
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		content := []byte(entries[name])
+		if name == badCRC {
+			fw, err := w.CreateRaw(&zip.FileHeader{
+				Name:               name,
+				Method:             zip.Store,
+				CRC32:              crc32.ChecksumIEEE(content) + 1,
+				CompressedSize64:   uint64(len(content)),
+				UncompressedSize64: uint64(len(content)),
+			})
+			if err != nil {
+				t.Fatalf("Failed to create zip entry: %v", err)
+			}
+			if _, err := fw.Write(content); err != nil {
+				t.Fatalf("Failed to write zip entry: %v", err)
+			}
+			continue
+		}
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write(content); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDiscover_Validation(t *testing.T) {
+	validArchive := map[string]string{
+		"db/codeql-database.yml":   validYAML,
+		"db/db-go/go.dbscheme":     "schema",
+		"db/db-go/default/strings": "data",
+	}
+	with := func(name, content string) map[string]string {
+		entries := make(map[string]string, len(validArchive)+1)
+		for k, v := range validArchive {
+			entries[k] = v
+		}
+		entries[name] = content
+		return entries
+	}
+	without := func(prefix string) map[string]string {
+		entries := make(map[string]string, len(validArchive))
+		for k, v := range validArchive {
+			if !strings.HasPrefix(k, prefix) {
+				entries[k] = v
+			}
+		}
+		return entries
+	}
+	truncated := zipBytes(t, validArchive, "")
+	truncated = truncated[:len(truncated)/2]
+
+	tests := []struct {
+		name       string
+		archive    []byte
+		validation Validation
+		wantErr    string
+	}{
+		{
+			name:    "valid",
+			archive: zipBytes(t, validArchive, ""),
+		},
+		{
+			name:       "valid strict",
+			archive:    zipBytes(t, validArchive, ""),
+			validation: ValidateStrict,
+		},
+		{
+			name:    "truncated upload",
+			archive: truncated,
+			wantErr: "cannot read zip directory",
+		},
+		{
+			name:    "corrupt metadata entry",
+			archive: zipBytes(t, validArchive, "db/codeql-database.yml"),
+			wantErr: "checksum error",
+		},
+		{
+			name:    "corrupt dataset entry without strict validation",
+			archive: zipBytes(t, validArchive, "db/db-go/default/strings"),
+		},
+		{
+			name:       "corrupt dataset entry",
+			archive:    zipBytes(t, validArchive, "db/db-go/default/strings"),
+			validation: ValidateStrict,
+			wantErr:    "db/db-go/default/strings: zip: checksum error",
+		},
+		{
+			name:    "unparsable yaml",
+			archive: zipBytes(t, with("db/codeql-database.yml", "primaryLanguage: [go\n"), ""),
+			wantErr: "failed to parse db/codeql-database.yml",
+		},
+		{
+			name:    "not finalised",
+			archive: zipBytes(t, with("db/codeql-database.yml", "sourceLocationPrefix: /src\nprimaryLanguage: go\nfinalised: false\n"), ""),
+			wantErr: "database is not finalised",
+		},
+		{
+			name:    "invalid fields",
+			archive: zipBytes(t, with("db/codeql-database.yml", "primaryLanguage: Go Lang\nbaselineLinesOfCode: -1\ncreationMetadata:\n  creationTime: yesterday\n"), ""),
+			wantErr: `invalid primaryLanguage "Go Lang"; negative baselineLinesOfCode -1; invalid creationMetadata.creationTime "yesterday"; missing dataset directory db-Go Lang`,
+		},
+		{
+			name:    "missing dataset",
+			archive: zipBytes(t, without("db/db-go/"), ""),
+			wantErr: "missing dataset directory db-go",
+		},
+		{
+			name:       "missing dbscheme",
+			archive:    zipBytes(t, without("db/db-go/go.dbscheme"), ""),
+			validation: ValidateStrict,
+			wantErr:    "db-go has no .dbscheme",
+		},
+		{
+			name: "empty dataset",
+			archive: zipBytes(t, map[string]string{
+				"db/codeql-database.yml": validYAML,
+				"db/db-go/go.dbscheme":   "schema",
+				"db/db-go/default/":      "",
+			}, ""),
+			validation: ValidateStrict,
+			wantErr:    "db-go/default holds no dataset",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := hashMapFS{fstest.MapFS{"team/widgets.zip": {Data: tt.archive}}}
+			result, err := Discover(fsys, Options{Validation: tt.validation})
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+
+			if tt.wantErr == "" {
+				if len(result.Databases) != 1 || len(result.Quarantined) != 0 {
+					t.Fatalf("Discover() = %d databases, quarantined %+v; want the database", len(result.Databases), result.Quarantined)
+				}
+				return
+			}
+			if len(result.Databases) != 0 || len(result.Quarantined) != 1 {
+				t.Fatalf("Discover() = %d databases, quarantined %+v; want it quarantined", len(result.Databases), result.Quarantined)
+			}
+			q := result.Quarantined[0]
+			if q.RelPath != "team/widgets.zip" {
+				t.Errorf("RelPath = %q, want %q", q.RelPath, "team/widgets.zip")
+			}
+			if got := strings.Join(q.Problems, "; "); !strings.Contains(got, tt.wantErr) {
+				t.Errorf("Problems = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiscover_ValidationDirectory(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		validation Validation
+		wantErr    string
+	}{
+		{
+			name: "valid strict",
+			files: map[string]string{
+				"codeql-database.yml":    validYAML,
+				"db-go/go.dbscheme":      "schema",
+				"db-go/default/cache/db": "data",
+			},
+			validation: ValidateStrict,
+		},
+		{
+			name: "not finalised",
+			files: map[string]string{
+				"codeql-database.yml": "primaryLanguage: go\nfinalised: false\n",
+				"db-go/default/x":     "data",
+			},
+			wantErr: "database is not finalised",
+		},
+		{
+			name: "no language",
+			files: map[string]string{
+				"codeql-database.yml": "sourceLocationPrefix: /src\n",
+			},
+			wantErr: "no language",
+		},
+		{
+			name: "missing dataset",
+			files: map[string]string{
+				"codeql-database.yml": validYAML,
+				"db-go/go.dbscheme":   "schema",
+				"db-go/other/x":       "data",
+			},
+			validation: ValidateStrict,
+			wantErr:    "db-go/default holds no dataset",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for name, content := range tt.files {
+				fsys["team/gadgets/"+name] = &fstest.MapFile{Data: []byte(content)}
+			}
+			result, err := Discover(fsys, Options{Validation: tt.validation})
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+
+			if tt.wantErr == "" {
+				if len(result.Databases) != 1 || len(result.Quarantined) != 0 {
+					t.Fatalf("Discover() = %d databases, quarantined %+v; want the database", len(result.Databases), result.Quarantined)
+				}
+				return
+			}
+			want := []QuarantinedDatabase{{RelPath: "team/gadgets"}}
+			if len(result.Quarantined) == 1 {
+				want[0].Problems = result.Quarantined[0].Problems
+			}
+			if len(result.Databases) != 0 || !reflect.DeepEqual(result.Quarantined, want) {
+				t.Fatalf("Discover() = %d databases, quarantined %+v; want %+v", len(result.Databases), result.Quarantined, want)
+			}
+			if got := strings.Join(want[0].Problems, "; "); !strings.Contains(got, tt.wantErr) {
+				t.Errorf("Problems = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseValidation(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Validation
+		wantErr bool
+	}{
+		{s: "", want: ValidateBasic},
+		{s: "basic", want: ValidateBasic},
+		{s: "strict", want: ValidateStrict},
+		{s: "paranoid", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseValidation(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseValidation(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseValidation(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
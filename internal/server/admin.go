@@ -0,0 +1,184 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// adminPrefix is the path prefix of the administrative endpoints. They are
+// authenticated with the admin tokens, never the client tokens, and are
+// disabled when no admin tokens are configured.
+const adminPrefix = "/admin/"
+
+// isAdminPath reports whether path is an administrative endpoint.
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, adminPrefix)
+}
+
+// requireAdmin allows a request only if it presents one of the admin
+// tokens of its generation. Without admin tokens the endpoint is reported
+// as not found, so an unconfigured server does not advertise it.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens := s.generation(r).config.AdminTokens
+		if len(tokens) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if !validToken(r, tokens) {
+			s.logger.Warn("unauthorized admin request", "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hepc-admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleReload reloads the configuration and storage backend.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.Reload(r.Context()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":       "reloaded",
+		"storage_type": s.current().backend.Type(),
+	})
+}
+
+// cacheStatus is the response of the cache admin endpoints.
+type cacheStatus struct {
+	StorageType           string       `json:"storage_type"`
+	Entries               int          `json:"entries"`
+	RefreshedAt           *time.Time   `json:"refreshed_at,omitempty"`
+	AgeSeconds            float64      `json:"age_seconds"`
+	TTLSeconds            float64      `json:"ttl_seconds"`
+	LastRefreshDurationMS int64        `json:"last_refresh_duration_ms"`
+	Errors                []cacheError `json:"errors"`
+}
+
+// cacheError is a failed cache refresh in a cacheStatus.
+type cacheError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// newCacheStatus converts the cache statistics of a backend.
+func newCacheStatus(storageType string, stats storage.CacheStats) cacheStatus {
+	status := cacheStatus{
+		StorageType:           storageType,
+		Entries:               stats.Entries,
+		TTLSeconds:            stats.TTL.Seconds(),
+		LastRefreshDurationMS: stats.LastRefreshDuration.Milliseconds(),
+		Errors:                make([]cacheError, 0, len(stats.Errors)),
+	}
+	if !stats.RefreshedAt.IsZero() {
+		refreshedAt := stats.RefreshedAt.UTC()
+		status.RefreshedAt = &refreshedAt
+		status.AgeSeconds = time.Since(stats.RefreshedAt).Seconds()
+	}
+	for _, e := range stats.Errors {
+		status.Errors = append(status.Errors, cacheError{Time: e.Time.UTC(), Error: e.Message})
+	}
+	return status
+}
+
+// cacheController returns the backend serving r if it supports cache
+// control, and otherwise responds with 501 Not Implemented.
+func (s *Server) cacheController(w http.ResponseWriter, r *http.Request) (storage.CacheController, bool) {
+	backend := s.storage(r)
+	cc, ok := backend.(storage.CacheController)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s storage does not support cache control", backend.Type()), http.StatusNotImplemented)
+		return nil, false
+	}
+	return cc, true
+}
+
+// handleCacheStats reports the state of the metadata cache.
+func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	cc, ok := s.cacheController(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newCacheStatus(s.storage(r).Type(), cc.CacheStats()))
+}
+
+// handleCacheInvalidate empties the metadata cache.
+func (s *Server) handleCacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	cc, ok := s.cacheController(w, r)
+	if !ok {
+		return
+	}
+	cc.InvalidateCache()
+	s.logger.Info("metadata cache invalidated")
+	writeJSON(w, http.StatusOK, newCacheStatus(s.storage(r).Type(), cc.CacheStats()))
+}
+
+// handleReindex rediscovers the databases, optionally only those under the
+// directory given by the "prefix" query parameter, and reports the cache
+// state afterwards.
+func (s *Server) handleReindex(w http.ResponseWriter, r *http.Request) {
+	cc, ok := s.cacheController(w, r)
+	if !ok {
+		return
+	}
+	prefix, err := storage.CleanPrefix(r.URL.Query().Get("prefix"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	if err := cc.Reindex(r.Context(), prefix); err != nil {
+		s.logger.Error("reindex failed", "prefix", prefix, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+	s.logger.Info("reindexed", "prefix", prefix, "duration", time.Since(start))
+	writeJSON(w, http.StatusOK, newCacheStatus(s.storage(r).Type(), cc.CacheStats()))
+}
+
+// quarantineStatus is the response of the quarantine endpoint.
+type quarantineStatus struct {
+	StorageType string                `json:"storage_type"`
+	Databases   []quarantinedDatabase `json:"databases"`
+}
+
+// quarantinedDatabase is a database that failed validation.
+type quarantinedDatabase struct {
+	Path     string   `json:"path"`
+	Problems []string `json:"problems"`
+}
+
+// handleQuarantine lists the databases left out of the index because they
+// failed validation in the last discovery.
+func (s *Server) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	backend := s.storage(r)
+	qr, ok := backend.(storage.QuarantineReporter)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s storage does not validate databases", backend.Type()), http.StatusNotImplemented)
+		return
+	}
+
+	quarantine := qr.Quarantine()
+	status := quarantineStatus{
+		StorageType: backend.Type(),
+		Databases:   make([]quarantinedDatabase, 0, len(quarantine)),
+	}
+	for _, q := range quarantine {
+		status.Databases = append(status.Databases, quarantinedDatabase{Path: q.RelPath, Problems: q.Problems})
+	}
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// cacheBackend is a mock backend with cache control.
+type cacheBackend struct {
+	*mockBackend
+	stats       storage.CacheStats
+	invalidated bool
+	reindexed   []string
+	reindexErr  error
+}
+
+func (b *cacheBackend) CacheStats() storage.CacheStats {
+	return b.stats
+}
+
+func (b *cacheBackend) InvalidateCache() {
+	b.invalidated = true
+	b.stats = storage.CacheStats{TTL: b.stats.TTL}
+}
+
+func (b *cacheBackend) Reindex(ctx context.Context, prefix string) error {
+	if b.reindexErr != nil {
+		return b.reindexErr
+	}
+	b.reindexed = append(b.reindexed, prefix)
+	return nil
+}
+
+func (b *cacheBackend) Quarantine() []codeql.QuarantinedDatabase {
+	return []codeql.QuarantinedDatabase{{RelPath: "team/broken.zip", Problems: []string{"database is not finalised"}}}
+}
+
+func newCacheBackend() *cacheBackend {
+	return &cacheBackend{
+		mockBackend: &mockBackend{typeStr: "mock"},
+		stats: storage.CacheStats{
+			Entries:             3,
+			RefreshedAt:         time.Now().Add(-time.Minute),
+			TTL:                 5 * time.Minute,
+			LastRefreshDuration: 1500 * time.Millisecond,
+			Errors:              []storage.CacheError{{Time: time.Now(), Message: "bucket unavailable"}},
+		},
+	}
+}
+
+// adminRequest sends an authenticated admin request to srv.
+func adminRequest(srv *Server, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestServer_handleCacheStats(t *testing.T) {
+	backend := newCacheBackend()
+	srv := New(Config{AdminTokens: []string{"admin"}}, backend, slog.Default())
+
+	rr := adminRequest(srv, http.MethodGet, "/admin/cache")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	var status cacheStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if status.StorageType != "mock" || status.Entries != 3 || status.TTLSeconds != 300 || status.LastRefreshDurationMS != 1500 {
+		t.Errorf("status = %+v", status)
+	}
+	if status.RefreshedAt == nil || status.AgeSeconds < 60 {
+		t.Errorf("RefreshedAt = %v, AgeSeconds = %v; want about a minute ago", status.RefreshedAt, status.AgeSeconds)
+	}
+	if len(status.Errors) != 1 || status.Errors[0].Error != "bucket unavailable" {
+		t.Errorf("Errors = %+v", status.Errors)
+	}
+}
+
+func TestServer_handleCacheInvalidate(t *testing.T) {
+	backend := newCacheBackend()
+	srv := New(Config{AdminTokens: []string{"admin"}}, backend, slog.Default())
+
+	rr := adminRequest(srv, http.MethodDelete, "/admin/cache")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if !backend.invalidated {
+		t.Error("cache not invalidated")
+	}
+
+	var status map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if _, ok := status["refreshed_at"]; ok || status["entries"] != float64(0) {
+		t.Errorf("status = %v, want an empty cache", status)
+	}
+}
+
+func TestServer_handleReindex(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		reindexErr     error
+		expectedStatus int
+		wantPrefix     string
+	}{
+		{
+			name:           "everything",
+			target:         "/admin/reindex",
+			expectedStatus: http.StatusOK,
+			wantPrefix:     "",
+		},
+		{
+			name:           "prefix",
+			target:         "/admin/reindex?prefix=/team-a/go/",
+			expectedStatus: http.StatusOK,
+			wantPrefix:     "team-a/go",
+		},
+		{
+			name:           "escaping prefix",
+			target:         "/admin/reindex?prefix=../secrets",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "backend error",
+			target:         "/admin/reindex",
+			reindexErr:     errors.New("bucket unavailable"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newCacheBackend()
+			backend.reindexErr = tt.reindexErr
+			srv := New(Config{AdminTokens: []string{"admin"}}, backend, slog.Default())
+
+			rr := adminRequest(srv, http.MethodPost, tt.target)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if len(backend.reindexed) != 1 || backend.reindexed[0] != tt.wantPrefix {
+				t.Errorf("reindexed = %q, want [%q]", backend.reindexed, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestServer_handleQuarantine(t *testing.T) {
+	srv := New(Config{AdminTokens: []string{"admin"}}, newCacheBackend(), slog.Default())
+
+	rr := adminRequest(srv, http.MethodGet, "/admin/quarantine")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	var status quarantineStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := quarantineStatus{
+		StorageType: "mock",
+		Databases:   []quarantinedDatabase{{Path: "team/broken.zip", Problems: []string{"database is not finalised"}}},
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("status = %+v, want %+v", status, want)
+	}
+}
+
+func TestServer_CacheAdmin_Unsupported(t *testing.T) {
+	srv := New(Config{AdminTokens: []string{"admin"}}, &mockBackend{typeStr: "mock"}, slog.Default())
+
+	for _, tt := range []struct{ method, target string }{
+		{http.MethodGet, "/admin/cache"},
+		{http.MethodDelete, "/admin/cache"},
+		{http.MethodPost, "/admin/reindex"},
+		{http.MethodGet, "/admin/quarantine"},
+	} {
+		if rr := adminRequest(srv, tt.method, tt.target); rr.Code != http.StatusNotImplemented {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rr.Code, http.StatusNotImplemented)
+		}
+	}
+}
+
+func TestServer_CacheAdmin_RequiresAdminToken(t *testing.T) {
+	srv := New(Config{Tokens: []string{"client"}, AdminTokens: []string{"admin"}}, newCacheBackend(), slog.Default())
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/cache", nil)
+	req.Header.Set("Authorization", "Bearer client")
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
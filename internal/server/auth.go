@@ -0,0 +1,41 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authMiddleware requires a configured bearer token on every request except
+// health checks and probes, which must be reachable without credentials,
+// and the administrative endpoints, which check admin tokens instead. Without
+// configured tokens all requests are allowed. The tokens are those of the
+// request's generation, so they change with a reload.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens := s.generation(r).config.Tokens
+		if len(tokens) == 0 || isProbePath(r.URL.Path) || isAdminPath(r.URL.Path) || validToken(r, tokens) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.logger.Warn("unauthorized request", "method", r.Method, "path", r.URL.Path)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="hepc"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// validToken reports whether the request carries one of the given bearer
+// tokens. Tokens are compared in constant time.
+func validToken(r *http.Request, tokens []string) bool {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return false
+	}
+	valid := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
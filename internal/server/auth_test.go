@@ -0,0 +1,84 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_authMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		tokens         []string
+		path           string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			name:           "no tokens configured",
+			path:           "/db/test.zip",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing token",
+			tokens:         []string{"secret"},
+			path:           "/db/test.zip",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong token",
+			tokens:         []string{"secret"},
+			path:           "/db/test.zip",
+			authorization:  "Bearer wrong",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong scheme",
+			tokens:         []string{"secret"},
+			path:           "/db/test.zip",
+			authorization:  "Basic secret",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "second token",
+			tokens:         []string{"secret", "rotated"},
+			path:           "/db/test.zip",
+			authorization:  "bearer rotated",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "health without token",
+			tokens:         []string{"secret"},
+			path:           "/health",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "database download without token",
+			tokens:         []string{"secret"},
+			path:           "/db/test.zip",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &mockBackend{typeStr: "local", metadata: advertising("test.zip"), fileContent: "x", fileSize: 1, fileType: "application/zip"}
+			srv := New(Config{Tokens: tt.tokens}, backend, slog.Default())
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.expectedStatus)
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Response encodings negotiated with Accept-Encoding, in order of
+// preference when a client accepts several equally.
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+)
+
+// negotiateEncoding returns the encoding to compress a response with for
+// the given Accept-Encoding header, or "" to send it uncompressed. Quality
+// values are honoured, and "*" stands for any encoding not listed.
+func negotiateEncoding(header string) string {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				parsed = 0
+			}
+			q = parsed
+		}
+		qualities[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range []string{encodingZstd, encodingGzip} {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// newEncoder returns a writer compressing to w with encoding.
+func newEncoder(w io.Writer, encoding string) (io.WriteCloser, error) {
+	if encoding == encodingZstd {
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return gzip.NewWriter(w), nil
+}
+
+// compress returns data compressed with encoding.
+func compress(data []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc, err := newEncoder(&buf, encoding)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := enc.Write(data); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressible reports whether responses of the given content type are
+// compressed: the JSON and JSONL bodies of the metadata, lookup and admin
+// endpoints.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "application/x-ndjson" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// writeEncoded writes a body that is already compressed with encoding.
+func writeEncoded(w http.ResponseWriter, contentType, encoding string, body []byte) error {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, err := w.Write(body)
+	return err
+}
+
+// compressionMiddleware compresses JSON responses for clients that accept
+// gzip or zstd. Handlers that send a body they compressed themselves, such
+// as the cached index, set Content-Encoding and are passed through.
+// Database files under /db/ are never compressed: archives are compressed
+// already, and byte ranges refer to the stored file.
+func (s *Server) compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/db/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		next.ServeHTTP(cw, r)
+		if err := cw.close(); err != nil {
+			s.logger.Error("failed to compress response", "error", err)
+		}
+	})
+}
+
+// compressWriter compresses the body written through it if its headers,
+// as set when it is first written, show a compressible response.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	wroteHeader bool
+	enc         io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	// File downloads, which answer ranges, are sent as stored
+	h := cw.Header()
+	if status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && h.Get("Accept-Ranges") == "" && compressible(h.Get("Content-Type")) {
+		enc, err := newEncoder(cw.ResponseWriter, cw.encoding)
+		if err == nil {
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			cw.enc = enc
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// close flushes the compressed body.
+func (cw *compressWriter) close() error {
+	if cw.enc == nil {
+		return nil
+	}
+	return cw.enc.Close()
+}
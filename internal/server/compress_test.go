@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "identity", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "gzip, deflate, br, zstd", want: "zstd"},
+		{header: "GZIP;q=0.5, zstd;q=0.4", want: "gzip"},
+		{header: "zstd;q=0, gzip", want: "gzip"},
+		{header: "*", want: "zstd"},
+		{header: "*;q=0.5, zstd;q=0", want: "gzip"},
+		{header: "gzip;q=bogus", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := negotiateEncoding(tt.header); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+// decode returns body decoded from encoding.
+func decode(t *testing.T, body []byte, encoding string) []byte {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "":
+		return body
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid gzip body: %v", err)
+		}
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid zstd body: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		t.Fatalf("unexpected Content-Encoding %q", encoding)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to decode %s body: %v", encoding, err)
+	}
+	return data
+}
+
+// fixedIndexBackend serves the same index until its records change, as the
+// built-in backends do between refreshes.
+type fixedIndexBackend struct {
+	*mockBackend
+	idx *storage.Index
+}
+
+func (b *fixedIndexBackend) Index(ctx context.Context) (*storage.Index, error) {
+	return b.idx, nil
+}
+
+func TestServer_Compression(t *testing.T) {
+	metadata := advertising("team/repo.zip", "team/other.zip")
+	metadata[0].ContentHash = "abc"
+	records := api.ToV2(metadata)
+	records[0].Team = "platform"
+	backend := &fixedIndexBackend{
+		mockBackend: &mockBackend{
+			typeStr:        "local",
+			metadata:       metadata,
+			metadataExists: true,
+			fileContent:    `{"not":"compressed"}`,
+			fileSize:       20,
+			fileType:       "application/json",
+		},
+		idx: storage.NewIndex(records),
+	}
+	handler := New(Config{}, backend, slog.Default()).Handler()
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want 200", path, w.Code)
+		}
+		return w
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{name: "index uncompressed", path: "/index", wantEncoding: ""},
+		{name: "index gzip", path: "/index", acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "index zstd", path: "/api/v1/latest_results/codeql-all", acceptEncoding: "gzip, zstd", wantEncoding: "zstd"},
+		{name: "filtered index", path: "/index?team=platform", acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "v2 index", path: "/api/v2/index", acceptEncoding: "zstd", wantEncoding: "zstd"},
+		{name: "filtered v2 index", path: "/api/v2/index?team=platform", acceptEncoding: "zstd", wantEncoding: "zstd"},
+		{name: "lookup", path: "/api/v1/databases/abc", acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "schema", path: "/api/v2/schema.json", acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "database file", path: "/db/team/repo.zip", acceptEncoding: "gzip, zstd", wantEncoding: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := get(tt.path, "")
+			w := get(tt.path, tt.acceptEncoding)
+
+			encoding := w.Header().Get("Content-Encoding")
+			if encoding != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", encoding, tt.wantEncoding)
+			}
+			if got := decode(t, w.Body.Bytes(), encoding); !bytes.Equal(got, plain.Body.Bytes()) {
+				t.Errorf("decoded body = %q, want %q", got, plain.Body.String())
+			}
+			if got, want := w.Header().Get("Content-Type"), plain.Header().Get("Content-Type"); got != want {
+				t.Errorf("Content-Type = %q, want %q", got, want)
+			}
+		})
+	}
+
+	if vary := get("/index", "").Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", vary)
+	}
+
+	// The compressed index is kept with the index it was built from
+	cached, err := backend.idx.Encoded("v1.jsonl.gzip", func() ([]byte, error) {
+		t.Error("compressed index was not cached")
+		return nil, nil
+	})
+	if err != nil || !bytes.Equal(cached, get("/index", "gzip").Body.Bytes()) {
+		t.Errorf("cached compressed index = %d bytes, %v; want the served body", len(cached), err)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// memberSeparator separates the path of a database from the path of a file
+// inside it in /db/ URLs, e.g. /db/team/repo.zip/-/files/codeql-database.yml.
+// Without a file path the URL lists the files of the database.
+const memberSeparator = "/-/files"
+
+// splitMemberPath splits a /db/ path addressing the files of a database into
+// the path of the database and of the file, which is empty for a listing.
+func splitMemberPath(requestedPath string) (dbPath, member string, ok bool) {
+	dbPath, rest, ok := strings.Cut(requestedPath, memberSeparator)
+	if !ok || dbPath == "" || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return "", "", false
+	}
+	return dbPath, strings.TrimPrefix(rest, "/"), true
+}
+
+// memberList is the response of a database file listing.
+type memberList struct {
+	Database string        `json:"database"`
+	Files    []memberEntry `json:"files"`
+}
+
+// memberEntry describes one file in a memberList.
+type memberEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// serveMember lists the files of the database at dbPath, or serves the file
+// member from inside it. The database must be advertised, as for full
+// downloads.
+func (s *Server) serveMember(w http.ResponseWriter, r *http.Request, dbPath, member string) {
+	backend := s.storage(r)
+	idx, err := backend.Index(r.Context())
+	if err != nil {
+		s.logger.Error("error loading metadata index", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	m, ok := selectArtifactRecord(idx.ByPath(dbPath), "")
+	if !ok {
+		s.logger.Warn("refusing files of unadvertised database", "path", dbPath)
+		http.Error(w, fmt.Sprintf("%s not found", dbPath), http.StatusNotFound)
+		return
+	}
+	reader, ok := backend.(storage.MemberReader)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s storage cannot serve files inside databases", backend.Type()), http.StatusNotImplemented)
+		return
+	}
+
+	if member == "" {
+		s.listMembers(w, r, reader, dbPath)
+		return
+	}
+	if !storage.ValidMember(member) {
+		http.Error(w, "invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	s.logger.Info("serving database file", "database", dbPath, "file", member)
+	content, size, err := reader.GetMember(r.Context(), dbPath, member)
+	if err != nil {
+		s.memberError(w, dbPath, member, err)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(member))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// The database's content hash covers its files too
+	setETag(w.Header(), m)
+	if r.Method == http.MethodHead {
+		if err := content.Close(); err != nil {
+			s.logger.Error("failed to close reader", "error", err)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.writeFile(w, r, dbPath+memberSeparator+"/"+member, content, size, contentType, nil)
+}
+
+// listMembers writes the files of the database at dbPath, sorted by path.
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request, reader storage.MemberReader, dbPath string) {
+	members, err := reader.ListMembers(r.Context(), dbPath)
+	if err != nil {
+		s.memberError(w, dbPath, "", err)
+		return
+	}
+
+	list := memberList{Database: dbPath, Files: make([]memberEntry, len(members))}
+	for i, m := range members {
+		list.Files[i] = memberEntry{Path: m.Path, Size: m.Size}
+	}
+	sort.Slice(list.Files, func(i, j int) bool { return list.Files[i].Path < list.Files[j].Path })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// memberError writes the response for a failure to read the files of a
+// database.
+func (s *Server) memberError(w http.ResponseWriter, dbPath, member string, err error) {
+	var notFound *storage.ErrNotFound
+	if errors.As(err, &notFound) {
+		http.Error(w, fmt.Sprintf("%s not found", path.Join(dbPath, member)), http.StatusNotFound)
+		return
+	}
+	s.logger.Error("error reading database files", "database", dbPath, "file", member, "error", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// memberBackend is a mock backend serving the files of its databases.
+type memberBackend struct {
+	*mockBackend
+	members map[string]map[string]string // database path to file contents
+}
+
+func (b *memberBackend) ListMembers(ctx context.Context, dbPath string) ([]storage.Member, error) {
+	files, ok := b.members[dbPath]
+	if !ok {
+		return nil, &storage.ErrNotFound{Path: dbPath}
+	}
+	var members []storage.Member
+	for name, content := range files {
+		members = append(members, storage.Member{Path: name, Size: int64(len(content))})
+	}
+	return members, nil
+}
+
+func (b *memberBackend) GetMember(ctx context.Context, dbPath, member string) (io.ReadCloser, int64, error) {
+	content, ok := b.members[dbPath][member]
+	if !ok {
+		return nil, 0, &storage.ErrNotFound{Path: member}
+	}
+	return io.NopCloser(strings.NewReader(content)), int64(len(content)), nil
+}
+
+func newMemberBackend() *memberBackend {
+	return &memberBackend{
+		mockBackend: &mockBackend{typeStr: "local", metadata: advertising("team/repo.zip", "team/other.zip")},
+		members: map[string]map[string]string{
+			"team/repo.zip": {
+				"codeql-database.yml":   "primaryLanguage: go\n",
+				"baseline-info.json":    `{"languages":{}}`,
+				"db-go/default/strings": "0123456789",
+			},
+			"team/secret.zip": {"codeql-database.yml": "unadvertised"},
+		},
+	}
+}
+
+func TestSplitMemberPath(t *testing.T) {
+	tests := []struct {
+		requestedPath string
+		wantDB        string
+		wantMember    string
+		wantOK        bool
+	}{
+		{"team/repo.zip/-/files", "team/repo.zip", "", true},
+		{"team/repo.zip/-/files/", "team/repo.zip", "", true},
+		{"team/repo.zip/-/files/codeql-database.yml", "team/repo.zip", "codeql-database.yml", true},
+		{"team/repo.zip/-/files/db-go/default/strings", "team/repo.zip", "db-go/default/strings", true},
+		{"team/repo.zip/-/filesystem", "", "", false},
+		{"/-/files/x", "", "", false},
+		{"team/repo.zip", "", "", false},
+	}
+
+	for _, tt := range tests {
+		dbPath, member, ok := splitMemberPath(tt.requestedPath)
+		if dbPath != tt.wantDB || member != tt.wantMember || ok != tt.wantOK {
+			t.Errorf("splitMemberPath(%q) = %q, %q, %v; want %q, %q, %v", tt.requestedPath, dbPath, member, ok, tt.wantDB, tt.wantMember, tt.wantOK)
+		}
+	}
+}
+
+func TestServer_serveMember(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		rangeHeader    string
+		expectedStatus int
+		expectedBody   string
+		expectedType   string
+		expectedLength string
+	}{
+		{
+			name:           "file",
+			path:           "/db/team/repo.zip/-/files/codeql-database.yml",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "primaryLanguage: go\n",
+			expectedLength: "20",
+		},
+		{
+			name:           "content type from extension",
+			path:           "/db/team/repo.zip/-/files/baseline-info.json",
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/json",
+		},
+		{
+			name:           "range of a file",
+			path:           "/db/team/repo.zip/-/files/db-go/default/strings",
+			rangeHeader:    "bytes=2-4",
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "234",
+		},
+		{
+			name:           "head of a file",
+			method:         http.MethodHead,
+			path:           "/db/team/repo.zip/-/files/db-go/default/strings",
+			expectedStatus: http.StatusOK,
+			expectedLength: "10",
+		},
+		{
+			name:           "missing file",
+			path:           "/db/team/repo.zip/-/files/missing.txt",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "database without files",
+			path:           "/db/team/other.zip/-/files",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "unadvertised database",
+			path:           "/db/team/secret.zip/-/files/codeql-database.yml",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "unadvertised database listing",
+			path:           "/db/team/secret.zip/-/files",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{}, newMemberBackend(), slog.Default())
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rr := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedStatus)
+			}
+			if tt.expectedBody != "" && rr.Body.String() != tt.expectedBody {
+				t.Errorf("body = %q, want %q", rr.Body.String(), tt.expectedBody)
+			}
+			if tt.expectedType != "" && rr.Header().Get("Content-Type") != tt.expectedType {
+				t.Errorf("Content-Type = %q, want %q", rr.Header().Get("Content-Type"), tt.expectedType)
+			}
+			if tt.expectedLength != "" && rr.Header().Get("Content-Length") != tt.expectedLength {
+				t.Errorf("Content-Length = %q, want %q", rr.Header().Get("Content-Length"), tt.expectedLength)
+			}
+			if method == http.MethodHead && rr.Body.Len() != 0 {
+				t.Errorf("body length = %d, want 0", rr.Body.Len())
+			}
+		})
+	}
+}
+
+func TestServer_serveMember_List(t *testing.T) {
+	srv := New(Config{}, newMemberBackend(), slog.Default())
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/db/team/repo.zip/-/files", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	var list memberList
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := memberList{
+		Database: "team/repo.zip",
+		Files: []memberEntry{
+			{Path: "baseline-info.json", Size: 16},
+			{Path: "codeql-database.yml", Size: 20},
+			{Path: "db-go/default/strings", Size: 10},
+		},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("listing = %+v, want %+v", list, want)
+	}
+}
+
+func TestServer_serveMember_Unsupported(t *testing.T) {
+	backend := &mockBackend{typeStr: "hepc", metadata: advertising("team/repo.zip")}
+	srv := New(Config{}, backend, slog.Default())
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/db/team/repo.zip/-/files", nil))
+
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusNotImplemented)
+	}
+}
+
+func TestServer_serveMember_RequiresToken(t *testing.T) {
+	srv := New(Config{Tokens: []string{"secret"}}, newMemberBackend(), slog.Default())
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/db/team/repo.zip/-/files/codeql-database.yml", nil))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
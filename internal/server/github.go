@@ -0,0 +1,176 @@
+package server
+
+import (
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// githubEnterpriseAPIPrefix is the path prefix of the REST API on GitHub
+// Enterprise Server, so clients can use "<server>/api/v3" as their API base URL.
+const githubEnterpriseAPIPrefix = "/api/v3"
+
+// githubDatabase mirrors the CodeQL database object returned by GitHub's
+// "List CodeQL databases for a repository" and "Get a CodeQL database for a
+// repository" REST endpoints.
+type githubDatabase struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Language    string `json:"language"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+	URL         string `json:"url"`
+	CommitOID   string `json:"commit_oid"`
+}
+
+// handleGitHubDatabases lists the latest database per language for a repository.
+func (s *Server) handleGitHubDatabases(w http.ResponseWriter, r *http.Request) {
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+
+	latest, ok := s.latestDatabasesByLanguage(w, r, owner, repo)
+	if !ok {
+		return
+	}
+
+	languages := make([]string, 0, len(latest))
+	for lang := range latest {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+
+	databases := make([]githubDatabase, 0, len(languages))
+	for _, lang := range languages {
+		databases = append(databases, toGitHubDatabase(latest[lang], githubDatabaseURL(r, owner, repo, lang)))
+	}
+	writeJSON(w, http.StatusOK, databases)
+}
+
+// handleGitHubDatabase describes the latest database of one language for a
+// repository, or streams the database when the client accepts application/zip.
+func (s *Server) handleGitHubDatabase(w http.ResponseWriter, r *http.Request) {
+	owner, repo, language := r.PathValue("owner"), r.PathValue("repo"), r.PathValue("language")
+
+	latest, ok := s.latestDatabasesByLanguage(w, r, owner, repo)
+	if !ok {
+		return
+	}
+	m, found := latest[language]
+	if !found {
+		writeGitHubError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "application/zip") {
+		writeJSON(w, http.StatusOK, toGitHubDatabase(m, githubDatabaseURL(r, owner, repo, language)))
+		return
+	}
+
+	artifact, ok := storage.ArtifactPath(m.ResultURL)
+	if !ok {
+		s.logger.Error("cannot determine database path", "result_url", m.ResultURL)
+		writeGitHubError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	s.serveFile(w, r, artifact)
+}
+
+// latestDatabasesByLanguage returns the most recent database per language of
+// a repository, matching owner and repo case-insensitively as GitHub does.
+// Unarchived databases are left out, as GitHub serves every database as a
+// zip archive. It writes an error response and returns false on failure.
+func (s *Server) latestDatabasesByLanguage(w http.ResponseWriter, r *http.Request, owner, repo string) (map[string]api.DatabaseMetadata, bool) {
+	idx, err := s.storage(r).Index(r.Context())
+	if err != nil {
+		s.logger.Error("error loading metadata", "error", err)
+		writeGitHubError(w, http.StatusInternalServerError, "Internal Server Error")
+		return nil, false
+	}
+
+	latest := make(map[string]api.DatabaseMetadata)
+	for _, m := range idx.ByProject(owner, repo) {
+		// Records of unknown format, such as those of a v1 upstream, are
+		// served as the archives the v1 API describes
+		if m.Format == api.FormatUnarchived {
+			continue
+		}
+		if cur, ok := latest[m.PrimaryLanguage]; !ok || m.IngestionDatetimeUTC > cur.IngestionDatetimeUTC {
+			latest[m.PrimaryLanguage] = m.DatabaseMetadata
+		}
+	}
+
+	if len(latest) == 0 {
+		writeGitHubError(w, http.StatusNotFound, "Not Found")
+		return nil, false
+	}
+	return latest, true
+}
+
+// toGitHubDatabase converts a metadata record to GitHub's database shape.
+func toGitHubDatabase(m api.DatabaseMetadata, databaseURL string) githubDatabase {
+	name := m.Projname
+	if artifact, ok := storage.ArtifactPath(m.ResultURL); ok {
+		name = path.Base(artifact)
+	}
+
+	return githubDatabase{
+		ID:          databaseID(m.ContentHash),
+		Name:        name,
+		Language:    m.PrimaryLanguage,
+		ContentType: "application/zip",
+		Size:        m.DBFileSize,
+		CreatedAt:   m.IngestionDatetimeUTC,
+		UpdatedAt:   m.IngestionDatetimeUTC,
+		URL:         databaseURL,
+		CommitOID:   m.GitCommitID,
+	}
+}
+
+// databaseID derives a stable numeric ID from a content hash. The first 52
+// bits of a hex hash are used so the ID is exactly representable in
+// JavaScript clients; other hashes fall back to FNV-1a.
+func databaseID(contentHash string) int64 {
+	if len(contentHash) >= 13 {
+		if id, err := strconv.ParseInt(contentHash[:13], 16, 64); err == nil {
+			return id
+		}
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(contentHash)) //nolint:errcheck // hash.Hash never returns an error
+	return int64(h.Sum64() >> 12)       //nolint:gosec // Shifted to 52 bits, cannot overflow
+}
+
+// githubDatabaseURL returns the API URL of a repository database as seen by
+// the client, keeping the GitHub Enterprise prefix if the request used it.
+func githubDatabaseURL(r *http.Request, owner, repo, language string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	prefix := ""
+	if strings.HasPrefix(r.URL.Path, githubEnterpriseAPIPrefix+"/") {
+		prefix = githubEnterpriseAPIPrefix
+	}
+
+	return scheme + "://" + r.Host + prefix + "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo) +
+		"/code-scanning/codeql/databases/" + url.PathEscape(language)
+}
+
+// writeGitHubError writes an error in GitHub's REST API format.
+func writeGitHubError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct {
+		Message string `json:"message"`
+	}{Message: message})
+}
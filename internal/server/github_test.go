@@ -0,0 +1,278 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+)
+
+func githubTestMetadata() []api.DatabaseMetadata {
+	return []api.DatabaseMetadata{
+		{
+			ContentHash:          "0123456789abcdef",
+			GitOwner:             "Octo",
+			GitRepo:              "Hello",
+			GitCommitID:          "old",
+			PrimaryLanguage:      "go",
+			IngestionDatetimeUTC: "2024-01-01T00:00:00Z",
+			ResultURL:            "http://localhost:8080/db/octo/hello-go-old.zip",
+			DBFileSize:           100,
+		},
+		{
+			ContentHash:          "fedcba9876543210",
+			GitOwner:             "octo",
+			GitRepo:              "hello",
+			GitCommitID:          "new",
+			PrimaryLanguage:      "go",
+			IngestionDatetimeUTC: "2024-02-01T00:00:00Z",
+			ResultURL:            "http://localhost:8080/db/octo/hello-go.zip",
+			DBFileSize:           200,
+		},
+		{
+			ContentHash:          "aaaaaaaaaaaaaaaa",
+			GitOwner:             "octo",
+			GitRepo:              "hello",
+			PrimaryLanguage:      "python",
+			IngestionDatetimeUTC: "2024-01-15T00:00:00Z",
+			ResultURL:            "http://localhost:8080/db/octo/multi.zip?language=python",
+			DBFileSize:           300,
+		},
+		{
+			ContentHash:     "bbbbbbbbbbbbbbbb",
+			GitOwner:        "other",
+			GitRepo:         "repo",
+			PrimaryLanguage: "go",
+			ResultURL:       "http://localhost:8080/db/other/repo.zip",
+		},
+	}
+}
+
+func TestServer_handleGitHubDatabases(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		backend        *mockBackend
+		expectedStatus int
+		expectedLangs  []string
+		expectedURL    string
+	}{
+		{
+			name:           "latest database per language",
+			path:           "/repos/OCTO/hello/code-scanning/codeql/databases",
+			backend:        &mockBackend{typeStr: "local", metadata: githubTestMetadata()},
+			expectedStatus: http.StatusOK,
+			expectedLangs:  []string{"go", "python"},
+			expectedURL:    "http://example.com/repos/OCTO/hello/code-scanning/codeql/databases/go",
+		},
+		{
+			name:           "enterprise prefix",
+			path:           "/api/v3/repos/octo/hello/code-scanning/codeql/databases",
+			backend:        &mockBackend{typeStr: "local", metadata: githubTestMetadata()},
+			expectedStatus: http.StatusOK,
+			expectedLangs:  []string{"go", "python"},
+			expectedURL:    "http://example.com/api/v3/repos/octo/hello/code-scanning/codeql/databases/go",
+		},
+		{
+			name:           "unknown repository",
+			path:           "/repos/nobody/nothing/code-scanning/codeql/databases",
+			backend:        &mockBackend{typeStr: "local", metadata: githubTestMetadata()},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "metadata error",
+			path:           "/repos/octo/hello/code-scanning/codeql/databases",
+			backend:        &mockBackend{typeStr: "local", metadataError: errors.New("boom")},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{}, tt.backend, slog.Default())
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rr := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				var body struct {
+					Message string `json:"message"`
+				}
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || body.Message == "" {
+					t.Errorf("error body = %q, %v; want GitHub error message", rr.Body.String(), err)
+				}
+				return
+			}
+
+			var databases []githubDatabase
+			if err := json.NewDecoder(rr.Body).Decode(&databases); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(databases) != len(tt.expectedLangs) {
+				t.Fatalf("got %d databases, want %d", len(databases), len(tt.expectedLangs))
+			}
+			for i, lang := range tt.expectedLangs {
+				if databases[i].Language != lang {
+					t.Errorf("databases[%d].Language = %q, want %q", i, databases[i].Language, lang)
+				}
+			}
+
+			goDB := databases[0]
+			if goDB.CommitOID != "new" || goDB.Size != 200 || goDB.Name != "hello-go.zip" {
+				t.Errorf("go database = %+v, want latest record", goDB)
+			}
+			if goDB.URL != tt.expectedURL {
+				t.Errorf("URL = %q, want %q", goDB.URL, tt.expectedURL)
+			}
+			if goDB.ContentType != "application/zip" || goDB.ID == 0 {
+				t.Errorf("go database = %+v, want content type and ID", goDB)
+			}
+			if databases[1].Name != "multi.zip" {
+				t.Errorf("python database Name = %q, want %q", databases[1].Name, "multi.zip")
+			}
+		})
+	}
+}
+
+func TestServer_handleGitHubDatabase(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		accept         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "describe database",
+			path:           "/repos/octo/hello/code-scanning/codeql/databases/go",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "download database",
+			path:           "/api/v3/repos/octo/hello/code-scanning/codeql/databases/go",
+			accept:         "application/zip",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "zip content",
+		},
+		{
+			name:           "unknown language",
+			path:           "/repos/octo/hello/code-scanning/codeql/databases/ruby",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &mockBackend{
+				typeStr:     "local",
+				metadata:    githubTestMetadata(),
+				fileContent: "zip content",
+				fileSize:    11,
+				fileType:    "application/zip",
+			}
+			srv := New(Config{}, backend, slog.Default())
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedStatus)
+			}
+			if tt.expectedBody != "" {
+				if rr.Body.String() != tt.expectedBody {
+					t.Errorf("body = %q, want %q", rr.Body.String(), tt.expectedBody)
+				}
+				return
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var db githubDatabase
+			if err := json.NewDecoder(rr.Body).Decode(&db); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if db.Language != "go" || db.CommitOID != "new" {
+				t.Errorf("database = %+v, want latest go database", db)
+			}
+		})
+	}
+}
+
+func TestServer_GitHubUnarchivedDatabases(t *testing.T) {
+	records := api.ToV2(githubTestMetadata())
+	for i := range records {
+		records[i].Format = api.FormatArchived
+	}
+	records = append(records,
+		api.DatabaseMetadataV2{
+			DatabaseMetadata: api.DatabaseMetadata{
+				ContentHash:          "cccccccccccccccc",
+				GitOwner:             "octo",
+				GitRepo:              "hello",
+				GitCommitID:          "newest",
+				PrimaryLanguage:      "go",
+				IngestionDatetimeUTC: "2024-03-01T00:00:00Z",
+				ResultURL:            "http://localhost:8080/db/octo/hello-go",
+			},
+			Format: api.FormatUnarchived,
+		},
+		api.DatabaseMetadataV2{
+			DatabaseMetadata: api.DatabaseMetadata{
+				ContentHash:     "dddddddddddddddd",
+				GitOwner:        "octo",
+				GitRepo:         "hello",
+				PrimaryLanguage: "java",
+				ResultURL:       "http://localhost:8080/db/octo/hello-java",
+			},
+			Format: api.FormatUnarchived,
+		},
+	)
+	srv := New(Config{}, &mockBackend{
+		typeStr:     "local",
+		records:     records,
+		fileContent: "zip content",
+		fileSize:    11,
+		fileType:    "application/zip",
+	}, slog.Default())
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Directory databases are not listed; the latest archive is
+	rr := get("/repos/octo/hello/code-scanning/codeql/databases", "")
+	var databases []githubDatabase
+	if err := json.NewDecoder(rr.Body).Decode(&databases); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(databases) != 2 || databases[0].Language != "go" || databases[0].CommitOID != "new" || databases[1].Language != "python" {
+		t.Errorf("databases = %+v, want the go and python archives", databases)
+	}
+
+	for _, accept := range []string{"", "application/zip"} {
+		if rr := get("/repos/octo/hello/code-scanning/codeql/databases/java", accept); rr.Code != http.StatusNotFound {
+			t.Errorf("java database with Accept %q status = %d, want %d", accept, rr.Code, http.StatusNotFound)
+		}
+	}
+	if rr := get("/repos/octo/hello/code-scanning/codeql/databases/go", "application/zip"); rr.Code != http.StatusOK || rr.Body.String() != "zip content" {
+		t.Errorf("go database download = %d %q, want the archive", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// contentHashHeader and hashKindHeader carry the content hash of a
+// database archive served from /db/ and its api.HashKind* kind.
+const (
+	contentHashHeader = "X-Content-Hash"
+	hashKindHeader    = "X-Hash-Kind"
+)
+
+// setContentHash sets the content hash headers for the archive m describes.
+// They are sent only if the hash covers the archive itself: hashes of an
+// unknown kind are left out, and the per-language hashes of multi-language
+// archives are declared to be of the "language" kind, without the hash.
+func setContentHash(h http.Header, m api.DatabaseMetadataV2) {
+	if m.HashKind == "" {
+		return
+	}
+	if len(m.Languages) > 1 {
+		h.Set(hashKindHeader, api.HashKindLanguage)
+		return
+	}
+	h.Set(contentHashHeader, m.ContentHash)
+	h.Set(hashKindHeader, m.HashKind)
+}
+
+// setETag sets the ETag header for the artifact m describes, or for a file
+// inside it, if its content hash changes with the content. Resumed downloads
+// send it back in If-Range so that a replaced artifact is sent in full.
+func setETag(h http.Header, m api.DatabaseMetadataV2) {
+	if m.HashKind == "" || m.HashKind == api.HashKindPath {
+		return
+	}
+	h.Set("ETag", `"`+m.ContentHash+`"`)
+}
+
+// handleDatabase serves the metadata record with a given content hash, in
+// the v1 or v2 format depending on the request path.
+func (s *Server) handleDatabase(w http.ResponseWriter, r *http.Request) {
+	contentHash := r.PathValue("hash")
+
+	idx, err := s.storage(r).Index(r.Context())
+	if err != nil {
+		s.logger.Error("error loading metadata", "error", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	m, ok := idx.ByHash(contentHash)
+	if !ok {
+		http.Error(w, fmt.Sprintf("database %s not found", contentHash), http.StatusNotFound)
+		return
+	}
+	if isV2(r) {
+		writeJSON(w, http.StatusOK, m)
+		return
+	}
+	writeJSON(w, http.StatusOK, m.DatabaseMetadata)
+}
+
+// handleRepoDatabases serves all metadata records of a repository as a JSON
+// array, optionally restricted to one language with ?language=. Records use
+// the v1 or v2 format depending on the request path.
+func (s *Server) handleRepoDatabases(w http.ResponseWriter, r *http.Request) {
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+
+	idx, err := s.storage(r).Index(r.Context())
+	if err != nil {
+		s.logger.Error("error loading metadata", "error", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	records := idx.ByProject(owner, repo)
+	if language := r.URL.Query().Get("language"); language != "" {
+		records = filterLanguage(records, language)
+	}
+	if len(records) == 0 {
+		http.Error(w, fmt.Sprintf("no databases found for %s/%s", owner, repo), http.StatusNotFound)
+		return
+	}
+	if isV2(r) {
+		writeJSON(w, http.StatusOK, records)
+		return
+	}
+	writeJSON(w, http.StatusOK, api.ToV1(records))
+}
+
+// handleHeadFile answers HEAD requests for database files with their size
+// and, where known, content hash but no body. Indexed archives are answered from the
+// metadata index; files of database directories are looked up in the
+// storage backend. Like GET, it answers only for advertised paths.
+func (s *Server) handleHeadFile(w http.ResponseWriter, r *http.Request) {
+	requestedPath := r.PathValue("filepath")
+	if requestedPath == "" {
+		http.Error(w, "file path required", http.StatusBadRequest)
+		return
+	}
+	if dbPath, member, ok := splitMemberPath(requestedPath); ok {
+		s.serveMember(w, r, dbPath, member)
+		return
+	}
+	idx, ok := s.advertisedIndex(w, r, requestedPath)
+	if !ok {
+		return
+	}
+
+	// Database directories are indexed too but are not servable, and must
+	// report the same status as a GET
+	if strings.HasSuffix(requestedPath, ".zip") {
+		if m, ok := selectArtifactRecord(idx.ByPath(requestedPath), r.URL.Query().Get("language")); ok {
+			contentType := mime.TypeByExtension(path.Ext(requestedPath))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			w.Header().Set("Content-Type", contentType)
+			if m.DBFileSize > 0 {
+				w.Header().Set("Content-Length", strconv.FormatInt(m.DBFileSize, 10))
+			}
+			setContentHash(w.Header(), m)
+			setETag(w.Header(), m)
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	reader, size, contentType, err := s.storage(r).GetFile(r.Context(), requestedPath)
+	if err != nil {
+		var notFound *storage.ErrNotFound
+		if errors.As(err, &notFound) {
+			http.Error(w, fmt.Sprintf("%s not found", requestedPath), http.StatusNotFound)
+			return
+		}
+		s.logger.Error("error accessing file", "path", requestedPath, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	setLastModified(w.Header(), reader)
+	if err := reader.Close(); err != nil {
+		s.logger.Error("failed to close reader", "error", err)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// selectArtifactRecord picks the record describing an artifact. For
+// multi-language archives the language selects the record; without one the
+// first record is used.
+func selectArtifactRecord(records []api.DatabaseMetadataV2, language string) (api.DatabaseMetadataV2, bool) {
+	if language != "" {
+		records = filterLanguage(records, language)
+	}
+	if len(records) == 0 {
+		return api.DatabaseMetadataV2{}, false
+	}
+	return records[0], true
+}
+
+// filterLanguage returns the records whose primary language is language.
+func filterLanguage(records []api.DatabaseMetadataV2, language string) []api.DatabaseMetadataV2 {
+	var filtered []api.DatabaseMetadataV2
+	for i := range records {
+		if records[i].PrimaryLanguage == language {
+			filtered = append(filtered, records[i])
+		}
+	}
+	return filtered
+}
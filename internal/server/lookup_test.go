@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+func TestServer_handleDatabase(t *testing.T) {
+	srv := New(Config{}, &mockBackend{typeStr: "local", metadata: githubTestMetadata()}, slog.Default())
+
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/databases/fedcba9876543210", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	var m api.DatabaseMetadata
+	if err := json.NewDecoder(rr.Body).Decode(&m); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if m.GitCommitID != "new" {
+		t.Errorf("GitCommitID = %q, want %q", m.GitCommitID, "new")
+	}
+
+	rr = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/databases/missing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("missing hash status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestServer_handleRepoDatabases(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"all languages", "/api/v1/repos/octo/hello", http.StatusOK, 3},
+		{"case-insensitive", "/api/v1/repos/OCTO/Hello", http.StatusOK, 3},
+		{"language filter", "/api/v1/repos/octo/hello?language=python", http.StatusOK, 1},
+		{"language without databases", "/api/v1/repos/octo/hello?language=ruby", http.StatusNotFound, 0},
+		{"unknown repository", "/api/v1/repos/nobody/nothing", http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{}, &mockBackend{typeStr: "local", metadata: githubTestMetadata()}, slog.Default())
+			rr := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var records []api.DatabaseMetadata
+			if err := json.NewDecoder(rr.Body).Decode(&records); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(records) != tt.expectedCount {
+				t.Errorf("got %d records, want %d", len(records), tt.expectedCount)
+			}
+		})
+	}
+}
+
+// hashedBackend serves githubTestMetadata with the hash kinds discovery
+// reports: octo/multi.zip holds two languages, so its records carry hashes
+// derived per language.
+func hashedBackend() *fixedIndexBackend {
+	metadata := githubTestMetadata()
+	records := api.ToV2(metadata)
+	for i := range records {
+		records[i].HashKind = api.HashKindSHA256
+		if strings.Contains(records[i].ResultURL, "/multi.zip") {
+			records[i].Languages = []string{"go", "python"}
+		}
+	}
+	return &fixedIndexBackend{
+		mockBackend: &mockBackend{typeStr: "local", metadata: metadata, fileContent: "zip", fileSize: 3, fileType: "application/zip"},
+		idx:         storage.NewIndex(records),
+	}
+}
+
+func TestServer_handleHeadFile(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		backend        storage.Backend
+		expectedStatus int
+		expectedLength string
+		expectedHash   string
+		expectedKind   string
+	}{
+		{
+			name:           "indexed archive",
+			path:           "/db/octo/hello-go.zip",
+			backend:        hashedBackend(),
+			expectedStatus: http.StatusOK,
+			expectedLength: "200",
+			expectedHash:   "fedcba9876543210",
+			expectedKind:   api.HashKindSHA256,
+		},
+		{
+			name:           "multi-language archive with language",
+			path:           "/db/octo/multi.zip?language=python",
+			backend:        hashedBackend(),
+			expectedStatus: http.StatusOK,
+			expectedLength: "300",
+			expectedKind:   api.HashKindLanguage,
+		},
+		{
+			name:           "archive with unknown hash kind",
+			path:           "/db/octo/hello-go.zip",
+			backend:        &mockBackend{typeStr: "local", metadata: githubTestMetadata()},
+			expectedStatus: http.StatusOK,
+			expectedLength: "200",
+		},
+		{
+			name:           "advertised file falls back to backend",
+			path:           "/db/readme.txt",
+			backend:        &mockBackend{typeStr: "local", metadata: advertising("readme.txt"), fileContent: "hello", fileSize: 5, fileType: "text/plain"},
+			expectedStatus: http.StatusOK,
+			expectedLength: "5",
+		},
+		{
+			name:           "missing file",
+			path:           "/db/missing.txt",
+			backend:        &mockBackend{typeStr: "local", metadata: advertising("missing.txt"), fileError: &storage.ErrNotFound{Path: "missing.txt"}},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "unadvertised file",
+			path:           "/db/readme.txt",
+			backend:        &mockBackend{typeStr: "local", metadata: githubTestMetadata(), fileContent: "hello", fileSize: 5, fileType: "text/plain"},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{}, tt.backend, slog.Default())
+			rr := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodHead, tt.path, nil))
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if got := rr.Header().Get("Content-Length"); got != tt.expectedLength {
+				t.Errorf("Content-Length = %q, want %q", got, tt.expectedLength)
+			}
+			if got := rr.Header().Get(contentHashHeader); got != tt.expectedHash {
+				t.Errorf("%s = %q, want %q", contentHashHeader, got, tt.expectedHash)
+			}
+			if got := rr.Header().Get(hashKindHeader); got != tt.expectedKind {
+				t.Errorf("%s = %q, want %q", hashKindHeader, got, tt.expectedKind)
+			}
+			if rr.Body.Len() != 0 {
+				t.Errorf("body length = %d, want 0", rr.Body.Len())
+			}
+		})
+	}
+}
+
+func TestServer_handleServeFile_ContentHash(t *testing.T) {
+	tests := []struct {
+		path         string
+		expectedHash string
+		expectedKind string
+	}{
+		{path: "/db/octo/hello-go.zip", expectedHash: "fedcba9876543210", expectedKind: api.HashKindSHA256},
+		{path: "/db/octo/multi.zip?language=python", expectedKind: api.HashKindLanguage},
+		{path: "/db/octo/multi.zip", expectedKind: api.HashKindLanguage},
+	}
+
+	handler := New(Config{}, hashedBackend(), slog.Default()).Handler()
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+			}
+			if got := rr.Header().Get(contentHashHeader); got != tt.expectedHash {
+				t.Errorf("%s = %q, want %q", contentHashHeader, got, tt.expectedHash)
+			}
+			if got := rr.Header().Get(hashKindHeader); got != tt.expectedKind {
+				t.Errorf("%s = %q, want %q", hashKindHeader, got, tt.expectedKind)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+const (
+	// discoveryTimeout bounds a background discovery of the databases.
+	discoveryTimeout = 10 * time.Minute
+
+	// probeTimeout bounds the storage check of a readiness probe.
+	probeTimeout = 5 * time.Second
+)
+
+// isProbePath reports whether path is a health check or probe endpoint.
+func isProbePath(path string) bool {
+	return path == "/health" || path == "/livez" || path == "/readyz"
+}
+
+// discoveryState tracks the initial discovery of a generation's databases.
+type discoveryState struct {
+	mu      sync.Mutex
+	done    bool
+	running bool
+	err     error
+}
+
+// discover discovers the databases of g in the background, warming its
+// cache, unless a discovery has already succeeded or is running. A failed
+// discovery is retried by the next readiness probe.
+func (s *Server) discover(g *generation) {
+	g.discovery.mu.Lock()
+	if g.discovery.done || g.discovery.running {
+		g.discovery.mu.Unlock()
+		return
+	}
+	g.discovery.running = true
+	g.discovery.mu.Unlock()
+
+	// Counted as in flight, so the backend is not closed under it
+	g.inflight.Add(1)
+	go func() {
+		defer g.inflight.Done()
+		ctx, cancel := context.WithTimeout(s.ctx, discoveryTimeout)
+		defer cancel()
+
+		start := time.Now()
+		index, err := g.backend.Index(ctx)
+
+		g.discovery.mu.Lock()
+		g.discovery.running = false
+		g.discovery.done = err == nil
+		g.discovery.err = err
+		g.discovery.mu.Unlock()
+
+		if err != nil {
+			s.logger.Error("discovery failed", "storage_type", g.backend.Type(), "error", err)
+			return
+		}
+		s.logger.Info("discovery complete",
+			"storage_type", g.backend.Type(),
+			"databases", index.Len(),
+			"duration", time.Since(start),
+		)
+	}()
+}
+
+// check is the outcome of one readiness check.
+type check struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// newCheck returns the check named name that took the time since start and
+// failed with err, if it is not nil.
+func newCheck(name string, start time.Time, err error) check {
+	c := check{Name: name, Status: "ok", DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		c.Status = "error"
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// handleLivez reports that the process is running. It does not depend on
+// storage, so an unreachable bucket never restarts the server.
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether the server can serve requests: the initial
+// discovery must have succeeded and the storage must be reachable. It
+// answers 503 Service Unavailable if any check fails.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	g := s.generation(r)
+	checks := []check{s.checkDiscovery(g), s.checkStorage(r.Context(), g.backend)}
+
+	status, code := "ok", http.StatusOK
+	for _, c := range checks {
+		if c.Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, code, struct {
+		Status      string  `json:"status"`
+		StorageType string  `json:"storage_type"`
+		Checks      []check `json:"checks"`
+	}{
+		Status:      status,
+		StorageType: g.backend.Type(),
+		Checks:      checks,
+	})
+}
+
+// checkDiscovery checks that the initial discovery of g has succeeded,
+// restarting it if it failed.
+func (s *Server) checkDiscovery(g *generation) check {
+	start := time.Now()
+	g.discovery.mu.Lock()
+	done, running, err := g.discovery.done, g.discovery.running, g.discovery.err
+	g.discovery.mu.Unlock()
+
+	switch {
+	case done:
+		return newCheck("discovery", start, nil)
+	case running:
+		return newCheck("discovery", start, errors.New("discovery in progress"))
+	default:
+		s.discover(g)
+		return newCheck("discovery", start, err)
+	}
+}
+
+// checkStorage checks that the storage of backend is reachable, using its
+// probe if it has one.
+func (s *Server) checkStorage(ctx context.Context, backend storage.Backend) check {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var err error
+	if p, ok := backend.(storage.Prober); ok {
+		err = p.Probe(ctx)
+	} else if exists, existsErr := backend.MetadataExists(ctx); existsErr != nil {
+		err = existsErr
+	} else if !exists {
+		err = errors.New("no metadata available")
+	}
+	if err != nil {
+		s.logger.Warn("storage check failed", "storage_type", backend.Type(), "error", err)
+	}
+	return newCheck("storage", start, err)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// probeBackend is a mock backend with a storage probe.
+type probeBackend struct {
+	*mockBackend
+	probeErr error
+	indexErr atomic.Pointer[error]
+}
+
+func (b *probeBackend) Probe(ctx context.Context) error {
+	return b.probeErr
+}
+
+func (b *probeBackend) Index(ctx context.Context) (*storage.Index, error) {
+	if err := b.indexErr.Load(); err != nil {
+		return nil, *err
+	}
+	return b.mockBackend.Index(ctx)
+}
+
+// readiness is the decoded response of /readyz.
+type readiness struct {
+	Status string  `json:"status"`
+	Checks []check `json:"checks"`
+}
+
+// getReadyz requests /readyz until the discovery check is no longer in
+// progress.
+func getReadyz(t *testing.T, srv *Server) (int, readiness) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rr := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var body readiness
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(body.Checks) == 0 || body.Checks[0].Error != "discovery in progress" {
+			return rr.Code, body
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("discovery did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServer_handleLivez(t *testing.T) {
+	backend := &probeBackend{mockBackend: &mockBackend{typeStr: "mock"}, probeErr: errors.New("bucket unavailable")}
+	srv := New(Config{Tokens: []string{"client"}}, backend, slog.Default())
+	defer srv.Close()
+
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestServer_handleReadyz(t *testing.T) {
+	tests := []struct {
+		name           string
+		backend        func() *probeBackend
+		expectedStatus int
+		wantChecks     map[string]string
+	}{
+		{
+			name: "ready",
+			backend: func() *probeBackend {
+				return &probeBackend{mockBackend: &mockBackend{typeStr: "mock"}}
+			},
+			expectedStatus: http.StatusOK,
+			wantChecks:     map[string]string{"discovery": "ok", "storage": "ok"},
+		},
+		{
+			name: "storage unreachable",
+			backend: func() *probeBackend {
+				return &probeBackend{mockBackend: &mockBackend{typeStr: "mock"}, probeErr: errors.New("bucket unavailable")}
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			wantChecks:     map[string]string{"discovery": "ok", "storage": "error"},
+		},
+		{
+			name: "discovery failed",
+			backend: func() *probeBackend {
+				b := &probeBackend{mockBackend: &mockBackend{typeStr: "mock"}}
+				err := errors.New("listing failed")
+				b.indexErr.Store(&err)
+				return b
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			wantChecks:     map[string]string{"discovery": "error", "storage": "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{Tokens: []string{"client"}}, tt.backend(), slog.Default())
+			defer srv.Close()
+
+			code, body := getReadyz(t, srv)
+			if code != tt.expectedStatus {
+				t.Errorf("status = %d, want %d: %+v", code, tt.expectedStatus, body)
+			}
+			got := make(map[string]string)
+			for _, c := range body.Checks {
+				got[c.Name] = c.Status
+			}
+			for name, want := range tt.wantChecks {
+				if got[name] != want {
+					t.Errorf("check %q = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_handleReadyz_MetadataFallback(t *testing.T) {
+	srv := New(Config{}, &mockBackend{typeStr: "mock", existsError: errors.New("no access")}, slog.Default())
+	defer srv.Close()
+
+	code, body := getReadyz(t, srv)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d: %+v", code, http.StatusServiceUnavailable, body)
+	}
+	if body.Checks[1].Name != "storage" || body.Checks[1].Error != "no access" {
+		t.Errorf("storage check = %+v, want the MetadataExists error", body.Checks[1])
+	}
+}
+
+func TestServer_handleReadyz_RetriesDiscovery(t *testing.T) {
+	backend := &probeBackend{mockBackend: &mockBackend{typeStr: "mock"}}
+	err := errors.New("listing failed")
+	backend.indexErr.Store(&err)
+	srv := New(Config{}, backend, slog.Default())
+	defer srv.Close()
+
+	if code, _ := getReadyz(t, srv); code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	// The failed probe restarts the discovery, which now succeeds
+	backend.indexErr.Store(nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, _ := getReadyz(t, srv)
+		if code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %d, want %d after discovery recovers", code, http.StatusOK)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
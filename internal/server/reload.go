@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// ReloadFunc builds the configuration and storage backend the server
+// switches to on Reload, typically by re-reading its configuration.
+type ReloadFunc func(ctx context.Context) (Config, storage.Backend, error)
+
+// generation is a storage backend with the configuration it was built from.
+// Every request runs against the generation that was current when it
+// arrived, so a reload never changes the backend under a running download.
+type generation struct {
+	backend storage.Backend
+	config  Config
+
+	// inflight counts the requests using this generation, and its initial
+	// discovery while that runs. Requests are only added while the
+	// generation is current.
+	inflight sync.WaitGroup
+
+	// discovery is the state of the initial discovery that readiness
+	// waits for
+	discovery discoveryState
+}
+
+// generationKey is the context key of the request's generation.
+type generationKey struct{}
+
+// acquire returns the current generation, counted as in use until the
+// returned release function is called.
+func (s *Server) acquire() (*generation, func()) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g := s.gen
+	g.inflight.Add(1)
+	return g, g.inflight.Done
+}
+
+// current returns the current generation.
+func (s *Server) current() *generation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gen
+}
+
+// generation returns the generation serving r, or the current one for
+// requests that did not pass through generationMiddleware.
+func (s *Server) generation(r *http.Request) *generation {
+	if g, ok := r.Context().Value(generationKey{}).(*generation); ok {
+		return g
+	}
+	return s.current()
+}
+
+// storage returns the storage backend serving r.
+func (s *Server) storage(r *http.Request) storage.Backend {
+	return s.generation(r).backend
+}
+
+// generationMiddleware pins each request to the current generation until
+// the handler returns, including the time spent streaming a response.
+func (s *Server) generationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g, release := s.acquire()
+		defer release()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), generationKey{}, g)))
+	})
+}
+
+// Reload builds a new configuration and storage backend with the server's
+// ReloadFunc and switches new requests to them. The previous backend is
+// closed once the requests using it have finished. On error the server
+// keeps its current configuration and backend.
+func (s *Server) Reload(ctx context.Context) error {
+	if s.config.Reload == nil {
+		return errors.New("reloading is not configured")
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	if s.closed {
+		return errors.New("server is closed")
+	}
+
+	cfg, store, err := s.config.Reload(ctx)
+	if err != nil {
+		s.logger.Error("reload failed", "error", err)
+		return err
+	}
+	if cfg.Host != s.config.Host || cfg.Port != s.config.Port {
+		s.logger.Warn("host and port changes take effect after a restart",
+			"host", cfg.Host,
+			"port", cfg.Port,
+		)
+	}
+
+	next := &generation{backend: store, config: cfg}
+	s.discover(next)
+	s.mu.Lock()
+	prev := s.gen
+	s.gen = next
+	s.mu.Unlock()
+
+	s.logger.Info("reloaded configuration", "storage_type", store.Type())
+	s.retire(prev)
+	return nil
+}
+
+// retire closes the backend of a replaced generation in the background once
+// its in-flight requests have finished.
+func (s *Server) retire(g *generation) {
+	s.retiring.Add(1)
+	go func() {
+		defer s.retiring.Done()
+		g.inflight.Wait()
+		if err := g.backend.Close(); err != nil {
+			s.logger.Error("failed to close previous storage", "error", err)
+			return
+		}
+		s.logger.Info("closed previous storage", "storage_type", g.backend.Type())
+	}()
+}
+
+// Close waits for in-flight requests to finish and closes the storage
+// backends of the server, including those replaced by reloads that are
+// still draining. The server must not serve requests after Close.
+func (s *Server) Close() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.cancel()
+
+	s.retiring.Wait()
+	g := s.current()
+	g.inflight.Wait()
+	return g.backend.Close()
+}
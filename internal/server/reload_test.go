@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// trackedBackend is a mock backend that reports when it is closed and can
+// hold a download open until the test releases it.
+type trackedBackend struct {
+	*mockBackend
+	body    io.ReadCloser
+	started chan struct{}
+	closed  chan struct{}
+}
+
+func newTrackedBackend(typeStr string) *trackedBackend {
+	return &trackedBackend{
+		mockBackend: &mockBackend{typeStr: typeStr, metadataExists: true},
+		started:     make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+func (b *trackedBackend) GetFile(ctx context.Context, filename string) (io.ReadCloser, int64, string, error) {
+	if b.body == nil {
+		return b.mockBackend.GetFile(ctx, filename)
+	}
+	close(b.started)
+	return b.body, 5, "application/zip", nil
+}
+
+func (b *trackedBackend) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func (b *trackedBackend) isClosed() bool {
+	select {
+	case <-b.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// reloadTo returns a ReloadFunc switching to cfg and store.
+func reloadTo(cfg Config, store storage.Backend) ReloadFunc {
+	return func(ctx context.Context) (Config, storage.Backend, error) {
+		return cfg, store, nil
+	}
+}
+
+func healthStorageType(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("health status = %d", rr.Code)
+	}
+	var status struct {
+		StorageType string `json:"storage_type"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode health response: %v", err)
+	}
+	return status.StorageType
+}
+
+func TestServer_Reload_DrainsInFlightRequests(t *testing.T) {
+	oldBackend := newTrackedBackend("old")
+	pr, pw := io.Pipe()
+	oldBackend.body = pr
+	oldBackend.metadata = advertising("big.zip")
+	newBackend := newTrackedBackend("new")
+
+	srv := New(Config{Reload: reloadTo(Config{}, newBackend)}, oldBackend, slog.Default())
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// Start a download that stays open across the reload
+	type result struct {
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + "/db/big.zip")
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		done <- result{body: string(body), err: err}
+	}()
+	<-oldBackend.started
+
+	if err := srv.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := healthStorageType(t, srv.Handler()); got != "new" {
+		t.Errorf("storage type after reload = %q, want %q", got, "new")
+	}
+	if oldBackend.isClosed() {
+		t.Fatal("old backend closed while a download was in flight")
+	}
+
+	if _, err := pw.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write body: %v", err)
+	}
+	_ = pw.Close()
+	res := <-done
+	if res.err != nil || res.body != "hello" {
+		t.Fatalf("download = %q, %v; want %q", res.body, res.err, "hello")
+	}
+
+	select {
+	case <-oldBackend.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("old backend not closed after the download finished")
+	}
+	if newBackend.isClosed() {
+		t.Error("new backend closed")
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !newBackend.isClosed() {
+		t.Error("Close() did not close the current backend")
+	}
+}
+
+func TestServer_Reload_Errors(t *testing.T) {
+	backend := newTrackedBackend("old")
+
+	srv := New(Config{}, backend, slog.Default())
+	if err := srv.Reload(context.Background()); err == nil {
+		t.Error("Reload() without ReloadFunc expected error, got nil")
+	}
+
+	srv = New(Config{Reload: func(ctx context.Context) (Config, storage.Backend, error) {
+		return Config{}, nil, errors.New("bucket not found")
+	}}, backend, slog.Default())
+	if err := srv.Reload(context.Background()); err == nil {
+		t.Error("Reload() expected error, got nil")
+	}
+	if got := healthStorageType(t, srv.Handler()); got != "old" {
+		t.Errorf("storage type after failed reload = %q, want %q", got, "old")
+	}
+	if backend.isClosed() {
+		t.Error("backend closed after failed reload")
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := srv.Reload(context.Background()); err == nil {
+		t.Error("Reload() after Close expected error, got nil")
+	}
+}
+
+func TestServer_handleReload(t *testing.T) {
+	tests := []struct {
+		name           string
+		adminTokens    []string
+		authorization  string
+		reloadErr      error
+		expectedStatus int
+		expectedType   string
+	}{
+		{
+			name:           "disabled without admin tokens",
+			authorization:  "Bearer client",
+			expectedStatus: http.StatusNotFound,
+			expectedType:   "old",
+		},
+		{
+			name:           "missing token",
+			adminTokens:    []string{"admin"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedType:   "old",
+		},
+		{
+			name:           "client token",
+			adminTokens:    []string{"admin"},
+			authorization:  "Bearer client",
+			expectedStatus: http.StatusUnauthorized,
+			expectedType:   "old",
+		},
+		{
+			name:           "admin token",
+			adminTokens:    []string{"admin"},
+			authorization:  "Bearer admin",
+			expectedStatus: http.StatusOK,
+			expectedType:   "new",
+		},
+		{
+			name:           "reload error",
+			adminTokens:    []string{"admin"},
+			authorization:  "Bearer admin",
+			reloadErr:      errors.New("invalid config"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedType:   "old",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reload := func(ctx context.Context) (Config, storage.Backend, error) {
+				if tt.reloadErr != nil {
+					return Config{}, nil, tt.reloadErr
+				}
+				return Config{AdminTokens: tt.adminTokens}, newTrackedBackend("new"), nil
+			}
+			cfg := Config{Tokens: []string{"client"}, AdminTokens: tt.adminTokens, Reload: reload}
+			srv := New(cfg, newTrackedBackend("old"), slog.Default())
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("status = %d, want %d: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+			if tt.reloadErr != nil && !strings.Contains(rr.Body.String(), tt.reloadErr.Error()) {
+				t.Errorf("body = %s, want the reload error", rr.Body.String())
+			}
+			if got := srv.current().backend.Type(); got != tt.expectedType {
+				t.Errorf("storage type = %q, want %q", got, tt.expectedType)
+			}
+		})
+	}
+}
+
+func TestServer_Reload_ReplacesTokens(t *testing.T) {
+	srv := New(Config{
+		Tokens: []string{"old-token"},
+		Reload: reloadTo(Config{Tokens: []string{"new-token"}}, newTrackedBackend("new")),
+	}, newTrackedBackend("old"), slog.Default())
+	handler := srv.Handler()
+
+	status := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/index", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if got := status("old-token"); got != http.StatusOK {
+		t.Errorf("old token before reload: status = %d, want %d", got, http.StatusOK)
+	}
+	if err := srv.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := status("old-token"); got != http.StatusUnauthorized {
+		t.Errorf("old token after reload: status = %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := status("new-token"); got != http.StatusOK {
+		t.Errorf("new token after reload: status = %d, want %d", got, http.StatusOK)
+	}
+}
@@ -9,10 +9,14 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/data-douser/mrva-go-hepc/api"
 	"github.com/data-douser/mrva-go-hepc/internal/storage"
 )
 
@@ -22,14 +26,35 @@ type Config struct {
 	Host string
 	// Port is the port number for the HTTP server.
 	Port int
+	// Tokens are the bearer tokens accepted by the server. If any are set,
+	// every request except health checks must present one of them.
+	Tokens []string
+	// AdminTokens are the bearer tokens accepted by the administrative
+	// endpoints under /admin/, which are disabled if none are set.
+	AdminTokens []string
+	// Reload builds the configuration and storage backend used after a
+	// reload. If nil, reloading is not supported.
+	Reload ReloadFunc
 }
 
 // Server represents the HEPC HTTP server.
 type Server struct {
-	config  Config
-	logger  *slog.Logger
-	mux     *http.ServeMux
-	storage storage.Backend
+	config Config
+	logger *slog.Logger
+	mux    *http.ServeMux
+
+	// mu guards gen, the configuration and backend serving new requests
+	mu  sync.RWMutex
+	gen *generation
+
+	// ctx is canceled by Close to stop background discoveries
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// reloadMu serializes reloads and Close
+	reloadMu sync.Mutex
+	closed   bool
+	retiring sync.WaitGroup
 }
 
 // New creates a new Server instance with the given configuration and storage backend.
@@ -39,13 +64,15 @@ func New(cfg Config, store storage.Backend, logger *slog.Logger) *Server {
 	}
 
 	s := &Server{
-		config:  cfg,
-		logger:  logger,
-		mux:     http.NewServeMux(),
-		storage: store,
+		config: cfg,
+		logger: logger,
+		mux:    http.NewServeMux(),
+		gen:    &generation{backend: store, config: cfg},
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	s.registerRoutes()
+	s.discover(s.gen)
 	return s
 }
 
@@ -53,18 +80,45 @@ func New(cfg Config, store storage.Backend, logger *slog.Logger) *Server {
 func (s *Server) registerRoutes() {
 	// Database file serving endpoint
 	s.mux.HandleFunc("GET /db/{filepath...}", s.handleServeFile)
+	s.mux.HandleFunc("HEAD /db/{filepath...}", s.handleHeadFile)
 
 	// Metadata endpoints (both return the same data)
 	s.mux.HandleFunc("GET /index", s.handleMetadata)
 	s.mux.HandleFunc("GET /api/v1/latest_results/codeql-all", s.handleMetadata)
 
-	// Health check endpoint
+	// Per-database lookup endpoints
+	s.mux.HandleFunc("GET /api/v1/databases/{hash}", s.handleDatabase)
+	s.mux.HandleFunc("GET /api/v1/repos/{owner}/{repo}", s.handleRepoDatabases)
+
+	// v2 API serving the superset metadata schema
+	s.mux.HandleFunc("GET "+apiV2Prefix+"index", s.handleMetadataV2)
+	s.mux.HandleFunc("GET "+apiV2Prefix+"databases/{hash}", s.handleDatabase)
+	s.mux.HandleFunc("GET "+apiV2Prefix+"repos/{owner}/{repo}", s.handleRepoDatabases)
+	s.mux.HandleFunc("GET "+apiV2Prefix+"schema.json", s.handleSchemaV2)
+
+	// GitHub-compatible CodeQL database endpoints, also under the
+	// GitHub Enterprise Server API prefix
+	for _, prefix := range []string{"", githubEnterpriseAPIPrefix} {
+		s.mux.HandleFunc("GET "+prefix+"/repos/{owner}/{repo}/code-scanning/codeql/databases", s.handleGitHubDatabases)
+		s.mux.HandleFunc("GET "+prefix+"/repos/{owner}/{repo}/code-scanning/codeql/databases/{language}", s.handleGitHubDatabase)
+	}
+
+	// Health check and probe endpoints
 	s.mux.HandleFunc("GET /health", s.handleHealth)
+	s.mux.HandleFunc("GET /livez", s.handleLivez)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+
+	// Administrative endpoints
+	s.mux.HandleFunc("POST "+adminPrefix+"reload", s.requireAdmin(s.handleReload))
+	s.mux.HandleFunc("POST "+adminPrefix+"reindex", s.requireAdmin(s.handleReindex))
+	s.mux.HandleFunc("GET "+adminPrefix+"cache", s.requireAdmin(s.handleCacheStats))
+	s.mux.HandleFunc("DELETE "+adminPrefix+"cache", s.requireAdmin(s.handleCacheInvalidate))
+	s.mux.HandleFunc("GET "+adminPrefix+"quarantine", s.requireAdmin(s.handleQuarantine))
 }
 
 // Handler returns the HTTP handler for the server.
 func (s *Server) Handler() http.Handler {
-	return s.loggingMiddleware(s.mux)
+	return s.loggingMiddleware(s.compressionMiddleware(s.generationMiddleware(s.authMiddleware(s.mux))))
 }
 
 // ListenAndServe starts the HTTP server.
@@ -88,7 +142,7 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 
 	s.logger.Info("starting server",
 		"addr", addr,
-		"storage_type", s.storage.Type(),
+		"storage_type", s.current().backend.Type(),
 	)
 	return srv.ListenAndServe()
 }
@@ -101,7 +155,9 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// handleServeFile serves database files from the storage backend.
+// handleServeFile serves database files from the storage backend. Only
+// advertised databases and the files of advertised database directories
+// are served. Archives carry the same content hash headers as for HEAD.
 func (s *Server) handleServeFile(w http.ResponseWriter, r *http.Request) {
 	// Extract the filepath from the URL pattern
 	requestedPath := r.PathValue("filepath")
@@ -109,10 +165,46 @@ func (s *Server) handleServeFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "file path required", http.StatusBadRequest)
 		return
 	}
+	if dbPath, member, ok := splitMemberPath(requestedPath); ok {
+		s.serveMember(w, r, dbPath, member)
+		return
+	}
+	idx, ok := s.advertisedIndex(w, r, requestedPath)
+	if !ok {
+		return
+	}
+	if m, ok := selectArtifactRecord(idx.ByPath(requestedPath), r.URL.Query().Get("language")); ok {
+		setContentHash(w.Header(), m)
+		setETag(w.Header(), m)
+	}
+
+	s.serveFile(w, r, requestedPath)
+}
+
+// advertisedIndex returns the metadata index if requestedPath is an
+// advertised database or a file inside an advertised database directory.
+// Otherwise it writes an error response and returns false; unadvertised
+// paths are reported as not found, whether or not they exist.
+func (s *Server) advertisedIndex(w http.ResponseWriter, r *http.Request, requestedPath string) (*storage.Index, bool) {
+	idx, err := s.storage(r).Index(r.Context())
+	if err != nil {
+		s.logger.Error("error loading metadata index", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !idx.Serves(requestedPath) {
+		s.logger.Warn("refusing unadvertised file", "path", requestedPath)
+		http.Error(w, fmt.Sprintf("%s not found", requestedPath), http.StatusNotFound)
+		return nil, false
+	}
+	return idx, true
+}
 
+// serveFile streams a file from the storage backend.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, requestedPath string) {
 	s.logger.Info("serving file", "requested", requestedPath)
 
-	reader, size, contentType, err := s.storage.GetFile(r.Context(), requestedPath)
+	reader, size, contentType, err := s.storage(r).GetFile(r.Context(), requestedPath)
 	if err != nil {
 		var notFound *storage.ErrNotFound
 		if errors.As(err, &notFound) {
@@ -124,6 +216,24 @@ func (s *Server) handleServeFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+
+	// Ranges of backends with ranged reads start without the bytes before
+	var openAt func(off int64) (io.ReadCloser, error)
+	if rr, ok := s.storage(r).(storage.RangeReader); ok {
+		openAt = func(off int64) (io.ReadCloser, error) {
+			return rr.GetFileRange(r.Context(), requestedPath, off)
+		}
+	}
+	s.writeFile(w, r, requestedPath, reader, size, contentType, openAt)
+}
+
+// writeFile streams the content of reader, closing it, and answers range
+// requests if its size is known. A range is served only if the request's
+// If-Range, if any, matches the ETag already set on w or the modification
+// time of reader. If openAt is not nil, a range that does not start at the
+// beginning is read from a reader it opens at the start of the range
+// instead.
+func (s *Server) writeFile(w http.ResponseWriter, r *http.Request, requestedPath string, reader io.ReadCloser, size int64, contentType string, openAt func(off int64) (io.ReadCloser, error)) {
 	defer func() {
 		if err := reader.Close(); err != nil {
 			s.logger.Error("failed to close reader", "error", err)
@@ -132,20 +242,148 @@ func (s *Server) handleServeFile(w http.ResponseWriter, r *http.Request) {
 
 	// Set response headers
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	setLastModified(w.Header(), reader)
+	if size < 0 {
+		// Ranges need a known size
+		if _, err := io.Copy(w, reader); err != nil {
+			s.logger.Error("error streaming file", "path", requestedPath, "error", err)
+		}
+		return
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	// Serve a single byte range so interrupted downloads can resume;
+	// anything else gets the whole file
+	rangeHeader := r.Header.Get("Range")
+	if !ifRangeMatches(r.Header.Get("If-Range"), w.Header()) {
+		rangeHeader = ""
+	}
+	start, end, ok := parseRange(rangeHeader, size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	length := end - start
+	status := http.StatusOK
+	if length != size {
+		var err error
+		if reader, err = skipBytes(reader, start, openAt); err != nil {
+			s.logger.Error("error seeking file", "path", requestedPath, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
 
 	// Stream the file content
-	if _, err := io.Copy(w, reader); err != nil {
+	if _, err := io.CopyN(w, reader, length); err != nil {
 		s.logger.Error("error streaming file", "path", requestedPath, "error", err)
 		// Can't set error status here as headers are already sent
 	}
 }
 
+// setLastModified sets the Last-Modified header if reader reports its
+// modification time.
+func setLastModified(h http.Header, reader io.Reader) {
+	if mt, ok := reader.(storage.ModTimer); ok && !mt.ModTime().IsZero() {
+		h.Set("Last-Modified", mt.ModTime().UTC().Format(http.TimeFormat))
+	}
+}
+
+// ifRangeMatches reports whether an If-Range request header is absent or
+// matches the ETag or Last-Modified validator in the response header h.
+// Entity tags are compared strongly, as a weak tag cannot validate a range.
+func ifRangeMatches(ifRange string, h http.Header) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == h.Get("ETag")
+	}
+	lastModified, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && t.Equal(lastModified)
+}
+
+// parseRange returns the half-open byte range [start, end) requested by a
+// Range header for a file of the given size. A missing, malformed or
+// multi-range header selects the whole file; ok is false only for a
+// well-formed single range that lies outside the file.
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, size, true
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, size, true
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, true
+		}
+		if n == 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, true
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+	end = size
+	if last != "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < start {
+			return 0, size, true
+		}
+		end = min(n+1, size)
+	}
+	return start, end, true
+}
+
+// skipBytes returns reader advanced by n bytes. It seeks when the reader
+// supports it, replaces the reader with one opened at n by openAt if that is
+// not nil, and reads and discards the bytes otherwise.
+func skipBytes(reader io.ReadCloser, n int64, openAt func(off int64) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	if n == 0 {
+		return reader, nil
+	}
+	if seeker, ok := reader.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekStart)
+		return reader, err
+	}
+	if openAt != nil {
+		ranged, err := openAt(n)
+		if err != nil {
+			return reader, err
+		}
+		_ = reader.Close() //nolint:errcheck // Replaced by the ranged reader
+		return ranged, nil
+	}
+	_, err := io.CopyN(io.Discard, reader, n)
+	return reader, err
+}
+
 // handleMetadata serves metadata from the storage backend as JSONL.
 func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 	s.logger.Info("serving metadata")
 
-	exists, err := s.storage.MetadataExists(r.Context())
+	exists, err := s.storage(r).MetadataExists(r.Context())
 	if err != nil {
 		s.logger.Error("error checking metadata existence", "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -157,35 +395,137 @@ func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metadata, err := s.storage.ListMetadata(r.Context())
-	if err != nil {
-		s.logger.Error("error loading metadata", "error", err)
-		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+	// The unfiltered index is compressed once per refresh
+	query := r.URL.Query()
+	if encoding := negotiateEncoding(r.Header.Get("Accept-Encoding")); encoding != "" && !hasFilters(query) {
+		idx, err := s.storage(r).Index(r.Context())
+		if err != nil {
+			s.logger.Error("error loading metadata", "error", err)
+			http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.writeCompressedJSONL(w, idx, "v1", encoding, func() []byte {
+			return encodeJSONL(s.logger, api.ToV1(idx.Records()))
+		})
+		s.logger.Info("served metadata records", "count", idx.Len(), "encoding", encoding)
 		return
 	}
 
-	// Write as JSONL (newline-delimited JSON)
-	w.Header().Set("Content-Type", "application/x-ndjson")
+	var metadata []api.DatabaseMetadata
+	if hasFilters(query) {
+		// The filtered fields are part of the v2 records only
+		idx, err := s.storage(r).Index(r.Context())
+		if err != nil {
+			s.logger.Error("error loading metadata", "error", err)
+			http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		metadata = api.ToV1(filterRecords(idx.Records(), query))
+	} else {
+		metadata, err = s.storage(r).ListMetadata(r.Context())
+		if err != nil {
+			s.logger.Error("error loading metadata", "error", err)
+			http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSONL(w, s.logger, metadata)
+	s.logger.Info("served metadata records", "count", len(metadata))
+}
+
+// jsonlContentType is the content type of JSONL responses.
+const jsonlContentType = "application/x-ndjson"
+
+// writeJSONL writes records as JSONL (newline-delimited JSON).
+func writeJSONL[T any](w http.ResponseWriter, logger *slog.Logger, records []T) {
+	w.Header().Set("Content-Type", jsonlContentType)
+	if _, err := w.Write(encodeJSONL(logger, records)); err != nil {
+		logger.Error("failed to write response", "error", err)
+	}
+}
 
+// writeCompressedJSONL writes the JSONL body built by encode, compressed
+// with encoding. The compressed body is cached in idx under name, so it is
+// built once for as long as idx is current.
+func (s *Server) writeCompressedJSONL(w http.ResponseWriter, idx *storage.Index, name, encoding string, encode func() []byte) {
+	body, err := idx.Encoded(name+".jsonl."+encoding, func() ([]byte, error) {
+		return compress(encode(), encoding)
+	})
+	if err != nil {
+		s.logger.Error("error compressing metadata", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := writeEncoded(w, jsonlContentType, encoding, body); err != nil {
+		s.logger.Error("failed to write response", "error", err)
+	}
+}
+
+// encodeJSONL encodes records as JSONL, leaving out records that cannot be
+// marshaled.
+func encodeJSONL[T any](logger *slog.Logger, records []T) []byte {
 	var lines []string
-	for i := range metadata {
-		line, err := json.Marshal(metadata[i])
+	for i := range records {
+		line, err := json.Marshal(records[i])
 		if err != nil {
-			s.logger.Error("error marshaling metadata", "error", err)
+			logger.Error("error marshaling metadata", "error", err)
 			continue
 		}
 		lines = append(lines, string(line))
 	}
+	return []byte(strings.Join(lines, "\n"))
+}
 
-	if _, err := w.Write([]byte(strings.Join(lines, "\n"))); err != nil {
-		s.logger.Error("failed to write response", "error", err)
+// filterRecords returns the records matching the index query filters:
+// every "tag" value must be present, and "team" and "visibility" must match
+// exactly when given. Without filters all records are returned.
+func filterRecords(records []api.DatabaseMetadataV2, query url.Values) []api.DatabaseMetadataV2 {
+	if !hasFilters(query) {
+		return records
 	}
-	s.logger.Info("served metadata records", "count", len(metadata))
+	tags := query["tag"]
+	team := query.Get("team")
+	visibility := query.Get("visibility")
+
+	filtered := make([]api.DatabaseMetadataV2, 0, len(records))
+	for i := range records {
+		if matchesFilters(&records[i], tags, team, visibility) {
+			filtered = append(filtered, records[i])
+		}
+	}
+	return filtered
+}
+
+// hasFilters reports whether an index query has any filters.
+func hasFilters(query url.Values) bool {
+	return len(query["tag"]) > 0 || query.Get("team") != "" || query.Get("visibility") != ""
+}
+
+// matchesFilters reports whether a record passes the index query filters.
+func matchesFilters(m *api.DatabaseMetadataV2, tags []string, team, visibility string) bool {
+	if team != "" && m.Team != team {
+		return false
+	}
+	if visibility != "" && m.Visibility != visibility {
+		return false
+	}
+	return hasAllTags(m.Tags, tags)
+}
+
+// hasAllTags reports whether tags contains every entry of want.
+func hasAllTags(tags, want []string) bool {
+	for _, w := range want {
+		if !slices.Contains(tags, w) {
+			return false
+		}
+	}
+	return true
 }
 
 // handleHealth provides a simple health check endpoint.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	hasMetaDB, err := s.storage.MetadataExists(r.Context())
+	hasMetaDB, err := s.storage(r).MetadataExists(r.Context())
 	if err != nil {
 		s.logger.Error("error checking metadata existence", "error", err)
 		hasMetaDB = false
@@ -197,7 +537,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		HasMetadataDB bool   `json:"has_metadata_db"`
 	}{
 		Status:        "ok",
-		StorageType:   s.storage.Type(),
+		StorageType:   s.storage(r).Type(),
 		HasMetadataDB: hasMetaDB,
 	}
 
@@ -206,3 +546,10 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		s.logger.Error("failed to encode response", "error", err)
 	}
 }
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v) //nolint:errcheck // Client disconnects are not actionable
+}
@@ -19,6 +19,7 @@ import (
 type mockBackend struct {
 	typeStr        string
 	metadata       []api.DatabaseMetadata
+	records        []api.DatabaseMetadataV2 // served instead of metadata if set
 	metadataError  error
 	metadataExists bool
 	existsError    error
@@ -38,9 +39,22 @@ func (m *mockBackend) ListMetadata(ctx context.Context) ([]api.DatabaseMetadata,
 	if m.metadataError != nil {
 		return nil, m.metadataError
 	}
+	if m.records != nil {
+		return api.ToV1(m.records), nil
+	}
 	return m.metadata, nil
 }
 
+func (m *mockBackend) Index(ctx context.Context) (*storage.Index, error) {
+	if m.metadataError != nil {
+		return nil, m.metadataError
+	}
+	if m.records != nil {
+		return storage.NewIndex(m.records), nil
+	}
+	return storage.NewIndex(api.ToV2(m.metadata)), nil
+}
+
 func (m *mockBackend) GetFile(ctx context.Context, filename string) (io.ReadCloser, int64, string, error) {
 	if m.fileError != nil {
 		return nil, 0, "", m.fileError
@@ -64,6 +78,28 @@ func (m *mockBackend) Close() error {
 	return nil
 }
 
+// rangeBackend is a mockBackend with ranged reads that records the offsets
+// it is asked for.
+type rangeBackend struct {
+	mockBackend
+	offsets []int64
+}
+
+func (m *rangeBackend) GetFileRange(ctx context.Context, filename string, off int64) (io.ReadCloser, error) {
+	m.offsets = append(m.offsets, off)
+	return io.NopCloser(strings.NewReader(m.fileContent[off:])), nil
+}
+
+// advertising returns metadata advertising archives at the given paths, as
+// /db/ serves only advertised databases.
+func advertising(paths ...string) []api.DatabaseMetadata {
+	metadata := make([]api.DatabaseMetadata, len(paths))
+	for i, p := range paths {
+		metadata[i] = api.DatabaseMetadata{ContentHash: "hash-" + p, ResultURL: "http://localhost:8080/db/" + p}
+	}
+	return metadata
+}
+
 func TestNew(t *testing.T) {
 	mock := &mockBackend{typeStr: "mock"}
 	cfg := Config{Host: "127.0.0.1", Port: 8080}
@@ -277,6 +313,30 @@ func TestServer_handleMetadata(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "filtered by tags and team",
+			backend: &mockBackend{
+				typeStr:        "local",
+				metadataExists: true,
+				records: []api.DatabaseMetadataV2{
+					{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "hash1", Projname: "owner1/repo1"}, Team: "core", Tags: []string{"critical", "archived"}},
+					{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "hash2", Projname: "owner2/repo2"}, Team: "core", Tags: []string{"critical"}},
+					{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "hash3", Projname: "owner3/repo3"}, Team: "web", Tags: []string{"critical", "archived"}},
+				},
+			},
+			path:           "/index?tag=critical&tag=archived&team=core",
+			expectedStatus: http.StatusOK,
+			checkBody: func(t *testing.T, body string) {
+				lines := strings.Split(strings.TrimSpace(body), "\n")
+				if len(lines) != 1 || !strings.Contains(lines[0], "owner1/repo1") {
+					t.Errorf("expected only owner1/repo1, got %q", body)
+				}
+				// The v1 record does not carry the sidecar fields
+				if strings.Contains(lines[0], `"tags"`) || strings.Contains(lines[0], `"team"`) {
+					t.Errorf("expected a v1 record without tags and team, got %q", lines[0])
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -311,6 +371,7 @@ func TestServer_handleServeFile(t *testing.T) {
 			name: "serve file successfully",
 			backend: &mockBackend{
 				typeStr:     "local",
+				metadata:    advertising("test/file.txt"),
 				fileContent: "file content here",
 				fileSize:    17,
 				fileType:    "text/plain",
@@ -335,6 +396,7 @@ func TestServer_handleServeFile(t *testing.T) {
 			name: "file not found",
 			backend: &mockBackend{
 				typeStr:   "local",
+				metadata:  advertising("nonexistent.txt"),
 				fileError: &storage.ErrNotFound{Path: "nonexistent.txt"},
 			},
 			path:           "/db/nonexistent.txt",
@@ -344,6 +406,7 @@ func TestServer_handleServeFile(t *testing.T) {
 			name: "server error",
 			backend: &mockBackend{
 				typeStr:   "local",
+				metadata:  advertising("test.txt"),
 				fileError: &mockError{"internal error"},
 			},
 			path:           "/db/test.txt",
@@ -353,6 +416,7 @@ func TestServer_handleServeFile(t *testing.T) {
 			name: "nested path",
 			backend: &mockBackend{
 				typeStr:     "local",
+				metadata:    advertising("path/to/nested/file.zip"),
 				fileContent: "nested content",
 				fileSize:    14,
 				fileType:    "application/octet-stream",
@@ -365,6 +429,51 @@ func TestServer_handleServeFile(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "unadvertised file",
+			backend: &mockBackend{
+				typeStr:     "local",
+				metadata:    advertising("test.zip"),
+				fileContent: "secret",
+				fileSize:    6,
+			},
+			path:           "/db/private/secret.txt",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "sidecar of an advertised archive",
+			backend: &mockBackend{
+				typeStr:     "local",
+				metadata:    advertising("test.zip"),
+				fileContent: "owner: octo",
+				fileSize:    11,
+			},
+			path:           "/db/test.hepc.yml",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "sidecar of an advertised database directory",
+			backend: &mockBackend{
+				typeStr: "local",
+				records: []api.DatabaseMetadataV2{{
+					DatabaseMetadata: api.DatabaseMetadata{ContentHash: "hash-widgets", ResultURL: "http://localhost:8080/db/team/widgets"},
+					Format:           api.FormatUnarchived,
+				}},
+				fileContent: "owner: octo",
+				fileSize:    11,
+			},
+			path:           "/db/team/widgets/hepc.yml",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "index unavailable",
+			backend: &mockBackend{
+				typeStr:       "local",
+				metadataError: &mockError{"storage down"},
+			},
+			path:           "/db/test.zip",
+			expectedStatus: http.StatusInternalServerError,
+		},
 	}
 
 	for _, tt := range tests {
@@ -504,3 +613,151 @@ type mockError struct {
 func (e *mockError) Error() string {
 	return e.message
 }
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header    string
+		wantStart int64
+		wantEnd   int64
+		wantOK    bool
+	}{
+		{"", 0, 10, true},
+		{"bytes=2-", 2, 10, true},
+		{"bytes=2-4", 2, 5, true},
+		{"bytes=2-100", 2, 10, true},
+		{"bytes=-3", 7, 10, true},
+		{"bytes=-30", 0, 10, true},
+		{"bytes=10-", 0, 0, false},
+		{"bytes=-0", 0, 0, false},
+		{"bytes=0-1,4-5", 0, 10, true},
+		{"bytes=5-2", 0, 10, true},
+		{"items=1-2", 0, 10, true},
+	}
+
+	for _, tt := range tests {
+		start, end, ok := parseRange(tt.header, 10)
+		if start != tt.wantStart || end != tt.wantEnd || ok != tt.wantOK {
+			t.Errorf("parseRange(%q) = %d, %d, %v; want %d, %d, %v", tt.header, start, end, ok, tt.wantStart, tt.wantEnd, tt.wantOK)
+		}
+	}
+}
+
+func TestServer_handleServeFile_Range(t *testing.T) {
+	tests := []struct {
+		name           string
+		rangeHeader    string
+		expectedStatus int
+		expectedBody   string
+		expectedRange  string
+	}{
+		{"whole file", "", http.StatusOK, "0123456789", ""},
+		{"open-ended range", "bytes=4-", http.StatusPartialContent, "456789", "bytes 4-9/10"},
+		{"bounded range", "bytes=1-3", http.StatusPartialContent, "123", "bytes 1-3/10"},
+		{"unsatisfiable range", "bytes=10-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &mockBackend{typeStr: "local", metadata: advertising("test.zip"), fileContent: "0123456789", fileSize: 10, fileType: "application/zip"}
+			srv := New(Config{}, backend, slog.Default())
+			req := httptest.NewRequest(http.MethodGet, "/db/test.zip", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rr := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedStatus)
+			}
+			if got := rr.Header().Get("Content-Range"); got != tt.expectedRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.expectedRange)
+			}
+			if tt.expectedBody != "" && rr.Body.String() != tt.expectedBody {
+				t.Errorf("body = %q, want %q", rr.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+}
+
+func TestServer_handleServeFile_RangeReader(t *testing.T) {
+	backend := &rangeBackend{mockBackend: mockBackend{typeStr: "gcs", metadata: advertising("test.zip"), fileContent: "0123456789", fileSize: 10, fileType: "application/zip"}}
+	srv := New(Config{}, backend, slog.Default())
+
+	req := httptest.NewRequest(http.MethodGet, "/db/test.zip", nil)
+	req.Header.Set("Range", "bytes=6-8")
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusPartialContent)
+	}
+	if rr.Body.String() != "678" {
+		t.Errorf("body = %q, want %q", rr.Body.String(), "678")
+	}
+	if len(backend.offsets) != 1 || backend.offsets[0] != 6 {
+		t.Errorf("GetFileRange() offsets = %v, want [6]", backend.offsets)
+	}
+}
+
+func TestServer_handleServeFile_IfRange(t *testing.T) {
+	records := []api.DatabaseMetadataV2{{
+		DatabaseMetadata: api.DatabaseMetadata{ContentHash: "abc123", ResultURL: "http://localhost:8080/db/test.zip"},
+		HashKind:         api.HashKindSHA256,
+	}}
+	tests := []struct {
+		name           string
+		ifRange        string
+		expectedStatus int
+	}{
+		{"no If-Range", "", http.StatusPartialContent},
+		{"matching ETag", `"abc123"`, http.StatusPartialContent},
+		{"changed ETag", `"def456"`, http.StatusOK},
+		{"date without Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &mockBackend{typeStr: "local", records: records, fileContent: "0123456789", fileSize: 10, fileType: "application/zip"}
+			srv := New(Config{}, backend, slog.Default())
+			req := httptest.NewRequest(http.MethodGet, "/db/test.zip", nil)
+			req.Header.Set("Range", "bytes=4-")
+			if tt.ifRange != "" {
+				req.Header.Set("If-Range", tt.ifRange)
+			}
+			rr := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedStatus)
+			}
+			if got := rr.Header().Get("ETag"); got != `"abc123"` {
+				t.Errorf("ETag = %q, want %q", got, `"abc123"`)
+			}
+		})
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	h := http.Header{}
+	h.Set("ETag", `"abc"`)
+	h.Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+
+	tests := []struct {
+		ifRange string
+		want    bool
+	}{
+		{"", true},
+		{`"abc"`, true},
+		{`"abd"`, false},
+		{`W/"abc"`, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", true},
+		{"Mon, 02 Jan 2006 15:04:06 GMT", false},
+		{"not a date", false},
+	}
+	for _, tt := range tests {
+		if got := ifRangeMatches(tt.ifRange, h); got != tt.want {
+			t.Errorf("ifRangeMatches(%q) = %v, want %v", tt.ifRange, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+)
+
+// apiV2Prefix is the path prefix of the v2 API, which serves
+// api.DatabaseMetadataV2 records.
+const apiV2Prefix = "/api/v2/"
+
+// isV2 reports whether a request was made to the v2 API.
+func isV2(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiV2Prefix)
+}
+
+// handleMetadataV2 serves all v2 metadata records as JSONL, accepting the
+// same filters as the v1 index.
+func (s *Server) handleMetadataV2(w http.ResponseWriter, r *http.Request) {
+	exists, err := s.storage(r).MetadataExists(r.Context())
+	if err != nil {
+		s.logger.Error("error checking metadata existence", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		s.logger.Error("metadata database not found")
+		http.Error(w, "metadata.sql not found", http.StatusNotFound)
+		return
+	}
+
+	idx, err := s.storage(r).Index(r.Context())
+	if err != nil {
+		s.logger.Error("error loading metadata", "error", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The unfiltered index is compressed once per refresh
+	query := r.URL.Query()
+	if encoding := negotiateEncoding(r.Header.Get("Accept-Encoding")); encoding != "" && !hasFilters(query) {
+		s.writeCompressedJSONL(w, idx, "v2", encoding, func() []byte {
+			return encodeJSONL(s.logger, idx.Records())
+		})
+		s.logger.Info("served v2 metadata records", "count", idx.Len(), "encoding", encoding)
+		return
+	}
+
+	filtered := filterRecords(idx.Records(), query)
+	writeJSONL(w, s.logger, filtered)
+	s.logger.Info("served v2 metadata records", "count", len(filtered))
+}
+
+// handleSchemaV2 serves the JSON Schema document of the v2 metadata record.
+func (s *Server) handleSchemaV2(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	if _, err := w.Write(api.MetadataV2Schema); err != nil {
+		s.logger.Error("failed to write response", "error", err)
+	}
+}
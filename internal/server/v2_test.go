@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+)
+
+func TestServer_APIv2(t *testing.T) {
+	srv := New(Config{}, &mockBackend{
+		typeStr:        "local",
+		metadataExists: true,
+		records: []api.DatabaseMetadataV2{
+			{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "h1", GitOwner: "octo", GitRepo: "hello", PrimaryLanguage: "go"}, Team: "platform"},
+			{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "h2", GitOwner: "octo", GitRepo: "other", PrimaryLanguage: "java"}},
+		},
+	}, slog.Default())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := get("/api/v2/index?team=platform")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("index status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"content_hash":"h1"`) {
+		t.Errorf("index = %q, want the platform record only", rr.Body.String())
+	}
+
+	rr = get("/api/v2/databases/h2")
+	var m api.DatabaseMetadataV2
+	if err := json.NewDecoder(rr.Body).Decode(&m); err != nil || m.PrimaryLanguage != "java" {
+		t.Errorf("databases/h2 = %+v, %v; want java record", m, err)
+	}
+
+	rr = get("/api/v2/repos/octo/hello")
+	var records []api.DatabaseMetadataV2
+	if err := json.NewDecoder(rr.Body).Decode(&records); err != nil || len(records) != 1 {
+		t.Errorf("repos/octo/hello = %+v, %v; want one record", records, err)
+	}
+
+	rr = get("/api/v2/schema.json")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/schema+json" {
+		t.Errorf("schema status, Content-Type = %d, %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if !bytes.Equal(rr.Body.Bytes(), api.MetadataV2Schema) {
+		t.Error("schema body differs from api.MetadataV2Schema")
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+)
+
+// CacheController is implemented by backends whose metadata cache can be
+// inspected and controlled at runtime, e.g. through the admin API.
+type CacheController interface {
+	// CacheStats describes the current state of the metadata cache.
+	CacheStats() CacheStats
+
+	// InvalidateCache empties the metadata cache, so the next request
+	// rediscovers the databases.
+	InvalidateCache()
+
+	// Reindex rediscovers the databases under prefix, a slash-separated
+	// directory relative to the storage root, and replaces the cached
+	// records under it, keeping the others. An empty prefix rediscovers
+	// everything.
+	Reindex(ctx context.Context, prefix string) error
+}
+
+// maxCacheErrors is the number of recent refresh errors kept for CacheStats.
+const maxCacheErrors = 10
+
+// CacheStats describes the metadata cache of a backend.
+type CacheStats struct {
+	// Entries is the number of cached metadata records.
+	Entries int
+
+	// RefreshedAt is when the cache was last filled, or zero if it is empty.
+	RefreshedAt time.Time
+
+	// TTL is how long the cache is used before it is refreshed.
+	TTL time.Duration
+
+	// LastRefreshDuration is how long the last successful refresh took.
+	LastRefreshDuration time.Duration
+
+	// Errors are the most recent refresh errors, oldest first.
+	Errors []CacheError
+}
+
+// CacheError is a failed cache refresh.
+type CacheError struct {
+	Time    time.Time
+	Message string
+}
+
+// RefreshLog records the outcome of cache refreshes for CacheStats. The zero
+// value is ready to use, and it is safe for concurrent use.
+type RefreshLog struct {
+	mu       sync.Mutex
+	duration time.Duration
+	errors   []CacheError
+}
+
+// Succeeded records a successful refresh that started at start.
+func (l *RefreshLog) Succeeded(start time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.duration = time.Since(start)
+}
+
+// Failed records a refresh error, keeping only the most recent ones.
+func (l *RefreshLog) Failed(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, CacheError{Time: time.Now(), Message: err.Error()})
+	if len(l.errors) > maxCacheErrors {
+		l.errors = l.errors[len(l.errors)-maxCacheErrors:]
+	}
+}
+
+// Stats returns the cache statistics for a cache with the given entries,
+// refresh time and TTL.
+func (l *RefreshLog) Stats(entries int, refreshedAt time.Time, ttl time.Duration) CacheStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return CacheStats{
+		Entries:             entries,
+		RefreshedAt:         refreshedAt,
+		TTL:                 ttl,
+		LastRefreshDuration: l.duration,
+		Errors:              append([]CacheError(nil), l.errors...),
+	}
+}
+
+// CleanPrefix validates a reindex prefix and returns it in canonical form:
+// slash-separated, without leading or trailing slashes, and "" for the
+// whole storage.
+func CleanPrefix(prefix string) (string, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" || prefix == "." {
+		return "", nil
+	}
+	if path.Clean(prefix) != prefix || prefix == ".." || strings.HasPrefix(prefix, "../") || strings.Contains(prefix, "\\") {
+		return "", fmt.Errorf("invalid prefix %q", prefix)
+	}
+	return prefix, nil
+}
+
+// InPrefix reports whether the storage path p lies in the directory prefix,
+// as returned by CleanPrefix. Every path lies in the empty prefix.
+func InPrefix(p, prefix string) bool {
+	return prefix == "" || strings.HasPrefix(p, prefix+"/")
+}
+
+// MergeRecords returns the cached records whose artifacts lie outside
+// prefix followed by the fresh records discovered under it.
+func MergeRecords(cached, fresh []api.DatabaseMetadataV2, prefix string) []api.DatabaseMetadataV2 {
+	if prefix == "" {
+		return fresh
+	}
+	merged := make([]api.DatabaseMetadataV2, 0, len(cached)+len(fresh))
+	for _, m := range cached {
+		if p, ok := ArtifactPath(m.ResultURL); ok && InPrefix(p, prefix) {
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return append(merged, fresh...)
+}
+
+// MergeQuarantine returns the cached quarantine entries outside prefix
+// followed by the fresh entries discovered under it.
+func MergeQuarantine(cached, fresh []codeql.QuarantinedDatabase, prefix string) []codeql.QuarantinedDatabase {
+	if prefix == "" {
+		return fresh
+	}
+	merged := make([]codeql.QuarantinedDatabase, 0, len(cached)+len(fresh))
+	for _, q := range cached {
+		if !InPrefix(q.RelPath, prefix) {
+			merged = append(merged, q)
+		}
+	}
+	return append(merged, fresh...)
+}
+
+// MergeArtifactRecords returns the cached records of other artifacts
+// followed by the fresh records discovered in the artifact at artifactPath.
+func MergeArtifactRecords(cached, fresh []api.DatabaseMetadataV2, artifactPath string) []api.DatabaseMetadataV2 {
+	merged := make([]api.DatabaseMetadataV2, 0, len(cached)+len(fresh))
+	for _, m := range cached {
+		if p, ok := ArtifactPath(m.ResultURL); ok && p == artifactPath {
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return append(merged, fresh...)
+}
+
+// MergeArtifactQuarantine returns the cached quarantine entries of other
+// artifacts followed by the fresh entry, if any, of the artifact at
+// artifactPath.
+func MergeArtifactQuarantine(cached, fresh []codeql.QuarantinedDatabase, artifactPath string) []codeql.QuarantinedDatabase {
+	merged := make([]codeql.QuarantinedDatabase, 0, len(cached)+len(fresh))
+	for _, q := range cached {
+		if q.RelPath != artifactPath {
+			merged = append(merged, q)
+		}
+	}
+	return append(merged, fresh...)
+}
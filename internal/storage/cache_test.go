@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+)
+
+func TestCleanPrefix(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{prefix: "", want: ""},
+		{prefix: "/", want: ""},
+		{prefix: ".", want: ""},
+		{prefix: "team-a", want: "team-a"},
+		{prefix: "/team-a/go/", want: "team-a/go"},
+		{prefix: "team-a//go", wantErr: true},
+		{prefix: "team-a/./go", wantErr: true},
+		{prefix: "..", wantErr: true},
+		{prefix: "../outside", wantErr: true},
+		{prefix: "team-a/../../outside", wantErr: true},
+		{prefix: `team-a\go`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got, err := CleanPrefix(tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CleanPrefix(%q) error = %v, wantErr %v", tt.prefix, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CleanPrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{path: "team-a/repo.zip", prefix: "", want: true},
+		{path: "team-a/repo.zip", prefix: "team-a", want: true},
+		{path: "team-a/go/repo.zip", prefix: "team-a", want: true},
+		{path: "team-ab/repo.zip", prefix: "team-a", want: false},
+		{path: "team-a", prefix: "team-a", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := InPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("InPrefix(%q, %q) = %v, want %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestMergeRecords(t *testing.T) {
+	record := func(p string) api.DatabaseMetadataV2 {
+		return api.DatabaseMetadataV2{DatabaseMetadata: api.DatabaseMetadata{
+			ResultURL: "http://localhost:8080/db/" + p,
+		}}
+	}
+	paths := func(records []api.DatabaseMetadataV2) []string {
+		var result []string
+		for _, m := range records {
+			p, _ := ArtifactPath(m.ResultURL)
+			result = append(result, p)
+		}
+		return result
+	}
+
+	cached := []api.DatabaseMetadataV2{record("a/one.zip"), record("b/two.zip"), record("a/old.zip?language=go")}
+	fresh := []api.DatabaseMetadataV2{record("a/one.zip"), record("a/new.zip")}
+
+	got := paths(MergeRecords(cached, fresh, "a"))
+	if want := []string{"b/two.zip", "a/one.zip", "a/new.zip"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeRecords() = %v, want %v", got, want)
+	}
+
+	got = paths(MergeRecords(cached, fresh, ""))
+	if want := []string{"a/one.zip", "a/new.zip"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeRecords() without prefix = %v, want %v", got, want)
+	}
+}
+
+func TestMergeQuarantine(t *testing.T) {
+	cached := []codeql.QuarantinedDatabase{{RelPath: "a/old.zip"}, {RelPath: "b/two.zip"}}
+	fresh := []codeql.QuarantinedDatabase{{RelPath: "a/new.zip"}}
+
+	got := MergeQuarantine(cached, fresh, "a")
+	if want := []codeql.QuarantinedDatabase{{RelPath: "b/two.zip"}, {RelPath: "a/new.zip"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeQuarantine() = %v, want %v", got, want)
+	}
+	if got := MergeQuarantine(cached, fresh, ""); !reflect.DeepEqual(got, fresh) {
+		t.Errorf("MergeQuarantine() without prefix = %v, want %v", got, fresh)
+	}
+}
+
+func TestMergeArtifact(t *testing.T) {
+	record := func(p string) api.DatabaseMetadataV2 {
+		return api.DatabaseMetadataV2{DatabaseMetadata: api.DatabaseMetadata{
+			ResultURL: "http://localhost:8080/db/" + p,
+		}}
+	}
+	cached := []api.DatabaseMetadataV2{record("a/one.zip?language=go"), record("a/one.zip?language=java"), record("a/one.zipped.zip"), record("a")}
+	fresh := []api.DatabaseMetadataV2{record("a/one.zip")}
+
+	got := MergeArtifactRecords(cached, fresh, "a/one.zip")
+	if want := []api.DatabaseMetadataV2{record("a/one.zipped.zip"), record("a"), record("a/one.zip")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeArtifactRecords() = %v, want %v", got, want)
+	}
+
+	quarantine := []codeql.QuarantinedDatabase{{RelPath: "a"}, {RelPath: "a/one.zip"}}
+	gotQ := MergeArtifactQuarantine(quarantine, nil, "a")
+	if want := []codeql.QuarantinedDatabase{{RelPath: "a/one.zip"}}; !reflect.DeepEqual(gotQ, want) {
+		t.Errorf("MergeArtifactQuarantine() = %v, want %v", gotQ, want)
+	}
+}
+
+func TestRefreshLog(t *testing.T) {
+	var log RefreshLog
+
+	stats := log.Stats(0, time.Time{}, time.Minute)
+	if stats.Entries != 0 || stats.TTL != time.Minute || len(stats.Errors) != 0 {
+		t.Errorf("Stats() of empty log = %+v", stats)
+	}
+
+	log.Succeeded(time.Now().Add(-2 * time.Second))
+	for i := range maxCacheErrors + 2 {
+		log.Failed(fmt.Errorf("error %d", i))
+	}
+
+	refreshedAt := time.Now()
+	stats = log.Stats(3, refreshedAt, time.Minute)
+	if stats.Entries != 3 || !stats.RefreshedAt.Equal(refreshedAt) {
+		t.Errorf("Stats() = %+v", stats)
+	}
+	if stats.LastRefreshDuration < 2*time.Second {
+		t.Errorf("LastRefreshDuration = %v, want at least 2s", stats.LastRefreshDuration)
+	}
+	if len(stats.Errors) != maxCacheErrors {
+		t.Fatalf("len(Errors) = %d, want %d", len(stats.Errors), maxCacheErrors)
+	}
+	if stats.Errors[0].Message != "error 2" || stats.Errors[maxCacheErrors-1].Message != fmt.Sprintf("error %d", maxCacheErrors+1) {
+		t.Errorf("Errors = %+v, want the most recent, oldest first", stats.Errors)
+	}
+
+	// The returned errors are a copy
+	stats.Errors[0].Message = "changed"
+	log.Failed(errors.New("another"))
+	if got := log.Stats(0, time.Time{}, 0).Errors[0].Message; got == "changed" {
+		t.Error("Stats() returned the log's own error slice")
+	}
+}
@@ -0,0 +1,499 @@
+package gcs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+	"google.golang.org/api/iterator"
+)
+
+// bucketFS presents the objects under a bucket prefix as a read-only
+// fs.ReadDirFS for the discovery pipeline. Directories are derived from
+// object names using delimiter listings, and files support ranged reads.
+// Candidate databases are found with a single glob-filtered listing.
+type bucketFS struct {
+	ctx    context.Context
+	bucket *storage.BucketHandle
+	prefix string
+
+	// objects holds the objects seen in listings, by object name, so
+	// opening, hashing or stamping them needs no further requests
+	mu      sync.Mutex
+	objects map[string]objectInfo
+
+	// trees holds the files below the directories listed by Tree, by
+	// directory, so reading those directories needs no further requests
+	trees map[string][]objectEntry
+}
+
+// objectEntry is an object listed by Tree.
+type objectEntry struct {
+	// path is relative to the listed directory
+	path string
+	info objectInfo
+}
+
+// objectHash is the content hash of an object and its api.HashKind* kind.
+type objectHash struct {
+	hash string
+	kind string
+}
+
+// newBucketFS returns a filesystem over the objects under prefix. All
+// requests made through it use ctx.
+func newBucketFS(ctx context.Context, bucket *storage.BucketHandle, prefix string) *bucketFS {
+	return &bucketFS{
+		ctx:     ctx,
+		bucket:  bucket,
+		prefix:  prefix,
+		objects: make(map[string]objectInfo),
+		trees:   make(map[string][]objectEntry),
+	}
+}
+
+// listedAttrs are the object attributes requested in listings.
+var listedAttrs = []string{"Name", "Size", "Updated", "Generation", "MD5", "CRC32C", "Metadata"}
+
+// remember records an object returned by a listing and returns its info.
+func (b *bucketFS) remember(attrs *storage.ObjectAttrs) objectInfo {
+	info := objectInfo{
+		name:       path.Base(attrs.Name),
+		size:       attrs.Size,
+		modTime:    attrs.Updated,
+		generation: attrs.Generation,
+		hash:       hashOf(attrs),
+	}
+	b.mu.Lock()
+	b.objects[attrs.Name] = info
+	b.mu.Unlock()
+	return info
+}
+
+// listed returns the info of an object seen in a listing.
+func (b *bucketFS) listed(objectName string) (objectInfo, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	info, ok := b.objects[objectName]
+	return info, ok
+}
+
+// objectName returns the object name for a filesystem path.
+func (b *bucketFS) objectName(name string) string {
+	if name == "." {
+		return b.prefix
+	}
+	return b.prefix + name
+}
+
+// Open implements fs.FS.
+func (b *bucketFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &bucketDir{fsys: b, name: name}, nil
+	}
+	obj := b.bucket.Object(b.objectName(name))
+	if info, ok := b.listed(b.objectName(name)); ok {
+		return &objectFile{objectReaderAt: objectReaderAt{ctx: b.ctx, obj: obj, size: info.size}, info: info}, nil
+	}
+	if entries, rel, ok := b.tree(name); ok {
+		return b.openListed(name, entries, rel)
+	}
+
+	attrs, err := obj.Attrs(b.ctx)
+	if err == nil {
+		return &objectFile{
+			objectReaderAt: objectReaderAt{ctx: b.ctx, obj: obj, size: attrs.Size},
+			info:           objectInfo{name: path.Base(name), size: attrs.Size, modTime: attrs.Updated, generation: attrs.Generation, hash: hashOf(attrs)},
+		}, nil
+	}
+	if !errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	// Not an object; it is a directory if any object lives beneath it
+	it := b.bucket.Objects(b.ctx, &storage.Query{Prefix: b.objectName(name) + "/", Delimiter: "/"})
+	if _, err := it.Next(); err != nil {
+		if errors.Is(err, iterator.Done) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &bucketDir{fsys: b, name: name}, nil
+}
+
+// ReadDir implements fs.ReadDirFS using a delimiter listing, so only the
+// direct children of name are fetched.
+func (b *bucketFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if tree, rel, ok := b.tree(name); ok {
+		return readListedDir(name, tree, rel)
+	}
+
+	dirPrefix := b.prefix
+	if name != "." {
+		dirPrefix = b.objectName(name) + "/"
+	}
+
+	var entries []fs.DirEntry
+	query := &storage.Query{Prefix: dirPrefix, Delimiter: "/"}
+	if err := query.SetAttrSelection(listedAttrs); err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	it := b.bucket.Objects(b.ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+
+		if attrs.Prefix != "" {
+			dir := strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, dirPrefix), "/")
+			if dir != "" {
+				entries = append(entries, fs.FileInfoToDirEntry(objectInfo{name: dir, dir: true}))
+			}
+			continue
+		}
+
+		// Skip directory placeholder objects such as "dir/"
+		base := strings.TrimPrefix(attrs.Name, dirPrefix)
+		if base == "" {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(b.remember(attrs)))
+	}
+
+	if entries == nil && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Tree implements codeql.TreeFS with a listing without delimiter, which
+// returns every object below dir in one pass however deeply it is nested.
+// Later reads below dir are answered from this listing.
+func (b *bucketFS) Tree(dir string) ([]codeql.TreeFile, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "tree", Path: dir, Err: fs.ErrInvalid}
+	}
+	dirPrefix := b.prefix
+	if dir != "." {
+		dirPrefix = b.objectName(dir) + "/"
+	}
+
+	var entries []objectEntry
+	query := &storage.Query{Prefix: dirPrefix}
+	if err := query.SetAttrSelection(listedAttrs); err != nil {
+		return nil, &fs.PathError{Op: "tree", Path: dir, Err: err}
+	}
+	it := b.bucket.Objects(b.ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "tree", Path: dir, Err: err}
+		}
+
+		// Skip directory placeholder objects such as "dir/"
+		rel := strings.TrimPrefix(attrs.Name, dirPrefix)
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			continue
+		}
+		entries = append(entries, objectEntry{path: rel, info: b.remember(attrs)})
+	}
+
+	b.mu.Lock()
+	b.trees[dir] = entries
+	b.mu.Unlock()
+
+	files := make([]codeql.TreeFile, len(entries))
+	for i, e := range entries {
+		files[i] = codeql.TreeFile{Path: e.path, Size: e.info.size, ModTime: e.info.modTime}
+	}
+	return files, nil
+}
+
+// tree returns the listing by Tree of a directory containing name, or name
+// itself, and the path of name relative to that directory.
+func (b *bucketFS) tree(name string) ([]objectEntry, string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if entries, ok := b.trees[dir]; ok {
+			if dir == name {
+				return entries, ".", true
+			}
+			return entries, name[len(dir)+1:], true
+		}
+	}
+	return nil, "", false
+}
+
+// openListed opens the file or directory rel of a directory listed by Tree.
+func (b *bucketFS) openListed(name string, tree []objectEntry, rel string) (fs.File, error) {
+	for _, e := range tree {
+		switch {
+		case e.path == rel:
+			return &objectFile{
+				objectReaderAt: objectReaderAt{ctx: b.ctx, obj: b.bucket.Object(b.objectName(name)), size: e.info.size},
+				info:           e.info,
+			}, nil
+		case rel == "." || strings.HasPrefix(e.path, rel+"/"):
+			return &bucketDir{fsys: b, name: name}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// readListedDir returns the entries of the directory rel of a directory
+// listed by Tree.
+func readListedDir(name string, tree []objectEntry, rel string) ([]fs.DirEntry, error) {
+	dirPrefix := ""
+	if rel != "." {
+		dirPrefix = rel + "/"
+	}
+
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+	for _, e := range tree {
+		rest, ok := strings.CutPrefix(e.path, dirPrefix)
+		if !ok {
+			continue
+		}
+		if dir, _, nested := strings.Cut(rest, "/"); nested {
+			if !seen[dir] {
+				seen[dir] = true
+				entries = append(entries, fs.FileInfoToDirEntry(objectInfo{name: dir, dir: true}))
+			}
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(e.info))
+	}
+
+	if entries == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Hash implements codeql.HashFS from the object's attributes, so that
+// discovery never downloads an object just to hash it.
+func (b *bucketFS) Hash(name string) (string, string, error) {
+	objectName := b.objectName(name)
+	if info, ok := b.listed(objectName); ok {
+		return info.hash.hash, info.hash.kind, nil
+	}
+
+	attrs, err := b.bucket.Object(objectName).Attrs(b.ctx)
+	if err != nil {
+		return "", "", &fs.PathError{Op: "hash", Path: name, Err: err}
+	}
+	h := hashOf(attrs)
+	return h.hash, h.kind, nil
+}
+
+// databaseGlob matches zip archives and codeql-database.yml files at any
+// depth. GCS matches it against whole object names, prefix included.
+const databaseGlob = "{**.[zZ][iI][pP],**/codeql-database.yml}"
+
+// FindDatabases implements codeql.FindFS with a listing filtered by
+// databaseGlob, so that GCS returns only candidate objects rather than every
+// file of every database. Names are checked again here, as not every GCS
+// implementation filters by glob.
+func (b *bucketFS) FindDatabases(dir string) ([]string, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "find", Path: dir, Err: fs.ErrInvalid}
+	}
+	dirPrefix := b.prefix
+	if dir != "." {
+		dirPrefix = b.objectName(dir) + "/"
+	}
+
+	query := &storage.Query{Prefix: dirPrefix, MatchGlob: databaseGlob}
+	if err := query.SetAttrSelection(listedAttrs); err != nil {
+		return nil, &fs.PathError{Op: "find", Path: dir, Err: err}
+	}
+	var found []string
+	it := b.bucket.Objects(b.ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "find", Path: dir, Err: err}
+		}
+
+		name := strings.TrimPrefix(attrs.Name, b.prefix)
+		if !fs.ValidPath(name) {
+			continue
+		}
+		if path.Base(name) != "codeql-database.yml" && !strings.HasSuffix(strings.ToLower(name), ".zip") {
+			continue
+		}
+		b.remember(attrs)
+		found = append(found, name)
+	}
+	return found, nil
+}
+
+// Stamp implements codeql.StampFS. An archive is stamped with its object
+// generation, which GCS changes whenever the object is rewritten, and a
+// directory listed by Tree with the names and generations of its objects.
+func (b *bucketFS) Stamp(name string) (string, bool) {
+	if info, ok := b.listed(b.objectName(name)); ok {
+		return strconv.FormatInt(info.generation, 10), true
+	}
+
+	b.mu.Lock()
+	entries, ok := b.trees[name]
+	b.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	h := sha256.New()
+	for _, e := range entries {
+		fmt.Fprintf(h, "%s\x00%d\n", e.path, e.info.generation)
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// hashOf returns the strongest content hash recorded for an object: the
+// SHA-256 that PutFile stores in its metadata, else the MD5 or CRC32C that
+// GCS computes. Composite objects have no MD5.
+func hashOf(attrs *storage.ObjectAttrs) objectHash {
+	if sum := attrs.Metadata[sha256MetadataKey]; sum != "" {
+		return objectHash{hash: sum, kind: api.HashKindSHA256}
+	}
+	if len(attrs.MD5) > 0 {
+		return objectHash{hash: hex.EncodeToString(attrs.MD5), kind: api.HashKindMD5}
+	}
+	return objectHash{hash: fmt.Sprintf("%08x", attrs.CRC32C), kind: api.HashKindCRC32C}
+}
+
+// objectFile is an open object. It supports sequential and ranged reads.
+type objectFile struct {
+	objectReaderAt
+	info   objectInfo
+	offset int64
+}
+
+// Stat implements fs.File.
+func (f *objectFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Read implements fs.File.
+func (f *objectFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Close implements fs.File.
+func (f *objectFile) Close() error {
+	return nil
+}
+
+// bucketDir is an open directory.
+type bucketDir struct {
+	fsys *bucketFS
+	name string
+
+	// entries is listed on the first ReadDir call and consumed by later calls
+	entries []fs.DirEntry
+	listed  bool
+}
+
+// Stat implements fs.File.
+func (d *bucketDir) Stat() (fs.FileInfo, error) {
+	return objectInfo{name: path.Base(d.name), dir: true}, nil
+}
+
+// Read implements fs.File.
+func (d *bucketDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fmt.Errorf("is a directory")}
+}
+
+// Close implements fs.File.
+func (d *bucketDir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *bucketDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// objectInfo implements fs.FileInfo for objects and directories.
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+
+	// generation and hash are known for objects only
+	generation int64
+	hash       objectHash
+}
+
+func (i objectInfo) Name() string       { return i.name }
+func (i objectInfo) Size() int64        { return i.size }
+func (i objectInfo) ModTime() time.Time { return i.modTime }
+func (i objectInfo) IsDir() bool        { return i.dir }
+func (i objectInfo) Sys() any           { return nil }
+
+// Mode implements fs.FileInfo.
+func (i objectInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
@@ -0,0 +1,171 @@
+package gcs
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // Matches the checksum GCS records
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"cloud.google.com/go/storage"
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
+
+func TestBucketFS(t *testing.T) {
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	server.createFile(t, "root/top.txt", []byte("top"), "")
+	server.createFile(t, "root/a/one.txt", []byte("one"), "")
+	server.createFile(t, "root/a/b/two.txt", []byte("two two"), "")
+	server.createFile(t, "root/c/", nil, "") // directory placeholder
+	server.createFile(t, "root/c/three.txt", []byte("three"), "")
+	server.createFile(t, "other/ignored.txt", []byte("ignored"), "")
+
+	fsys := newBucketFS(context.Background(), server.Client().Bucket("test-bucket"), "root/")
+
+	if err := fstest.TestFS(fsys, "top.txt", "a/one.txt", "a/b/two.txt", "c/three.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Stat(fsys, "ignored.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(ignored.txt) error = %v, want ErrNotExist", err)
+	}
+	if _, err := fs.ReadDir(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDir(missing) error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestBucketFS_Tree(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	server.createFile(t, "root/db/codeql-database.yml", []byte("yml"), "")
+	server.createFile(t, "root/db/db-go/default/strings", []byte("strings"), "")
+	server.createFile(t, "root/db/db-go/default/", nil, "") // directory placeholder
+	server.createFile(t, "root/db.zip", []byte("not below db/"), "")
+
+	bucket := server.Client().Bucket("test-bucket")
+	fsys := newBucketFS(ctx, bucket, "root/")
+	files, err := fsys.Tree("db")
+	if err != nil {
+		t.Fatalf("Tree() error = %v", err)
+	}
+	var got []string
+	var size int64
+	for _, f := range files {
+		got = append(got, f.Path)
+		size += f.Size
+	}
+	sort.Strings(got)
+	if want := []string{"codeql-database.yml", "db-go/default/strings"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tree() paths = %v, want %v", got, want)
+	}
+	if size != int64(len("yml")+len("strings")) {
+		t.Errorf("Tree() total size = %d, want %d", size, len("yml")+len("strings"))
+	}
+	if err := fstest.TestFS(fsys, "db/codeql-database.yml", "db/db-go/default/strings", "db.zip"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads below the listed directory are answered from the listing, so
+	// they still see an object deleted since
+	if err := bucket.Object("root/db/codeql-database.yml").Delete(ctx); err != nil {
+		t.Fatalf("failed to delete object: %v", err)
+	}
+	if info, err := fs.Stat(fsys, "db/codeql-database.yml"); err != nil || info.Size() != 3 {
+		t.Errorf("Stat(db/codeql-database.yml) = %v, %v; want the listed object", info, err)
+	}
+	entries, err := fs.ReadDir(fsys, "db")
+	if err != nil || len(entries) != 2 || !entries[1].IsDir() {
+		t.Errorf("ReadDir(db) = %v, %v; want codeql-database.yml and db-go/", entries, err)
+	}
+	if _, err := fs.ReadDir(fsys, "db/db-go/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDir(db/db-go/missing) error = %v, want ErrNotExist", err)
+	}
+	if _, _, err := fsys.Hash("db/db-go/default/strings"); err != nil {
+		t.Errorf("Hash() error = %v", err)
+	}
+}
+
+func TestBucketFS_Hash(t *testing.T) {
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	content := []byte("database")
+	md5Sum := md5.Sum(content)
+	server.createFile(t, "a/plain.zip", content, "")
+	server.CreateObject(fakestorage.Object{
+		ObjectAttrs: fakestorage.ObjectAttrs{
+			BucketName: "test-bucket",
+			Name:       "a/uploaded.zip",
+			Metadata:   map[string]string{sha256MetadataKey: "recorded-sha256"},
+		},
+		Content: content,
+	})
+
+	tests := []struct {
+		name     string
+		wantHash string
+		wantKind string
+	}{
+		{name: "uploaded.zip", wantHash: "recorded-sha256", wantKind: api.HashKindSHA256},
+		{name: "plain.zip", wantHash: hex.EncodeToString(md5Sum[:]), wantKind: api.HashKindMD5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Hashes come from the attributes of a single object, or from
+			// a listing of its directory
+			fsys := newBucketFS(context.Background(), server.Client().Bucket("test-bucket"), "a/")
+			for _, listed := range []bool{false, true} {
+				if listed {
+					if _, err := fsys.ReadDir("."); err != nil {
+						t.Fatalf("ReadDir() error = %v", err)
+					}
+				}
+				hash, kind, err := fsys.Hash(tt.name)
+				if err != nil {
+					t.Fatalf("Hash() error = %v", err)
+				}
+				if hash != tt.wantHash || kind != tt.wantKind {
+					t.Errorf("Hash() (listed %v) = %q, %q; want %q, %q", listed, hash, kind, tt.wantHash, tt.wantKind)
+				}
+			}
+		})
+	}
+
+	fsys := newBucketFS(context.Background(), server.Client().Bucket("test-bucket"), "a/")
+	if _, _, err := fsys.Hash("missing.zip"); err == nil {
+		t.Error("Hash(missing.zip) error = nil, want error")
+	}
+}
+
+func TestHashOf_CRC32C(t *testing.T) {
+	// Composite objects have a CRC32C but no MD5
+	attrs := &storage.ObjectAttrs{Name: "widgets.zip", CRC32C: 0xdeadbeef, ComponentCount: 2}
+	h := hashOf(attrs)
+	if h.hash != "deadbeef" || h.kind != api.HashKindCRC32C {
+		t.Errorf("hashOf() = %+v, want CRC32C deadbeef", h)
+	}
+
+	// Its database has a build CID even without creation metadata
+	m := codeql.BuildMetadata(&codeql.DiscoveredDatabase{
+		Name:        attrs.Name,
+		RelPath:     attrs.Name,
+		IsArchived:  true,
+		Language:    "go",
+		ContentHash: h.hash,
+		HashKind:    h.kind,
+	}, "http://localhost:8080")
+	if m.ContentHash != "deadbeef" || len(m.BuildCID) != 10 {
+		t.Errorf("ContentHash, BuildCID = %q, %q, want deadbeef and 10 characters", m.ContentHash, m.BuildCID)
+	}
+}
@@ -8,18 +8,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"cloud.google.com/go/pubsub/v2"
 	"cloud.google.com/go/storage"
 	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
 	hepcStorage "github.com/data-douser/mrva-go-hepc/internal/storage"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
-	"gopkg.in/yaml.v3"
 )
 
 // Backend implements storage.Backend for Google Cloud Storage.
@@ -29,12 +32,25 @@ type Backend struct {
 	prefix        string
 	localCacheDir string
 	endpointURL   string
+	discovery     codeql.Options
 
 	// Cache for discovered databases
 	mu             sync.RWMutex
 	cachedMetadata []api.DatabaseMetadata
+	cachedIndex    *hepcStorage.Index
 	cacheTime      time.Time
 	cacheTTL       time.Duration
+	quarantine     []codeql.QuarantinedDatabase
+	refreshLog     hepcStorage.RefreshLog
+
+	// Object change notifications, if subscribed
+	notifier *notifier
+
+	// Persisted metadata, and the background rediscovery after restoring it
+	snapshots       *hepcStorage.SnapshotStore
+	snapshotSeq     uint64
+	cancelReconcile context.CancelFunc
+	reconciled      chan struct{}
 }
 
 // Config holds configuration for the GCS storage backend.
@@ -50,7 +66,9 @@ type Config struct {
 	CredentialsFile string
 
 	// LocalCacheDir is a local directory for caching downloaded files.
-	// If empty, uses a temp directory.
+	// If empty, uses a temp directory. If set, the metadata of each
+	// discovery is persisted there, and served on startup while the bucket
+	// is rediscovered in the background.
 	LocalCacheDir string
 
 	// EndpointURL is the base URL for constructing result URLs.
@@ -59,9 +77,32 @@ type Config struct {
 	// CacheTTL is how long to cache discovered metadata (default: 5 minutes).
 	CacheTTL time.Duration
 
+	// IdentityRules map database paths or names to repositories when no
+	// sidecar file or git remote identifies them.
+	IdentityRules []codeql.IdentityRule
+
+	// Validation selects the checks databases must pass to be advertised
+	// (default: codeql.ValidateBasic).
+	Validation codeql.Validation
+
+	// Subscription is an optional Pub/Sub subscription, named
+	// "projects/<project>/subscriptions/<id>", receiving the bucket's
+	// object change notifications. Created and deleted objects are then
+	// applied to the cached metadata as they are reported; full scans
+	// still run whenever the cache expires.
+	Subscription string
+
+	// NotificationDelay is how long to wait after a notification for
+	// further ones before applying them (default: 2 seconds).
+	NotificationDelay time.Duration
+
 	// Client is an optional pre-configured GCS client for testing.
 	// If provided, CredentialsFile is ignored.
 	Client *storage.Client
+
+	// PubSubClient is an optional pre-configured Pub/Sub client for
+	// testing. If provided, CredentialsFile is ignored for it.
+	PubSubClient *pubsub.Client
 }
 
 // New creates a new GCS storage backend.
@@ -77,16 +118,10 @@ func New(ctx context.Context, cfg Config) (*Backend, error) {
 	if cfg.Client != nil {
 		client = cfg.Client
 	} else {
-		var opts []option.ClientOption
-		if cfg.CredentialsFile != "" {
-			credsData, readErr := os.ReadFile(cfg.CredentialsFile)
-			if readErr != nil {
-				return nil, fmt.Errorf("gcs storage: failed to read credentials file: %w", readErr)
-			}
-			//nolint:staticcheck // SA1019: option.WithCredentialsJSON is deprecated, but still needed for service account support
-			opts = append(opts, option.WithCredentialsJSON(credsData))
+		opts, optsErr := clientOptions(cfg)
+		if optsErr != nil {
+			return nil, fmt.Errorf("gcs storage: %w", optsErr)
 		}
-
 		client, err = storage.NewClient(ctx, opts...)
 		if err != nil {
 			return nil, fmt.Errorf("gcs storage: failed to create client: %w", err)
@@ -105,6 +140,9 @@ func New(ctx context.Context, cfg Config) (*Backend, error) {
 			_ = client.Close() //nolint:errcheck // Best effort close
 			return nil, fmt.Errorf("gcs storage: failed to create cache directory: %w", err)
 		}
+	} else if err := os.MkdirAll(localCacheDir, 0o750); err != nil {
+		_ = client.Close() //nolint:errcheck // Best effort close
+		return nil, fmt.Errorf("gcs storage: failed to create cache directory: %w", err)
 	}
 
 	endpointURL := cfg.EndpointURL
@@ -117,14 +155,51 @@ func New(ctx context.Context, cfg Config) (*Backend, error) {
 		cacheTTL = 5 * time.Minute
 	}
 
-	return &Backend{
+	var snapshots *hepcStorage.SnapshotStore
+	if cfg.LocalCacheDir != "" {
+		// A temporary directory would not outlive the process
+		snapshots = hepcStorage.NewSnapshotStore(filepath.Join(cfg.LocalCacheDir, hepcStorage.SnapshotFileName),
+			append([]string{"gcs", cfg.Bucket, prefix, endpointURL}, hepcStorage.DiscoverySettings(cfg.Validation, cfg.IdentityRules)...)...)
+	}
+
+	b := &Backend{
 		client:        client,
 		bucket:        cfg.Bucket,
 		prefix:        prefix,
 		localCacheDir: localCacheDir,
 		endpointURL:   endpointURL,
-		cacheTTL:      cacheTTL,
-	}, nil
+		discovery: codeql.Options{
+			IdentityRules:  cfg.IdentityRules,
+			Validation:     cfg.Validation,
+			DiscoveryCache: codeql.NewDiscoveryCache(),
+		},
+		cacheTTL:  cacheTTL,
+		snapshots: snapshots,
+	}
+	b.restore()
+
+	if cfg.Subscription != "" {
+		if err := b.startNotifications(ctx, cfg); err != nil {
+			b.stopReconcile()
+			_ = client.Close() //nolint:errcheck // Best effort close
+			return nil, fmt.Errorf("gcs storage: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// clientOptions returns the options for creating Google Cloud clients with
+// the configured credentials.
+func clientOptions(cfg Config) ([]option.ClientOption, error) {
+	if cfg.CredentialsFile == "" {
+		return nil, nil
+	}
+	credsData, err := os.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	//nolint:staticcheck // SA1019: option.WithCredentialsJSON is deprecated, but still needed for service account support
+	return []option.ClientOption{option.WithCredentialsJSON(credsData)}, nil
 }
 
 // Type returns the storage backend type identifier.
@@ -132,9 +207,14 @@ func (b *Backend) Type() string {
 	return "gcs"
 }
 
-// objectPath returns the full object path including prefix.
-func (b *Backend) objectPath(filename string) string {
-	return b.prefix + filename
+// objectPath returns the full object path including prefix. File names
+// that are not clean relative paths, such as those with ".." segments, are
+// rejected so that no request can reach objects outside the prefix.
+func (b *Backend) objectPath(filename string) (string, error) {
+	if err := validObjectPath(filename); err != nil {
+		return "", err
+	}
+	return b.prefix + filename, nil
 }
 
 // ListMetadata discovers CodeQL databases in the GCS bucket and returns their metadata.
@@ -149,184 +229,244 @@ func (b *Backend) ListMetadata(ctx context.Context) ([]api.DatabaseMetadata, err
 	}
 	b.mu.RUnlock()
 
+	return b.refresh(ctx, "")
+}
+
+// refresh rediscovers the databases under dir, or all databases if dir is
+// empty, and updates the cache, keeping the cached databases outside dir.
+// It returns the metadata of all cached databases.
+func (b *Backend) refresh(ctx context.Context, dir string) ([]api.DatabaseMetadata, error) {
+	start := time.Now()
+
 	// Discover databases
-	metadata, err := b.discoverDatabases(ctx)
+	records, quarantine, err := b.discoverDatabases(ctx, dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to discover databases: %w", err)
+		err = fmt.Errorf("failed to discover databases: %w", err)
+		b.refreshLog.Failed(err)
+		return nil, err
 	}
 
-	// Update cache
-	b.mu.Lock()
-	b.cachedMetadata = metadata
-	b.cacheTime = time.Now()
-	b.mu.Unlock()
-
-	return metadata, nil
+	if dir == "" {
+		return b.update(start, records, quarantine, nil), nil
+	}
+	return b.update(start, records, quarantine, func(cached []api.DatabaseMetadataV2, cachedQuarantine []codeql.QuarantinedDatabase) ([]api.DatabaseMetadataV2, []codeql.QuarantinedDatabase) {
+		return hepcStorage.MergeRecords(cached, records, dir), hepcStorage.MergeQuarantine(cachedQuarantine, quarantine, dir)
+	}), nil
 }
 
-// discoverDatabases scans the GCS bucket for unarchived CodeQL databases.
-// Note: Archived (.zip) databases are not supported in cloud storage because
-// reading metadata would require downloading the entire archive, which is
-// unacceptable for large databases (often multiple gigabytes).
-func (b *Backend) discoverDatabases(ctx context.Context) ([]api.DatabaseMetadata, error) {
-	var metadata []api.DatabaseMetadata
+// refreshArtifact rediscovers the single database archive or directory
+// at name, relative to the prefix, and replaces its databases in the
+// cache. An artifact that no longer exists is dropped from the cache.
+func (b *Backend) refreshArtifact(ctx context.Context, name string) error {
+	start := time.Now()
 
-	// Track discovered databases to avoid duplicates
-	discovered := make(map[string]bool)
+	result, err := codeql.DiscoverArtifact(b.bucketFS(ctx), name, b.discovery)
+	if err != nil {
+		err = fmt.Errorf("failed to discover database %s: %w", name, err)
+		b.refreshLog.Failed(err)
+		return err
+	}
+	records := b.records(result)
 
-	bkt := b.client.Bucket(b.bucket)
-	query := &storage.Query{Prefix: b.prefix}
+	b.update(start, records, result.Quarantined, func(cached []api.DatabaseMetadataV2, cachedQuarantine []codeql.QuarantinedDatabase) ([]api.DatabaseMetadataV2, []codeql.QuarantinedDatabase) {
+		return hepcStorage.MergeArtifactRecords(cached, records, name), hepcStorage.MergeArtifactQuarantine(cachedQuarantine, result.Quarantined, name)
+	})
+	return nil
+}
 
-	it := bkt.Objects(ctx, query)
-	for {
-		attrs, err := it.Next()
-		if errors.Is(err, iterator.Done) {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to list objects: %w", err)
+// update replaces the cache with records and quarantine, or, if merge is
+// not nil, with what merge combines from the cached databases and them.
+// A merge keeps the expiry of the cache, since the databases it keeps
+// expire as they would have, and is skipped if the cache was invalidated
+// meanwhile. It returns the metadata of all cached databases.
+func (b *Backend) update(start time.Time, records []api.DatabaseMetadataV2, quarantine []codeql.QuarantinedDatabase,
+	merge func([]api.DatabaseMetadataV2, []codeql.QuarantinedDatabase) ([]api.DatabaseMetadataV2, []codeql.QuarantinedDatabase)) []api.DatabaseMetadata {
+	b.mu.Lock()
+	cacheTime := time.Now()
+	if merge != nil {
+		if b.cachedIndex == nil {
+			// Invalidated meanwhile; the next request rediscovers everything
+			b.mu.Unlock()
+			return nil
 		}
+		records, quarantine = merge(b.cachedIndex.Records(), b.quarantine)
+		cacheTime = b.cacheTime
+	}
+	metadata := api.ToV1(records)
 
-		objectName := attrs.Name
+	// Update cache
+	b.cachedMetadata = metadata
+	b.cachedIndex = hepcStorage.NewIndex(records)
+	b.cacheTime = cacheTime
+	b.quarantine = quarantine
+	b.refreshLog.Succeeded(start)
+	b.snapshotSeq++
+	seq := b.snapshotSeq
+	b.mu.Unlock()
 
-		// Only support unarchived databases (look for codeql-database.yml)
-		// Archived (.zip) databases are skipped - reading metadata would require
-		// downloading the entire archive which is not acceptable for large databases.
-		if strings.HasSuffix(objectName, "/codeql-database.yml") {
-			// Extract the database directory path
-			dbPath := strings.TrimSuffix(objectName, "/codeql-database.yml")
-			if discovered[dbPath] {
-				continue
-			}
-			discovered[dbPath] = true
+	if err := b.snapshots.Save(seq, records, quarantine); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return metadata
+}
 
-			m, err := b.discoverUnarchivedDatabase(ctx, dbPath)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to process %s: %v\n", dbPath, err)
-				continue
-			}
-			if m != nil {
-				metadata = append(metadata, *m)
-			}
-		}
+// restore fills the cache from the persisted snapshot, if there is one, and
+// rediscovers the bucket in the background to bring it up to date.
+func (b *Backend) restore() {
+	snapshot, err := b.snapshots.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring metadata snapshot: %v\n", err)
+		return
+	}
+	if snapshot == nil {
+		return
 	}
 
-	return metadata, nil
+	b.cachedMetadata = api.ToV1(snapshot.Records)
+	b.cachedIndex = hepcStorage.NewIndex(snapshot.Records)
+	b.cacheTime = time.Now()
+	b.quarantine = snapshot.Quarantine
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancelReconcile = cancel
+	b.reconciled = make(chan struct{})
+	go func() {
+		defer close(b.reconciled)
+		if _, err := b.refresh(ctx, ""); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Warning: serving metadata snapshot from %s: %v\n", snapshot.SavedAt.Format(time.RFC3339), err)
+		}
+	}()
 }
 
-// discoverUnarchivedDatabase extracts metadata from an unarchived CodeQL database in GCS.
-func (b *Backend) discoverUnarchivedDatabase(ctx context.Context, dbPath string) (*api.DatabaseMetadata, error) {
-	// Download codeql-database.yml
-	ymlContent, err := b.readObject(ctx, dbPath+"/codeql-database.yml")
-	if err != nil {
-		return nil, fmt.Errorf("failed to read codeql-database.yml: %w", err)
+// stopReconcile cancels the reconciliation started by restore, if any, and
+// waits for it to finish.
+func (b *Backend) stopReconcile() {
+	if b.reconciled != nil {
+		b.cancelReconcile()
+		<-b.reconciled
 	}
+}
 
-	var dbYAML databaseYAML
-	if err := yaml.Unmarshal(ymlContent, &dbYAML); err != nil {
-		return nil, fmt.Errorf("failed to parse codeql-database.yml: %w", err)
+// Index returns a lookup index over the current metadata, refreshing it
+// first if the cache has expired.
+func (b *Backend) Index(ctx context.Context) (*hepcStorage.Index, error) {
+	b.mu.RLock()
+	if b.cachedIndex != nil && time.Since(b.cacheTime) < b.cacheTTL {
+		idx := b.cachedIndex
+		b.mu.RUnlock()
+		return idx, nil
 	}
+	b.mu.RUnlock()
 
-	// Determine language
-	language := dbYAML.PrimaryLanguage
-	if language == "" {
-		language = b.detectLanguageFromGCS(ctx, dbPath)
+	if _, err := b.ListMetadata(ctx); err != nil {
+		return nil, err
 	}
 
-	// Skip directory size calculation for performance - would require listing
-	// all objects in the database directory which is slow for large databases.
-	// Use 0 as a placeholder; consumers should not rely on this value for GCS storage.
-	var totalSize int64 = 0
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.cachedIndex, nil
+}
 
-	// Generate content hash from path
-	h := sha256.Sum256([]byte(dbPath))
-	contentHash := hex.EncodeToString(h[:])
+// discoverDatabases scans the GCS bucket, or the directory dir within the
+// prefix if dir is not empty, for CodeQL databases using the shared
+// discovery pipeline. Directories are listed with delimiter queries, and
+// archived (.zip) databases are read with ranged requests for their zip
+// directory and metadata entries only, never downloaded in full, unless
+// strict validation verifies their checksums. It also returns the
+// databases that failed validation.
+func (b *Backend) discoverDatabases(ctx context.Context, dir string) ([]api.DatabaseMetadataV2, []codeql.QuarantinedDatabase, error) {
+	opts := b.discovery
+	opts.Dir = dir
+	result, err := codeql.Discover(b.bucketFS(ctx), opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	return b.records(result), result.Quarantined, nil
+}
 
-	// Extract owner/repo
-	owner, repo := extractOwnerRepo(dbYAML.SourceLocationPrefix)
+// bucketFS returns the bucket below the prefix as a filesystem.
+func (b *Backend) bucketFS(ctx context.Context) *bucketFS {
+	return newBucketFS(ctx, b.client.Bucket(b.bucket), b.prefix)
+}
 
-	// Build result URL
-	relPath := strings.TrimPrefix(dbPath, b.prefix)
-	resultURL := fmt.Sprintf("%s/db/%s", strings.TrimSuffix(b.endpointURL, "/"), relPath)
+// records returns the metadata of the databases of a discovery.
+func (b *Backend) records(result *codeql.Result) []api.DatabaseMetadataV2 {
+	records := make([]api.DatabaseMetadataV2, 0, len(result.Databases))
+	for _, db := range result.Databases {
+		// Identify databases by their full object path
+		objectName, err := b.objectPath(db.RelPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping database %s: %v\n", db.RelPath, err)
+			continue
+		}
+		db.Path = objectName
+		m := codeql.BuildMetadataV2(db, b.endpointURL)
+		m.Location = "gs://" + b.bucket + "/" + db.Path
+		records = append(records, m)
+	}
+	return records
+}
 
-	// Build metadata
-	buildCID := contentHash[:10]
-	creationTime := ""
-	cliVersion := ""
-	sourceSHA := ""
+// objectReaderBlockSize is the size of the range requests made by objectReaderAt.
+const objectReaderBlockSize = 1 << 20
 
-	if dbYAML.CreationMetadata != nil {
-		cliVersion = dbYAML.CreationMetadata.CLIVersion
-		creationTime = dbYAML.CreationMetadata.CreationTime
-		sourceSHA = dbYAML.CreationMetadata.SHA
-		buildCID = generateBuildCID(cliVersion, creationTime, language, sourceSHA)
-	}
+// objectReaderAt implements io.ReaderAt over a GCS object using range reads.
+// The most recently fetched block is kept, so sequential small reads (such as
+// those made while parsing a zip directory) share one request per block.
+type objectReaderAt struct {
+	ctx  context.Context
+	obj  *storage.ObjectHandle
+	size int64
 
-	toolName := "codeql"
-	toolID := "codeql"
-	if language != "" && language != "unknown" {
-		toolName = fmt.Sprintf("codeql-%s", language)
-		toolID = toolName // tool_id matches tool_name format
-	}
+	blockOffset int64
+	block       []byte
+}
 
-	return &api.DatabaseMetadata{
-		ContentHash:          contentHash,
-		BuildCID:             buildCID,
-		GitBranch:            "HEAD",
-		GitCommitID:          sourceSHA,
-		GitOwner:             owner,
-		GitRepo:              repo,
-		IngestionDatetimeUTC: creationTime,
-		PrimaryLanguage:      language,
-		ResultURL:            resultURL,
-		ToolID:               toolID,
-		ToolName:             toolName,
-		ToolVersion:          cliVersion,
-		Projname:             fmt.Sprintf("%s/%s", owner, repo),
-		DBFileSize:           totalSize,
-	}, nil
+// ReadAt implements io.ReaderAt.
+func (r *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		if r.block == nil || pos < r.blockOffset || pos >= r.blockOffset+int64(len(r.block)) {
+			if err := r.fetch(pos - pos%objectReaderBlockSize); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], r.block[pos-r.blockOffset:])
+	}
+	return n, nil
 }
 
-// readObject reads the contents of an object from GCS.
-func (b *Backend) readObject(ctx context.Context, objectName string) ([]byte, error) {
-	obj := b.client.Bucket(b.bucket).Object(objectName)
-	reader, err := obj.NewReader(ctx)
+// fetch loads the block starting at offset.
+func (r *objectReaderAt) fetch(offset int64) error {
+	reader, err := r.obj.NewRangeReader(r.ctx, offset, min(objectReaderBlockSize, r.size-offset))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
 		_ = reader.Close() //nolint:errcheck // Best effort close
 	}()
 
-	return io.ReadAll(reader)
-}
-
-// downloadObject downloads an object from GCS to a local file.
-// detectLanguageFromGCS detects the language by looking for db-<lang> directories.
-func (b *Backend) detectLanguageFromGCS(ctx context.Context, dbPath string) string {
-	bkt := b.client.Bucket(b.bucket)
-	query := &storage.Query{Prefix: dbPath + "/db-"}
-
-	it := bkt.Objects(ctx, query)
-	attrs, err := it.Next()
+	block, err := io.ReadAll(reader)
 	if err != nil {
-		return "unknown"
+		return err
 	}
-
-	// Extract language from path like "ex1/minio-db/db-go/..."
-	parts := strings.Split(attrs.Name, "/")
-	for _, part := range parts {
-		if strings.HasPrefix(part, "db-") {
-			return strings.TrimPrefix(part, "db-")
-		}
+	if len(block) == 0 {
+		return io.ErrUnexpectedEOF
 	}
-
-	return "unknown"
+	r.blockOffset, r.block = offset, block
+	return nil
 }
 
 // GetFile retrieves a database file from GCS.
 func (b *Backend) GetFile(ctx context.Context, filename string) (io.ReadCloser, int64, string, error) {
-	objectName := b.objectPath(filename)
+	objectName, err := b.objectPath(filename)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("access denied: %w", err)
+	}
 	obj := b.client.Bucket(b.bucket).Object(objectName)
 
 	attrs, err := obj.Attrs(ctx)
@@ -347,15 +487,45 @@ func (b *Backend) GetFile(ctx context.Context, filename string) (io.ReadCloser,
 		contentType = "application/octet-stream"
 	}
 
-	return reader, attrs.Size, contentType, nil
+	return &objectReader{ReadCloser: reader, updated: attrs.Updated}, attrs.Size, contentType, nil
+}
+
+// objectReader reads an object and reports when it was last updated.
+type objectReader struct {
+	io.ReadCloser
+	updated time.Time
+}
+
+// ModTime implements storage.ModTimer.
+func (r *objectReader) ModTime() time.Time { return r.updated }
+
+// GetFileRange reads a database file from GCS from byte offset off to its
+// end with a ranged read.
+func (b *Backend) GetFileRange(ctx context.Context, filename string, off int64) (io.ReadCloser, error) {
+	objectName, err := b.objectPath(filename)
+	if err != nil {
+		return nil, fmt.Errorf("access denied: %w", err)
+	}
+
+	reader, err := b.client.Bucket(b.bucket).Object(objectName).NewRangeReader(ctx, off, -1)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, &hepcStorage.ErrNotFound{Path: objectName}
+		}
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	return reader, nil
 }
 
 // FileExists checks if a file exists in GCS.
 func (b *Backend) FileExists(ctx context.Context, filename string) (bool, error) {
-	objectName := b.objectPath(filename)
+	objectName, err := b.objectPath(filename)
+	if err != nil {
+		return false, fmt.Errorf("access denied: %w", err)
+	}
 	obj := b.client.Bucket(b.bucket).Object(objectName)
 
-	_, err := obj.Attrs(ctx)
+	_, err = obj.Attrs(ctx)
 	if err != nil {
 		if errors.Is(err, storage.ErrObjectNotExist) {
 			return false, nil
@@ -365,66 +535,247 @@ func (b *Backend) FileExists(ctx context.Context, filename string) (bool, error)
 	return true, nil
 }
 
+// ListMembers lists the files of the archive or database directory at
+// dbPath. Archives are listed from their zip directory with ranged reads;
+// directories are listed by object prefix, leaving out the sidecar file.
+func (b *Backend) ListMembers(ctx context.Context, dbPath string) ([]hepcStorage.Member, error) {
+	objectName, err := b.objectPath(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("access denied: %w", err)
+	}
+
+	if strings.HasSuffix(dbPath, ".zip") {
+		ra, err := b.archiveReader(ctx, objectName)
+		if err != nil {
+			return nil, err
+		}
+		return hepcStorage.ZipMembers(ra, ra.size)
+	}
+
+	dirPrefix := objectName + "/"
+	var members []hepcStorage.Member
+	it := b.client.Bucket(b.bucket).Objects(ctx, &storage.Query{Prefix: dirPrefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dbPath, err)
+		}
+		member := strings.TrimPrefix(attrs.Name, dirPrefix)
+		if strings.HasSuffix(attrs.Name, "/") || member == codeql.SidecarFileName {
+			continue
+		}
+		members = append(members, hepcStorage.Member{Path: member, Size: attrs.Size})
+	}
+	if len(members) == 0 {
+		return nil, &hepcStorage.ErrNotFound{Path: objectName}
+	}
+	return members, nil
+}
+
+// GetMember returns one file of the archive or database directory at
+// dbPath. Archive entries are read with ranged requests for the zip
+// directory and the entry only.
+func (b *Backend) GetMember(ctx context.Context, dbPath, member string) (io.ReadCloser, int64, error) {
+	if !hepcStorage.ValidMember(member) {
+		return nil, 0, fmt.Errorf("invalid member %q", member)
+	}
+	if !strings.HasSuffix(dbPath, ".zip") {
+		if member == codeql.SidecarFileName {
+			return nil, 0, &hepcStorage.ErrNotFound{Path: dbPath + "/" + member}
+		}
+		reader, size, _, err := b.GetFile(ctx, dbPath+"/"+member)
+		return reader, size, err
+	}
+
+	objectName, err := b.objectPath(dbPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("access denied: %w", err)
+	}
+	ra, err := b.archiveReader(ctx, objectName)
+	if err != nil {
+		return nil, 0, err
+	}
+	return hepcStorage.OpenZipMember(ra, ra.size, member)
+}
+
+// archiveReader returns a reader for ranged reads of an archive object.
+func (b *Backend) archiveReader(ctx context.Context, objectName string) (*objectReaderAt, error) {
+	obj := b.client.Bucket(b.bucket).Object(objectName)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, &hepcStorage.ErrNotFound{Path: objectName}
+		}
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	return &objectReaderAt{ctx: ctx, obj: obj, size: attrs.Size}, nil
+}
+
+// sha256MetadataKey is the custom object metadata key under which PutFile
+// records the SHA-256 of an object, as GCS itself only reports MD5 and CRC32C.
+const sha256MetadataKey = "sha256"
+
+// PutFile uploads the content of r to the object for filename. GCS makes the
+// object visible only once the upload completes; its SHA-256 is recorded in
+// the object metadata for FileHash.
+func (b *Backend) PutFile(ctx context.Context, filename string, r io.Reader) error {
+	objectName, err := b.objectPath(filename)
+	if err != nil {
+		return err
+	}
+	obj := b.client.Bucket(b.bucket).Object(objectName)
+
+	contentType := mime.TypeByExtension(path.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Cancelling the context aborts the upload if copying fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := obj.NewWriter(ctx)
+	w.ContentType = contentType
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		cancel()
+		_ = w.Close() //nolint:errcheck // The upload is aborted
+		return fmt.Errorf("failed to upload %s: %w", filename, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", filename, err)
+	}
+
+	if _, err := obj.Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: map[string]string{sha256MetadataKey: hex.EncodeToString(h.Sum(nil))},
+	}); err != nil {
+		return fmt.Errorf("failed to record hash of %s: %w", filename, err)
+	}
+	return nil
+}
+
+// DeleteFile deletes the object for filename.
+func (b *Backend) DeleteFile(ctx context.Context, filename string) error {
+	objectName, err := b.objectPath(filename)
+	if err != nil {
+		return err
+	}
+	err = b.client.Bucket(b.bucket).Object(objectName).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", filename, err)
+	}
+	return nil
+}
+
+// FileHash returns the SHA-256 of an object from its metadata, reading the
+// object if it was not uploaded by PutFile.
+func (b *Backend) FileHash(ctx context.Context, filename string) (string, error) {
+	objectName, err := b.objectPath(filename)
+	if err != nil {
+		return "", err
+	}
+	attrs, err := b.client.Bucket(b.bucket).Object(objectName).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return "", &hepcStorage.ErrNotFound{Path: objectName}
+		}
+		return "", fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	if sum := attrs.Metadata[sha256MetadataKey]; sum != "" {
+		return sum, nil
+	}
+	return hepcStorage.ReadHash(ctx, b, filename)
+}
+
+// validObjectPath rejects file names that are not clean relative paths, so
+// neither reads nor writes can escape the configured prefix.
+func validObjectPath(filename string) error {
+	if filename == "" || path.IsAbs(filename) || path.Clean(filename) != filename || strings.HasPrefix(filename, "../") || filename == ".." {
+		return fmt.Errorf("invalid file name %q", filename)
+	}
+	return nil
+}
+
 // MetadataExists always returns true for GCS storage since we discover dynamically.
 func (b *Backend) MetadataExists(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+// Probe checks that the bucket can be listed by fetching at most one object
+// under the prefix. Listing needs only the permissions discovery already
+// requires, unlike reading the bucket attributes.
+func (b *Backend) Probe(ctx context.Context) error {
+	it := b.client.Bucket(b.bucket).Objects(ctx, &storage.Query{Prefix: b.prefix})
+	it.PageInfo().MaxSize = 1
+	if _, err := it.Next(); err != nil && !errors.Is(err, iterator.Done) {
+		return fmt.Errorf("gcs storage: cannot list bucket %s: %w", b.bucket, err)
+	}
+	return nil
+}
+
 // Close releases any resources held by the backend.
 func (b *Backend) Close() error {
+	b.stopReconcile()
+	if err := b.stopNotifications(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to close Pub/Sub client: %v\n", err)
+	}
+
 	b.mu.Lock()
 	b.cachedMetadata = nil
+	b.cachedIndex = nil
 	b.mu.Unlock()
 
 	return b.client.Close()
 }
 
-// Helper types for YAML parsing
-
-type databaseYAML struct {
-	SourceLocationPrefix string                    `yaml:"sourceLocationPrefix"`
-	PrimaryLanguage      string                    `yaml:"primaryLanguage"`
-	CreationMetadata     *databaseCreationMetadata `yaml:"creationMetadata,omitempty"`
+// InvalidateCache forces a refresh of the discovered databases cache. The
+// refresh reads every database again, even those whose objects are unchanged.
+func (b *Backend) InvalidateCache() {
+	b.mu.Lock()
+	b.cachedMetadata = nil
+	b.cachedIndex = nil
+	b.cacheTime = time.Time{}
+	b.mu.Unlock()
+	b.discovery.DiscoveryCache.Clear()
 }
 
-type databaseCreationMetadata struct {
-	SHA          string `yaml:"sha"`
-	CLIVersion   string `yaml:"cliVersion"`
-	CreationTime string `yaml:"creationTime"`
+// CacheStats describes the discovered databases cache.
+func (b *Backend) CacheStats() hepcStorage.CacheStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entries := 0
+	if b.cachedIndex != nil {
+		entries = b.cachedIndex.Len()
+	}
+	return b.refreshLog.Stats(entries, b.cacheTime, b.cacheTTL)
 }
 
-// Helper functions
-
-func extractOwnerRepo(sourceLocationPrefix string) (owner, repo string) {
-	if sourceLocationPrefix == "" {
-		return "unknown", "unknown"
-	}
+// Quarantine returns the databases that failed validation in the last
+// discovery, with paths relative to the configured object prefix.
+func (b *Backend) Quarantine() []codeql.QuarantinedDatabase {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]codeql.QuarantinedDatabase(nil), b.quarantine...)
+}
 
-	parts := strings.Split(filepath.Clean(sourceLocationPrefix), string(filepath.Separator))
-	var filteredParts []string
-	for _, p := range parts {
-		if p != "" {
-			filteredParts = append(filteredParts, p)
-		}
+// Reindex rediscovers the databases under prefix, a directory relative to
+// the configured object prefix, or all databases if prefix is empty. An
+// empty cache is always rediscovered in full.
+func (b *Backend) Reindex(ctx context.Context, prefix string) error {
+	prefix, err := hepcStorage.CleanPrefix(prefix)
+	if err != nil {
+		return err
 	}
 
-	switch {
-	case len(filteredParts) >= 2:
-		repo = filteredParts[len(filteredParts)-1]
-		owner = filteredParts[len(filteredParts)-2]
-	case len(filteredParts) == 1:
-		repo = filteredParts[0]
-		owner = "unknown"
-	default:
-		owner = "unknown"
-		repo = "unknown"
+	b.mu.RLock()
+	if b.cachedIndex == nil {
+		prefix = ""
 	}
+	b.mu.RUnlock()
 
-	return owner, repo
-}
-
-func generateBuildCID(cliVersion, creationTime, language, sourceSHA string) string {
-	s := fmt.Sprintf("%s %s %s %s", cliVersion, creationTime, language, sourceSHA)
-	h := sha256.Sum256([]byte(s))
-	return hex.EncodeToString(h[:])[:10]
+	_, err = b.refresh(ctx, prefix)
+	return err
 }
@@ -2,14 +2,29 @@
 package gcs
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/md5" //nolint:gosec // Matches the checksum GCS records
+	"encoding/hex"
+	"errors"
 	"io"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"cloud.google.com/go/storage"
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+	hepcStorage "github.com/data-douser/mrva-go-hepc/internal/storage"
 	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"google.golang.org/api/option"
 )
 
 // testServer wraps a fakestorage.Server and provides helper methods.
@@ -254,6 +269,38 @@ func TestBackend_MetadataExists(t *testing.T) {
 	}
 }
 
+func TestBackend_Probe(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	tests := []struct {
+		name    string
+		bucket  string
+		wantErr bool
+	}{
+		{name: "existing bucket", bucket: "test-bucket"},
+		{name: "missing bucket", bucket: "missing-bucket", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := New(ctx, Config{
+				Bucket: tt.bucket,
+				Client: server.Client(),
+			})
+			if err != nil {
+				t.Fatalf("failed to create backend: %v", err)
+			}
+			defer backend.Close()
+
+			if err := backend.Probe(ctx); (err != nil) != tt.wantErr {
+				t.Errorf("Probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestBackend_ListMetadata_EmptyBucket(t *testing.T) {
 	ctx := context.Background()
 	server := newTestServer(t, "test-bucket")
@@ -450,6 +497,280 @@ primaryLanguage: python
 	}
 }
 
+func TestBackend_Reindex(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	databaseYAML := func(ownerRepo string) []byte {
+		return []byte("sourceLocationPrefix: /src/" + ownerRepo + "\nprimaryLanguage: go\n")
+	}
+	server.createDatabase(t, "dbs/team-a/one", databaseYAML("a/one"), "go")
+	server.createDatabase(t, "dbs/team-b/two", databaseYAML("b/two"), "go")
+
+	backend, err := New(ctx, Config{
+		Bucket:   "test-bucket",
+		Client:   server.Client(),
+		Prefix:   "dbs/",
+		CacheTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	projects := func() []string {
+		t.Helper()
+		metadata, err := backend.ListMetadata(ctx)
+		if err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+		var names []string
+		for _, m := range metadata {
+			names = append(names, m.Projname)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if got, want := projects(), []string{"a/one", "b/two"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("projects = %v, want %v", got, want)
+	}
+
+	server.createDatabase(t, "dbs/team-a/three", databaseYAML("a/three"), "go")
+	server.createDatabase(t, "dbs/team-b/four", databaseYAML("b/four"), "go")
+	if err := backend.Reindex(ctx, "team-a"); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	if got, want := projects(), []string{"a/one", "a/three", "b/two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after scoped reindex projects = %v, want %v", got, want)
+	}
+
+	if err := backend.Reindex(ctx, ""); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	if got, want := projects(), []string{"a/one", "a/three", "b/four", "b/two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after full reindex projects = %v, want %v", got, want)
+	}
+
+	if stats := backend.CacheStats(); stats.Entries != 4 || stats.RefreshedAt.IsZero() {
+		t.Errorf("CacheStats() = %+v, want 4 entries", stats)
+	}
+	backend.InvalidateCache()
+	if stats := backend.CacheStats(); stats.Entries != 0 {
+		t.Errorf("CacheStats() after InvalidateCache = %+v, want empty", stats)
+	}
+}
+
+func TestBackend_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	databaseYAML := func(ownerRepo string) []byte {
+		return []byte("sourceLocationPrefix: /src/" + ownerRepo + "\nprimaryLanguage: go\n")
+	}
+	server.createDatabase(t, "dbs/one", databaseYAML("a/one"), "go")
+
+	config := Config{
+		Bucket:        "test-bucket",
+		Client:        server.Client(),
+		Prefix:        "dbs/",
+		LocalCacheDir: cacheDir,
+	}
+	first, err := New(ctx, config)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	if _, err := first.ListMetadata(ctx); err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+
+	// A restarted backend serves the snapshot before listing the bucket,
+	// and then picks up the database added meanwhile
+	server.createDatabase(t, "dbs/two", databaseYAML("b/two"), "go")
+	restarted, err := New(ctx, config)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer restarted.Close()
+	if stats := restarted.CacheStats(); stats.Entries != 1 {
+		t.Errorf("CacheStats() after restart = %+v, want the snapshot's 1 entry", stats)
+	}
+	<-restarted.reconciled
+	if stats := restarted.CacheStats(); stats.Entries != 2 {
+		t.Errorf("CacheStats() after reconciling = %+v, want 2 entries", stats)
+	}
+
+	// A snapshot of another prefix is not served
+	config.Prefix = "other/"
+	other, err := New(ctx, config)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer other.Close()
+	if stats := other.CacheStats(); stats.Entries != 0 {
+		t.Errorf("CacheStats() with another prefix = %+v, want empty", stats)
+	}
+}
+
+func TestNew_NotificationFailureStopsReconciling(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	server.createDatabase(t, "dbs/one", []byte("sourceLocationPrefix: /src/a/one\nprimaryLanguage: go\n"), "go")
+
+	config := Config{Bucket: "test-bucket", Client: server.Client(), Prefix: "dbs/", LocalCacheDir: cacheDir}
+	first, err := New(ctx, config)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	if _, err := first.ListMetadata(ctx); err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	first.Close()
+
+	// The snapshot starts a reconciliation whose requests hang until
+	// cancelled; a backend that fails to start is left with none running
+	blocker := &blockingTransport{}
+	config.Client, err = storage.NewClient(ctx, option.WithHTTPClient(&http.Client{Transport: blocker}), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	config.Subscription = "not-a-subscription"
+	if _, err := New(ctx, config); err == nil {
+		t.Fatal("New() error = nil, want the invalid subscription")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := blocker.inFlight.Load(); n != 0 {
+		t.Errorf("%d requests still running after New() failed", n)
+	}
+}
+
+// blockingTransport holds every request until its context is cancelled.
+type blockingTransport struct {
+	inFlight atomic.Int32
+}
+
+func (b *blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b.inFlight.Add(1)
+	defer b.inFlight.Add(-1)
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+// readCounter counts the object downloads made through it.
+type readCounter struct {
+	base  http.RoundTripper
+	mu    sync.Mutex
+	reads int
+}
+
+func (c *readCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(req.URL.Path, "/storage/v1/") || req.URL.Query().Get("alt") == "media" {
+		c.mu.Lock()
+		c.reads++
+		c.mu.Unlock()
+	}
+	return c.base.RoundTrip(req)
+}
+
+// count returns the downloads counted since the last call.
+func (c *readCounter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.reads
+	c.reads = 0
+	return n
+}
+
+func TestBackend_ListMetadata_Incremental(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	archive := func(language string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range map[string]string{
+			"codeql-database.yml":              "primaryLanguage: " + language + "\n",
+			"db-" + language + "/default/data": "data",
+		} {
+			fw, err := zw.Create(name)
+			if err != nil {
+				t.Fatalf("failed to create zip entry: %v", err)
+			}
+			if _, err := fw.Write([]byte(content)); err != nil {
+				t.Fatalf("failed to write zip entry: %v", err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("failed to close zip: %v", err)
+		}
+		return buf.Bytes()
+	}
+	server.createFile(t, "dbs/widgets.zip", archive("go"), "application/zip")
+	server.createDatabase(t, "dbs/gadgets", []byte("primaryLanguage: go\n"), "go")
+
+	counter := &readCounter{base: server.HTTPClient().Transport}
+	client, err := storage.NewClient(ctx, option.WithHTTPClient(&http.Client{Transport: counter}), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	backend, err := New(ctx, Config{Bucket: "test-bucket", Client: client, Prefix: "dbs/", CacheTTL: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	languages := func() []string {
+		t.Helper()
+		metadata, err := backend.ListMetadata(ctx)
+		if err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+		var got []string
+		for _, m := range metadata {
+			got = append(got, m.PrimaryLanguage)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	if got := languages(); !reflect.DeepEqual(got, []string{"go", "go"}) {
+		t.Fatalf("languages = %v, want [go go]", got)
+	}
+	if reads := counter.count(); reads == 0 {
+		t.Fatal("first discovery read no objects")
+	}
+
+	// Unchanged objects are not read again
+	if got := languages(); !reflect.DeepEqual(got, []string{"go", "go"}) {
+		t.Errorf("languages = %v, want [go go]", got)
+	}
+	if reads := counter.count(); reads != 0 {
+		t.Errorf("rediscovery of unchanged databases made %d reads, want 0", reads)
+	}
+
+	// A rewritten object has a new generation and is read again
+	server.createFile(t, "dbs/widgets.zip", archive("python"), "application/zip")
+	if got := languages(); !reflect.DeepEqual(got, []string{"go", "python"}) {
+		t.Errorf("languages after rewrite = %v, want [go python]", got)
+	}
+	if reads := counter.count(); reads == 0 {
+		t.Error("rewritten archive was not read again")
+	}
+
+	// Invalidating the cache reads everything again
+	backend.InvalidateCache()
+	languages()
+	if reads := counter.count(); reads == 0 {
+		t.Error("rediscovery after InvalidateCache read no objects")
+	}
+}
+
 func TestBackend_ListMetadata_Caching(t *testing.T) {
 	ctx := context.Background()
 	server := newTestServer(t, "test-bucket")
@@ -536,6 +857,258 @@ sourceLocationPrefix: "/home/user/github/testowner/testrepo"
 	}
 }
 
+func TestBackend_ListMetadata_MultiLanguage(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	// A database holding two languages yields one record per language
+	yamlContent := []byte(`
+sourceLocationPrefix: "/home/user/github/testowner/testrepo"
+primaryLanguage: python
+`)
+	server.createDatabase(t, "multi-db", yamlContent, "python")
+	server.createFile(t, "multi-db/db-javascript/.marker", []byte("marker"), "text/plain")
+
+	backend, err := New(ctx, Config{
+		Bucket:      "test-bucket",
+		Client:      server.Client(),
+		EndpointURL: "http://example.com",
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	metadata, err := backend.ListMetadata(ctx)
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(metadata) != 2 {
+		t.Fatalf("ListMetadata() returned %d items, want 2", len(metadata))
+	}
+
+	if metadata[0].PrimaryLanguage != "python" || metadata[1].PrimaryLanguage != "javascript" {
+		t.Errorf("languages = [%q %q], want [python javascript]", metadata[0].PrimaryLanguage, metadata[1].PrimaryLanguage)
+	}
+	if metadata[0].ContentHash == metadata[1].ContentHash {
+		t.Error("per-language records must have distinct content hashes")
+	}
+	if metadata[1].ResultURL != "http://example.com/db/multi-db?language=javascript" {
+		t.Errorf("ResultURL = %q, want language query", metadata[1].ResultURL)
+	}
+}
+
+func TestBackend_ListMetadata_ArchivedDatabase(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"codeql-database.yml": "sourceLocationPrefix: /src/octo/widgets\nprimaryLanguage: go\n",
+		"db-go/default/x":     "data",
+	} {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+	server.createFile(t, "dbs/widgets.zip", buf.Bytes(), "application/zip")
+
+	// Unarchived databases report the total size of their objects
+	yamlContent := []byte("sourceLocationPrefix: /src/octo/gadgets\nprimaryLanguage: python\n")
+	server.createDatabase(t, "dbs/gadgets", yamlContent, "python")
+
+	backend, err := New(ctx, Config{
+		Bucket:      "test-bucket",
+		Client:      server.Client(),
+		EndpointURL: "http://example.com",
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	metadata, err := backend.ListMetadata(ctx)
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(metadata) != 2 {
+		t.Fatalf("ListMetadata() returned %d items, want 2", len(metadata))
+	}
+
+	byProject := make(map[string]int)
+	for i, m := range metadata {
+		byProject[m.Projname] = i
+	}
+
+	archived := metadata[byProject["octo/widgets"]]
+	if archived.ResultURL != "http://example.com/db/dbs/widgets.zip" {
+		t.Errorf("ResultURL = %q, want %q", archived.ResultURL, "http://example.com/db/dbs/widgets.zip")
+	}
+	if archived.DBFileSize != int64(buf.Len()) {
+		t.Errorf("archived DBFileSize = %d, want %d", archived.DBFileSize, buf.Len())
+	}
+	if archived.PrimaryLanguage != "go" {
+		t.Errorf("PrimaryLanguage = %q, want %q", archived.PrimaryLanguage, "go")
+	}
+
+	unarchived := metadata[byProject["octo/gadgets"]]
+	if want := int64(len(yamlContent) + len("marker")); unarchived.DBFileSize != want {
+		t.Errorf("unarchived DBFileSize = %d, want %d", unarchived.DBFileSize, want)
+	}
+
+	// v2 records locate databases in the bucket
+	idx, err := backend.Index(ctx)
+	if err != nil {
+		t.Fatalf("Index() error = %v", err)
+	}
+	v2, ok := idx.ByHash(archived.ContentHash)
+	if !ok || v2.Location != "gs://test-bucket/dbs/widgets.zip" || v2.Format != "archived" {
+		t.Errorf("v2 Location, Format = %q, %q; want gs://test-bucket/dbs/widgets.zip, archived", v2.Location, v2.Format)
+	}
+
+	// Content hashes come from object checksums, not object names
+	md5Sum := md5.Sum(buf.Bytes())
+	if archived.ContentHash != hex.EncodeToString(md5Sum[:]) || v2.HashKind != api.HashKindMD5 {
+		t.Errorf("archived ContentHash, HashKind = %q, %q; want the object MD5", archived.ContentHash, v2.HashKind)
+	}
+	if v2, _ := idx.ByHash(unarchived.ContentHash); v2.HashKind != api.HashKindMerkle {
+		t.Errorf("unarchived HashKind = %q, want %q", v2.HashKind, api.HashKindMerkle)
+	}
+}
+
+func TestBackend_ListMetadata_Identity(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	yamlContent := []byte(`
+sourceLocationPrefix: "/home/runner/work/repo/repo"
+primaryLanguage: go
+`)
+
+	// Identity from a sidecar object
+	server.createDatabase(t, "sidecar-db", yamlContent, "go")
+	server.createFile(t, "sidecar-db/hepc.yml", []byte("repository: octo/widgets\nbranch: main\nteam: platform\ntags: [critical]\n"), "application/x-yaml")
+
+	// Identity from the git remote recorded in src.zip
+	var src bytes.Buffer
+	zw := zip.NewWriter(&src)
+	for name, content := range map[string]string{
+		"home/runner/work/repo/repo/.git/config": "[remote \"origin\"]\n\turl = https://github.com/octo/gadgets.git\n",
+		"home/runner/work/repo/repo/main.go":     "package main\n",
+	} {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+	server.createDatabase(t, "git-db", yamlContent, "go")
+	server.createFile(t, "git-db/src.zip", src.Bytes(), "application/zip")
+
+	// No evidence: path heuristic
+	server.createDatabase(t, "plain-db", yamlContent, "go")
+
+	backend, err := New(ctx, Config{
+		Bucket: "test-bucket",
+		Client: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	idx, err := backend.Index(ctx)
+	if err != nil {
+		t.Fatalf("Index() error = %v", err)
+	}
+	metadata := idx.Records()
+	if len(metadata) != 3 {
+		t.Fatalf("Index() returned %d records, want 3", len(metadata))
+	}
+
+	want := map[string]string{
+		"octo/widgets": codeql.IdentitySourceSidecar,
+		"octo/gadgets": codeql.IdentitySourceGitRemote,
+		"repo/repo":    codeql.IdentitySourceHeuristic,
+	}
+	for _, m := range metadata {
+		source, ok := want[m.Projname]
+		if !ok {
+			t.Errorf("unexpected project %q", m.Projname)
+			continue
+		}
+		if m.IdentitySource != source {
+			t.Errorf("%s IdentitySource = %q, want %q", m.Projname, m.IdentitySource, source)
+		}
+		if m.Projname == "octo/widgets" {
+			if m.GitBranch != "main" || m.Team != "platform" || len(m.Tags) != 1 || m.Tags[0] != "critical" {
+				t.Errorf("%s branch, team, tags = %q, %q, %v, want main, platform, [critical]", m.Projname, m.GitBranch, m.Team, m.Tags)
+			}
+		}
+	}
+}
+
+func TestObjectReaderAt(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	// Span several blocks so reads cross block boundaries
+	content := make([]byte, 2*objectReaderBlockSize+1234)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	server.createFile(t, "big.bin", content, "")
+
+	obj := server.Client().Bucket("test-bucket").Object("big.bin")
+	r := &objectReaderAt{ctx: ctx, obj: obj, size: int64(len(content))}
+
+	tests := []struct {
+		name string
+		off  int64
+		n    int
+	}{
+		{"start", 0, 100},
+		{"across boundary", objectReaderBlockSize - 10, 20},
+		{"tail", int64(len(content)) - 50, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, tt.n)
+			n, err := r.ReadAt(buf, tt.off)
+			if err != nil {
+				t.Fatalf("ReadAt() error = %v", err)
+			}
+			if n != tt.n || !bytes.Equal(buf, content[tt.off:tt.off+int64(tt.n)]) {
+				t.Errorf("ReadAt(%d) returned wrong data", tt.off)
+			}
+		})
+	}
+
+	// Reading past the end returns io.EOF
+	buf := make([]byte, 10)
+	n, err := r.ReadAt(buf, int64(len(content))-5)
+	if n != 5 || err != io.EOF {
+		t.Errorf("ReadAt() at end = (%d, %v), want (5, EOF)", n, err)
+	}
+}
+
 func TestBackend_GetFile(t *testing.T) {
 	ctx := context.Background()
 	server := newTestServer(t, "test-bucket")
@@ -578,6 +1151,43 @@ func TestBackend_GetFile(t *testing.T) {
 	}
 }
 
+func TestBackend_GetFileRange(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	server.createFile(t, "databases/test-file.txt", []byte("Hello, GCS!"), "text/plain")
+
+	backend, err := New(ctx, Config{
+		Bucket: "test-bucket",
+		Client: server.Client(),
+		Prefix: "databases/",
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	reader, err := backend.GetFileRange(ctx, "test-file.txt", 7)
+	if err != nil {
+		t.Fatalf("GetFileRange() error = %v", err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(content) != "GCS!" {
+		t.Errorf("GetFileRange() content = %q, want %q", content, "GCS!")
+	}
+
+	var notFound *hepcStorage.ErrNotFound
+	if _, err := backend.GetFileRange(ctx, "nonexistent-file.txt", 1); !errors.As(err, &notFound) {
+		t.Errorf("GetFileRange() error = %v, want ErrNotFound", err)
+	}
+}
+
 func TestBackend_GetFile_WithPrefix(t *testing.T) {
 	ctx := context.Background()
 	server := newTestServer(t, "test-bucket")
@@ -734,7 +1344,7 @@ func TestBackend_FileExists_WithPrefix(t *testing.T) {
 	}
 }
 
-func TestBackend_Close(t *testing.T) {
+func TestBackend_PutFile(t *testing.T) {
 	ctx := context.Background()
 	server := newTestServer(t, "test-bucket")
 	defer server.Stop()
@@ -742,105 +1352,131 @@ func TestBackend_Close(t *testing.T) {
 	backend, err := New(ctx, Config{
 		Bucket: "test-bucket",
 		Client: server.Client(),
+		Prefix: "databases/",
 	})
 	if err != nil {
 		t.Fatalf("failed to create backend: %v", err)
 	}
+	defer backend.Close()
 
-	// Pre-populate cache
-	_, _ = backend.ListMetadata(ctx) //nolint:errcheck // ignoring error for cache pre-population in test
+	if err := backend.PutFile(ctx, "octo/hello.zip", strings.NewReader("second")); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
 
-	// Close should clear cache and close client
-	err = backend.Close()
+	obj, err := server.GetObject("test-bucket", "databases/octo/hello.zip")
 	if err != nil {
-		t.Errorf("Close() error = %v", err)
+		t.Fatalf("object not stored under the prefix: %v", err)
+	}
+	if string(obj.Content) != "second" || obj.ContentType != "application/zip" {
+		t.Errorf("object = %q (%s), want %q (application/zip)", obj.Content, obj.ContentType, "second")
 	}
 
-	// Verify cache is cleared
-	backend.mu.RLock()
-	if backend.cachedMetadata != nil {
-		t.Error("cache should be cleared after Close()")
+	// sha256("second"), recorded in the object metadata
+	want := "16367aacb67a4a017c8da8ab95682ccb390863780f7114dda0a0e0c55644c7c4"
+	if got := obj.Metadata[sha256MetadataKey]; got != want {
+		t.Errorf("metadata hash = %q, want %q", got, want)
+	}
+	hash, err := backend.FileHash(ctx, "octo/hello.zip")
+	if err != nil {
+		t.Fatalf("FileHash() error = %v", err)
+	}
+	if hash != want {
+		t.Errorf("FileHash() = %q, want %q", hash, want)
+	}
+
+	for _, name := range []string{"../escaped.zip", "/escaped.zip", "a/../../b.zip", ""} {
+		if err := backend.PutFile(ctx, name, strings.NewReader("x")); err == nil {
+			t.Errorf("PutFile(%q) expected error, got nil", name)
+		}
 	}
-	backend.mu.RUnlock()
 }
 
-func TestExtractOwnerRepo(t *testing.T) {
-	tests := []struct {
-		name      string
-		input     string
-		wantOwner string
-		wantRepo  string
-	}{
-		{
-			name:      "full path",
-			input:     "/home/user/github/myowner/myrepo",
-			wantOwner: "myowner",
-			wantRepo:  "myrepo",
-		},
-		{
-			name:      "short path",
-			input:     "owner/repo",
-			wantOwner: "owner",
-			wantRepo:  "repo",
-		},
-		{
-			name:      "single component",
-			input:     "repo",
-			wantOwner: "unknown",
-			wantRepo:  "repo",
-		},
-		{
-			name:      "empty",
-			input:     "",
-			wantOwner: "unknown",
-			wantRepo:  "unknown",
-		},
-		{
-			name:      "root path",
-			input:     "/",
-			wantOwner: "unknown",
-			wantRepo:  "unknown",
-		},
-		{
-			name:      "deep path",
-			input:     "/a/b/c/d/e/owner/repo",
-			wantOwner: "owner",
-			wantRepo:  "repo",
-		},
+func TestBackend_FileHash_WithoutMetadata(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	server.createFile(t, "hello.zip", []byte("second"), "application/zip")
+
+	backend, err := New(ctx, Config{
+		Bucket: "test-bucket",
+		Client: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
 	}
+	defer backend.Close()
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gotOwner, gotRepo := extractOwnerRepo(tt.input)
-			if gotOwner != tt.wantOwner {
-				t.Errorf("extractOwnerRepo(%q) owner = %q, want %q", tt.input, gotOwner, tt.wantOwner)
-			}
-			if gotRepo != tt.wantRepo {
-				t.Errorf("extractOwnerRepo(%q) repo = %q, want %q", tt.input, gotRepo, tt.wantRepo)
-			}
-		})
+	hash, err := backend.FileHash(ctx, "hello.zip")
+	if err != nil {
+		t.Fatalf("FileHash() error = %v", err)
+	}
+	if want := "16367aacb67a4a017c8da8ab95682ccb390863780f7114dda0a0e0c55644c7c4"; hash != want {
+		t.Errorf("FileHash() = %q, want %q", hash, want)
+	}
+
+	_, err = backend.FileHash(ctx, "missing.zip")
+	var notFound *hepcStorage.ErrNotFound
+	if !errors.As(err, &notFound) {
+		t.Errorf("FileHash() of missing object error = %v, want ErrNotFound", err)
 	}
 }
 
-func TestGenerateBuildCID(t *testing.T) {
-	cid := generateBuildCID("2.15.0", "2024-01-15T10:30:00Z", "go", "abc123")
+func TestBackend_DeleteFile(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
 
-	// BuildCID should be 10 characters (truncated SHA256)
-	if len(cid) != 10 {
-		t.Errorf("generateBuildCID() length = %d, want 10", len(cid))
+	server.createFile(t, "hello.zip", []byte("content"), "application/zip")
+
+	backend, err := New(ctx, Config{
+		Bucket: "test-bucket",
+		Client: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
 	}
+	defer backend.Close()
+
+	if err := backend.DeleteFile(ctx, "hello.zip"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if exists, _ := backend.FileExists(ctx, "hello.zip"); exists {
+		t.Error("object still exists after DeleteFile()")
+	}
+	if err := backend.DeleteFile(ctx, "hello.zip"); err != nil {
+		t.Errorf("DeleteFile() of missing object error = %v, want nil", err)
+	}
+}
+
+func TestBackend_Close(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	backend, err := New(ctx, Config{
+		Bucket: "test-bucket",
+		Client: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+
+	// Pre-populate cache
+	_, _ = backend.ListMetadata(ctx) //nolint:errcheck // ignoring error for cache pre-population in test
 
-	// Same inputs should produce same output
-	cid2 := generateBuildCID("2.15.0", "2024-01-15T10:30:00Z", "go", "abc123")
-	if cid != cid2 {
-		t.Errorf("generateBuildCID() not deterministic: %q != %q", cid, cid2)
+	// Close should clear cache and close client
+	err = backend.Close()
+	if err != nil {
+		t.Errorf("Close() error = %v", err)
 	}
 
-	// Different inputs should produce different output
-	cid3 := generateBuildCID("2.16.0", "2024-01-15T10:30:00Z", "go", "abc123")
-	if cid == cid3 {
-		t.Errorf("generateBuildCID() should produce different CID for different inputs")
+	// Verify cache is cleared
+	backend.mu.RLock()
+	if backend.cachedMetadata != nil {
+		t.Error("cache should be cleared after Close()")
 	}
+	backend.mu.RUnlock()
 }
 
 func TestBackend_ListMetadata_WithoutCreationMetadata(t *testing.T) {
@@ -875,7 +1511,7 @@ primaryLanguage: python
 
 	m := metadata[0]
 
-	// BuildCID should still be generated (truncated content hash)
+	// BuildCID should still be generated (derived from the content hash)
 	if len(m.BuildCID) != 10 {
 		t.Errorf("BuildCID length = %d, want 10", len(m.BuildCID))
 	}
@@ -897,36 +1533,163 @@ func TestBackend_objectPath(t *testing.T) {
 	server := newTestServer(t, "test-bucket")
 	defer server.Stop()
 
-	t.Run("without prefix", func(t *testing.T) {
-		backend, err := New(ctx, Config{
-			Bucket: "test-bucket",
-			Client: server.Client(),
+	tests := []struct {
+		name     string
+		prefix   string
+		filename string
+		want     string
+		wantErr  bool
+	}{
+		{name: "without prefix", filename: "test.txt", want: "test.txt"},
+		{name: "with prefix", prefix: "databases/", filename: "test.txt", want: "databases/test.txt"},
+		{name: "nested", prefix: "databases/", filename: "team/repo.zip", want: "databases/team/repo.zip"},
+		{name: "leading dot-dot", prefix: "databases/", filename: "../secret.zip", wantErr: true},
+		{name: "inner dot-dot", prefix: "databases/", filename: "team/../../secret.zip", wantErr: true},
+		{name: "trailing dot-dot", prefix: "databases/", filename: "team/..", wantErr: true},
+		{name: "absolute", prefix: "databases/", filename: "/secret.zip", wantErr: true},
+		{name: "empty", prefix: "databases/", filename: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := New(ctx, Config{
+				Bucket: "test-bucket",
+				Client: server.Client(),
+				Prefix: tt.prefix,
+			})
+			if err != nil {
+				t.Fatalf("failed to create backend: %v", err)
+			}
+			defer backend.Close()
+
+			got, err := backend.objectPath(tt.filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("objectPath(%q) error = %v, wantErr %v", tt.filename, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("objectPath(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
 		})
-		if err != nil {
-			t.Fatalf("failed to create backend: %v", err)
-		}
-		defer backend.Close()
+	}
+}
 
-		path := backend.objectPath("test.txt")
-		if path != "test.txt" {
-			t.Errorf("objectPath() = %q, want %q", path, "test.txt")
-		}
+func TestBackend_GetFile_DotDot(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+	server.CreateObject(fakestorage.Object{
+		ObjectAttrs: fakestorage.ObjectAttrs{BucketName: "test-bucket", Name: "secret.zip"},
+		Content:     []byte("secret"),
 	})
 
-	t.Run("with prefix", func(t *testing.T) {
-		backend, err := New(ctx, Config{
-			Bucket: "test-bucket",
-			Client: server.Client(),
-			Prefix: "databases/",
-		})
+	backend, err := New(ctx, Config{
+		Bucket: "test-bucket",
+		Client: server.Client(),
+		Prefix: "databases/",
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	if _, _, _, err := backend.GetFile(ctx, "../secret.zip"); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("GetFile() error = %v, want access denied", err)
+	}
+	if _, err := backend.FileExists(ctx, "team/../../secret.zip"); err == nil {
+		t.Error("FileExists() expected error for dot-dot path, got nil")
+	}
+}
+
+func TestBackend_Members(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range []struct{ name, content string }{
+		{"codeql-database.yml", "primaryLanguage: go\n"},
+		{"db-go/", ""},
+		{"db-go/default/x", "data"},
+	} {
+		fw, err := zw.Create(entry.name)
 		if err != nil {
-			t.Fatalf("failed to create backend: %v", err)
+			t.Fatalf("failed to create zip entry: %v", err)
 		}
-		defer backend.Close()
+		if _, err := fw.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+	server.createFile(t, "dbs/widgets.zip", buf.Bytes(), "application/zip")
+	server.createDatabase(t, "dbs/gadgets", []byte("primaryLanguage: python\n"), "python")
+	server.createFile(t, "dbs/gadgets/hepc.yml", []byte("owner: octo\n"), "text/yaml")
+	server.createFile(t, "secret.txt", []byte("secret"), "text/plain")
+
+	backend, err := New(ctx, Config{Bucket: "test-bucket", Client: server.Client(), Prefix: "dbs/"})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
 
-		path := backend.objectPath("test.txt")
-		if path != "databases/test.txt" {
-			t.Errorf("objectPath() = %q, want %q", path, "databases/test.txt")
+	listTests := []struct {
+		dbPath string
+		want   []hepcStorage.Member
+	}{
+		{"widgets.zip", []hepcStorage.Member{{Path: "codeql-database.yml", Size: 20}, {Path: "db-go/default/x", Size: 4}}},
+		{"gadgets", []hepcStorage.Member{{Path: "codeql-database.yml", Size: 24}, {Path: "db-python/.marker", Size: 6}}},
+	}
+	for _, tt := range listTests {
+		members, err := backend.ListMembers(ctx, tt.dbPath)
+		if err != nil {
+			t.Fatalf("ListMembers(%q) error = %v", tt.dbPath, err)
 		}
-	})
+		if !reflect.DeepEqual(members, tt.want) {
+			t.Errorf("ListMembers(%q) = %+v, want %+v", tt.dbPath, members, tt.want)
+		}
+	}
+	if _, err := backend.ListMembers(ctx, "missing"); err == nil {
+		t.Error("ListMembers(missing) expected error, got nil")
+	}
+
+	tests := []struct {
+		name        string
+		dbPath      string
+		member      string
+		wantContent string
+		wantErr     bool
+	}{
+		{name: "archive entry", dbPath: "widgets.zip", member: "db-go/default/x", wantContent: "data"},
+		{name: "directory object", dbPath: "gadgets", member: "codeql-database.yml", wantContent: "primaryLanguage: python\n"},
+		{name: "missing archive entry", dbPath: "widgets.zip", member: "missing", wantErr: true},
+		{name: "directory sidecar file", dbPath: "gadgets", member: "hepc.yml", wantErr: true},
+		{name: "dot-dot member", dbPath: "gadgets", member: "../../secret.txt", wantErr: true},
+		{name: "dot-dot database", dbPath: "../secret.txt", member: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, size, err := backend.GetMember(ctx, tt.dbPath, tt.member)
+			if tt.wantErr {
+				if err == nil {
+					_ = reader.Close()
+					t.Fatal("GetMember() expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetMember() error = %v", err)
+			}
+			defer reader.Close()
+
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("failed to read member: %v", err)
+			}
+			if string(content) != tt.wantContent || size != int64(len(tt.wantContent)) {
+				t.Errorf("GetMember() = %q, %d; want %q", content, size, tt.wantContent)
+			}
+		})
+	}
 }
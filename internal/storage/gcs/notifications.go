@@ -0,0 +1,232 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub/v2"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+	hepcStorage "github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// Event types of GCS Pub/Sub notifications that change the databases. An
+// overwritten object is reported as a delete followed by a finalize.
+const (
+	eventFinalize = "OBJECT_FINALIZE"
+	eventDelete   = "OBJECT_DELETE"
+)
+
+// notifier receives the bucket's object change notifications and queues
+// the database artifacts they affect for rediscovery.
+type notifier struct {
+	client     *pubsub.Client
+	ownsClient bool
+	delay      time.Duration
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	// pending holds the archives and database directories to rediscover,
+	// and signal wakes the goroutine applying them
+	mu      sync.Mutex
+	pending map[string]bool
+	signal  chan struct{}
+}
+
+// subscriptionProject returns the project of a subscription named
+// "projects/<project>/subscriptions/<id>".
+func subscriptionProject(subscription string) (string, error) {
+	parts := strings.Split(subscription, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != "subscriptions" || parts[3] == "" {
+		return "", fmt.Errorf("invalid subscription %q: want projects/<project>/subscriptions/<id>", subscription)
+	}
+	return parts[1], nil
+}
+
+// startNotifications subscribes to the object change notifications of the
+// bucket. It returns once the subscription is set up; notifications are
+// received and applied in the background until Close.
+func (b *Backend) startNotifications(ctx context.Context, cfg Config) error {
+	project, err := subscriptionProject(cfg.Subscription)
+	if err != nil {
+		return err
+	}
+
+	n := &notifier{
+		client:  cfg.PubSubClient,
+		delay:   cfg.NotificationDelay,
+		done:    make(chan struct{}),
+		pending: make(map[string]bool),
+		signal:  make(chan struct{}, 1),
+	}
+	if n.delay == 0 {
+		n.delay = 2 * time.Second
+	}
+	if n.client == nil {
+		opts, err := clientOptions(cfg)
+		if err != nil {
+			return err
+		}
+		n.client, err = pubsub.NewClient(ctx, project, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to create Pub/Sub client: %w", err)
+		}
+		n.ownsClient = true
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+	b.notifier = n
+
+	subscriber := n.client.Subscriber(cfg.Subscription)
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		err := subscriber.Receive(runCtx, func(_ context.Context, msg *pubsub.Message) {
+			msg.Ack()
+			b.notify(msg.Attributes)
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Fprintf(os.Stderr, "Warning: stopped receiving notifications from %s, relying on periodic scans: %v\n", cfg.Subscription, err)
+		}
+	}()
+	go func() {
+		defer close(n.done)
+		b.applyNotifications(runCtx)
+		<-received
+	}()
+	return nil
+}
+
+// stopNotifications stops receiving notifications and waits for a
+// rediscovery in progress to finish.
+func (b *Backend) stopNotifications() error {
+	n := b.notifier
+	if n == nil {
+		return nil
+	}
+	n.cancel()
+	<-n.done
+	if n.ownsClient {
+		return n.client.Close()
+	}
+	return nil
+}
+
+// notify queues the database artifact affected by a notification with the given
+// attributes for rediscovery. Notifications for other buckets, objects
+// outside the prefix and other event types are ignored.
+func (b *Backend) notify(attrs map[string]string) {
+	if attrs["bucketId"] != b.bucket {
+		return
+	}
+	if event := attrs["eventType"]; event != eventFinalize && event != eventDelete {
+		return
+	}
+	name, ok := strings.CutPrefix(attrs["objectId"], b.prefix)
+	if !ok {
+		return
+	}
+
+	b.mu.RLock()
+	idx, quarantine := b.cachedIndex, b.quarantine
+	b.mu.RUnlock()
+	scope, ok := notificationScope(name, idx, quarantine)
+	if !ok {
+		return
+	}
+
+	n := b.notifier
+	n.mu.Lock()
+	n.pending[scope] = true
+	n.mu.Unlock()
+	select {
+	case n.signal <- struct{}{}:
+	default:
+	}
+}
+
+// notificationScope returns the database artifact to rediscover after the
+// object at name, relative to the prefix, was created or deleted, or false
+// if the change cannot affect any database. A change inside a known
+// database rediscovers that archive or directory; a new archive, the
+// sidecar file of an archive and a new database metadata file rediscover
+// the archive or database directory they belong to. Other objects are
+// ignored until the metadata file of their database arrives.
+func notificationScope(name string, idx *hepcStorage.Index, quarantine []codeql.QuarantinedDatabase) (string, bool) {
+	if !fs.ValidPath(name) || name == "." {
+		return "", false
+	}
+
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if len(idx.ByPath(dir)) > 0 || quarantined(quarantine, dir) {
+			return dir, true
+		}
+	}
+	switch {
+	case strings.EqualFold(path.Ext(name), ".zip"):
+		return name, true
+	case strings.HasSuffix(name, "."+codeql.SidecarFileName):
+		return strings.TrimSuffix(name, "."+codeql.SidecarFileName) + ".zip", true
+	case path.Base(name) == "codeql-database.yml" && path.Dir(name) != ".":
+		// The top-level directory is never a database
+		return path.Dir(name), true
+	}
+	return "", false
+}
+
+// quarantined reports whether the artifact at relPath was quarantined.
+func quarantined(quarantine []codeql.QuarantinedDatabase, relPath string) bool {
+	for _, q := range quarantine {
+		if q.RelPath == relPath {
+			return true
+		}
+	}
+	return false
+}
+
+// applyNotifications rediscovers the queued artifacts until ctx is done.
+// After each notification it waits for the notifier's delay, so that a
+// burst of changes, such as the files of one database upload, is applied
+// with a single rediscovery.
+func (b *Backend) applyNotifications(ctx context.Context) {
+	n := b.notifier
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.signal:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(n.delay):
+		}
+
+		for _, scope := range n.take() {
+			if err := b.refreshArtifact(ctx, scope); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to apply notifications for %q: %v\n", scope, err)
+			}
+		}
+	}
+}
+
+// take returns and clears the queued artifacts in path order.
+func (n *notifier) take() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	scopes := make([]string, 0, len(n.pending))
+	for scope := range n.pending {
+		scopes = append(scopes, scope)
+	}
+	clear(n.pending)
+	sort.Strings(scopes)
+	return scopes
+}
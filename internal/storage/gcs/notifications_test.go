@@ -0,0 +1,210 @@
+package gcs
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/v2"
+	"cloud.google.com/go/pubsub/v2/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/v2/pstest"
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+	hepcStorage "github.com/data-douser/mrva-go-hepc/internal/storage"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestSubscriptionProject(t *testing.T) {
+	tests := []struct {
+		subscription string
+		want         string
+		wantErr      bool
+	}{
+		{subscription: "projects/octo/subscriptions/hepc", want: "octo"},
+		{subscription: "hepc", wantErr: true},
+		{subscription: "projects//subscriptions/hepc", wantErr: true},
+		{subscription: "projects/octo/topics/hepc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subscription, func(t *testing.T) {
+			got, err := subscriptionProject(tt.subscription)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("subscriptionProject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("subscriptionProject() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotificationScope(t *testing.T) {
+	idx := hepcStorage.NewIndex([]api.DatabaseMetadataV2{
+		{DatabaseMetadata: api.DatabaseMetadata{ResultURL: "http://localhost:8080/db/team/widgets.zip"}},
+		{DatabaseMetadata: api.DatabaseMetadata{ResultURL: "http://localhost:8080/db/team/gadgets"}},
+		{DatabaseMetadata: api.DatabaseMetadata{ResultURL: "http://localhost:8080/db/top.zip"}},
+	})
+	quarantine := []codeql.QuarantinedDatabase{{RelPath: "other/broken"}}
+
+	tests := []struct {
+		name      string
+		object    string
+		wantScope string
+		wantOK    bool
+	}{
+		{name: "known archive", object: "team/widgets.zip", wantScope: "team/widgets.zip", wantOK: true},
+		{name: "sidecar of known archive", object: "team/widgets.hepc.yml", wantScope: "team/widgets.zip", wantOK: true},
+		{name: "file in known directory", object: "team/gadgets/db-go/default/x", wantScope: "team/gadgets", wantOK: true},
+		{name: "file in quarantined directory", object: "other/broken/db-go/default/x", wantScope: "other/broken", wantOK: true},
+		{name: "top-level archive", object: "top.zip", wantScope: "top.zip", wantOK: true},
+		{name: "new archive", object: "new/tools.ZIP", wantScope: "new/tools.ZIP", wantOK: true},
+		{name: "new metadata file", object: "new/team/tools/codeql-database.yml", wantScope: "new/team/tools", wantOK: true},
+		{name: "top-level metadata file", object: "codeql-database.yml", wantOK: false},
+		{name: "file in new directory", object: "new/tools/db-go/default/x", wantOK: false},
+		{name: "unrelated file", object: "README.md", wantOK: false},
+		{name: "invalid path", object: "team/../widgets.zip", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, ok := notificationScope(tt.object, idx, quarantine)
+			if ok != tt.wantOK || scope != tt.wantScope {
+				t.Errorf("notificationScope(%q) = %q, %v, want %q, %v", tt.object, scope, ok, tt.wantScope, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNotifier_Take(t *testing.T) {
+	n := &notifier{pending: map[string]bool{"a/b.zip": true, "a": true, "c/d": true}}
+	if got, want := n.take(), []string{"a", "a/b.zip", "c/d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("take() = %v, want %v", got, want)
+	}
+	if got := n.take(); len(got) != 0 {
+		t.Errorf("second take() = %v, want none", got)
+	}
+}
+
+// newTestPubSub starts an in-process Pub/Sub server with a topic and a
+// subscription to it, and returns the server and a client connected to it.
+func newTestPubSub(t *testing.T, topic, subscription string) (*pstest.Server, *pubsub.Client) {
+	t.Helper()
+	ctx := context.Background()
+
+	srv := pstest.NewServer()
+	t.Cleanup(func() { _ = srv.Close() }) //nolint:errcheck // Best effort close in cleanup
+
+	if _, err := srv.GServer.CreateTopic(ctx, &pubsubpb.Topic{Name: topic}); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	if _, err := srv.GServer.CreateSubscription(ctx, &pubsubpb.Subscription{Name: subscription, Topic: topic}); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to connect to Pub/Sub server: %v", err)
+	}
+	client, err := pubsub.NewClient(ctx, "octo", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("failed to create Pub/Sub client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() }) //nolint:errcheck // Best effort close in cleanup
+	return srv, client
+}
+
+func TestBackend_Notifications(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, "test-bucket")
+	defer server.Stop()
+
+	const (
+		topic        = "projects/octo/topics/hepc"
+		subscription = "projects/octo/subscriptions/hepc"
+	)
+	pubsubServer, pubsubClient := newTestPubSub(t, topic, subscription)
+
+	databaseYAML := func(ownerRepo string) []byte {
+		return []byte("sourceLocationPrefix: /src/" + ownerRepo + "\nprimaryLanguage: go\n")
+	}
+	server.createDatabase(t, "dbs/team-a/one", databaseYAML("a/one"), "go")
+
+	// The cache never expires, so only notifications can change it
+	backend, err := New(ctx, Config{
+		Bucket:            "test-bucket",
+		Client:            server.Client(),
+		Prefix:            "dbs/",
+		CacheTTL:          time.Hour,
+		Subscription:      subscription,
+		NotificationDelay: 10 * time.Millisecond,
+		PubSubClient:      pubsubClient,
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	projects := func() []string {
+		t.Helper()
+		metadata, err := backend.ListMetadata(ctx)
+		if err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+		var names []string
+		for _, m := range metadata {
+			names = append(names, m.Projname)
+		}
+		sort.Strings(names)
+		return names
+	}
+	waitFor := func(want []string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			got := projects()
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("projects = %v, want %v", got, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	publish := func(bucket, event, object string) {
+		pubsubServer.Publish(topic, nil, map[string]string{
+			"bucketId":  bucket,
+			"eventType": event,
+			"objectId":  object,
+		})
+	}
+
+	waitFor([]string{"a/one"})
+
+	// Notifications for other buckets and events are ignored
+	server.createDatabase(t, "dbs/team-b/two", databaseYAML("b/two"), "go")
+	publish("other-bucket", eventFinalize, "dbs/team-b/two/codeql-database.yml")
+	publish("test-bucket", "OBJECT_METADATA_UPDATE", "dbs/team-b/two/codeql-database.yml")
+
+	// Only the database a notification belongs to is rediscovered, not
+	// its unannounced neighbours
+	server.createDatabase(t, "dbs/team-a/four", databaseYAML("a/four"), "go")
+	server.createDatabase(t, "dbs/team-a/three", databaseYAML("a/three"), "go")
+	publish("test-bucket", eventFinalize, "dbs/team-a/three/db-go/.marker")
+	publish("test-bucket", eventFinalize, "dbs/team-a/three/codeql-database.yml")
+	waitFor([]string{"a/one", "a/three"})
+
+	bucket := server.Client().Bucket("test-bucket")
+	for _, name := range []string{"dbs/team-a/one/codeql-database.yml", "dbs/team-a/one/db-go/.marker"} {
+		if err := bucket.Object(name).Delete(ctx); err != nil {
+			t.Fatalf("failed to delete %s: %v", name, err)
+		}
+		publish("test-bucket", eventDelete, name)
+	}
+	waitFor([]string{"a/three"})
+}
@@ -0,0 +1,730 @@
+// Package hepc provides a storage backend that federates one or more upstream
+// HEPC servers (Python or Go) behind a single endpoint.
+package hepc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+)
+
+// Upstream describes a single upstream HEPC server.
+type Upstream struct {
+	// Name identifies the upstream in proxied paths (default: "upstream<N>").
+	Name string
+
+	// URL is the base URL of the upstream server (e.g., "https://hepc.example.com").
+	// Metadata is fetched from URL + "/index".
+	URL string
+
+	// Headers are added to every request sent to this upstream,
+	// typically an Authorization header.
+	Headers map[string]string
+
+	// Timeout bounds metadata requests and the wait for download response
+	// headers from this upstream (default: Config.Timeout).
+	Timeout time.Duration
+}
+
+// Config holds configuration for the federation storage backend.
+type Config struct {
+	// Upstreams is the list of upstream HEPC servers (at least one is required).
+	Upstreams []Upstream
+
+	// EndpointURL is the base URL of this server, used to rewrite result URLs.
+	EndpointURL string
+
+	// CacheTTL is how long to cache upstream metadata (default: 5 minutes).
+	CacheTTL time.Duration
+
+	// Timeout is the default per-upstream request timeout (default: 30 seconds).
+	Timeout time.Duration
+
+	// MaxRetries is the number of retries for failed upstream requests (default: 3).
+	// A negative value disables retries.
+	MaxRetries int
+
+	// RetryBackoff is the initial delay between retries, doubled after each
+	// attempt (default: 500 milliseconds).
+	RetryBackoff time.Duration
+
+	// HTTPClient is an optional HTTP client (default: a new http.Client).
+	HTTPClient *http.Client
+}
+
+// remoteFile records where a proxied database lives upstream.
+type remoteFile struct {
+	upstream *Upstream
+
+	// path is the database's path under the upstream's /db/
+	path string
+}
+
+// url returns the download URL of the database on its upstream's host.
+func (rf remoteFile) url() string {
+	return rf.upstream.URL + "/db/" + (&url.URL{Path: rf.path}).EscapedPath()
+}
+
+// Backend implements storage.Backend by proxying upstream HEPC servers.
+type Backend struct {
+	upstreams    []Upstream
+	endpointURL  string
+	maxRetries   int
+	retryBackoff time.Duration
+	client       *http.Client
+
+	// Cache for upstream metadata
+	mu             sync.RWMutex
+	cachedMetadata []api.DatabaseMetadata
+	cachedIndex    *storage.Index
+	cacheTime      time.Time
+	cacheTTL       time.Duration
+	files          map[string]remoteFile               // keyed by local path under /db/
+	lastGood       map[string][]api.DatabaseMetadataV2 // last successful fetch, keyed by upstream name
+	refreshLog     storage.RefreshLog
+}
+
+// New creates a new federation storage backend.
+func New(cfg Config) (*Backend, error) {
+	if len(cfg.Upstreams) == 0 {
+		return nil, fmt.Errorf("hepc storage: at least one upstream is required")
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	upstreams := make([]Upstream, len(cfg.Upstreams))
+	seen := make(map[string]bool)
+	for i, up := range cfg.Upstreams {
+		if up.URL == "" {
+			return nil, fmt.Errorf("hepc storage: upstream %d: URL is required", i)
+		}
+		u, err := url.Parse(up.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("hepc storage: upstream %d: invalid URL: %s", i, up.URL)
+		}
+		if up.Name == "" {
+			up.Name = fmt.Sprintf("upstream%d", i)
+		}
+		if strings.ContainsAny(up.Name, "/?#") {
+			return nil, fmt.Errorf("hepc storage: upstream name must not contain '/', '?' or '#': %s", up.Name)
+		}
+		if seen[up.Name] {
+			return nil, fmt.Errorf("hepc storage: duplicate upstream name: %s", up.Name)
+		}
+		seen[up.Name] = true
+		if up.Timeout == 0 {
+			up.Timeout = timeout
+		}
+		up.URL = strings.TrimSuffix(up.URL, "/")
+		upstreams[i] = up
+	}
+
+	endpointURL := cfg.EndpointURL
+	if endpointURL == "" {
+		endpointURL = "http://localhost:8080"
+	}
+
+	cacheTTL := cfg.CacheTTL
+	if cacheTTL == 0 {
+		cacheTTL = 5 * time.Minute
+	}
+
+	maxRetries := cfg.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 3
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	retryBackoff := cfg.RetryBackoff
+	if retryBackoff == 0 {
+		retryBackoff = 500 * time.Millisecond
+	}
+
+	client := &http.Client{}
+	if cfg.HTTPClient != nil {
+		c := *cfg.HTTPClient
+		client = &c
+	}
+	client.CheckRedirect = dropHeadersOnHostChange(client.CheckRedirect)
+
+	return &Backend{
+		upstreams:    upstreams,
+		endpointURL:  strings.TrimSuffix(endpointURL, "/"),
+		maxRetries:   maxRetries,
+		retryBackoff: retryBackoff,
+		client:       client,
+		cacheTTL:     cacheTTL,
+		files:        make(map[string]remoteFile),
+		lastGood:     make(map[string][]api.DatabaseMetadataV2),
+	}, nil
+}
+
+// Type returns the storage backend type identifier.
+func (b *Backend) Type() string {
+	return "hepc"
+}
+
+// ListMetadata fetches the metadata index of every upstream and returns the
+// combined records with ResultURL rewritten to point at this server.
+//
+// An upstream that cannot be reached contributes its last successfully
+// fetched records; an error is returned only if no upstream has ever answered.
+func (b *Backend) ListMetadata(ctx context.Context) ([]api.DatabaseMetadata, error) {
+	// Check if we have valid cached data
+	b.mu.RLock()
+	if b.cachedMetadata != nil && time.Since(b.cacheTime) < b.cacheTTL {
+		result := make([]api.DatabaseMetadata, len(b.cachedMetadata))
+		copy(result, b.cachedMetadata)
+		b.mu.RUnlock()
+		return result, nil
+	}
+	b.mu.RUnlock()
+
+	return b.refresh(ctx, "")
+}
+
+// Index returns a lookup index over the current metadata, refreshing it
+// first if the cache has expired.
+func (b *Backend) Index(ctx context.Context) (*storage.Index, error) {
+	b.mu.RLock()
+	if b.cachedIndex != nil && time.Since(b.cacheTime) < b.cacheTTL {
+		idx := b.cachedIndex
+		b.mu.RUnlock()
+		return idx, nil
+	}
+	b.mu.RUnlock()
+
+	if _, err := b.ListMetadata(ctx); err != nil {
+		return nil, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.cachedIndex, nil
+}
+
+// refresh re-fetches the index of the upstream named only, or of every
+// upstream if only is empty, and rebuilds the cache. Upstreams that are not
+// fetched contribute their last successfully fetched records.
+func (b *Backend) refresh(ctx context.Context, only string) ([]api.DatabaseMetadata, error) {
+	type fetchResult struct {
+		records []api.DatabaseMetadataV2
+		err     error
+		skipped bool
+	}
+
+	start := time.Now()
+	results := make([]fetchResult, len(b.upstreams))
+	var wg sync.WaitGroup
+	for i := range b.upstreams {
+		if only != "" && b.upstreams[i].Name != only {
+			results[i].skipped = true
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			records, err := b.fetchIndex(ctx, &b.upstreams[i])
+			results[i] = fetchResult{records: records, err: err}
+		}(i)
+	}
+	wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var errs []error
+	answered := 0
+	for i := range b.upstreams {
+		up := &b.upstreams[i]
+		if results[i].skipped {
+			if _, ok := b.lastGood[up.Name]; ok {
+				answered++
+			}
+			continue
+		}
+		if results[i].err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to fetch index from upstream %s: %v\n", up.Name, results[i].err)
+			err := fmt.Errorf("upstream %s: %w", up.Name, results[i].err)
+			b.refreshLog.Failed(err)
+			errs = append(errs, err)
+			if _, ok := b.lastGood[up.Name]; ok {
+				answered++
+			}
+			continue
+		}
+		b.lastGood[up.Name] = results[i].records
+		answered++
+	}
+	if answered == 0 {
+		return nil, fmt.Errorf("failed to fetch metadata from upstreams: %w", errors.Join(errs...))
+	}
+
+	metadata := make([]api.DatabaseMetadata, 0)
+	var records []api.DatabaseMetadataV2
+	files := make(map[string]remoteFile)
+	seenHashes := make(map[string]bool)
+	for i := range b.upstreams {
+		up := &b.upstreams[i]
+		for _, r := range b.lastGood[up.Name] {
+			// Databases are downloaded from the configured upstream URL
+			// only, never from the host a result URL names
+			rel, ok := upstreamPath(r.DatabaseMetadata)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Warning: upstream %s advertises %s outside /db/, skipping\n", up.Name, r.ResultURL)
+				continue
+			}
+
+			// The same database may be advertised by several upstreams;
+			// the first upstream in configuration order wins.
+			if r.ContentHash != "" {
+				if seenHashes[r.ContentHash] {
+					continue
+				}
+				seenHashes[r.ContentHash] = true
+			}
+
+			localPath := up.Name + "/" + rel
+			rf := remoteFile{upstream: up, path: rel}
+			files[localPath] = rf
+			r.ResultURL = b.endpointURL + "/db/" + localPath
+			r.Location = rf.url()
+			metadata = append(metadata, r.DatabaseMetadata)
+			records = append(records, r)
+		}
+	}
+
+	b.cachedMetadata = metadata
+	b.cachedIndex = storage.NewIndex(records)
+	b.cacheTime = time.Now()
+	b.files = files
+	b.refreshLog.Succeeded(start)
+
+	result := make([]api.DatabaseMetadata, len(metadata))
+	copy(result, metadata)
+	return result, nil
+}
+
+// upstreamPath returns the path under /db/ at which an upstream serves a
+// database, taken from the path of its result URL; the scheme and host of
+// the result URL are ignored. This server exposes the database at the same
+// path below the upstream's name. It returns false if the result URL has no
+// valid /db/ path.
+func upstreamPath(m api.DatabaseMetadata) (string, bool) {
+	u, err := url.Parse(m.ResultURL)
+	if err != nil {
+		return "", false
+	}
+	_, rel, ok := strings.Cut(u.Path, "/db/")
+	if !ok || rel == "." || !fs.ValidPath(rel) {
+		return "", false
+	}
+	return rel, true
+}
+
+// dropHeadersOnHostChange returns a redirect policy that removes the
+// request headers, which carry an upstream's credentials, when a redirect
+// leaves the upstream's scheme and host, and otherwise applies next, or the
+// default policy if next is nil.
+func dropHeadersOnHostChange(next func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != via[0].URL.Scheme || req.URL.Host != via[0].URL.Host {
+			req.Header = make(http.Header)
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
+
+// fetchIndex downloads and decodes the JSONL index of one upstream. The v2
+// index is preferred, as only it carries the hash kind and the other v2
+// facts; upstreams without it, such as Python HEPC servers, answer it with
+// 404, and their v1 /index is read instead.
+func (b *Backend) fetchIndex(ctx context.Context, up *Upstream) ([]api.DatabaseMetadataV2, error) {
+	ctx, cancel := context.WithTimeout(ctx, up.Timeout)
+	defer cancel()
+
+	records, err := b.readIndex(ctx, up, "/api/v2/index", func(line []byte) (api.DatabaseMetadataV2, error) {
+		var r api.DatabaseMetadataV2
+		err := json.Unmarshal(line, &r)
+		return r, err
+	})
+	if !errors.Is(err, errNoIndex) {
+		return records, err
+	}
+	return b.readIndex(ctx, up, "/index", func(line []byte) (api.DatabaseMetadataV2, error) {
+		var r api.DatabaseMetadataV2
+		if err := json.Unmarshal(line, &r.DatabaseMetadata); err != nil {
+			return r, err
+		}
+		if isSHA256Archive(r.DatabaseMetadata) {
+			r.Format = api.FormatArchived
+			r.HashKind = api.HashKindSHA256
+		}
+		return r, nil
+	})
+}
+
+// errNoIndex is returned by readIndex if the upstream answers 404.
+var errNoIndex = errors.New("index not found")
+
+// readIndex downloads the JSONL index at path of an upstream and decodes
+// each line with decode.
+func (b *Backend) readIndex(ctx context.Context, up *Upstream, path string, decode func([]byte) (api.DatabaseMetadataV2, error)) ([]api.DatabaseMetadataV2, error) {
+	resp, err := b.doWithRetry(ctx, up, up.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNoIndex
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var records []api.DatabaseMetadataV2
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		r, err := decode(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode index record: %w", err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	return records, nil
+}
+
+// isSHA256Archive reports whether a v1 record describes a ZIP archive whose
+// content hash is a SHA-256, which v1 servers publish for their archives.
+func isSHA256Archive(m api.DatabaseMetadata) bool {
+	rel, ok := upstreamPath(m)
+	if !ok || !strings.HasSuffix(rel, ".zip") || len(m.ContentHash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(m.ContentHash)
+	return err == nil
+}
+
+// doWithRetry issues a GET request with the given extra headers to an
+// upstream, retrying network errors, 429 and 5xx responses with exponential
+// backoff.
+func (b *Backend) doWithRetry(ctx context.Context, up *Upstream, target string, header http.Header) (*http.Response, error) {
+	backoff := b.retryBackoff
+	var lastErr error
+
+	for attempt := 0; attempt <= b.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, errors.Join(ctx.Err(), lastErr)
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, http.NoBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		for k, v := range up.Headers {
+			req.Header.Set(k, v)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("unexpected status: %s", resp.Status)
+			_ = resp.Body.Close() //nolint:errcheck // Response is discarded before retrying
+			continue
+		}
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("request to %s failed after %d attempts: %w", target, b.maxRetries+1, lastErr)
+}
+
+// lookup returns the upstream location of a proxied database, refreshing
+// the metadata once if the path is not yet known.
+func (b *Backend) lookup(ctx context.Context, filename string) (remoteFile, error) {
+	filename = strings.TrimPrefix(filename, "/")
+
+	b.mu.RLock()
+	rf, ok := b.files[filename]
+	b.mu.RUnlock()
+	if ok {
+		return rf, nil
+	}
+
+	if _, err := b.ListMetadata(ctx); err != nil {
+		return remoteFile{}, err
+	}
+
+	b.mu.RLock()
+	rf, ok = b.files[filename]
+	b.mu.RUnlock()
+	if !ok {
+		return remoteFile{}, &storage.ErrNotFound{Path: filename}
+	}
+	return rf, nil
+}
+
+// GetFile proxies a database download from the upstream that advertised it.
+func (b *Backend) GetFile(ctx context.Context, filename string) (io.ReadCloser, int64, string, error) {
+	return b.fetch(ctx, filename, 0)
+}
+
+// GetFileRange downloads a proxied database from byte offset off onwards,
+// forwarding the range to the upstream so the bytes before it are not
+// transferred.
+func (b *Backend) GetFileRange(ctx context.Context, filename string, off int64) (io.ReadCloser, error) {
+	reader, _, _, err := b.fetch(ctx, filename, off)
+	return reader, err
+}
+
+// fetch downloads a proxied database from byte offset off onwards and
+// returns it with the length of the response, if known, and content type.
+func (b *Backend) fetch(ctx context.Context, filename string, off int64) (io.ReadCloser, int64, string, error) {
+	rf, err := b.lookup(ctx, filename)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	var header http.Header
+	if off > 0 {
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", off)}}
+	}
+
+	// The timeout covers only the wait for response headers; the body of a
+	// multi-gigabyte download may take much longer to stream.
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(rf.upstream.Timeout, cancel)
+
+	resp, err := b.doWithRetry(ctx, rf.upstream, rf.url(), header)
+	if !timer.Stop() && err == nil {
+		_ = resp.Body.Close() //nolint:errcheck // Response is discarded after timeout
+		err = fmt.Errorf("timed out waiting for upstream %s", rf.upstream.Name)
+	}
+	if err != nil {
+		cancel()
+		return nil, 0, "", fmt.Errorf("failed to fetch from upstream %s: %w", rf.upstream.Name, err)
+	}
+	body := &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		body.modTime = modTime
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		_ = body.Close() //nolint:errcheck // Response is discarded
+		return nil, 0, "", &storage.ErrNotFound{Path: filename}
+	case resp.StatusCode == http.StatusPartialContent && off > 0:
+		if contentRange := resp.Header.Get("Content-Range"); !strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-", off)) {
+			_ = body.Close() //nolint:errcheck // Response is discarded
+			return nil, 0, "", fmt.Errorf("upstream %s returned unexpected Content-Range %q", rf.upstream.Name, contentRange)
+		}
+	case resp.StatusCode == http.StatusOK:
+		// An upstream without range support sends the whole file
+		if off > 0 {
+			if _, err := io.CopyN(io.Discard, body, off); err != nil {
+				_ = body.Close() //nolint:errcheck // Response is discarded
+				return nil, 0, "", fmt.Errorf("failed to skip to offset %d: %w", off, err)
+			}
+		}
+	default:
+		_ = body.Close() //nolint:errcheck // Response is discarded
+		return nil, 0, "", fmt.Errorf("upstream %s returned %s", rf.upstream.Name, resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return body, resp.ContentLength, contentType, nil
+}
+
+// cancelReadCloser releases the request context when the body is closed.
+// It reports the upstream's Last-Modified time, if any.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	modTime time.Time
+}
+
+// ModTime implements storage.ModTimer.
+func (c *cancelReadCloser) ModTime() time.Time { return c.modTime }
+
+func (c *cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// FileExists reports whether a database is advertised by any upstream.
+func (b *Backend) FileExists(ctx context.Context, filename string) (bool, error) {
+	_, err := b.lookup(ctx, filename)
+	if err != nil {
+		var notFound *storage.ErrNotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// MetadataExists always returns true since metadata is fetched dynamically.
+func (b *Backend) MetadataExists(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// Probe checks that at least one upstream answers its health check; the
+// others are served from their last fetched index. Probes are not retried.
+func (b *Backend) Probe(ctx context.Context) error {
+	errs := make([]error, len(b.upstreams))
+	var wg sync.WaitGroup
+	for i := range b.upstreams {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = b.probeUpstream(ctx, &b.upstreams[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no upstream is reachable: %w", errors.Join(errs...))
+}
+
+// probeUpstream requests the health check of one upstream.
+func (b *Backend) probeUpstream(ctx context.Context, up *Upstream) error {
+	ctx, cancel := context.WithTimeout(ctx, up.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, up.URL+"/health", http.NoBody)
+	if err != nil {
+		return fmt.Errorf("upstream %s: %w", up.Name, err)
+	}
+	for k, v := range up.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upstream %s: %w", up.Name, err)
+	}
+	_ = resp.Body.Close() //nolint:errcheck // Only the status is used
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream %s: unexpected status: %s", up.Name, resp.Status)
+	}
+	return nil
+}
+
+// Close releases any resources held by the backend.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	b.cachedMetadata = nil
+	b.cachedIndex = nil
+	b.files = nil
+	b.mu.Unlock()
+
+	b.client.CloseIdleConnections()
+	return nil
+}
+
+// InvalidateCache forces the upstream indexes to be fetched again on the
+// next request. The last successfully fetched records are kept as the
+// fallback for unreachable upstreams.
+func (b *Backend) InvalidateCache() {
+	b.mu.Lock()
+	b.cachedMetadata = nil
+	b.cachedIndex = nil
+	b.cacheTime = time.Time{}
+	b.mu.Unlock()
+}
+
+// CacheStats describes the cache of upstream metadata.
+func (b *Backend) CacheStats() storage.CacheStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entries := 0
+	if b.cachedIndex != nil {
+		entries = b.cachedIndex.Len()
+	}
+	return b.refreshLog.Stats(entries, b.cacheTime, b.cacheTTL)
+}
+
+// Reindex re-fetches the upstream indexes. Databases are served under
+// <upstream>/..., so a prefix re-fetches the index of the upstream named by
+// its first component only; upstreams cannot be reindexed more narrowly.
+// An empty cache is always fetched in full.
+func (b *Backend) Reindex(ctx context.Context, prefix string) error {
+	prefix, err := storage.CleanPrefix(prefix)
+	if err != nil {
+		return err
+	}
+
+	only, _, _ := strings.Cut(prefix, "/")
+	if only != "" && !slices.ContainsFunc(b.upstreams, func(up Upstream) bool { return up.Name == only }) {
+		return fmt.Errorf("unknown upstream %q", only)
+	}
+
+	b.mu.RLock()
+	if b.cachedIndex == nil {
+		only = ""
+	}
+	b.mu.RUnlock()
+
+	_, err = b.refresh(ctx, only)
+	return err
+}
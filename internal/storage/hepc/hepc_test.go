@@ -0,0 +1,823 @@
+// Package hepc tests for the federation backend using httptest upstreams
+// built from server.Server.
+package hepc
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/server"
+	"github.com/data-douser/mrva-go-hepc/internal/storage"
+	"github.com/data-douser/mrva-go-hepc/internal/storage/local"
+)
+
+// testUpstream is an HEPC server backed by a local directory.
+type testUpstream struct {
+	*httptest.Server
+	dir string
+
+	// wrap optionally intercepts requests before they reach the HEPC server.
+	wrap func(w http.ResponseWriter, r *http.Request, next http.Handler)
+
+	indexRequests atomic.Int32
+}
+
+// newTestUpstream starts an upstream HEPC server serving the given zip databases.
+// Each database is described by its file name and source location prefix.
+func newTestUpstream(t *testing.T, dbs map[string]string) *testUpstream {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, prefix := range dbs {
+		createTestDatabaseZip(t, filepath.Join(dir, name), prefix)
+	}
+
+	up := &testUpstream{dir: dir}
+	var handler http.Handler
+	up.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isIndexPath(r.URL.Path) {
+			up.indexRequests.Add(1)
+		}
+		if up.wrap != nil {
+			up.wrap(w, r, handler)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(up.Close)
+
+	// The upstream advertises its default endpoint URL, not where it is
+	// reachable; the backend downloads from the configured URL regardless
+	store, err := local.New(local.Config{BasePath: dir})
+	if err != nil {
+		t.Fatalf("failed to create upstream storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler = server.New(server.Config{}, store, logger).Handler()
+
+	return up
+}
+
+// isIndexPath reports whether path is that of the v1 or v2 index.
+func isIndexPath(path string) bool {
+	return path == "/index" || path == "/api/v2/index"
+}
+
+// createTestDatabaseZip writes a minimal archived CodeQL database.
+func createTestDatabaseZip(t *testing.T, zipPath, sourceLocationPrefix string) {
+	t.Helper()
+
+	file, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatalf("failed to create zip file: %v", err)
+	}
+	defer file.Close()
+
+	w := zip.NewWriter(file)
+	yw, err := w.Create("codeql-database.yml")
+	if err != nil {
+		t.Fatalf("failed to create yaml entry: %v", err)
+	}
+	yamlContent := "sourceLocationPrefix: " + sourceLocationPrefix + "\nprimaryLanguage: go\n"
+	if _, err := yw.Write([]byte(yamlContent)); err != nil {
+		t.Fatalf("failed to write yaml entry: %v", err)
+	}
+	if _, err := w.Create("db-go/"); err != nil {
+		t.Fatalf("failed to create lang dir entry: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+}
+
+func newTestBackend(t *testing.T, upstreams ...Upstream) *Backend {
+	t.Helper()
+
+	backend, err := New(Config{
+		Upstreams:    upstreams,
+		EndpointURL:  "http://federation.example.com",
+		RetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return backend
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		errSubstr string
+	}{
+		{
+			name:      "no upstreams",
+			cfg:       Config{},
+			errSubstr: "at least one upstream",
+		},
+		{
+			name:      "missing URL",
+			cfg:       Config{Upstreams: []Upstream{{Name: "a"}}},
+			errSubstr: "URL is required",
+		},
+		{
+			name:      "invalid URL",
+			cfg:       Config{Upstreams: []Upstream{{URL: "not a url"}}},
+			errSubstr: "invalid URL",
+		},
+		{
+			name: "duplicate names",
+			cfg: Config{Upstreams: []Upstream{
+				{Name: "a", URL: "http://a.example.com"},
+				{Name: "a", URL: "http://b.example.com"},
+			}},
+			errSubstr: "duplicate upstream name",
+		},
+		{
+			name:      "name with slash",
+			cfg:       Config{Upstreams: []Upstream{{Name: "a/b", URL: "http://a.example.com"}}},
+			errSubstr: "must not contain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if err == nil {
+				t.Fatal("New() expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errSubstr) {
+				t.Errorf("New() error = %q, want error containing %q", err.Error(), tt.errSubstr)
+			}
+		})
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		backend, err := New(Config{Upstreams: []Upstream{{URL: "http://a.example.com/"}}})
+		if err != nil {
+			t.Fatalf("New() unexpected error: %v", err)
+		}
+		defer backend.Close()
+
+		if backend.Type() != "hepc" {
+			t.Errorf("Type() = %q, want %q", backend.Type(), "hepc")
+		}
+		if backend.upstreams[0].Name != "upstream0" {
+			t.Errorf("upstream name = %q, want %q", backend.upstreams[0].Name, "upstream0")
+		}
+		if backend.upstreams[0].URL != "http://a.example.com" {
+			t.Errorf("upstream URL = %q, want trailing slash trimmed", backend.upstreams[0].URL)
+		}
+		if backend.upstreams[0].Timeout != 30*time.Second {
+			t.Errorf("upstream timeout = %v, want %v", backend.upstreams[0].Timeout, 30*time.Second)
+		}
+		if backend.maxRetries != 3 {
+			t.Errorf("maxRetries = %d, want 3", backend.maxRetries)
+		}
+		if backend.cacheTTL != 5*time.Minute {
+			t.Errorf("cacheTTL = %v, want %v", backend.cacheTTL, 5*time.Minute)
+		}
+	})
+}
+
+func TestBackend_ListMetadata_RewritesResultURL(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	backend := newTestBackend(t, Upstream{Name: "team-a", URL: up.URL})
+
+	metadata, err := backend.ListMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(metadata) != 1 {
+		t.Fatalf("ListMetadata() returned %d items, want 1", len(metadata))
+	}
+
+	want := "http://federation.example.com/db/team-a/repo.zip"
+	if metadata[0].ResultURL != want {
+		t.Errorf("ResultURL = %q, want %q", metadata[0].ResultURL, want)
+	}
+	if metadata[0].Projname != "owner/repo" {
+		t.Errorf("Projname = %q, want %q", metadata[0].Projname, "owner/repo")
+	}
+}
+
+func TestBackend_GetFile_ProxiesUpstream(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	backend := newTestBackend(t, Upstream{Name: "team-a", URL: up.URL})
+	ctx := context.Background()
+
+	// GetFile must work without a prior ListMetadata call
+	reader, size, contentType, err := backend.GetFile(ctx, "team-a/repo.zip")
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	defer reader.Close()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read proxied file: %v", err)
+	}
+	want, err := os.ReadFile(filepath.Join(up.dir, "repo.zip"))
+	if err != nil {
+		t.Fatalf("failed to read upstream file: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Error("proxied content does not match upstream file")
+	}
+	if size != int64(len(want)) {
+		t.Errorf("size = %d, want %d", size, len(want))
+	}
+	if contentType != "application/zip" {
+		t.Errorf("contentType = %q, want %q", contentType, "application/zip")
+	}
+
+	exists, err := backend.FileExists(ctx, "team-a/repo.zip")
+	if err != nil || !exists {
+		t.Errorf("FileExists() = %v, %v, want true, nil", exists, err)
+	}
+}
+
+func TestBackend_GetFileRange(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	var ranges []string
+	up.wrap = func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		if !isIndexPath(r.URL.Path) {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		next.ServeHTTP(w, r)
+	}
+	backend := newTestBackend(t, Upstream{Name: "team-a", URL: up.URL})
+
+	reader, err := backend.GetFileRange(context.Background(), "team-a/repo.zip", 10)
+	if err != nil {
+		t.Fatalf("GetFileRange() error = %v", err)
+	}
+	defer reader.Close()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read proxied range: %v", err)
+	}
+	want, err := os.ReadFile(filepath.Join(up.dir, "repo.zip"))
+	if err != nil {
+		t.Fatalf("failed to read upstream file: %v", err)
+	}
+	if string(got) != string(want[10:]) {
+		t.Error("proxied range does not match upstream file")
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=10-" {
+		t.Errorf("upstream Range headers = %q, want [\"bytes=10-\"]", ranges)
+	}
+}
+
+func TestBackend_GetFileRange_UpstreamWithoutRanges(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	up.wrap = func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		r.Header.Del("Range")
+		next.ServeHTTP(w, r)
+	}
+	backend := newTestBackend(t, Upstream{Name: "team-a", URL: up.URL})
+
+	reader, err := backend.GetFileRange(context.Background(), "team-a/repo.zip", 10)
+	if err != nil {
+		t.Fatalf("GetFileRange() error = %v", err)
+	}
+	defer reader.Close()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read proxied range: %v", err)
+	}
+	want, err := os.ReadFile(filepath.Join(up.dir, "repo.zip"))
+	if err != nil {
+		t.Fatalf("failed to read upstream file: %v", err)
+	}
+	if string(got) != string(want[10:]) {
+		t.Error("proxied range does not match upstream file")
+	}
+}
+
+func TestBackend_GetFile_ForeignResultURL(t *testing.T) {
+	// foreign records every request it receives
+	var foreignRequests atomic.Int32
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		foreignRequests.Add(1)
+		if r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-Key") != "" {
+			t.Errorf("upstream headers sent to %s%s", r.Host, r.URL.Path)
+		}
+		_, _ = io.WriteString(w, "foreign") //nolint:errcheck // Test response
+	}))
+	defer foreign.Close()
+
+	// The upstream advertises databases on the foreign host, and redirects
+	// one download there
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index":
+			for _, m := range []api.DatabaseMetadata{
+				{ContentHash: "a", ResultURL: foreign.URL + "/db/repo.zip"},
+				{ContentHash: "b", ResultURL: foreign.URL + "/db/moved.zip"},
+				{ContentHash: "c", ResultURL: foreign.URL + "/latest/repo.zip"},
+			} {
+				_ = json.NewEncoder(w).Encode(m) //nolint:errcheck // Test response
+			}
+		case "/db/repo.zip":
+			_, _ = io.WriteString(w, "upstream") //nolint:errcheck // Test response
+		case "/db/moved.zip":
+			http.Redirect(w, r, foreign.URL+"/db/moved.zip", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+
+	backend := newTestBackend(t, Upstream{
+		Name:    "team-a",
+		URL:     up.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret", "X-Api-Key": "secret"},
+	})
+	ctx := context.Background()
+
+	metadata, err := backend.ListMetadata(ctx)
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(metadata) != 2 {
+		t.Errorf("ListMetadata() returned %d items, want 2 (the record outside /db/ is skipped)", len(metadata))
+	}
+
+	reader, _, _, err := backend.GetFile(ctx, "team-a/repo.zip")
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	got, _ := io.ReadAll(reader) //nolint:errcheck // Compared below
+	reader.Close()
+	if string(got) != "upstream" {
+		t.Errorf("GetFile() content = %q, want the configured upstream's", got)
+	}
+	if n := foreignRequests.Load(); n != 0 {
+		t.Errorf("foreign host received %d requests, want 0", n)
+	}
+
+	// Following the redirect drops the upstream's headers
+	reader, _, _, err = backend.GetFile(ctx, "team-a/moved.zip")
+	if err != nil {
+		t.Fatalf("GetFile() of redirected database error = %v", err)
+	}
+	reader.Close()
+	if n := foreignRequests.Load(); n != 1 {
+		t.Errorf("foreign host received %d requests, want 1", n)
+	}
+}
+
+func TestBackend_GetFile_NotFound(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	backend := newTestBackend(t, Upstream{Name: "team-a", URL: up.URL})
+	ctx := context.Background()
+
+	_, _, _, err := backend.GetFile(ctx, "team-a/missing.zip")
+	var notFound *storage.ErrNotFound
+	if !errors.As(err, &notFound) {
+		t.Errorf("GetFile() error = %v, want ErrNotFound", err)
+	}
+
+	exists, err := backend.FileExists(ctx, "team-a/missing.zip")
+	if err != nil || exists {
+		t.Errorf("FileExists() = %v, %v, want false, nil", exists, err)
+	}
+}
+
+func TestBackend_AuthHeaders(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	up.wrap = func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+
+	t.Run("without header", func(t *testing.T) {
+		backend := newTestBackend(t, Upstream{URL: up.URL})
+		if _, err := backend.ListMetadata(context.Background()); err == nil {
+			t.Error("ListMetadata() expected error without auth header")
+		}
+	})
+
+	t.Run("with header", func(t *testing.T) {
+		backend := newTestBackend(t, Upstream{
+			URL:     up.URL,
+			Headers: map[string]string{"Authorization": "Bearer secret"},
+		})
+		metadata, err := backend.ListMetadata(context.Background())
+		if err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+		if len(metadata) != 1 {
+			t.Fatalf("ListMetadata() returned %d items, want 1", len(metadata))
+		}
+
+		reader, _, _, err := backend.GetFile(context.Background(), "upstream0/repo.zip")
+		if err != nil {
+			t.Fatalf("GetFile() error = %v", err)
+		}
+		reader.Close()
+	})
+}
+
+func TestBackend_Retries(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	var failures atomic.Int32
+	failures.Store(2)
+	up.wrap = func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		if failures.Add(-1) >= 0 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+
+	backend := newTestBackend(t, Upstream{URL: up.URL})
+	metadata, err := backend.ListMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(metadata) != 1 {
+		t.Errorf("ListMetadata() returned %d items, want 1", len(metadata))
+	}
+	if n := up.indexRequests.Load(); n != 3 {
+		t.Errorf("index requests = %d, want 3", n)
+	}
+}
+
+func TestBackend_Timeout(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	up.wrap = func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	backend, err := New(Config{
+		Upstreams:  []Upstream{{URL: up.URL, Timeout: 50 * time.Millisecond}},
+		MaxRetries: -1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	start := time.Now()
+	if _, err := backend.ListMetadata(context.Background()); err == nil {
+		t.Error("ListMetadata() expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ListMetadata() took %v, want timeout near 50ms", elapsed)
+	}
+}
+
+func TestBackend_ListMetadata_Caching(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	backend := newTestBackend(t, Upstream{URL: up.URL})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := backend.ListMetadata(ctx); err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+	}
+	if n := up.indexRequests.Load(); n != 1 {
+		t.Errorf("index requests = %d, want 1 (cached)", n)
+	}
+}
+
+func TestBackend_ListMetadata_StaleOnFailure(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	backend, err := New(Config{
+		Upstreams:    []Upstream{{URL: up.URL}},
+		CacheTTL:     time.Nanosecond,
+		RetryBackoff: time.Millisecond,
+		MaxRetries:   -1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+	ctx := context.Background()
+
+	if _, err := backend.ListMetadata(ctx); err != nil {
+		t.Fatalf("first ListMetadata() error = %v", err)
+	}
+
+	// Upstream goes down; the last good index is still served
+	up.wrap = func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}
+	metadata, err := backend.ListMetadata(ctx)
+	if err != nil {
+		t.Fatalf("second ListMetadata() error = %v", err)
+	}
+	if len(metadata) != 1 {
+		t.Errorf("ListMetadata() returned %d items, want 1 (stale)", len(metadata))
+	}
+}
+
+func TestBackend_MultipleUpstreams(t *testing.T) {
+	upA := newTestUpstream(t, map[string]string{"a.zip": "/src/owner/a"})
+	upB := newTestUpstream(t, map[string]string{"b.zip": "/src/owner/b"})
+
+	// Same database advertised by two upstreams is listed once
+	shared, err := os.ReadFile(filepath.Join(upA.dir, "a.zip"))
+	if err != nil {
+		t.Fatalf("failed to read database: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(upB.dir, "copy-of-a.zip"), shared, 0o644); err != nil {
+		t.Fatalf("failed to copy database: %v", err)
+	}
+
+	// One upstream is unreachable
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	backend := newTestBackend(t,
+		Upstream{Name: "a", URL: upA.URL},
+		Upstream{Name: "b", URL: upB.URL},
+		Upstream{Name: "down", URL: down.URL},
+	)
+
+	metadata, err := backend.ListMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(metadata) != 2 {
+		t.Fatalf("ListMetadata() returned %d items, want 2", len(metadata))
+	}
+
+	urls := map[string]bool{}
+	for _, m := range metadata {
+		urls[m.ResultURL] = true
+	}
+	for _, want := range []string{
+		"http://federation.example.com/db/a/a.zip",
+		"http://federation.example.com/db/b/b.zip",
+	} {
+		if !urls[want] {
+			t.Errorf("missing ResultURL %q in %v", want, urls)
+		}
+	}
+}
+
+func TestBackend_Probe(t *testing.T) {
+	up := newTestUpstream(t, nil)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	tests := []struct {
+		name      string
+		upstreams []Upstream
+		wantErr   bool
+	}{
+		{
+			name:      "reachable",
+			upstreams: []Upstream{{Name: "a", URL: up.URL}},
+		},
+		{
+			name:      "one of several reachable",
+			upstreams: []Upstream{{Name: "a", URL: up.URL}, {Name: "b", URL: down.URL}},
+		},
+		{
+			name:      "none reachable",
+			upstreams: []Upstream{{Name: "b", URL: down.URL}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newTestBackend(t, tt.upstreams...)
+			if err := backend.Probe(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackend_Reindex(t *testing.T) {
+	upA := newTestUpstream(t, map[string]string{"a.zip": "/src/owner/a"})
+	upB := newTestUpstream(t, map[string]string{"b.zip": "/src/owner/b"})
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	backend, err := New(Config{
+		Upstreams: []Upstream{
+			{Name: "a", URL: upA.URL},
+			{Name: "b", URL: upB.URL},
+			{Name: "down", URL: down.URL},
+		},
+		CacheTTL:     time.Hour,
+		RetryBackoff: time.Millisecond,
+		MaxRetries:   -1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+	ctx := context.Background()
+
+	if _, err := backend.ListMetadata(ctx); err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+
+	// A prefix re-fetches only the upstream it names
+	if err := backend.Reindex(ctx, "b/some/dir"); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	if a, b := upA.indexRequests.Load(), upB.indexRequests.Load(); a != 1 || b != 2 {
+		t.Errorf("index requests = %d, %d; want 1, 2", a, b)
+	}
+	if stats := backend.CacheStats(); stats.Entries != 2 {
+		t.Errorf("Entries = %d, want 2", stats.Entries)
+	}
+
+	if err := backend.Reindex(ctx, ""); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	if a, b := upA.indexRequests.Load(), upB.indexRequests.Load(); a != 2 || b != 3 {
+		t.Errorf("index requests = %d, %d; want 2, 3", a, b)
+	}
+
+	if err := backend.Reindex(ctx, "unknown"); err == nil {
+		t.Error("Reindex() expected error for unknown upstream, got nil")
+	}
+
+	// Failures of the unreachable upstream are reported
+	stats := backend.CacheStats()
+	if len(stats.Errors) != 2 || !strings.Contains(stats.Errors[0].Message, "upstream down") {
+		t.Errorf("Errors = %+v, want 2 errors from upstream down", stats.Errors)
+	}
+
+	backend.InvalidateCache()
+	if _, err := backend.ListMetadata(ctx); err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if a := upA.indexRequests.Load(); a != 3 {
+		t.Errorf("index requests after InvalidateCache = %d, want 3", a)
+	}
+}
+
+func TestBackend_Index_V2Facts(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	backend := newTestBackend(t, Upstream{Name: "team-a", URL: up.URL})
+
+	idx, err := backend.Index(context.Background())
+	if err != nil {
+		t.Fatalf("Index() error = %v", err)
+	}
+	records := idx.Records()
+	if len(records) != 1 {
+		t.Fatalf("Index() has %d records, want 1", len(records))
+	}
+	r := records[0]
+	if r.HashKind != api.HashKindSHA256 || r.Format != api.FormatArchived || r.SourceLocationPrefix != "/src/owner/repo" {
+		t.Errorf("record = hash kind %q, format %q, prefix %q; want the upstream's v2 facts", r.HashKind, r.Format, r.SourceLocationPrefix)
+	}
+	if want := up.URL + "/db/repo.zip"; r.Location != want {
+		t.Errorf("Location = %q, want %q", r.Location, want)
+	}
+
+	// The hash kind reaches clients of the federating server
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	front := httptest.NewServer(server.New(server.Config{}, backend, logger).Handler())
+	defer front.Close()
+	resp, err := http.Head(front.URL + "/db/team-a/repo.zip")
+	if err != nil {
+		t.Fatalf("HEAD failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Hash-Kind"); got != api.HashKindSHA256 {
+		t.Errorf("X-Hash-Kind = %q, want %q", got, api.HashKindSHA256)
+	}
+	if got := resp.Header.Get("ETag"); got != `"`+r.ContentHash+`"` {
+		t.Errorf("ETag = %q, want the content hash", got)
+	}
+}
+
+func TestBackend_Index_V1Upstream(t *testing.T) {
+	sha := strings.Repeat("ab", 32)
+	var v2Requests atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index":
+			for _, m := range []api.DatabaseMetadata{
+				{ContentHash: sha, ResultURL: "http://up/db/repo.zip"},
+				{ContentHash: "abc123", ResultURL: "http://up/db/short.zip"},
+				{ContentHash: strings.Repeat("cd", 32), ResultURL: "http://up/db/dir"},
+			} {
+				_ = json.NewEncoder(w).Encode(m) //nolint:errcheck // Test response
+			}
+		case "/api/v2/index":
+			v2Requests.Add(1)
+			http.NotFound(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+	backend := newTestBackend(t, Upstream{Name: "team-a", URL: up.URL})
+
+	idx, err := backend.Index(context.Background())
+	if err != nil {
+		t.Fatalf("Index() error = %v", err)
+	}
+	if n := v2Requests.Load(); n != 1 {
+		t.Errorf("v2 index requests = %d, want 1", n)
+	}
+
+	// Only archives with a SHA-256 are known to be hashed by content
+	want := map[string]string{
+		sha:                      api.HashKindSHA256,
+		"abc123":                 "",
+		strings.Repeat("cd", 32): "",
+	}
+	for _, r := range idx.Records() {
+		if r.HashKind != want[r.ContentHash] {
+			t.Errorf("%s: HashKind = %q, want %q", r.ResultURL, r.HashKind, want[r.ContentHash])
+		}
+	}
+	if idx.Len() != len(want) {
+		t.Errorf("Index() has %d records, want %d", idx.Len(), len(want))
+	}
+}
+
+func TestBackend_ServedThroughServer(t *testing.T) {
+	up := newTestUpstream(t, map[string]string{"repo.zip": "/src/owner/repo"})
+	backend := newTestBackend(t, Upstream{Name: "team-a", URL: up.URL})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	front := httptest.NewServer(server.New(server.Config{}, backend, logger).Handler())
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/db/team-a/repo.zip")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if _, err := zip.NewReader(strings.NewReader(string(body)), int64(len(body))); err != nil {
+		t.Errorf("proxied body is not a valid zip: %v", err)
+	}
+}
+
+func TestUpstreamPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		resultURL string
+		want      string
+		wantOK    bool
+	}{
+		{"db path", "http://up/db/dir/repo.zip", "dir/repo.zip", true},
+		{"mounted below a path", "https://up/hepc/db/repo.zip", "repo.zip", true},
+		{"language query", "http://up/db/repo.zip?language=go", "repo.zip", true},
+		{"escaped", "http://up/db/my%20repo.zip", "my repo.zip", true},
+		{"no db path", "http://up/files/repo.zip", "", false},
+		{"empty db path", "http://up/db/", "", false},
+		{"dot dot", "http://up/db/../admin/reload", "", false},
+		{"invalid URL", "://bad", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := upstreamPath(api.DatabaseMetadata{ResultURL: tt.resultURL})
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("upstreamPath() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+)
+
+// Index is an immutable in-memory lookup structure over a set of v2 metadata
+// records. Backends rebuild it whenever they refresh their metadata, so
+// lookups by content hash, project or artifact path take constant time.
+//
+// A nil *Index is valid and empty. It is safe for concurrent use.
+type Index struct {
+	records   []api.DatabaseMetadataV2
+	byHash    map[string]int
+	byProject map[string][]int
+	byPath    map[string][]int
+
+	// encoded caches bodies derived from the records, such as the
+	// compressed index, for as long as the index is current
+	encodedMu sync.Mutex
+	encoded   map[string]*encodedBody
+}
+
+// encodedBody is a body cached by Index.Encoded, built once.
+type encodedBody struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// NewIndex builds an index over records. The index keeps its own copy of
+// the slice; records must not be modified afterwards.
+func NewIndex(records []api.DatabaseMetadataV2) *Index {
+	idx := &Index{
+		records:   make([]api.DatabaseMetadataV2, len(records)),
+		byHash:    make(map[string]int, len(records)),
+		byProject: make(map[string][]int),
+		byPath:    make(map[string][]int),
+	}
+	copy(idx.records, records)
+
+	for i := range idx.records {
+		m := &idx.records[i]
+		if m.ContentHash != "" {
+			// Keep the first record for a hash, matching the order clients
+			// see in the index
+			if _, ok := idx.byHash[m.ContentHash]; !ok {
+				idx.byHash[m.ContentHash] = i
+			}
+		}
+		key := projectKey(m.GitOwner, m.GitRepo)
+		idx.byProject[key] = append(idx.byProject[key], i)
+		if p, ok := ArtifactPath(m.ResultURL); ok {
+			idx.byPath[p] = append(idx.byPath[p], i)
+		}
+	}
+	return idx
+}
+
+// Encoded returns the body cached under key, building it with build on
+// first use. Bodies derived from the records, such as the compressed JSONL
+// index, are thus built once per refresh and dropped with the index.
+// Concurrent callers wait for a single build; a failed build is cached too.
+func (idx *Index) Encoded(key string, build func() ([]byte, error)) ([]byte, error) {
+	if idx == nil {
+		return build()
+	}
+	idx.encodedMu.Lock()
+	if idx.encoded == nil {
+		idx.encoded = make(map[string]*encodedBody)
+	}
+	body, ok := idx.encoded[key]
+	if !ok {
+		body = &encodedBody{}
+		idx.encoded[key] = body
+	}
+	idx.encodedMu.Unlock()
+
+	body.once.Do(func() { body.data, body.err = build() })
+	return body.data, body.err
+}
+
+// Len returns the number of records in the index.
+func (idx *Index) Len() int {
+	if idx == nil {
+		return 0
+	}
+	return len(idx.records)
+}
+
+// Records returns a copy of all records in the index.
+func (idx *Index) Records() []api.DatabaseMetadataV2 {
+	if idx == nil {
+		return nil
+	}
+	result := make([]api.DatabaseMetadataV2, len(idx.records))
+	copy(result, idx.records)
+	return result
+}
+
+// ByHash returns the record with the given content hash.
+func (idx *Index) ByHash(contentHash string) (api.DatabaseMetadataV2, bool) {
+	if idx == nil {
+		return api.DatabaseMetadataV2{}, false
+	}
+	i, ok := idx.byHash[contentHash]
+	if !ok {
+		return api.DatabaseMetadataV2{}, false
+	}
+	return idx.records[i], true
+}
+
+// ByProject returns all records of a repository. Owner and repo are matched
+// case-insensitively, as on GitHub.
+func (idx *Index) ByProject(owner, repo string) []api.DatabaseMetadataV2 {
+	if idx == nil {
+		return nil
+	}
+	return idx.collect(idx.byProject[projectKey(owner, repo)])
+}
+
+// ByPath returns the records served from the given artifact path, i.e. the
+// part of their result URL after "/db/". Multi-language archives have one
+// record per language.
+func (idx *Index) ByPath(artifactPath string) []api.DatabaseMetadataV2 {
+	if idx == nil {
+		return nil
+	}
+	return idx.collect(idx.byPath[artifactPath])
+}
+
+// Serves reports whether artifactPath may be served from "/db/": it must be
+// the path of an advertised database, or of a file inside an advertised
+// unarchived database directory other than its sidecar file. Paths that are
+// not clean and relative are never served.
+func (idx *Index) Serves(artifactPath string) bool {
+	if idx == nil || !fs.ValidPath(artifactPath) || artifactPath == "." {
+		return false
+	}
+	if _, ok := idx.byPath[artifactPath]; ok {
+		return true
+	}
+	for dir := path.Dir(artifactPath); dir != "."; dir = path.Dir(dir) {
+		for _, i := range idx.byPath[dir] {
+			if idx.records[i].Format == api.FormatUnarchived {
+				return artifactPath != path.Join(dir, codeql.SidecarFileName)
+			}
+		}
+	}
+	return false
+}
+
+// collect copies the records at the given positions.
+func (idx *Index) collect(positions []int) []api.DatabaseMetadataV2 {
+	if len(positions) == 0 {
+		return nil
+	}
+	result := make([]api.DatabaseMetadataV2, len(positions))
+	for i, pos := range positions {
+		result[i] = idx.records[pos]
+	}
+	return result
+}
+
+// projectKey returns the case-insensitive lookup key of a repository.
+func projectKey(owner, repo string) string {
+	return strings.ToLower(owner) + "/" + strings.ToLower(repo)
+}
+
+// ArtifactPath returns the storage path of a database from its result URL,
+// i.e. the part after "/db/" without any query.
+func ArtifactPath(resultURL string) (string, bool) {
+	u, err := url.Parse(resultURL)
+	if err != nil {
+		return "", false
+	}
+	_, p, ok := strings.Cut(u.Path, "/db/")
+	if !ok || p == "" {
+		return "", false
+	}
+	return p, true
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+)
+
+func TestIndex(t *testing.T) {
+	records := []api.DatabaseMetadata{
+		{ContentHash: "h1", GitOwner: "Octo", GitRepo: "Hello", PrimaryLanguage: "go", ResultURL: "http://x/db/octo/hello.zip"},
+		{ContentHash: "h2", GitOwner: "octo", GitRepo: "hello", PrimaryLanguage: "java", ResultURL: "http://x/db/multi.zip?language=java"},
+		{ContentHash: "h3", GitOwner: "octo", GitRepo: "other", PrimaryLanguage: "python", ResultURL: "http://x/db/multi.zip?language=python"},
+		{ContentHash: "h1", GitOwner: "dup", GitRepo: "dup", ResultURL: "http://x/db/dup.zip"},
+	}
+	idx := NewIndex(api.ToV2(records))
+
+	// The index must not observe later changes to the input
+	records[0].GitRepo = "changed"
+
+	if idx.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", idx.Len())
+	}
+
+	m, ok := idx.ByHash("h1")
+	if !ok || m.GitOwner != "Octo" || m.GitRepo != "Hello" {
+		t.Errorf("ByHash(h1) = %+v, %v; want first record", m, ok)
+	}
+	if _, ok := idx.ByHash("missing"); ok {
+		t.Error("ByHash(missing) found a record")
+	}
+
+	if got := idx.ByProject("OCTO", "hello"); len(got) != 2 {
+		t.Errorf("ByProject(OCTO, hello) returned %d records, want 2", len(got))
+	}
+	if got := idx.ByProject("nobody", "nothing"); got != nil {
+		t.Errorf("ByProject(nobody, nothing) = %v, want nil", got)
+	}
+
+	if got := idx.ByPath("multi.zip"); len(got) != 2 || got[0].ContentHash != "h2" || got[1].ContentHash != "h3" {
+		t.Errorf("ByPath(multi.zip) = %+v, want h2 and h3", got)
+	}
+	if got := idx.ByPath("octo/hello.zip"); len(got) != 1 {
+		t.Errorf("ByPath(octo/hello.zip) returned %d records, want 1", len(got))
+	}
+}
+
+func TestIndex_Nil(t *testing.T) {
+	var idx *Index
+
+	if idx.Len() != 0 || idx.Records() != nil {
+		t.Error("nil Index is not empty")
+	}
+	if _, ok := idx.ByHash("h"); ok {
+		t.Error("nil Index ByHash found a record")
+	}
+	if idx.ByProject("o", "r") != nil || idx.ByPath("p") != nil {
+		t.Error("nil Index lookups returned records")
+	}
+}
+
+func TestIndex_Encoded(t *testing.T) {
+	builds := 0
+	build := func() ([]byte, error) {
+		builds++
+		return []byte("body"), nil
+	}
+
+	idx := NewIndex(nil)
+	for range 2 {
+		if body, err := idx.Encoded("v1.gzip", build); err != nil || string(body) != "body" {
+			t.Fatalf("Encoded() = %q, %v, want body", body, err)
+		}
+	}
+	if builds != 1 {
+		t.Errorf("Encoded() built the body %d times, want once", builds)
+	}
+	if _, err := idx.Encoded("v1.zstd", build); err != nil || builds != 2 {
+		t.Errorf("Encoded() for another key built %d bodies, %v; want 2", builds, err)
+	}
+
+	// A nil index caches nothing
+	var none *Index
+	_, _ = none.Encoded("v1.gzip", build) //nolint:errcheck // build never fails
+	_, _ = none.Encoded("v1.gzip", build) //nolint:errcheck // build never fails
+	if builds != 4 {
+		t.Errorf("nil Encoded() built %d bodies, want 4", builds)
+	}
+}
+
+func TestIndex_Serves(t *testing.T) {
+	idx := NewIndex([]api.DatabaseMetadataV2{
+		{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "h1", ResultURL: "http://x/db/octo/hello.zip"}, Format: api.FormatArchived},
+		{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "h2", ResultURL: "http://x/db/multi.zip?language=java"}, Format: api.FormatArchived},
+		{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "h3", ResultURL: "http://x/db/team/widgets"}, Format: api.FormatUnarchived},
+	})
+
+	tests := []struct {
+		artifactPath string
+		want         bool
+	}{
+		{"octo/hello.zip", true},
+		{"multi.zip", true},
+		{"team/widgets", true},
+		{"team/widgets/codeql-database.yml", true},
+		{"team/widgets/db-go/default/strings", true},
+		{"team/widgets/hepc.yml", false},
+		{"team/widgets/db-go/hepc.yml", true},
+		{"octo/hello.zip/codeql-database.yml", false},
+		{"octo/other.zip", false},
+		{"octo/hello.hepc.yml", false},
+		{"team/widgets-private/secret", false},
+		{"team/widgets/../secret", false},
+		{"../etc/passwd", false},
+		{"/etc/passwd", false},
+		{"", false},
+		{".", false},
+	}
+
+	for _, tt := range tests {
+		if got := idx.Serves(tt.artifactPath); got != tt.want {
+			t.Errorf("Serves(%q) = %v, want %v", tt.artifactPath, got, tt.want)
+		}
+	}
+
+	var nilIdx *Index
+	if nilIdx.Serves("octo/hello.zip") {
+		t.Error("nil Index serves a path")
+	}
+}
+
+func TestArtifactPath(t *testing.T) {
+	tests := []struct {
+		resultURL string
+		want      string
+		wantOK    bool
+	}{
+		{"http://localhost:8080/db/octo/hello.zip", "octo/hello.zip", true},
+		{"http://localhost:8080/db/multi.zip?language=go", "multi.zip", true},
+		{"http://localhost:8080/index", "", false},
+		{"http://localhost:8080/db/", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ArtifactPath(tt.resultURL)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("ArtifactPath(%q) = %q, %v; want %q, %v", tt.resultURL, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -3,14 +3,13 @@ package local
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
 	"mime"
 	"os"
+	"path"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
@@ -21,17 +20,31 @@ import (
 
 // Backend implements storage.Backend for local filesystem storage.
 type Backend struct {
-	basePath    string
-	endpointURL string
+	basePath       string
+	realBasePath   string
+	symlinkTargets []string
+	endpointURL    string
+	discovery      codeql.Options
 
 	// Cache for discovered databases
 	mu             sync.RWMutex
 	cachedMetadata []api.DatabaseMetadata
+	cachedIndex    *storage.Index
 	cacheTime      time.Time
 	cacheTTL       time.Duration
-	discoveredDBs  map[string]*codeql.DiscoveredDatabase // keyed by content hash or path
+	discoveredDBs  map[string]*codeql.DiscoveredDatabase // keyed by advertised content hash
+	quarantine     []codeql.QuarantinedDatabase
+	refreshLog     storage.RefreshLog
+
+	// Persisted metadata, and the background rediscovery after restoring it
+	snapshots   *storage.SnapshotStore
+	snapshotSeq uint64
+	reconciled  chan struct{}
 }
 
+// hashCacheFileName is the name of the hash cache in a state directory.
+const hashCacheFileName = "hash-cache.json"
+
 // Config holds configuration for the local storage backend.
 type Config struct {
 	// BasePath is the directory containing CodeQL databases.
@@ -42,6 +55,31 @@ type Config struct {
 
 	// CacheTTL is how long to cache discovered metadata (default: 5 minutes).
 	CacheTTL time.Duration
+
+	// IdentityRules map database paths or file names to repositories when no
+	// sidecar file or git remote identifies them.
+	IdentityRules []codeql.IdentityRule
+
+	// Validation selects the checks databases must pass to be advertised
+	// (default: codeql.ValidateBasic).
+	Validation codeql.Validation
+
+	// SymlinkTargets are directories outside BasePath that symlinks under it
+	// may point to. Files reached through any other symlink leaving BasePath
+	// are refused.
+	SymlinkTargets []string
+
+	// HashCacheFile persists the content hashes of unarchived databases
+	// across restarts, so their files are only read again when they change.
+	// If empty, hashes are persisted to hash-cache.json in StateDir, or
+	// cached in memory only without a StateDir.
+	HashCacheFile string
+
+	// StateDir is a directory for state kept across restarts. The metadata
+	// of each discovery is persisted there, and served on startup while the
+	// databases are rediscovered in the background. If empty, nothing is
+	// persisted and the first request waits for discovery.
+	StateDir string
 }
 
 // New creates a new local filesystem storage backend.
@@ -62,6 +100,21 @@ func New(cfg Config) (*Backend, error) {
 		return nil, fmt.Errorf("local storage: not a directory: %s", cfg.BasePath)
 	}
 
+	// Symlinks are resolved before containment is checked, so compare
+	// against the real locations
+	realBasePath, err := realDir(cfg.BasePath)
+	if err != nil {
+		return nil, fmt.Errorf("local storage: cannot resolve directory: %w", err)
+	}
+	symlinkTargets := make([]string, 0, len(cfg.SymlinkTargets))
+	for _, dir := range cfg.SymlinkTargets {
+		target, err := realDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("local storage: cannot resolve symlink target: %w", err)
+		}
+		symlinkTargets = append(symlinkTargets, target)
+	}
+
 	endpointURL := cfg.EndpointURL
 	if endpointURL == "" {
 		endpointURL = "http://localhost:8080"
@@ -72,12 +125,62 @@ func New(cfg Config) (*Backend, error) {
 		cacheTTL = 5 * time.Minute
 	}
 
-	return &Backend{
-		basePath:      cfg.BasePath,
-		endpointURL:   endpointURL,
-		cacheTTL:      cacheTTL,
-		discoveredDBs: make(map[string]*codeql.DiscoveredDatabase),
-	}, nil
+	var snapshots *storage.SnapshotStore
+	hashCacheFile := cfg.HashCacheFile
+	if cfg.StateDir != "" {
+		if err := os.MkdirAll(cfg.StateDir, 0o750); err != nil {
+			return nil, fmt.Errorf("local storage: failed to create state directory: %w", err)
+		}
+		snapshots = storage.NewSnapshotStore(filepath.Join(cfg.StateDir, storage.SnapshotFileName),
+			append([]string{"local", realBasePath, endpointURL}, storage.DiscoverySettings(cfg.Validation, cfg.IdentityRules)...)...)
+		if hashCacheFile == "" {
+			hashCacheFile = filepath.Join(cfg.StateDir, hashCacheFileName)
+		}
+	}
+
+	hashCache, err := codeql.NewHashCache(hashCacheFile)
+	if err != nil {
+		return nil, fmt.Errorf("local storage: %w", err)
+	}
+
+	b := &Backend{
+		basePath:       cfg.BasePath,
+		realBasePath:   realBasePath,
+		symlinkTargets: symlinkTargets,
+		endpointURL:    endpointURL,
+		discovery:      codeql.Options{IdentityRules: cfg.IdentityRules, Validation: cfg.Validation, HashCache: hashCache},
+		cacheTTL:       cacheTTL,
+		discoveredDBs:  make(map[string]*codeql.DiscoveredDatabase),
+		snapshots:      snapshots,
+	}
+	b.restore()
+	return b, nil
+}
+
+// restore fills the cache from the persisted snapshot, if there is one, and
+// rediscovers the databases in the background to bring it up to date.
+func (b *Backend) restore() {
+	snapshot, err := b.snapshots.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring metadata snapshot: %v\n", err)
+		return
+	}
+	if snapshot == nil {
+		return
+	}
+
+	b.cachedMetadata = api.ToV1(snapshot.Records)
+	b.cachedIndex = storage.NewIndex(snapshot.Records)
+	b.cacheTime = time.Now()
+	b.quarantine = snapshot.Quarantine
+
+	b.reconciled = make(chan struct{})
+	go func() {
+		defer close(b.reconciled)
+		if _, err := b.refresh(""); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: serving metadata snapshot from %s: %v\n", snapshot.SavedAt.Format(time.RFC3339), err)
+		}
+	}()
 }
 
 // Type returns the storage backend type identifier.
@@ -97,125 +200,124 @@ func (b *Backend) ListMetadata(ctx context.Context) ([]api.DatabaseMetadata, err
 	}
 	b.mu.RUnlock()
 
+	return b.refresh("")
+}
+
+// refresh rediscovers the databases under prefix, or all databases if
+// prefix is empty, and updates the cache, keeping the cached databases
+// outside prefix. It returns the metadata of all cached databases.
+func (b *Backend) refresh(prefix string) ([]api.DatabaseMetadata, error) {
+	start := time.Now()
+
 	// Discover databases
-	databases, err := codeql.DiscoverDatabases(b.basePath)
+	opts := b.discovery
+	opts.Dir = prefix
+	result, err := codeql.Discover(os.DirFS(b.basePath), opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to discover databases: %w", err)
+		err = fmt.Errorf("failed to discover databases: %w", err)
+		b.refreshLog.Failed(err)
+		return nil, err
+	}
+	// Forget the hashes of directories under prefix no longer discovered
+	hashed := make(map[string]bool)
+	for _, db := range result.Databases {
+		if !db.IsArchived {
+			hashed[db.RelPath] = true
+		}
+	}
+	opts.HashCache.Prune(func(dbPath string) bool {
+		return hashed[dbPath] || !storage.InPrefix(dbPath, prefix)
+	})
+	if err := opts.HashCache.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 	}
 
 	// Convert to API metadata format
-	var metadata []api.DatabaseMetadata
+	var records []api.DatabaseMetadataV2
 	discoveredMap := make(map[string]*codeql.DiscoveredDatabase)
+	quarantine := result.Quarantined
 
-	for _, db := range databases {
-		m := b.convertToMetadata(db)
-		metadata = append(metadata, m)
+	for _, db := range result.Databases {
+		db.Path = filepath.Join(b.basePath, filepath.FromSlash(db.RelPath))
+		m := codeql.BuildMetadataV2(db, b.endpointURL)
+		records = append(records, m)
 
-		// Index by content hash for archived, by path for unarchived
-		if db.ContentHash != "" {
-			discoveredMap[db.ContentHash] = db
-		} else {
-			discoveredMap[db.Path] = db
+		// Index by the advertised content hash, which is unique per language
+		discoveredMap[m.ContentHash] = db
+	}
+
+	b.mu.Lock()
+	cacheTime := time.Now()
+	if prefix != "" {
+		if b.cachedIndex == nil {
+			// Invalidated meanwhile; the next request rediscovers everything
+			b.mu.Unlock()
+			return nil, nil
 		}
+		records = storage.MergeRecords(b.cachedIndex.Records(), records, prefix)
+		quarantine = storage.MergeQuarantine(b.quarantine, quarantine, prefix)
+		for _, m := range records {
+			if _, ok := discoveredMap[m.ContentHash]; !ok {
+				discoveredMap[m.ContentHash] = b.discoveredDBs[m.ContentHash]
+			}
+		}
+		// Databases outside prefix expire as they would have
+		cacheTime = b.cacheTime
 	}
+	metadata := api.ToV1(records)
 
 	// Update cache
-	b.mu.Lock()
 	b.cachedMetadata = metadata
-	b.cacheTime = time.Now()
+	b.cachedIndex = storage.NewIndex(records)
+	b.cacheTime = cacheTime
 	b.discoveredDBs = discoveredMap
+	b.quarantine = quarantine
+	b.refreshLog.Succeeded(start)
+	b.snapshotSeq++
+	seq := b.snapshotSeq
 	b.mu.Unlock()
 
+	if err := b.snapshots.Save(seq, records, quarantine); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
 	return metadata, nil
 }
 
-// convertToMetadata converts a discovered database to API metadata format.
-func (b *Backend) convertToMetadata(db *codeql.DiscoveredDatabase) api.DatabaseMetadata {
-	// Generate content hash for unarchived databases
-	contentHash := db.ContentHash
-	if contentHash == "" {
-		// For unarchived databases, create a hash from the path
-		h := sha256.Sum256([]byte(db.Path))
-		contentHash = hex.EncodeToString(h[:])
-	}
-
-	// Build CID from available metadata
-	buildCID := ""
-	creationTime := ""
-	cliVersion := ""
-	sourceSHA := ""
-
-	if db.CreationMetadata != nil {
-		cliVersion = db.CreationMetadata.CLIVersion
-		creationTime = db.CreationMetadata.CreationTime
-		sourceSHA = db.CreationMetadata.SHA
-		buildCID = generateBuildCID(cliVersion, creationTime, db.Language, sourceSHA)
-	} else {
-		// Generate a build CID from available info
-		buildCID = contentHash[:10]
-	}
-
-	// Construct the result URL
-	relPath, err := filepath.Rel(b.basePath, db.Path)
-	if err != nil {
-		// Fallback to base name if relative path fails
-		relPath = filepath.Base(db.Path)
-	}
-	resultURL := fmt.Sprintf("%s/db/%s", strings.TrimSuffix(b.endpointURL, "/"), relPath)
-
-	// Determine tool name and tool ID
-	toolName := "codeql"
-	toolID := "codeql"
-	if db.Language != "" && db.Language != "unknown" {
-		toolName = fmt.Sprintf("codeql-%s", db.Language)
-		toolID = toolName // tool_id matches tool_name format
-	}
-
-	return api.DatabaseMetadata{
-		ContentHash:          contentHash,
-		BuildCID:             buildCID,
-		GitBranch:            "HEAD",
-		GitCommitID:          sourceSHA,
-		GitOwner:             db.Owner,
-		GitRepo:              db.Repo,
-		IngestionDatetimeUTC: creationTime,
-		PrimaryLanguage:      db.Language,
-		ResultURL:            resultURL,
-		ToolID:               toolID,
-		ToolName:             toolName,
-		ToolVersion:          cliVersion,
-		Projname:             fmt.Sprintf("%s/%s", db.Owner, db.Repo),
-		DBFileSize:           db.FileSize,
+// Index returns a lookup index over the current metadata, refreshing it
+// first if the cache has expired.
+func (b *Backend) Index(ctx context.Context) (*storage.Index, error) {
+	b.mu.RLock()
+	if b.cachedIndex != nil && time.Since(b.cacheTime) < b.cacheTTL {
+		idx := b.cachedIndex
+		b.mu.RUnlock()
+		return idx, nil
 	}
-}
+	b.mu.RUnlock()
 
-// generateBuildCID creates a build context identifier.
-func generateBuildCID(cliVersion, creationTime, language, sourceSHA string) string {
-	s := fmt.Sprintf("%s %s %s %s", cliVersion, creationTime, language, sourceSHA)
-	h := sha256.Sum256([]byte(s))
-	return hex.EncodeToString(h[:])[:10]
+	if _, err := b.ListMetadata(ctx); err != nil {
+		return nil, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.cachedIndex, nil
 }
 
-// GetFile retrieves a database file by path from the local filesystem.
+// GetFile retrieves a database file by path from the local filesystem. The
+// path is relative to the base path, as in a result URL.
 func (b *Backend) GetFile(ctx context.Context, filename string) (io.ReadCloser, int64, string, error) {
-	// Resolve the path - it could be a relative path from the result URL
-	var fullPath string
-	if filepath.IsAbs(filename) {
-		fullPath = filename
-	} else {
-		fullPath = filepath.Join(b.basePath, filename)
-	}
-
-	// Security: ensure the path is within the base path
-	fullPath = filepath.Clean(fullPath)
-	if !strings.HasPrefix(fullPath, filepath.Clean(b.basePath)) {
-		return nil, 0, "", fmt.Errorf("access denied: path outside base directory")
+	fullPath, err := b.resolve(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, "", &storage.ErrNotFound{Path: filename}
+		}
+		return nil, 0, "", err
 	}
 
 	info, err := os.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, 0, "", &storage.ErrNotFound{Path: fullPath}
+			return nil, 0, "", &storage.ErrNotFound{Path: filename}
 		}
 		return nil, 0, "", fmt.Errorf("error accessing file: %w", err)
 	}
@@ -230,26 +332,31 @@ func (b *Backend) GetFile(ctx context.Context, filename string) (io.ReadCloser,
 	}
 
 	// Determine content type from extension
-	contentType := mime.TypeByExtension(filepath.Ext(fullPath))
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
 
-	return file, info.Size(), contentType, nil
+	return &modTimeFile{File: file, modTime: info.ModTime()}, info.Size(), contentType, nil
+}
+
+// modTimeFile is an open file that reports its modification time.
+type modTimeFile struct {
+	*os.File
+	modTime time.Time
 }
 
+// ModTime implements storage.ModTimer.
+func (f *modTimeFile) ModTime() time.Time { return f.modTime }
+
 // FileExists checks if a file exists in the local filesystem.
 func (b *Backend) FileExists(ctx context.Context, filename string) (bool, error) {
-	var fullPath string
-	if filepath.IsAbs(filename) {
-		fullPath = filename
-	} else {
-		fullPath = filepath.Join(b.basePath, filename)
-	}
-
-	fullPath = filepath.Clean(fullPath)
-	if !strings.HasPrefix(fullPath, filepath.Clean(b.basePath)) {
-		return false, fmt.Errorf("access denied: path outside base directory")
+	fullPath, err := b.resolve(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
 	}
 
 	info, err := os.Stat(fullPath)
@@ -262,17 +369,270 @@ func (b *Backend) FileExists(ctx context.Context, filename string) (bool, error)
 	return !info.IsDir(), nil
 }
 
+// ListMembers lists the files of the archive or database directory at
+// dbPath. Archives are listed from their zip directory; directories are
+// walked without following symlinks, leaving out the sidecar file.
+func (b *Backend) ListMembers(ctx context.Context, dbPath string) ([]storage.Member, error) {
+	fullPath, info, err := b.resolveDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		file, err := os.Open(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer func() {
+			_ = file.Close() //nolint:errcheck // Best effort close in defer
+		}()
+		return storage.ZipMembers(file, info.Size())
+	}
+
+	var members []storage.Member
+	err = filepath.WalkDir(fullPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(fullPath, p)
+		if err != nil {
+			return err
+		}
+		if rel == codeql.SidecarFileName {
+			return nil
+		}
+		members = append(members, storage.Member{Path: filepath.ToSlash(rel), Size: fi.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dbPath, err)
+	}
+	return members, nil
+}
+
+// GetMember returns one file of the archive or database directory at
+// dbPath. Files of directories are resolved like GetFile, so symlinks
+// leaving the base path are refused; their sidecar file is not found.
+func (b *Backend) GetMember(ctx context.Context, dbPath, member string) (io.ReadCloser, int64, error) {
+	if !storage.ValidMember(member) {
+		return nil, 0, fmt.Errorf("invalid member %q", member)
+	}
+	fullPath, info, err := b.resolveDatabase(dbPath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if info.IsDir() {
+		if member == codeql.SidecarFileName {
+			return nil, 0, &storage.ErrNotFound{Path: path.Join(dbPath, member)}
+		}
+		reader, size, _, err := b.GetFile(ctx, path.Join(dbPath, member))
+		return reader, size, err
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	reader, size, err := storage.OpenZipMember(file, info.Size(), member)
+	if err != nil {
+		_ = file.Close() //nolint:errcheck // The open error takes precedence
+		return nil, 0, err
+	}
+	return &memberReader{ReadCloser: reader, file: file}, size, nil
+}
+
+// memberReader closes the archive along with the entry read from it.
+type memberReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r *memberReader) Close() error {
+	err := r.ReadCloser.Close()
+	if fileErr := r.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// resolveDatabase resolves the database at dbPath like GetFile and returns
+// its real path and file information.
+func (b *Backend) resolveDatabase(dbPath string) (string, os.FileInfo, error) {
+	fullPath, err := b.resolve(dbPath)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(fullPath); err == nil {
+			return fullPath, info, nil
+		}
+	}
+	if os.IsNotExist(err) {
+		return "", nil, &storage.ErrNotFound{Path: dbPath}
+	}
+	return "", nil, err
+}
+
+// resolve returns the real path of filename, a slash-separated path relative
+// to the base path. Absolute paths and paths leaving the base path are
+// refused, as are symlinks resolving outside it other than into one of the
+// allowed symlink targets. Errors for missing files satisfy os.IsNotExist.
+func (b *Backend) resolve(filename string) (string, error) {
+	name := filepath.FromSlash(filename)
+	if filepath.IsAbs(name) || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("access denied: path outside base directory: %q", filename)
+	}
+
+	realPath, err := filepath.EvalSymlinks(filepath.Join(b.realBasePath, name))
+	if err != nil {
+		return "", err
+	}
+	if within(b.realBasePath, realPath) {
+		return realPath, nil
+	}
+	for _, dir := range b.symlinkTargets {
+		if within(dir, realPath) {
+			return realPath, nil
+		}
+	}
+	return "", fmt.Errorf("access denied: %q is a symlink outside base directory", filename)
+}
+
+// realDir returns the absolute path of dir with all symlinks resolved.
+func realDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// within reports whether the path p is the directory dir or inside it,
+// comparing whole path components, so /data-private is not within /data.
+func within(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// PutFile stores the content of r under filename. The content is written to
+// a hidden temporary file in the target directory and renamed into place, so
+// discovery never sees a partial database.
+func (b *Backend) PutFile(ctx context.Context, filename string, r io.Reader) error {
+	fullPath, err := b.writablePath(filename)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil { //nolint:gosec // Databases are served to clients
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fullPath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name()) //nolint:errcheck // Already renamed on success
+	}()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close() //nolint:errcheck // The copy error takes precedence
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil { //nolint:gosec // Databases are served to clients
+		return fmt.Errorf("failed to set permissions of %s: %w", filename, err)
+	}
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", filename, err)
+	}
+	return nil
+}
+
+// DeleteFile removes a file from the local filesystem.
+func (b *Backend) DeleteFile(ctx context.Context, filename string) error {
+	fullPath, err := b.writablePath(filename)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete %s: %w", filename, err)
+	}
+	return nil
+}
+
+// writablePath resolves filename, a slash-separated path relative to the
+// base path, for writing or removing. Like resolve it refuses absolute paths
+// and paths leaving the base path, and it resolves the symlinks of the
+// parent directories, which must lie inside the base path itself: writes
+// never go into the allowed symlink targets. The file itself is replaced or
+// removed, not followed, if it is a symlink.
+func (b *Backend) writablePath(filename string) (string, error) {
+	name := filepath.FromSlash(filename)
+	if filepath.IsAbs(name) || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("access denied: path outside base directory: %q", filename)
+	}
+	name = filepath.Clean(name)
+	if name == "." {
+		return "", fmt.Errorf("invalid file name %q", filename)
+	}
+
+	// Resolve the deepest parent directory that exists; PutFile creates the
+	// ones below it
+	dir, rest := filepath.Join(b.realBasePath, filepath.Dir(name)), filepath.Base(name)
+	for {
+		realPath, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			if !within(b.realBasePath, realPath) {
+				return "", fmt.Errorf("access denied: %q is below a symlink outside base directory", filename)
+			}
+			return filepath.Join(realPath, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = filepath.Dir(dir)
+	}
+}
+
 // MetadataExists always returns true for local storage since we discover dynamically.
 func (b *Backend) MetadataExists(ctx context.Context) (bool, error) {
 	// We can always discover databases dynamically
 	return true, nil
 }
 
+// Probe checks that the base directory is still accessible.
+func (b *Backend) Probe(ctx context.Context) error {
+	info, err := os.Stat(b.basePath)
+	if err != nil {
+		return fmt.Errorf("local storage: cannot access directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("local storage: not a directory: %s", b.basePath)
+	}
+	return nil
+}
+
 // Close releases any resources held by the backend.
 func (b *Backend) Close() error {
+	if b.reconciled != nil {
+		<-b.reconciled
+	}
+
 	// Clear the cache
 	b.mu.Lock()
 	b.cachedMetadata = nil
+	b.cachedIndex = nil
 	b.discoveredDBs = nil
 	b.mu.Unlock()
 	return nil
@@ -287,6 +647,45 @@ func (b *Backend) BasePath() string {
 func (b *Backend) InvalidateCache() {
 	b.mu.Lock()
 	b.cachedMetadata = nil
+	b.cachedIndex = nil
 	b.cacheTime = time.Time{}
 	b.mu.Unlock()
 }
+
+// CacheStats describes the discovered databases cache.
+func (b *Backend) CacheStats() storage.CacheStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entries := 0
+	if b.cachedIndex != nil {
+		entries = b.cachedIndex.Len()
+	}
+	return b.refreshLog.Stats(entries, b.cacheTime, b.cacheTTL)
+}
+
+// Quarantine returns the databases that failed validation in the last
+// discovery, with paths relative to the base path.
+func (b *Backend) Quarantine() []codeql.QuarantinedDatabase {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]codeql.QuarantinedDatabase(nil), b.quarantine...)
+}
+
+// Reindex rediscovers the databases under prefix, a directory relative to
+// the base path, or all databases if prefix is empty. An empty cache is
+// always rediscovered in full.
+func (b *Backend) Reindex(ctx context.Context, prefix string) error {
+	prefix, err := storage.CleanPrefix(prefix)
+	if err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	if b.cachedIndex == nil {
+		prefix = ""
+	}
+	b.mu.RUnlock()
+
+	_, err = b.refresh(prefix)
+	return err
+}
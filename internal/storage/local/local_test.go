@@ -2,16 +2,22 @@
 package local
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/data-douser/mrva-go-hepc/api"
-	"github.com/data-douser/mrva-go-hepc/internal/codeql"
 	"github.com/data-douser/mrva-go-hepc/internal/storage"
 )
 
@@ -159,6 +165,26 @@ func TestBackend_MetadataExists(t *testing.T) {
 	}
 }
 
+func TestBackend_Probe(t *testing.T) {
+	tempDir := t.TempDir()
+	backend, err := New(Config{BasePath: tempDir})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	if err := backend.Probe(context.Background()); err != nil {
+		t.Errorf("Probe() error = %v", err)
+	}
+
+	if err := os.RemoveAll(tempDir); err != nil {
+		t.Fatalf("Failed to remove temp dir: %v", err)
+	}
+	if err := backend.Probe(context.Background()); err == nil {
+		t.Error("Probe() of removed directory expected error, got nil")
+	}
+}
+
 func TestBackend_ListMetadata_EmptyDirectory(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "local-list-empty-*")
 	if err != nil {
@@ -256,6 +282,54 @@ creationMetadata:
 	}
 }
 
+func TestBackend_ListMetadata_MultiLanguage(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// A database holding two languages yields one record per language
+	dbDir := filepath.Join(tempDir, "multi-db")
+	for _, lang := range []string{"db-go", "db-python"} {
+		if err := os.MkdirAll(filepath.Join(dbDir, lang), 0o755); err != nil {
+			t.Fatalf("Failed to create db directory: %v", err)
+		}
+	}
+	yamlContent := `sourceLocationPrefix: /Users/test/src/owner/repo
+primaryLanguage: go
+`
+	if err := os.WriteFile(filepath.Join(dbDir, "codeql-database.yml"), []byte(yamlContent), 0o644); err != nil {
+		t.Fatalf("Failed to write codeql-database.yml: %v", err)
+	}
+
+	backend, err := New(Config{BasePath: tempDir, EndpointURL: "http://localhost:8080"})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	metadata, err := backend.ListMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if len(metadata) != 2 {
+		t.Fatalf("ListMetadata() returned %d items, want 2", len(metadata))
+	}
+
+	want := map[string]string{
+		"go":     "http://localhost:8080/db/multi-db?language=go",
+		"python": "http://localhost:8080/db/multi-db?language=python",
+	}
+	if metadata[0].ContentHash == metadata[1].ContentHash {
+		t.Error("per-language records must have distinct content hashes")
+	}
+	for _, m := range metadata {
+		if m.ResultURL != want[m.PrimaryLanguage] {
+			t.Errorf("ResultURL for %s = %q, want %q", m.PrimaryLanguage, m.ResultURL, want[m.PrimaryLanguage])
+		}
+		if m.ToolName != "codeql-"+m.PrimaryLanguage {
+			t.Errorf("ToolName = %q, want %q", m.ToolName, "codeql-"+m.PrimaryLanguage)
+		}
+	}
+}
+
 func TestBackend_ListMetadata_Caching(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "local-cache-test-*")
 	if err != nil {
@@ -371,6 +445,348 @@ primaryLanguage: python
 	}
 }
 
+// writeTestDatabase creates an unarchived Go database of owner/repo at dir.
+func writeTestDatabase(t *testing.T, dir, ownerRepo string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Join(dir, "db-go"), 0o755); err != nil {
+		t.Fatalf("Failed to create db directory: %v", err)
+	}
+	yamlContent := "sourceLocationPrefix: /src/" + ownerRepo + "\nprimaryLanguage: go\n"
+	if err := os.WriteFile(filepath.Join(dir, "codeql-database.yml"), []byte(yamlContent), 0o644); err != nil {
+		t.Fatalf("Failed to write codeql-database.yml: %v", err)
+	}
+}
+
+// projects returns the sorted project names of the backend's records.
+func projects(t *testing.T, backend *Backend) []string {
+	t.Helper()
+
+	metadata, err := backend.ListMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	var names []string
+	for _, m := range metadata {
+		names = append(names, m.Projname)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBackend_Reindex(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestDatabase(t, filepath.Join(tempDir, "team-a", "one"), "a/one")
+	writeTestDatabase(t, filepath.Join(tempDir, "team-b", "two"), "b/two")
+
+	backend, err := New(Config{BasePath: tempDir, CacheTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+	ctx := context.Background()
+
+	if got, want := projects(t, backend), []string{"a/one", "b/two"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("projects = %v, want %v", got, want)
+	}
+	refreshedAt := backend.CacheStats().RefreshedAt
+
+	// Change both directories, then reindex only one of them
+	writeTestDatabase(t, filepath.Join(tempDir, "team-a", "three"), "a/three")
+	if err := os.RemoveAll(filepath.Join(tempDir, "team-b")); err != nil {
+		t.Fatalf("Failed to remove database: %v", err)
+	}
+	if err := backend.Reindex(ctx, "/team-a/"); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	if got, want := projects(t, backend), []string{"a/one", "a/three", "b/two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after scoped reindex projects = %v, want %v", got, want)
+	}
+	if idx, _ := backend.Index(ctx); len(idx.ByProject("a", "three")) != 1 {
+		t.Error("Index() does not contain the reindexed database")
+	}
+	if got := backend.CacheStats().RefreshedAt; !got.Equal(refreshedAt) {
+		t.Errorf("scoped reindex moved RefreshedAt from %v to %v", refreshedAt, got)
+	}
+
+	// A prefix that no longer exists drops its databases
+	if err := backend.Reindex(ctx, "team-b"); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	if got, want := projects(t, backend), []string{"a/one", "a/three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after reindex of removed prefix projects = %v, want %v", got, want)
+	}
+
+	if err := backend.Reindex(ctx, "../outside"); err == nil {
+		t.Error("Reindex() expected error for escaping prefix, got nil")
+	}
+
+	stats := backend.CacheStats()
+	if stats.Entries != 2 || stats.TTL != time.Hour || stats.RefreshedAt.IsZero() || len(stats.Errors) != 0 {
+		t.Errorf("CacheStats() = %+v", stats)
+	}
+	backend.InvalidateCache()
+	if stats := backend.CacheStats(); stats.Entries != 0 || !stats.RefreshedAt.IsZero() {
+		t.Errorf("CacheStats() after InvalidateCache = %+v, want empty", stats)
+	}
+
+	// With an empty cache a scoped reindex rediscovers everything
+	writeTestDatabase(t, filepath.Join(tempDir, "team-b", "four"), "b/four")
+	if err := backend.Reindex(ctx, "team-a"); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	if got := backend.CacheStats().Entries; got != 3 {
+		t.Errorf("Entries after reindex of empty cache = %d, want 3", got)
+	}
+}
+
+func TestBackend_Quarantine(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestDatabase(t, filepath.Join(tempDir, "team-a", "good"), "a/good")
+	writeTestDatabase(t, filepath.Join(tempDir, "team-b", "good"), "b/good")
+	unfinalised := func(dir string) {
+		writeTestDatabase(t, dir, "x/unfinalised")
+		content := "primaryLanguage: go\nfinalised: false\n"
+		if err := os.WriteFile(filepath.Join(dir, "codeql-database.yml"), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write codeql-database.yml: %v", err)
+		}
+	}
+	unfinalised(filepath.Join(tempDir, "team-a", "bad"))
+	unfinalised(filepath.Join(tempDir, "team-b", "bad"))
+
+	backend, err := New(Config{BasePath: tempDir, CacheTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	if got, want := projects(t, backend), []string{"a/good", "b/good"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("projects = %v, want %v", got, want)
+	}
+	quarantined := func() []string {
+		var paths []string
+		for _, q := range backend.Quarantine() {
+			paths = append(paths, q.RelPath)
+		}
+		return paths
+	}
+	if got, want := quarantined(), []string{"team-a/bad", "team-b/bad"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Quarantine() = %v, want %v", got, want)
+	}
+	if problems := backend.Quarantine()[0].Problems; len(problems) != 1 || problems[0] != "database is not finalised" {
+		t.Errorf("Problems = %q", problems)
+	}
+
+	// A scoped reindex replaces only the quarantine entries under its prefix
+	if err := os.RemoveAll(filepath.Join(tempDir, "team-a", "bad")); err != nil {
+		t.Fatalf("Failed to remove database: %v", err)
+	}
+	if err := backend.Reindex(context.Background(), "team-a"); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	if got, want := quarantined(), []string{"team-b/bad"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Quarantine() after reindex = %v, want %v", got, want)
+	}
+}
+
+func TestBackend_Index(t *testing.T) {
+	tempDir := t.TempDir()
+
+	dbDir := filepath.Join(tempDir, "test-db")
+	if err := os.MkdirAll(filepath.Join(dbDir, "db-go"), 0o755); err != nil {
+		t.Fatalf("Failed to create db directory: %v", err)
+	}
+	yamlContent := `sourceLocationPrefix: /src/owner/repo
+primaryLanguage: go
+`
+	if err := os.WriteFile(filepath.Join(dbDir, "codeql-database.yml"), []byte(yamlContent), 0o644); err != nil {
+		t.Fatalf("Failed to write codeql-database.yml: %v", err)
+	}
+
+	backend, err := New(Config{BasePath: tempDir})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	// Index refreshes the metadata itself when the cache is empty
+	idx, err := backend.Index(context.Background())
+	if err != nil {
+		t.Fatalf("Index() error = %v", err)
+	}
+	records := idx.ByProject("owner", "repo")
+	if len(records) != 1 {
+		t.Fatalf("ByProject(owner, repo) returned %d records, want 1", len(records))
+	}
+	if m, ok := idx.ByHash(records[0].ContentHash); !ok || m.PrimaryLanguage != "go" {
+		t.Errorf("ByHash(%q) = %+v, %v; want go database", records[0].ContentHash, m, ok)
+	}
+	if got := idx.ByPath("test-db"); len(got) != 1 {
+		t.Errorf("ByPath(test-db) returned %d records, want 1", len(got))
+	}
+}
+
+func TestBackend_ContentHash(t *testing.T) {
+	tempDir := t.TempDir()
+	cacheFile := filepath.Join(t.TempDir(), "hashes.json")
+	writeTestDatabase(t, filepath.Join(tempDir, "team", "widgets"), "octo/widgets")
+
+	hashOf := func() api.DatabaseMetadataV2 {
+		t.Helper()
+		backend, err := New(Config{BasePath: tempDir, HashCacheFile: cacheFile})
+		if err != nil {
+			t.Fatalf("Failed to create backend: %v", err)
+		}
+		defer backend.Close()
+		idx, err := backend.Index(context.Background())
+		if err != nil {
+			t.Fatalf("Index() error = %v", err)
+		}
+		records := idx.ByProject("octo", "widgets")
+		if len(records) != 1 {
+			t.Fatalf("ByProject(octo, widgets) returned %d records, want 1", len(records))
+		}
+		return records[0]
+	}
+
+	first := hashOf()
+	if first.HashKind != api.HashKindMerkle {
+		t.Errorf("HashKind = %q, want %q", first.HashKind, api.HashKindMerkle)
+	}
+	if _, err := os.Stat(cacheFile); err != nil {
+		t.Errorf("hash cache not persisted: %v", err)
+	}
+
+	// Moving a database keeps its identity
+	if err := os.Rename(filepath.Join(tempDir, "team"), filepath.Join(tempDir, "moved")); err != nil {
+		t.Fatalf("Failed to move database: %v", err)
+	}
+	if moved := hashOf(); moved.ContentHash != first.ContentHash {
+		t.Errorf("ContentHash after move = %q, want %q", moved.ContentHash, first.ContentHash)
+	}
+
+	// Modifying it changes its identity
+	if err := os.WriteFile(filepath.Join(tempDir, "moved", "widgets", "db-go", "strings"), []byte("new"), 0o644); err != nil {
+		t.Fatalf("Failed to modify database: %v", err)
+	}
+	if modified := hashOf(); modified.ContentHash == first.ContentHash {
+		t.Error("ContentHash unchanged after modifying the database")
+	}
+}
+
+func TestBackend_HashCacheInStateDir(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := filepath.Join(t.TempDir(), "state")
+	for _, p := range []string{"team-a/one", "team-a/two", "team-b/three"} {
+		writeTestDatabase(t, filepath.Join(tempDir, filepath.FromSlash(p)), "octo/"+path.Base(p))
+	}
+	ctx := context.Background()
+
+	backend, err := New(Config{BasePath: tempDir, StateDir: stateDir})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	cached := func() []string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(stateDir, hashCacheFileName))
+		if err != nil {
+			t.Fatalf("hash cache not persisted: %v", err)
+		}
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(data, &entries); err != nil {
+			t.Fatalf("failed to parse hash cache: %v", err)
+		}
+		var paths []string
+		for p := range entries {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	if _, err := backend.ListMetadata(ctx); err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if got, want := cached(), []string{"team-a/one", "team-a/two", "team-b/three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cached paths = %q, want %q", got, want)
+	}
+
+	// Reindexing a prefix forgets the removed databases under it only
+	for _, p := range []string{"team-a/two", "team-b/three"} {
+		if err := os.RemoveAll(filepath.Join(tempDir, filepath.FromSlash(p))); err != nil {
+			t.Fatalf("Failed to remove database: %v", err)
+		}
+	}
+	if err := backend.Reindex(ctx, "team-a"); err != nil {
+		t.Fatalf("Reindex() error = %v", err)
+	}
+	if got, want := cached(), []string{"team-a/one", "team-b/three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cached paths after reindexing team-a = %q, want %q", got, want)
+	}
+
+	// A full rediscovery forgets all of them
+	backend.InvalidateCache()
+	if _, err := backend.ListMetadata(ctx); err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	if got, want := cached(), []string{"team-a/one"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cached paths after rediscovery = %q, want %q", got, want)
+	}
+}
+
+func TestNew_InvalidHashCache(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "hashes.json")
+	if err := os.WriteFile(cacheFile, []byte("not json"), 0o644); err != nil {
+		t.Fatalf("Failed to write hash cache: %v", err)
+	}
+	if _, err := New(Config{BasePath: t.TempDir(), HashCacheFile: cacheFile}); err == nil {
+		t.Error("New() error = nil, want error for invalid hash cache")
+	}
+}
+
+func TestBackend_Snapshot(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := filepath.Join(t.TempDir(), "state")
+	writeTestDatabase(t, filepath.Join(tempDir, "widgets"), "octo/widgets")
+
+	first, err := New(Config{BasePath: tempDir, StateDir: stateDir})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	if _, err := first.ListMetadata(context.Background()); err != nil {
+		t.Fatalf("ListMetadata() error = %v", err)
+	}
+	first.Close()
+
+	// A restarted backend serves the snapshot before rediscovering, and
+	// then picks up the database added meanwhile
+	writeTestDatabase(t, filepath.Join(tempDir, "gadgets"), "octo/gadgets")
+	restarted, err := New(Config{BasePath: tempDir, StateDir: stateDir})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer restarted.Close()
+	if stats := restarted.CacheStats(); stats.Entries != 1 || stats.RefreshedAt.IsZero() {
+		t.Errorf("CacheStats() after restart = %+v, want the snapshot's 1 entry", stats)
+	}
+	<-restarted.reconciled
+	if stats := restarted.CacheStats(); stats.Entries != 2 {
+		t.Errorf("CacheStats() after reconciling = %+v, want 2 entries", stats)
+	}
+
+	// A snapshot taken for another endpoint is not served
+	other, err := New(Config{BasePath: tempDir, StateDir: stateDir, EndpointURL: "https://hepc.example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer other.Close()
+	if stats := other.CacheStats(); stats.Entries != 0 {
+		t.Errorf("CacheStats() with another endpoint = %+v, want empty", stats)
+	}
+}
+
 func TestBackend_GetFile(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "local-getfile-test-*")
 	if err != nil {
@@ -405,6 +821,18 @@ func TestBackend_GetFile(t *testing.T) {
 		t.Errorf("contentType = %q, want %q", contentType, "text/plain; charset=utf-8")
 	}
 
+	// The modification time validates resumed downloads
+	info, err := os.Stat(testFile)
+	if err != nil {
+		t.Fatalf("Failed to stat test file: %v", err)
+	}
+	if mt, ok := reader.(storage.ModTimer); !ok || !mt.ModTime().Equal(info.ModTime()) {
+		t.Errorf("reader does not report the modification time %v", info.ModTime())
+	}
+	if _, ok := reader.(io.Seeker); !ok {
+		t.Error("reader is not seekable")
+	}
+
 	// Read content
 	buf := make([]byte, size)
 	n, err := reader.Read(buf)
@@ -467,6 +895,99 @@ func TestBackend_GetFile_DirectoryTraversal(t *testing.T) {
 	}
 }
 
+func TestBackend_GetFile_Resolution(t *testing.T) {
+	// Layout: root/data is the base path, root/data-private a sibling and
+	// root/shared an allowed symlink target
+	root := t.TempDir()
+	base := filepath.Join(root, "data")
+	for _, dir := range []string{"data/team", "data-private", "shared", "outside"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	for name, content := range map[string]string{
+		"data/team/repo.zip":      "inside",
+		"data-private/secret.zip": "private",
+		"shared/repo.zip":         "shared",
+		"outside/secret.zip":      "outside",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	for link, target := range map[string]string{
+		"data/team/alias.zip":   "repo.zip",
+		"data/team/shared.zip":  filepath.Join(root, "shared/repo.zip"),
+		"data/team/shared":      filepath.Join(root, "shared"),
+		"data/team/escape.zip":  filepath.Join(root, "outside/secret.zip"),
+		"data/team/outside":     filepath.Join(root, "outside"),
+		"data/team/private.zip": "../../data-private/secret.zip",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	backend, err := New(Config{BasePath: base, SymlinkTargets: []string{filepath.Join(root, "shared")}})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	tests := []struct {
+		name         string
+		filename     string
+		wantContent  string
+		wantNotFound bool
+		wantErr      string
+	}{
+		{name: "file", filename: "team/repo.zip", wantContent: "inside"},
+		{name: "symlink inside base path", filename: "team/alias.zip", wantContent: "inside"},
+		{name: "symlink to allowed target", filename: "team/shared.zip", wantContent: "shared"},
+		{name: "through symlinked allowed directory", filename: "team/shared/repo.zip", wantContent: "shared"},
+		{name: "missing file", filename: "team/missing.zip", wantNotFound: true},
+		{name: "absolute path", filename: filepath.Join(base, "team/repo.zip"), wantErr: "access denied"},
+		{name: "sibling directory", filename: "../data-private/secret.zip", wantErr: "access denied"},
+		{name: "dot-dot inside base path", filename: "team/../team/repo.zip", wantContent: "inside"},
+		{name: "symlink to sibling directory", filename: "team/private.zip", wantErr: "access denied"},
+		{name: "symlink outside base path", filename: "team/escape.zip", wantErr: "access denied"},
+		{name: "through symlinked outside directory", filename: "team/outside/secret.zip", wantErr: "access denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, _, _, err := backend.GetFile(context.Background(), tt.filename)
+			var notFound *storage.ErrNotFound
+			switch {
+			case tt.wantNotFound:
+				if !errors.As(err, &notFound) {
+					t.Fatalf("GetFile() error = %v, want ErrNotFound", err)
+				}
+				return
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GetFile() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				if exists, err := backend.FileExists(context.Background(), tt.filename); err == nil || exists {
+					t.Errorf("FileExists() = %v, %v; want an error", exists, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("GetFile() error = %v", err)
+			}
+			defer reader.Close()
+
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+			if string(content) != tt.wantContent {
+				t.Errorf("GetFile() content = %q, want %q", content, tt.wantContent)
+			}
+		})
+	}
+}
+
 func TestBackend_GetFile_Directory(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "local-getfile-dir-*")
 	if err != nil {
@@ -567,6 +1088,148 @@ func TestBackend_FileExists_DirectoryTraversal(t *testing.T) {
 	}
 }
 
+func TestBackend_PutFile(t *testing.T) {
+	tempDir := t.TempDir()
+
+	backend, err := New(Config{BasePath: tempDir})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	ctx := context.Background()
+	if err := backend.PutFile(ctx, "octo/hello.zip", strings.NewReader("first")); err != nil {
+		t.Fatalf("PutFile() error = %v", err)
+	}
+	if err := backend.PutFile(ctx, "octo/hello.zip", strings.NewReader("second")); err != nil {
+		t.Fatalf("PutFile() replace error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "octo", "hello.zip"))
+	if err != nil {
+		t.Fatalf("Failed to read stored file: %v", err)
+	}
+	if string(data) != "second" {
+		t.Errorf("content = %q, want %q", data, "second")
+	}
+
+	entries, err := os.ReadDir(filepath.Join(tempDir, "octo"))
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the stored file", len(entries))
+	}
+
+	hash, err := storage.FileHash(ctx, backend, "octo/hello.zip")
+	if err != nil {
+		t.Fatalf("FileHash() error = %v", err)
+	}
+	// sha256("second")
+	if want := "16367aacb67a4a017c8da8ab95682ccb390863780f7114dda0a0e0c55644c7c4"; hash != want {
+		t.Errorf("FileHash() = %q, want %q", hash, want)
+	}
+}
+
+func TestBackend_PutFile_DirectoryTraversal(t *testing.T) {
+	tempDir := t.TempDir()
+
+	backend, err := New(Config{BasePath: tempDir})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	for _, name := range []string{"../escaped.zip", "/tmp/escaped.zip", "", "."} {
+		if err := backend.PutFile(context.Background(), name, strings.NewReader("x")); err == nil {
+			t.Errorf("PutFile(%q) expected error, got nil", name)
+		}
+		if err := backend.DeleteFile(context.Background(), name); err == nil {
+			t.Errorf("DeleteFile(%q) expected error, got nil", name)
+		}
+	}
+}
+
+func TestBackend_PutFile_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "data")
+	for _, dir := range []string{"data/team", "outside", "shared"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "outside", "victim.zip"), []byte("outside"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for link, target := range map[string]string{
+		"data/alias":      "team",
+		"data/outside":    filepath.Join(root, "outside"),
+		"data/shared":     filepath.Join(root, "shared"),
+		"data/team/inner": "../outside",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	backend, err := New(Config{BasePath: base, SymlinkTargets: []string{filepath.Join(root, "shared")}})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+	ctx := context.Background()
+
+	// Symlinks inside the base path are followed
+	if err := backend.PutFile(ctx, "alias/new/hello.zip", strings.NewReader("hello")); err != nil {
+		t.Fatalf("PutFile() through symlink inside base path error = %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(base, "team", "new", "hello.zip")); err != nil || string(data) != "hello" {
+		t.Errorf("stored file = %q, %v, want %q", data, err, "hello")
+	}
+
+	// Writes never leave the base path, not even into allowed symlink targets
+	for _, name := range []string{"outside/victim.zip", "outside/new/x.zip", "team/inner/victim.zip", "shared/x.zip"} {
+		if err := backend.PutFile(ctx, name, strings.NewReader("x")); err == nil || !strings.Contains(err.Error(), "access denied") {
+			t.Errorf("PutFile(%q) error = %v, want access denied", name, err)
+		}
+		if err := backend.DeleteFile(ctx, name); err == nil || !strings.Contains(err.Error(), "access denied") {
+			t.Errorf("DeleteFile(%q) error = %v, want access denied", name, err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "outside", "victim.zip")); err != nil || string(data) != "outside" {
+		t.Errorf("file outside base path = %q, %v, want it unchanged", data, err)
+	}
+	for dir, want := range map[string]int{"outside": 1, "shared": 0} {
+		if entries, _ := os.ReadDir(filepath.Join(root, dir)); len(entries) != want {
+			t.Errorf("%s has %d entries after refused writes, want %d", dir, len(entries), want)
+		}
+	}
+}
+
+func TestBackend_DeleteFile(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "hello.zip")
+	if err := os.WriteFile(testFile, []byte("content"), 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	backend, err := New(Config{BasePath: tempDir})
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	if err := backend.DeleteFile(context.Background(), "hello.zip"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if _, err := os.Stat(testFile); !os.IsNotExist(err) {
+		t.Errorf("file still exists after DeleteFile()")
+	}
+	if err := backend.DeleteFile(context.Background(), "hello.zip"); err != nil {
+		t.Errorf("DeleteFile() of missing file error = %v, want nil", err)
+	}
+}
+
 func TestBackend_Close(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "local-close-test-*")
 	if err != nil {
@@ -585,157 +1248,127 @@ func TestBackend_Close(t *testing.T) {
 	}
 }
 
-func TestConvertToMetadata(t *testing.T) {
-	tempDir, err := os.MkdirTemp("", "local-convert-test-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
+func TestBackend_Members(t *testing.T) {
+	tempDir := t.TempDir()
+	outside := t.TempDir()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"db/codeql-database.yml": "primaryLanguage: go\n",
+		"db/db-go/":              "",
+		"db/src.zip":             "source",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close zip: %v", err)
+	}
+	files := map[string]string{
+		"team/repo.zip":                    buf.String(),
+		"team/widgets/codeql-database.yml": "primaryLanguage: go\n",
+		"team/widgets/db-go/default/x":     "dataset",
+		"team/widgets/hepc.yml":            "owner: octo\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(tempDir, "team/widgets/leak")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
 	}
-	defer os.RemoveAll(tempDir)
 
-	backend, err := New(Config{
-		BasePath:    tempDir,
-		EndpointURL: "http://example.com",
-	})
+	backend, err := New(Config{BasePath: tempDir})
 	if err != nil {
 		t.Fatalf("Failed to create backend: %v", err)
 	}
 	defer backend.Close()
+	ctx := context.Background()
+
+	t.Run("list archive", func(t *testing.T) {
+		members, err := backend.ListMembers(ctx, "team/repo.zip")
+		if err != nil {
+			t.Fatalf("ListMembers() error = %v", err)
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i].Path < members[j].Path })
+		want := []storage.Member{{Path: "db/codeql-database.yml", Size: 20}, {Path: "db/src.zip", Size: 6}}
+		if !reflect.DeepEqual(members, want) {
+			t.Errorf("ListMembers() = %+v, want %+v", members, want)
+		}
+	})
+
+	t.Run("list directory", func(t *testing.T) {
+		members, err := backend.ListMembers(ctx, "team/widgets")
+		if err != nil {
+			t.Fatalf("ListMembers() error = %v", err)
+		}
+		// The symlink and the sidecar file are not listed
+		want := []storage.Member{{Path: "codeql-database.yml", Size: 20}, {Path: "db-go/default/x", Size: 7}}
+		if !reflect.DeepEqual(members, want) {
+			t.Errorf("ListMembers() = %+v, want %+v", members, want)
+		}
+	})
 
 	tests := []struct {
-		name string
-		db   *codeql.DiscoveredDatabase
-		want api.DatabaseMetadata
+		name         string
+		dbPath       string
+		member       string
+		wantContent  string
+		wantNotFound bool
+		wantErr      bool
 	}{
-		{
-			name: "with creation metadata",
-			db: &codeql.DiscoveredDatabase{
-				Path:                 filepath.Join(tempDir, "test-db"),
-				Name:                 "test-db",
-				IsArchived:           false,
-				Language:             "go",
-				SourceLocationPrefix: "/src/owner/repo",
-				CreationMetadata: &codeql.DatabaseCreationMetadata{
-					SHA:          "abc123",
-					CLIVersion:   "2.15.0",
-					CreationTime: "2024-01-15T10:30:00Z",
-				},
-				FileSize:    1000,
-				ContentHash: "deadbeef",
-				Owner:       "owner",
-				Repo:        "repo",
-			},
-			want: api.DatabaseMetadata{
-				ContentHash:          "deadbeef",
-				GitBranch:            "HEAD",
-				GitCommitID:          "abc123",
-				GitOwner:             "owner",
-				GitRepo:              "repo",
-				IngestionDatetimeUTC: "2024-01-15T10:30:00Z",
-				PrimaryLanguage:      "go",
-				ToolName:             "codeql-go",
-				ToolVersion:          "2.15.0",
-				Projname:             "owner/repo",
-				DBFileSize:           1000,
-			},
-		},
-		{
-			name: "without creation metadata",
-			db: &codeql.DiscoveredDatabase{
-				Path:       filepath.Join(tempDir, "simple-db"),
-				Name:       "simple-db",
-				IsArchived: false,
-				Language:   "python",
-				FileSize:   500,
-				Owner:      "testowner",
-				Repo:       "testrepo",
-			},
-			want: api.DatabaseMetadata{
-				GitBranch:       "HEAD",
-				GitOwner:        "testowner",
-				GitRepo:         "testrepo",
-				PrimaryLanguage: "python",
-				ToolName:        "codeql-python",
-				Projname:        "testowner/testrepo",
-				DBFileSize:      500,
-			},
-		},
-		{
-			name: "unknown language",
-			db: &codeql.DiscoveredDatabase{
-				Path:     filepath.Join(tempDir, "unknown-db"),
-				Name:     "unknown-db",
-				Language: "unknown",
-				Owner:    "owner",
-				Repo:     "repo",
-			},
-			want: api.DatabaseMetadata{
-				GitBranch:       "HEAD",
-				GitOwner:        "owner",
-				GitRepo:         "repo",
-				PrimaryLanguage: "unknown",
-				ToolName:        "codeql",
-				Projname:        "owner/repo",
-			},
-		},
+		{name: "archive entry", dbPath: "team/repo.zip", member: "db/codeql-database.yml", wantContent: "primaryLanguage: go\n"},
+		{name: "missing archive entry", dbPath: "team/repo.zip", member: "db/missing", wantNotFound: true},
+		{name: "directory file", dbPath: "team/widgets", member: "db-go/default/x", wantContent: "dataset"},
+		{name: "missing directory file", dbPath: "team/widgets", member: "missing", wantNotFound: true},
+		{name: "directory sidecar file", dbPath: "team/widgets", member: "hepc.yml", wantNotFound: true},
+		{name: "missing database", dbPath: "team/missing.zip", member: "x", wantNotFound: true},
+		{name: "symlink outside base path", dbPath: "team/widgets", member: "leak", wantErr: true},
+		{name: "dot-dot member", dbPath: "team/widgets", member: "../repo.zip", wantErr: true},
+		{name: "database outside base path", dbPath: "../" + filepath.Base(outside), member: "secret", wantErr: true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := backend.convertToMetadata(tt.db)
-
-			// Check specific fields (not all, as some are generated)
-			if got.PrimaryLanguage != tt.want.PrimaryLanguage {
-				t.Errorf("PrimaryLanguage = %q, want %q", got.PrimaryLanguage, tt.want.PrimaryLanguage)
-			}
-			if got.GitOwner != tt.want.GitOwner {
-				t.Errorf("GitOwner = %q, want %q", got.GitOwner, tt.want.GitOwner)
-			}
-			if got.GitRepo != tt.want.GitRepo {
-				t.Errorf("GitRepo = %q, want %q", got.GitRepo, tt.want.GitRepo)
-			}
-			if got.Projname != tt.want.Projname {
-				t.Errorf("Projname = %q, want %q", got.Projname, tt.want.Projname)
-			}
-			if got.ToolName != tt.want.ToolName {
-				t.Errorf("ToolName = %q, want %q", got.ToolName, tt.want.ToolName)
-			}
-			if got.GitBranch != tt.want.GitBranch {
-				t.Errorf("GitBranch = %q, want %q", got.GitBranch, tt.want.GitBranch)
-			}
-			if got.DBFileSize != tt.want.DBFileSize {
-				t.Errorf("DBFileSize = %d, want %d", got.DBFileSize, tt.want.DBFileSize)
+			reader, size, err := backend.GetMember(ctx, tt.dbPath, tt.member)
+			var notFound *storage.ErrNotFound
+			switch {
+			case tt.wantNotFound:
+				if !errors.As(err, &notFound) {
+					t.Fatalf("GetMember() error = %v, want ErrNotFound", err)
+				}
+				return
+			case tt.wantErr:
+				if err == nil || errors.As(err, &notFound) {
+					t.Fatalf("GetMember() error = %v, want access error", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("GetMember() error = %v", err)
 			}
+			defer reader.Close()
 
-			// Check that generated fields are present
-			if got.ContentHash == "" {
-				t.Error("ContentHash is empty")
-			}
-			if got.BuildCID == "" {
-				t.Error("BuildCID is empty")
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("failed to read member: %v", err)
 			}
-			if !strings.HasPrefix(got.ResultURL, "http://example.com/db/") {
-				t.Errorf("ResultURL = %q, want prefix %q", got.ResultURL, "http://example.com/db/")
+			if string(content) != tt.wantContent || size != int64(len(tt.wantContent)) {
+				t.Errorf("GetMember() = %q, %d; want %q", content, size, tt.wantContent)
 			}
 		})
 	}
 }
-
-func TestGenerateBuildCID(t *testing.T) {
-	// Test that generateBuildCID produces consistent results
-	cid1 := generateBuildCID("2.15.0", "2024-01-15T10:30:00Z", "go", "abc123")
-	cid2 := generateBuildCID("2.15.0", "2024-01-15T10:30:00Z", "go", "abc123")
-
-	if cid1 != cid2 {
-		t.Errorf("generateBuildCID() produced inconsistent results: %q vs %q", cid1, cid2)
-	}
-
-	if len(cid1) != 10 {
-		t.Errorf("generateBuildCID() length = %d, want 10", len(cid1))
-	}
-
-	// Different inputs should produce different CIDs
-	cid3 := generateBuildCID("2.14.0", "2024-01-15T10:30:00Z", "go", "abc123")
-	if cid1 == cid3 {
-		t.Error("generateBuildCID() should produce different CIDs for different inputs")
-	}
-}
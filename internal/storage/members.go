@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// Member is a file inside a database: an entry of an archived database or
+// a file below an unarchived database directory.
+type Member struct {
+	// Path is the slash-separated path of the file within the database,
+	// i.e. the zip entry name or the path relative to the directory.
+	Path string
+
+	// Size is the uncompressed size of the file in bytes.
+	Size int64
+}
+
+// ValidMember reports whether member is a clean relative path that may name
+// a file inside a database.
+func ValidMember(member string) bool {
+	return member != "." && fs.ValidPath(member)
+}
+
+// ZipMembers lists the files of the zip archive in r of size bytes. Only the
+// zip directory is read.
+func ZipMembers(r io.ReaderAt, size int64) ([]Member, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip directory: %w", err)
+	}
+	members := make([]Member, 0, len(reader.File))
+	for _, f := range reader.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		members = append(members, Member{Path: f.Name, Size: int64(f.UncompressedSize64)}) //nolint:gosec // Sizes beyond int64 are rejected by zip.NewReader
+	}
+	return members, nil
+}
+
+// OpenZipMember opens the entry name of the zip archive in r of size bytes
+// and returns it with its uncompressed size. Only the zip directory and the
+// entry itself are read; the entry's CRC-32 is verified at its end.
+func OpenZipMember(r io.ReaderAt, size int64, name string) (io.ReadCloser, int64, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read zip directory: %w", err)
+	}
+	for _, f := range reader.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		return rc, int64(f.UncompressedSize64), nil //nolint:gosec // Sizes beyond int64 are rejected by zip.NewReader
+	}
+	return nil, 0, &ErrNotFound{Path: name}
+}
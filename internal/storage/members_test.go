@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestValidMember(t *testing.T) {
+	tests := []struct {
+		member string
+		want   bool
+	}{
+		{"codeql-database.yml", true},
+		{"db-go/default/strings", true},
+		{"", false},
+		{".", false},
+		{"../secret", false},
+		{"db-go/../../secret", false},
+		{"/etc/passwd", false},
+		{"db-go//x", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidMember(tt.member); got != tt.want {
+			t.Errorf("ValidMember(%q) = %v, want %v", tt.member, got, tt.want)
+		}
+	}
+}
+
+func TestZipMembers(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range []struct{ name, content string }{
+		{"db/", ""},
+		{"db/codeql-database.yml", "primaryLanguage: go\n"},
+		{"db/src.zip", "source"},
+	} {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			t.Fatalf("Failed to create zip entry: %v", err)
+		}
+		if _, err := w.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("Failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close zip: %v", err)
+	}
+	archive := bytes.NewReader(buf.Bytes())
+	size := int64(buf.Len())
+
+	members, err := ZipMembers(archive, size)
+	if err != nil {
+		t.Fatalf("ZipMembers() error = %v", err)
+	}
+	want := []Member{{Path: "db/codeql-database.yml", Size: 20}, {Path: "db/src.zip", Size: 6}}
+	if !reflect.DeepEqual(members, want) {
+		t.Errorf("ZipMembers() = %+v, want %+v", members, want)
+	}
+
+	reader, n, err := OpenZipMember(archive, size, "db/src.zip")
+	if err != nil {
+		t.Fatalf("OpenZipMember() error = %v", err)
+	}
+	content, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil || string(content) != "source" || n != 6 {
+		t.Errorf("OpenZipMember() = %q, %d, %v; want %q, 6", content, n, err, "source")
+	}
+
+	var notFound *ErrNotFound
+	if _, _, err := OpenZipMember(archive, size, "db/missing"); !errors.As(err, &notFound) {
+		t.Errorf("OpenZipMember(missing) error = %v, want ErrNotFound", err)
+	}
+	if _, err := ZipMembers(bytes.NewReader([]byte("not a zip")), 9); err == nil {
+		t.Error("ZipMembers() expected error for a corrupt archive, got nil")
+	}
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+)
+
+// SnapshotFileName is the name of the metadata snapshot in a state directory.
+const SnapshotFileName = "metadata-snapshot.jsonl"
+
+// snapshotFormat is the version of the snapshot file layout. Snapshots in
+// other layouts are ignored.
+const snapshotFormat = 1
+
+// maxSnapshotLine bounds the length of one line of a snapshot file.
+const maxSnapshotLine = 16 << 20
+
+// Snapshot is the metadata of a backend as persisted between runs.
+type Snapshot struct {
+	// SavedAt is when the snapshot was written.
+	SavedAt time.Time
+
+	// Records are the advertised databases.
+	Records []api.DatabaseMetadataV2
+
+	// Quarantine lists the databases that failed validation.
+	Quarantine []codeql.QuarantinedDatabase
+}
+
+// snapshotHeader is the first line of a snapshot file.
+type snapshotHeader struct {
+	Format     int                          `json:"format"`
+	Generation string                       `json:"generation"`
+	SavedAt    time.Time                    `json:"saved_at"`
+	Records    int                          `json:"records"`
+	Quarantine []codeql.QuarantinedDatabase `json:"quarantine,omitempty"`
+}
+
+// SnapshotStore persists the metadata of a backend to a JSON Lines file: a
+// header line followed by one v2 record per line. The header carries a
+// generation marker derived from the settings the records were discovered
+// with, so that a snapshot taken with another storage location, endpoint
+// URL or discovery settings is never served.
+//
+// A nil *SnapshotStore persists nothing. It is safe for concurrent use.
+type SnapshotStore struct {
+	file       string
+	generation string
+
+	mu    sync.Mutex
+	saved uint64
+}
+
+// NewSnapshotStore returns a store persisting to file, or nil if file is
+// empty. The settings identify the storage and discovery options; they are
+// hashed into the generation marker.
+func NewSnapshotStore(file string, settings ...string) *SnapshotStore {
+	if file == "" {
+		return nil
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", snapshotFormat)
+	for _, s := range settings {
+		fmt.Fprintf(h, "%s\x00", s)
+	}
+	return &SnapshotStore{file: file, generation: hex.EncodeToString(h.Sum(nil))}
+}
+
+// Load reads the persisted snapshot. It returns nil without an error if
+// there is none, or if it was taken in another layout or generation.
+func (s *SnapshotStore) Load() (*Snapshot, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	f, err := os.Open(s.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata snapshot: %w", err)
+	}
+	defer func() {
+		_ = f.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSnapshotLine)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read metadata snapshot %s: %w", s.file, err)
+		}
+		return nil, fmt.Errorf("invalid metadata snapshot %s: empty file", s.file)
+	}
+	var header snapshotHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("invalid metadata snapshot %s: %w", s.file, err)
+	}
+	if header.Format != snapshotFormat || header.Generation != s.generation {
+		return nil, nil
+	}
+
+	snapshot := &Snapshot{
+		SavedAt:    header.SavedAt,
+		Records:    make([]api.DatabaseMetadataV2, 0, header.Records),
+		Quarantine: header.Quarantine,
+	}
+	for scanner.Scan() {
+		var m api.DatabaseMetadataV2
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("invalid metadata snapshot %s: line %d: %w", s.file, len(snapshot.Records)+2, err)
+		}
+		snapshot.Records = append(snapshot.Records, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metadata snapshot %s: %w", s.file, err)
+	}
+	if len(snapshot.Records) != header.Records {
+		return nil, fmt.Errorf("invalid metadata snapshot %s: %d records, header says %d", s.file, len(snapshot.Records), header.Records)
+	}
+	return snapshot, nil
+}
+
+// Save persists records and quarantine, replacing the file atomically. seq
+// orders concurrent saves: a snapshot older than the last one saved, by a
+// lower seq, is dropped.
+func (s *SnapshotStore) Save(seq uint64, records []api.DatabaseMetadataV2, quarantine []codeql.QuarantinedDatabase) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq <= s.saved {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), "."+filepath.Base(s.file)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name()) //nolint:errcheck // Already renamed on success
+	}()
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(snapshotHeader{
+		Format:     snapshotFormat,
+		Generation: s.generation,
+		SavedAt:    time.Now().UTC(),
+		Records:    len(records),
+		Quarantine: quarantine,
+	})
+	for i := 0; err == nil && i < len(records); i++ {
+		err = enc.Encode(records[i])
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		_ = tmp.Close() //nolint:errcheck // The write error takes precedence
+		return fmt.Errorf("failed to write metadata snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metadata snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.file); err != nil {
+		return fmt.Errorf("failed to move metadata snapshot into place: %w", err)
+	}
+	s.saved = seq
+	return nil
+}
+
+// DiscoverySettings describes the discovery options that shape the records,
+// for NewSnapshotStore.
+func DiscoverySettings(validation codeql.Validation, rules []codeql.IdentityRule) []string {
+	settings := []string{validation.String()}
+	for _, rule := range rules {
+		settings = append(settings, rule.Field+":"+rule.Pattern.String())
+	}
+	return settings
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), SnapshotFileName)
+	store := NewSnapshotStore(file, "local", "/data", "http://localhost:8080")
+
+	if snapshot, err := store.Load(); err != nil || snapshot != nil {
+		t.Fatalf("Load() without a file = %+v, %v, want nil, nil", snapshot, err)
+	}
+
+	records := []api.DatabaseMetadataV2{
+		{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "abc", Projname: "octo/widgets"}, HashKind: api.HashKindSHA256},
+		{DatabaseMetadata: api.DatabaseMetadata{ContentHash: "def", Projname: "octo/gadgets"}, Team: "platform"},
+	}
+	quarantine := []codeql.QuarantinedDatabase{{RelPath: "broken.zip", Problems: []string{"not a zip file"}}}
+	if err := store.Save(1, records, quarantine); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	snapshot, err := store.Load()
+	if err != nil || snapshot == nil {
+		t.Fatalf("Load() = %+v, %v, want the saved snapshot", snapshot, err)
+	}
+	if !reflect.DeepEqual(snapshot.Records, records) {
+		t.Errorf("Records = %+v, want %+v", snapshot.Records, records)
+	}
+	if !reflect.DeepEqual(snapshot.Quarantine, quarantine) {
+		t.Errorf("Quarantine = %+v, want %+v", snapshot.Quarantine, quarantine)
+	}
+	if snapshot.SavedAt.IsZero() {
+		t.Error("SavedAt is zero")
+	}
+
+	// An older save does not overwrite a newer one
+	if err := store.Save(1, records[:1], nil); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if snapshot, _ := store.Load(); len(snapshot.Records) != 2 {
+		t.Errorf("Load() after a stale save returned %d records, want 2", len(snapshot.Records))
+	}
+
+	// Other settings make it another generation
+	other := NewSnapshotStore(file, "local", "/data", "https://hepc.example.com")
+	if snapshot, err := other.Load(); err != nil || snapshot != nil {
+		t.Errorf("Load() of another generation = %+v, %v, want nil, nil", snapshot, err)
+	}
+
+	var none *SnapshotStore
+	if err := none.Save(1, records, nil); err != nil {
+		t.Errorf("nil Save() error = %v", err)
+	}
+	if snapshot, err := none.Load(); err != nil || snapshot != nil {
+		t.Errorf("nil Load() = %+v, %v, want nil, nil", snapshot, err)
+	}
+}
+
+func TestSnapshotStore_Invalid(t *testing.T) {
+	store := NewSnapshotStore(filepath.Join(t.TempDir(), SnapshotFileName), "local")
+	if err := store.Save(1, []api.DatabaseMetadataV2{{}, {}}, nil); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, err := os.ReadFile(store.file)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "bad header", data: []byte("not json\n")},
+		{name: "truncated", data: data[:len(data)-10]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(store.file, tt.data, 0o600); err != nil {
+				t.Fatalf("failed to write snapshot: %v", err)
+			}
+			if _, err := store.Load(); err == nil {
+				t.Error("Load() error = nil, want error")
+			}
+		})
+	}
+}
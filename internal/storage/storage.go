@@ -3,9 +3,14 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/data-douser/mrva-go-hepc/api"
+	"github.com/data-douser/mrva-go-hepc/internal/codeql"
 )
 
 // Backend represents a storage backend for CodeQL databases.
@@ -20,6 +25,10 @@ type Backend interface {
 	// equivalent storage mechanism.
 	ListMetadata(ctx context.Context) ([]api.DatabaseMetadata, error)
 
+	// Index returns a lookup index over the records returned by ListMetadata.
+	// It is rebuilt whenever the backend refreshes its metadata.
+	Index(ctx context.Context) (*Index, error)
+
 	// GetFile retrieves a database file by name and returns a ReadCloser.
 	// The caller is responsible for closing the returned reader.
 	// Returns the file size and content type along with the reader.
@@ -35,6 +44,97 @@ type Backend interface {
 	Close() error
 }
 
+// Writer is implemented by backends that can store database files, such as
+// the destination of a sync between backends.
+type Writer interface {
+	// PutFile stores the content of r under filename, creating parent
+	// directories as needed and replacing any existing file. The file
+	// appears only once it is complete.
+	PutFile(ctx context.Context, filename string, r io.Reader) error
+
+	// DeleteFile removes a file. Removing a file that does not exist is not
+	// an error.
+	DeleteFile(ctx context.Context, filename string) error
+}
+
+// RangeReader is implemented by backends that can read a file from an
+// offset without transferring the bytes before it, e.g. to resume
+// downloads.
+type RangeReader interface {
+	// GetFileRange returns the content of a file from byte offset off to
+	// its end. The caller is responsible for closing the returned reader.
+	GetFileRange(ctx context.Context, filename string, off int64) (io.ReadCloser, error)
+}
+
+// ModTimer is implemented by readers returned by GetFile that know when the
+// file they read was last modified. The server sends the time as a
+// Last-Modified validator so that resumed downloads detect replaced files.
+type ModTimer interface {
+	// ModTime returns the modification time, or the zero time if unknown.
+	ModTime() time.Time
+}
+
+// Hasher is implemented by backends that can report the SHA-256 of a file
+// more cheaply than by reading it, e.g. from object metadata.
+type Hasher interface {
+	// FileHash returns the hex-encoded SHA-256 of a file.
+	FileHash(ctx context.Context, filename string) (string, error)
+}
+
+// Prober is implemented by backends that can cheaply check that their
+// storage is reachable, e.g. for readiness probes.
+type Prober interface {
+	// Probe returns an error if the storage cannot currently be reached.
+	Probe(ctx context.Context) error
+}
+
+// QuarantineReporter is implemented by backends that validate databases
+// during discovery and leave those that fail out of the index.
+type QuarantineReporter interface {
+	// Quarantine returns the databases that failed validation, with paths
+	// relative to the storage root.
+	Quarantine() []codeql.QuarantinedDatabase
+}
+
+// MemberReader is implemented by backends that can serve single files from
+// inside a database without transferring all of it.
+type MemberReader interface {
+	// ListMembers lists the files of the database at dbPath, an artifact
+	// path as served under /db/.
+	ListMembers(ctx context.Context, dbPath string) ([]Member, error)
+
+	// GetMember returns the file member of the database at dbPath and its
+	// size. The caller is responsible for closing the returned reader.
+	GetMember(ctx context.Context, dbPath, member string) (io.ReadCloser, int64, error)
+}
+
+// FileHash returns the hex-encoded SHA-256 of a file in b, using the
+// backend's Hasher implementation if it has one and reading the file
+// otherwise.
+func FileHash(ctx context.Context, b Backend, filename string) (string, error) {
+	if h, ok := b.(Hasher); ok {
+		return h.FileHash(ctx, filename)
+	}
+	return ReadHash(ctx, b, filename)
+}
+
+// ReadHash returns the hex-encoded SHA-256 of a file in b by reading it.
+func ReadHash(ctx context.Context, b Backend, filename string) (string, error) {
+	reader, _, _, err := b.GetFile(ctx, filename)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = reader.Close() //nolint:errcheck // Best effort close in defer
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // Config holds common configuration for storage backends.
 type Config struct {
 	// MetadataFile is the name of the metadata database file (default: "metadata.sql").